@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/pkg/profile"
 	"github.com/urfave/cli/v2"
@@ -13,6 +14,7 @@ import (
 	"fb2converter/commands"
 	"fb2converter/config"
 	"fb2converter/misc"
+	"fb2converter/processor"
 	"fb2converter/reporter"
 	"fb2converter/state"
 )
@@ -64,9 +66,13 @@ func (w *appWrapper) beforeAppRun(c *cli.Context) error {
 	}
 
 	// Prepare configuration
-	if env.Cfg, err = config.BuildConfig(fconfig...); err != nil {
+	if env.Cfg, err = config.BuildConfig(c.String("device"), c.StringSlice("preset"), config.JoinOverrides(c.StringSlice("set")), fconfig...); err != nil {
 		return cli.Exit(fmt.Errorf("%sunable to build configuration: %w", errPrefix, err), errCode)
 	}
+	// configuration files and logs are stored in debug report as is
+	for _, s := range env.Cfg.Secrets() {
+		env.Rpt.Redact(s)
+	}
 
 	// We may want to do some profiling
 	if p := c.String("cpuprofile"); len(p) > 0 {
@@ -106,10 +112,13 @@ func (w *appWrapper) beforeCommandRun(c *cli.Context) error {
 	// Log errors rather then print them
 	w.inCommand = true
 
-	w.log.Debug("Program started", zap.Strings("args", os.Args), zap.String("ver", misc.GetVersion()+" ("+runtime.Version()+") : "+misc.GetGitHash()))
+	w.log.Debug("Program started", zap.Strings("args", config.RedactArgs(os.Args)), zap.String("ver", misc.GetVersion()+" ("+runtime.Version()+") : "+misc.GetGitHash()))
 	if len(c.String("config")) == 0 {
 		w.log.Info("Using defaults (no configuration file)")
 	}
+	if len(env.Cfg.Presets) > 0 {
+		w.log.Info("Using presets", zap.Strings("presets", env.Cfg.Presets))
+	}
 
 	return nil
 }
@@ -196,6 +205,9 @@ func main() {
 		&cli.IntFlag{Name: "mhl", Value: config.MhlNone, Hidden: true, Usage: "--internal--"},
 
 		&cli.StringSliceFlag{Name: "config", Aliases: []string{"c"}, Usage: "load configuration from `FILE` (YAML, TOML or JSON). if FILE is \"-\" JSON will be expected from STDIN"},
+		&cli.StringFlag{Name: "device", Usage: "apply built-in profile for device `NAME` (" + strings.Join(config.Devices(), ", ") + "), configuration files still take precedence"},
+		&cli.StringSliceFlag{Name: "preset", Aliases: []string{"p"}, Usage: "apply named preset `NAME` from \"presets\" configuration section, presets are applied in order after all configuration files"},
+		&cli.StringSliceFlag{Name: "set", Usage: "override configuration value `KEY=VALUE`, for example \"document.toc.type=flat\", has precedence over FB2C_DOCUMENT__TOC__TYPE environment variables, presets, configuration files and device profile"},
 		&cli.BoolFlag{Name: "debug", Aliases: []string{"d"}, Usage: "prepare archive with details of a current run (may overwrite some log settings)"},
 	}
 
@@ -207,9 +219,10 @@ func main() {
 			Before: wrap.beforeCommandRun,
 			After:  wrap.afterCommandRun,
 			Flags: []cli.Flag{
-				&cli.StringFlag{Name: "to", Value: "epub", Usage: "conversion output `TYPE` (supported types: epub, kepub, azw3, mobi)"},
+				&cli.StringFlag{Name: "to", Value: "epub", Usage: "conversion output `TYPE` (supported types: " + strings.Join(processor.Formats(), ", ") + ")"},
 				&cli.BoolFlag{Name: "nodirs", Usage: "when producing output do not keep input directory structure"},
 				&cli.BoolFlag{Name: "stk", Usage: "send converted file to kindle (epub only)"},
+				&cli.BoolFlag{Name: "nodeliver", Usage: "do not deliver converted files to targets from \"delivery\" configuration section"},
 				&cli.BoolFlag{Name: "ow", Usage: "continue even if destination exits, overwrite files"},
 				&cli.StringFlag{Name: "force-zip-cp", Usage: "Force `ENCODING` for ALL file names in archives (see IANA.org for character set names)"},
 			},
@@ -226,6 +239,9 @@ func main() {
 DESTINATION:
     always a path, output file name(s) and extension will be derived from other parameters
     if absent - current working directory
+
+    Converted files are delivered to all targets from "delivery" configuration section accepting output format (copied
+    to device, uploaded to WebDAV server or webhook, passed to a command) unless --nodeliver is specified.
 `, cli.CommandHelpTemplate),
 		},
 		{
@@ -245,6 +261,262 @@ SOURCE:
 	full path to file/directory on mounted device
 
 Synchronizes kindle thumbnails with books already in Kindle memory so Kindle home page looks better.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "devicesync",
+			Usage:  "Converts or copies books to mounted Kindle with thumbnails and collections (Kindle only!)",
+			Action: commands.DeviceSync,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "to", Value: "azw3", Usage: "conversion output `TYPE` (azw3 or mobi)"},
+				&cli.StringFlag{Name: "collections", Value: "series", Usage: "how to group books into collections, separated by \";\": series, author, genre or none"},
+				&cli.BoolFlag{Name: "ow", Usage: "convert or copy books even if they are already on device"},
+				&cli.BoolFlag{Name: "prune", Usage: "remove thumbnails and collection entries devicesync created earlier for books which are no longer on device"},
+				&cli.IntFlag{Name: "width", Value: 330, Usage: "width of the resulting thumbnail (default: 330)"},
+				&cli.IntFlag{Name: "height", Value: 470, Usage: "height of the resulting thumbnail (default: 470)"},
+				&cli.BoolFlag{Name: "stretch", Usage: "do not preserve thumbnail aspect ratio when resizing"},
+			},
+			ArgsUsage: "SOURCE DEVICE",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to book(s) to synchronize, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+DEVICE:
+    path to mounted Kindle (directory with "documents" and "system" subdirectories)
+
+    FB2 books are converted directly into "documents" directory, MOBI and AZW3 books are copied there. Books keep the layout
+    "convert" would produce (see "file_name_format" configuration), books already on device are skipped unless --ow is used.
+    Thumbnails are created for all synchronized books. Books are added to collections named after their series, authors
+    or genres. Collections are kept in "system/collections.json" which older devices read directly, newer devices need
+    collections manager extension to import it.
+    Nothing is removed from device unless --prune is used. Even then only thumbnails and collection entries devicesync
+    itself created are removed (they are listed in "system/fb2c-devicesync.json"), everything else on device is left alone.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "info",
+			Usage:  "Prints book(s) metadata and structure",
+			Action: commands.Info,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "json", Usage: "output results as JSON array"},
+				&cli.StringFlag{Name: "to", Value: "epub", Usage: "conversion output `TYPE` to use when calculating output file name (supported types: " + strings.Join(processor.Formats(), ", ") + ")"},
+				&cli.BoolFlag{Name: "nodirs", Usage: "when calculating output file name do not keep input directory structure"},
+			},
+			ArgsUsage: "SOURCE",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to book(s) to inspect, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+    Besides fb2 files epub, mobi and azw3 books are recognized. For Kindle books ASIN, cdetype, cdekey and KF8 offset are reported.
+    For fb2 files output name shows what "convert" would produce with current configuration (file_name_format, overwrites, etc.).
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "setmeta",
+			Usage:  "Changes book(s) description in FB2 files",
+			Action: commands.SetMeta,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "title", Usage: "book title"},
+				&cli.StringFlag{Name: "authors", Usage: "book authors separated by \";\", each as \"First Middle Last\" or \"Last, First Middle\""},
+				&cli.StringFlag{Name: "sequence", Usage: "series name"},
+				&cli.IntFlag{Name: "number", Usage: "book number in the series"},
+				&cli.StringFlag{Name: "genres", Usage: "FB2 genre codes separated by \";\""},
+				&cli.StringFlag{Name: "lang", Usage: "book language"},
+				&cli.StringFlag{Name: "date", Usage: "book date"},
+				&cli.StringFlag{Name: "annotation", Usage: "book annotation, \\n starts new paragraph"},
+				&cli.StringFlag{Name: "cover", Usage: "`FILE` with cover image (jpeg, png or gif)"},
+				&cli.BoolFlag{Name: "remove-cover", Usage: "remove cover from the book"},
+				&cli.StringFlag{Name: "id", Usage: "book id (document-info)"},
+				&cli.StringFlag{Name: "from", Usage: "read meta information from `FILE` (TOML sidecar, JSON overwrite meta or calibre OPF), flags have precedence"},
+				&cli.BoolFlag{Name: "overwrites", Usage: "apply matching overwrites from configuration, flags have precedence"},
+			},
+			ArgsUsage: "SOURCE",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to fb2 file(s) to change, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+    Changes title-info (and document-info id) permanently, using the same meta information as "overwrites" configuration does.
+    Specified authors, genres and series replace all existing ones (nested series included).
+    Files are replaced only after new content was successfully written, archives are rewritten once with all changed books.
+    Changed books are always saved in UTF-8 encoding.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "catalog",
+			Usage:  "Lists books with their descriptions",
+			Action: commands.Catalog,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "format", Value: "csv", Usage: "catalog format: csv, jsonl or html"},
+				&cli.StringFlag{Name: "template", Usage: "`FILE` with html/template to use instead of built-in one for html catalog"},
+			},
+			ArgsUsage: "SOURCE [DESTINATION]",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to fb2 file(s) to list, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+DESTINATION:
+    file to write csv or jsonl catalog to, if absent - STDOUT
+    directory for html catalog (index.html and cover thumbnails), required
+
+    Only book descriptions are parsed, so even large libraries are listed quickly. Meta information overwrites are applied.
+    Built-in html template could be exported with "export" command and customized.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "opds",
+			Usage:  "Builds static OPDS catalog for converted library",
+			Action: commands.Opds,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "formats", Usage: "\";\" separated list of output formats to look for (default: all known formats)"},
+				&cli.BoolFlag{Name: "nodirs", Usage: "library was converted without keeping input directory structure"},
+				&cli.StringFlag{Name: "title", Usage: "catalog title (default: DESTINATION directory name)"},
+				&cli.IntFlag{Name: "page-size", Value: 100, Usage: "number of books per acquisition feed page, 0 - no pagination"},
+			},
+			ArgsUsage: "SOURCE DESTINATION",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to fb2 file(s) library was converted from, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+DESTINATION:
+    converted library, catalog is written to its "opds" subdirectory with "index.xml" as root feed
+
+    Converted books are located the same way convert names them, so configuration and --nodirs should match ones used for conversion.
+    Books are navigable by author, series, genre and language, every produced format is listed as acquisition link.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "opds-serve",
+			Usage:  "Serves OPDS catalog of FB2 books converting them on download",
+			Action: commands.OpdsServe,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "listen", Value: "127.0.0.1:8080", Usage: "`ADDRESS` to listen on, use \":8080\" to accept connections from other hosts"},
+				&cli.StringFlag{Name: "formats", Value: "epub;kepub;azw3", Usage: "\";\" separated list of output formats offered for download"},
+				&cli.StringFlag{Name: "cache-dir", Usage: "`DIRECTORY` to keep converted books in (default: \"fb2c/opds\" in user cache directory)"},
+				&cli.Int64Flag{Name: "cache-size", Value: 1024, Usage: "maximum size of converted books cache in megabytes"},
+				&cli.StringFlag{Name: "title", Usage: "catalog title (default: SOURCE directory name)"},
+				&cli.IntFlag{Name: "page-size", Value: 100, Usage: "number of books per acquisition feed page, 0 - no pagination"},
+			},
+			ArgsUsage: "SOURCE",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to fb2 file(s) to serve, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+    Catalog root feed is "/opds/index.xml". Books are indexed once on start, restart server to pick up library changes.
+    Server has no authentication, by default it only accepts connections from this host.
+    Book is converted to requested format only when it is downloaded, using current configuration, and kept in cache
+    until cache grows over its size, least recently downloaded books are removed first. Conversions are done one at a time.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "organize",
+			Usage:  "Copies, moves or links books into library tree named according to configuration",
+			Action: commands.Organize,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "mode", Value: "copy", Usage: "how to place books: copy, move or link (hard link)"},
+				&cli.StringFlag{Name: "on-collision", Value: "rename", Usage: "what to do when destination exists: skip, rename or overwrite"},
+				&cli.BoolFlag{Name: "nodirs", Usage: "do not keep input directory structure"},
+				&cli.BoolFlag{Name: "dry-run", Usage: "only report what would be done"},
+				&cli.StringFlag{Name: "undo-log", Usage: "record performed operations to `FILE` (default: time stamped file in DESTINATION)"},
+				&cli.StringFlag{Name: "undo", Usage: "revert operations recorded in undo log `FILE`, SOURCE and DESTINATION are not used"},
+			},
+			ArgsUsage: "SOURCE DESTINATION",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to book(s) to organize, same forms as for convert command are supported (file, directory, archive with optional path inside)
+    FB2, EPUB and Kindle books are recognized, books in archives are always copied (extracted)
+
+DESTINATION:
+    library path, books are named according to "file_name_format" and "file_name_transliterate" the same way convert does,
+    but keep their original extensions
+
+    Existing identical books are skipped. Every performed operation is recorded in undo log, so it could be reverted with --undo.
+    Content of overwritten files cannot be restored.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "dedupe",
+			Usage:  "Finds duplicate FB2 books",
+			Action: commands.Dedupe,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "match", Value: "id;title;text", Usage: "what makes books duplicates, separated by \";\": id (document-info), title (normalized title, authors, language and series), text (similar body text)"},
+				&cli.Float64Flag{Name: "similarity", Value: 0.8, Usage: "minimal estimated share of the same text for text match"},
+				&cli.StringFlag{Name: "prefer", Value: "version;cover;size", Usage: "rules to select preferred copy in order of importance, separated by \";\": version, cover, size, text, unpacked"},
+				&cli.StringFlag{Name: "action", Value: "report", Usage: "what to do with duplicates: report, move or delete"},
+				&cli.StringFlag{Name: "to", Usage: "`DIR` to move duplicates to (keeping relative paths)"},
+				&cli.BoolFlag{Name: "json", Usage: "report in JSON format"},
+			},
+			ArgsUsage: "SOURCE",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to fb2 file(s) to check, same forms as for convert command are supported (file, directory, archive with optional path inside)
+
+    Books matching by any of selected criteria are grouped together, preferred copy is marked with "+" in the report.
+    Text similarity is estimated by comparing hashes of word sequences of the main bodies (notes are ignored).
+    Books in archives are moved or deleted only when archive contains nothing else.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "kindlemeta",
+			Usage:  "Changes meta information of already produced Kindle books",
+			Action: commands.KindleMeta,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "title", Usage: "book title, formatted according to title_format"},
+				&cli.StringFlag{Name: "authors", Usage: "book authors separated by \";\", each as \"First Middle Last\" or \"Last, First Middle\""},
+				&cli.StringFlag{Name: "sequence", Usage: "series name (requires title)"},
+				&cli.IntFlag{Name: "number", Usage: "book number in the series (requires title)"},
+				&cli.StringFlag{Name: "lang", Usage: "book language"},
+				&cli.StringFlag{Name: "publisher", Usage: "book publisher"},
+				&cli.StringFlag{Name: "asin", Usage: "book ASIN (also used as content key)"},
+				&cli.StringFlag{Name: "cdetype", Usage: "Kindle content type: EBOK or PDOC"},
+				&cli.StringFlag{Name: "cover", Usage: "`FILE` with new cover image, thumbnail is regenerated"},
+				&cli.StringFlag{Name: "from", Usage: "read meta information from `FILE` (TOML sidecar, JSON overwrite meta or calibre OPF), flags have precedence"},
+			},
+			ArgsUsage: "SOURCE",
+			CustomHelpTemplate: fmt.Sprintf(`%sSOURCE:
+    path to mobi/azw3 file(s) to change, file or directory (books in archives are skipped)
+
+    Changes EXTH records in both MOBI 7 and KF8 headers of the book without reconversion.
+    MOBI has no place for series, so sequence name and number only become part of the title according to title_format.
+    Existing cover image is replaced, books without cover are not changed.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "outbox",
+			Usage:  "Lists, sends or clears books which could not be sent to Kindle",
+			Action: commands.Outbox,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "send", Usage: "try to send books kept in outbox"},
+				&cli.BoolFlag{Name: "clear", Usage: "remove all books from outbox"},
+			},
+			CustomHelpTemplate: fmt.Sprintf(`%s
+Books which could not be sent to Kindle (see "sendtokindle" configuration section) are kept in outbox and "convert"
+tries to send them again at the end of every run. Without options command lists books waiting in outbox.
+`, cli.CommandHelpTemplate),
+		},
+		{
+			Name:   "checkconfig",
+			Usage:  "Validates configuration",
+			Action: commands.CheckConfig,
+			Before: wrap.beforeCommandRun,
+			After:  wrap.afterCommandRun,
+			CustomHelpTemplate: fmt.Sprintf(`%s
+Checks configuration before anything is processed: unknown keys (including presets), values outside of supported sets
+and ranges, files configuration refers to (stylesheet, cover image, fonts, vignettes, genre table). Every problem is
+printed with the path of configuration key, for example "error: document.toc.type: unknown value ...".
+Command fails if any errors were found, warnings point to settings which would be ignored or features which are not available.
+"convert" and "opds-serve" refuse to start on configuration with errors.
 `, cli.CommandHelpTemplate),
 		},
 		{
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskCache keeps files in a directory limiting their total size, least recently used files are removed first.
+type diskCache struct {
+	dir   string
+	limit int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // of *cacheItem, most recently used first
+	items map[string]*list.Element
+}
+
+type cacheItem struct {
+	name string
+	size int64
+}
+
+// newDiskCache creates cache in directory picking up files left from previous runs, their modification times are used
+// as last access times.
+func newDiskCache(dir string, limit int64) (*diskCache, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type found struct {
+		item    *cacheItem
+		modTime time.Time
+	}
+	var files []found
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, found{item: &cacheItem{name: e.Name(), size: fi.Size()}, modTime: fi.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	c := &diskCache{dir: dir, limit: limit, order: list.New(), items: make(map[string]*list.Element)}
+	for _, f := range files {
+		c.items[f.item.name] = c.order.PushBack(f.item)
+		c.size += f.item.size
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return c, nil
+}
+
+// open returns cached file or nil if there is no such file in cache.
+func (c *diskCache) open(name string) (*os.File, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[name]
+	if !ok {
+		return nil, nil
+	}
+	fname := filepath.Join(c.dir, name)
+	f, err := os.Open(fname)
+	if err != nil {
+		// removed behind our back
+		c.remove(e)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	c.order.MoveToFront(e)
+	now := time.Now()
+	_ = os.Chtimes(fname, now, now)
+	return f, nil
+}
+
+// put moves file into cache under name and returns its new location.
+func (c *diskCache) put(name, from string) (string, error) {
+
+	fi, err := os.Stat(from)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[name]; ok {
+		c.remove(e)
+	}
+	fname := filepath.Join(c.dir, name)
+	if err := moveFile(from, fname); err != nil {
+		return "", err
+	}
+	c.items[name] = c.order.PushFront(&cacheItem{name: name, size: fi.Size()})
+	c.size += fi.Size()
+	c.evict()
+	return fname, nil
+}
+
+// evict removes least recently used files until cache fits its limit, most recently used file is always kept.
+func (c *diskCache) evict() {
+	for c.size > c.limit && c.order.Len() > 1 {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *diskCache) remove(e *list.Element) {
+	item := c.order.Remove(e).(*cacheItem)
+	delete(c.items, item.name)
+	c.size -= item.size
+	_ = os.Remove(filepath.Join(c.dir, item.name))
+}
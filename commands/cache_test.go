@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func cachePut(t *testing.T, c *diskCache, name string, size int) {
+	from := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(from, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.put(name, from); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cacheFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.Name())
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+
+	dir := t.TempDir()
+	c, err := newDiskCache(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachePut(t, c, "a", 10)
+	cachePut(t, c, "b", 10)
+
+	// a becomes most recently used, so b goes first
+	f, err := c.open("a")
+	if err != nil || f == nil {
+		t.Fatalf("cached file was not found: %v", err)
+	}
+	f.Close()
+	cachePut(t, c, "c", 10)
+	if files := cacheFiles(t, dir); !reflect.DeepEqual(files, []string{"a", "c"}) {
+		t.Errorf("unexpected cache content: %v", files)
+	}
+	if f, err := c.open("b"); err != nil || f != nil {
+		t.Errorf("evicted file was found: %v", err)
+	}
+
+	// replacing file does not count it twice
+	cachePut(t, c, "c", 5)
+	if c.size != 15 || c.order.Len() != 2 {
+		t.Errorf("unexpected cache size %d with %d files", c.size, c.order.Len())
+	}
+
+	// file larger than limit is still kept, everything else goes
+	cachePut(t, c, "d", 100)
+	if files := cacheFiles(t, dir); !reflect.DeepEqual(files, []string{"d"}) {
+		t.Errorf("unexpected cache content: %v", files)
+	}
+	if c.size != 100 {
+		t.Errorf("unexpected cache size %d", c.size)
+	}
+
+	// removed behind our back
+	os.Remove(filepath.Join(dir, "d"))
+	if f, err := c.open("d"); err != nil || f != nil || c.size != 0 {
+		t.Errorf("missing file was not dropped: %v, size %d", err, c.size)
+	}
+}
+
+func TestDiskCacheRestart(t *testing.T) {
+
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"old", "middle", "new"} {
+		fname := filepath.Join(dir, name)
+		if err := os.WriteFile(fname, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mt := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(fname, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".work"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// files left from previous run are picked up, least recently used are evicted to fit new limit
+	c, err := newDiskCache(dir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := cacheFiles(t, dir); !reflect.DeepEqual(files, []string{".work", "middle", "new"}) {
+		t.Errorf("unexpected cache content: %v", files)
+	}
+	if c.size != 20 {
+		t.Errorf("unexpected cache size %d", c.size)
+	}
+
+	// access order survives restart through modification times
+	f, err := c.open("middle")
+	if err != nil || f == nil {
+		t.Fatalf("cached file was not found: %v", err)
+	}
+	f.Close()
+	if c, err = newDiskCache(dir, 10); err != nil {
+		t.Fatal(err)
+	}
+	if files := cacheFiles(t, dir); !reflect.DeepEqual(files, []string{".work", "middle"}) {
+		t.Errorf("unexpected cache content: %v", files)
+	}
+}
@@ -0,0 +1,233 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+	"fb2converter/static"
+)
+
+const catalogTemplate = "templates/catalog.html"
+
+// catalogEntry is a single book in catalog.
+type catalogEntry struct {
+	Path       string   `json:"path"`
+	Member     string   `json:"member,omitempty"`
+	Size       int64    `json:"size"`
+	ID         string   `json:"id,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Authors    []string `json:"authors,omitempty"`
+	Series     string   `json:"series,omitempty"`
+	Number     int      `json:"number,omitempty"`
+	Genres     []string `json:"genres,omitempty"`
+	GenreNames []string `json:"genre_names,omitempty"`
+	Language   string   `json:"language,omitempty"`
+	Date       string   `json:"date,omitempty"`
+	Cover      bool     `json:"cover"`
+	Annotation string   `json:"annotation,omitempty"`
+	Thumbnail  string   `json:"-"`
+}
+
+var catalogColumns = []string{"path", "member", "size", "id", "title", "authors", "series", "number", "genres", "language", "date", "cover", "annotation"}
+
+// record returns entry as CSV record, lists are separated by ";" as in overwrites CSV.
+func (e *catalogEntry) record() []string {
+	var number string
+	if e.Number > 0 {
+		number = strconv.Itoa(e.Number)
+	}
+	return []string{e.Path, e.Member, strconv.FormatInt(e.Size, 10), e.ID, e.Title, strings.Join(e.Authors, "; "), e.Series, number,
+		strings.Join(e.Genres, "; "), e.Language, e.Date, strconv.FormatBool(e.Cover), e.Annotation}
+}
+
+// saveThumbnail writes cover image thumbnail to file.
+func saveThumbnail(fname string, data []byte) error {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return err
+	}
+	return imaging.Save(imaging.Fit(img, 240, 360, imaging.Lanczos), fname, imaging.JPEGQuality(80))
+}
+
+// Catalog is "catalog" command body.
+func Catalog(ctx *cli.Context) (err error) {
+
+	const (
+		errPrefix = "catalog: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	if src, err = filepath.Abs(src); err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	dst := ctx.Args().Get(1)
+	if ctx.Args().Len() > 2 {
+		env.Log.Warn("Mailformed command line, too many arguments", zap.Strings("ignoring", ctx.Args().Slice()[2:]))
+	}
+
+	format := strings.ToLower(ctx.String("format"))
+	var tmpl *template.Template
+	switch format {
+	case "csv", "jsonl":
+	case "html":
+		if len(dst) == 0 {
+			return cli.Exit(errors.New(errPrefix+"destination directory is required for html catalog"), errCode)
+		}
+		var data []byte
+		if fname := ctx.String("template"); len(fname) > 0 {
+			data, err = os.ReadFile(fname)
+		} else {
+			data, err = static.Asset(catalogTemplate)
+		}
+		if err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to read catalog template: %w", errPrefix, err), errCode)
+		}
+		tmpl, err = template.New("catalog").Funcs(template.FuncMap{
+			"join":  strings.Join,
+			"lines": func(s string) []string { return strings.Split(s, "\n") },
+		}).Parse(string(data))
+		if err != nil {
+			return cli.Exit(fmt.Errorf("%sbad catalog template: %w", errPrefix, err), errCode)
+		}
+		if err := os.MkdirAll(filepath.Join(dst, "thumbnails"), 0755); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to create destination directory: %w", errPrefix, err), errCode)
+		}
+	default:
+		return cli.Exit(fmt.Errorf("%sunknown catalog format %s, should be csv, jsonl or html", errPrefix, format), errCode)
+	}
+
+	// csv and jsonl are written as books are found, html index when everything is collected
+	var out io.Writer = os.Stdout
+	if len(dst) > 0 {
+		fname := dst
+		if tmpl != nil {
+			fname = filepath.Join(dst, "index.html")
+		}
+		f, err := os.Create(fname)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to create catalog: %w", errPrefix, err), errCode)
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil && err == nil {
+				err = cli.Exit(fmt.Errorf("%sunable to write catalog: %w", errPrefix, cerr), errCode)
+			}
+		}()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	cw := csv.NewWriter(bw)
+	jw := json.NewEncoder(bw)
+	jw.SetEscapeHTML(false)
+	if format == "csv" {
+		if err := cw.Write(catalogColumns); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to write catalog: %w", errPrefix, err), errCode)
+		}
+	}
+
+	var books []*catalogEntry
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		if b.kind != kindFB2 {
+			return nil
+		}
+		r, err := b.open()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		defer r.Close()
+
+		info, err := processor.ReadDescription(selectReader(r, b.enc), b.enc == encUnknown, b.src, b.file, tmpl != nil, env)
+		if err != nil {
+			env.Log.Warn("Unable to read book description", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+
+		e := &catalogEntry{
+			Path:       b.file,
+			Size:       b.size,
+			ID:         info.ID,
+			Title:      info.Title,
+			Authors:    info.Authors,
+			Genres:     info.Genres,
+			GenreNames: info.GenreNames,
+			Language:   info.Language,
+			Date:       info.Date,
+			Cover:      info.Cover,
+			Annotation: info.Annotation,
+		}
+		if len(b.archive) > 0 {
+			e.Path, e.Member = b.archive, b.entry
+		}
+		if len(info.Series) > 0 {
+			e.Series, e.Number = info.Series[0].Name, info.Series[0].Num
+		}
+
+		switch format {
+		case "csv":
+			err = cw.Write(e.record())
+		case "jsonl":
+			err = jw.Encode(e)
+		case "html":
+			if len(info.CoverImage) > 0 {
+				name := fmt.Sprintf("thumbnails/%d.jpg", len(books)+1)
+				if err := saveThumbnail(filepath.Join(dst, filepath.FromSlash(name)), info.CoverImage); err != nil {
+					env.Log.Warn("Unable to create cover thumbnail", zap.String("file", b.location()), zap.Error(err))
+				} else {
+					e.Thumbnail = name
+				}
+			}
+			books = append(books, e)
+		}
+		return err
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	if tmpl != nil {
+		err = tmpl.Execute(bw, struct {
+			Title     string
+			Generated string
+			Books     []*catalogEntry
+		}{
+			Title:     filepath.Base(src),
+			Generated: time.Now().Format("2006-01-02 15:04"),
+			Books:     books,
+		})
+	}
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to write catalog: %w", errPrefix, err), errCode)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// CheckConfig is "checkconfig" command body.
+func CheckConfig(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "checkconfig: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+	if ctx.Args().Len() > 0 {
+		env.Log.Warn("Mailformed command line, too many arguments", zap.Strings("ignoring", ctx.Args().Slice()))
+	}
+
+	problems := processor.CheckConfig(env.Cfg)
+	for _, p := range problems {
+		fmt.Fprintln(ctx.App.Writer, p.String())
+	}
+	if n := problems.Errors(); n > 0 {
+		return cli.Exit(fmt.Errorf("%sconfiguration has %d error(s) and %d warning(s)", errPrefix, n, len(problems)-n), errCode)
+	}
+	env.Log.Info("Configuration is valid", zap.Int("warnings", len(problems)))
+	return nil
+}
+
+// validateConfig makes sure configuration could be used for conversion before any book is touched. Warnings are only
+// logged as they may not be relevant for requested operation.
+func validateConfig(env *state.LocalEnv) error {
+	problems := processor.CheckConfig(env.Cfg)
+	for _, p := range problems {
+		if p.Warning {
+			env.Log.Debug("Configuration warning", zap.String("key", p.Key), zap.String("problem", p.Message))
+		}
+	}
+	return problems.Err()
+}
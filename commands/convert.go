@@ -20,14 +20,16 @@ import (
 
 	"fb2converter/archive"
 	"fb2converter/config"
+	"fb2converter/delivery"
 	"fb2converter/processor"
 	"fb2converter/state"
 )
 
 // processBook processes single FB2 file. "src" is part of the source path (always including file name) relative to the original
 // path. When actual file was specified it will be just base file name without a path. When looking inside archive or directory
-// it will be relative path inside archive or directory (including base file name).
-func processBook(r io.Reader, enc srcEncoding, src, dst string, nodirs, stk, overwrite bool, format processor.OutputFmt, env *state.LocalEnv) error {
+// it will be relative path inside archive or directory (including base file name). "file" is actual book file when book is not
+// inside archive.
+func processBook(r io.Reader, enc srcEncoding, src, file, dst string, nodirs, stk, overwrite bool, format processor.OutputFmt, env *state.LocalEnv) error {
 
 	var fname, id string
 
@@ -44,6 +46,7 @@ func processBook(r io.Reader, enc srcEncoding, src, dst string, nodirs, stk, ove
 	if err != nil {
 		return err
 	}
+	p.SetSourceFile(file)
 	id = p.Book.ID.String() // store for reference in the log
 
 	if err = p.Process(); err != nil {
@@ -56,6 +59,10 @@ func processBook(r io.Reader, enc srcEncoding, src, dst string, nodirs, stk, ove
 	// store convertion result
 	env.Rpt.Store(fmt.Sprintf("fb2c-%s/%s", id, filepath.Base(fname)), fname)
 
+	if err = p.Deliver(fname); err != nil {
+		// book was converted, failing delivery should not prevent it from being sent
+		env.Log.Error("Unable to deliver book", zap.String("file", fname), zap.Error(err))
+	}
 	if err = p.SendToKindle(fname); err != nil {
 		return err
 	}
@@ -94,7 +101,7 @@ func processDir(dir string, format processor.OutputFmt, nodirs, stk, overwrite b
 				} else {
 					defer file.Close()
 					if err := processBook(file, enc,
-						strings.TrimPrefix(strings.TrimPrefix(path, dir), string(filepath.Separator)), dst,
+						strings.TrimPrefix(strings.TrimPrefix(path, dir), string(filepath.Separator)), path, dst,
 						nodirs, stk, overwrite, format, env); err != nil {
 
 						env.Log.Error("Unable to process file", zap.String("file", path), zap.Error(err))
@@ -145,7 +152,7 @@ func processArchive(path, pathIn, pathOut string, format processor.OutputFmt, no
 						env.Log.Warn("Unable to convert archive name from specified encoding", zap.String("charset", n), zap.String("path", apath), zap.Error(err))
 					}
 				}
-				if err := processBook(r, enc, filepath.Join(pathOut, apath), dst, nodirs, stk, overwrite, format, env); err != nil {
+				if err := processBook(r, enc, filepath.Join(pathOut, apath), "", dst, nodirs, stk, overwrite, format, env); err != nil {
 					env.Log.Error("Unable to process file in archive",
 						zap.String("archive", archive),
 						zap.String("file", f.FileHeader.Name),
@@ -217,6 +224,10 @@ func Convert(ctx *cli.Context) (err error) {
 	nodirs := ctx.Bool("nodirs")
 	overwrite := ctx.Bool("ow")
 
+	if err := validateConfig(env); err != nil {
+		return cli.Exit(fmt.Errorf("%sconfiguration is not valid, run \"checkconfig\" command for details: %w", errPrefix, err), errCode)
+	}
+
 	if !env.Cfg.Doc.ChapterPerFile && (env.Cfg.Doc.PagesPerFile != math.MaxInt32 || len(env.Cfg.Doc.ChapterDividers) > 0) {
 		env.Log.Warn("With chapter_per_file=false settings to control resulting content size (ex: pages_per_file, chapter_subtitle_dividers) will be ignored")
 	}
@@ -243,10 +254,42 @@ func Convert(ctx *cli.Context) (err error) {
 		env.Log.Warn("Send to Kindle could only be used with epub output format, turning off", zap.Stringer("format", format))
 		stk = false
 	}
+	if stk {
+		mailer, err := delivery.NewMailer(env.Cfg, env.Log, env.Rpt)
+		if err != nil {
+			env.Log.Warn("Send to Kindle is not possible, turning off", zap.Any("configuration", env.Cfg.SMTPConfig), zap.Error(err))
+			stk = false
+		} else {
+			env.Stk = mailer
+			defer func() {
+				// books may be waiting for the batch to be filled
+				if err := mailer.Close(); err != nil {
+					env.Log.Error("Send to Kindle failed", zap.Error(err))
+				}
+			}()
+		}
+	}
 	if stk {
 		env.Cfg.Doc.Cover.Convert = true
 	}
 
+	if len(env.Cfg.Targets) > 0 && !ctx.Bool("nodeliver") {
+		targets, err := delivery.NewTargets(env.Cfg, env.Log)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+		}
+		if stk {
+			// mailer sends books later, they have to stay in place until then
+			targets.Keep = true
+			for _, t := range env.Cfg.Targets {
+				if t.DeleteOnSuccess {
+					env.Log.Warn("Books are sent to Kindle, delete_delivered_book is ignored", zap.Stringer("target", t))
+				}
+			}
+		}
+		env.Dlv = targets
+	}
+
 	env.Log.Info("Processing starting", zap.String("source", src), zap.String("destination", dst), zap.Stringer("format", format))
 	defer func(start time.Time) {
 		env.Log.Info("Processing completed", zap.Duration("elapsed", time.Since(start)))
@@ -306,7 +349,7 @@ func Convert(ctx *cli.Context) (err error) {
 					env.Log.Error("Unable to process file", zap.String("file", head), zap.Error(err))
 				} else {
 					defer file.Close()
-					if err := processBook(file, enc, filepath.Base(head), dst, nodirs, stk, overwrite, format, env); err != nil {
+					if err := processBook(file, enc, filepath.Base(head), head, dst, nodirs, stk, overwrite, format, env); err != nil {
 						env.Log.Error("Unable to process file", zap.String("file", head), zap.Error(err))
 					}
 				}
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fb2converter/processor"
+)
+
+type failingDeliverer struct{ calls int }
+
+func (d *failingDeliverer) Deliver(fname, root, format string) error {
+	d.calls++
+	return errors.New("webdav is down")
+}
+
+type recordingSender struct{ books []string }
+
+func (s *recordingSender) Add(fname, root string) error {
+	s.books = append(s.books, fname)
+	return nil
+}
+
+// failing delivery target must not prevent book from being sent to Kindle.
+func TestProcessBookDeliveryFailure(t *testing.T) {
+
+	env, buf := testEnv(t)
+	dlv, stk := &failingDeliverer{}, &recordingSender{}
+	env.Dlv, env.Stk = dlv, stk
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "book.fb2")
+	writeFB2(t, src, "Title", "text")
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dst := filepath.Join(dir, "out")
+	if err := processBook(f, encUTF8, "book.fb2", src, dst, false, true, false, processor.OEpub, env); err != nil {
+		t.Fatalf("converted book was reported as failed: %v", err)
+	}
+	if dlv.calls != 1 {
+		t.Errorf("delivery was attempted %d times", dlv.calls)
+	}
+	if len(stk.books) != 1 || filepath.Dir(stk.books[0]) != dst {
+		t.Errorf("book was not sent to Kindle: %v", stk.books)
+	}
+	if recs := logRecords(t, buf, "Unable to deliver book"); len(recs) != 1 {
+		t.Errorf("delivery failure was not logged: %v", recs)
+	}
+}
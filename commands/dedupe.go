@@ -0,0 +1,375 @@
+package commands
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// dupBook is a single book considered for duplicate detection.
+type dupBook struct {
+	Source  string                 `json:"source"`
+	Size    int64                  `json:"size"`
+	Match   []string               `json:"match,omitempty"` // how book matches preferred copy
+	FP      *processor.Fingerprint `json:"fingerprint"`
+	src     string
+	file    string
+	archive string
+}
+
+type dupCluster struct {
+	Preferred  *dupBook   `json:"preferred"`
+	Duplicates []*dupBook `json:"duplicates"`
+}
+
+// unionFind is a disjoint set of book indexes.
+type unionFind []int
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(i, j int) {
+	if i, j = u.find(i), u.find(j); i != j {
+		u[j] = i
+	}
+}
+
+// preferenceRules returns comparison functions for preference rules, each returns true when a is better than b and
+// false when it is worse, nil means rule cannot decide.
+func preferenceRules(names string) ([]func(a, b *dupBook) *bool, error) {
+
+	decide := func(better, worse bool) *bool {
+		if better || worse {
+			return &better
+		}
+		return nil
+	}
+
+	var rules []func(a, b *dupBook) *bool
+	for _, name := range strings.Split(names, ";") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "version":
+			rules = append(rules, func(a, b *dupBook) *bool { return decide(a.FP.Version > b.FP.Version, a.FP.Version < b.FP.Version) })
+		case "cover":
+			rules = append(rules, func(a, b *dupBook) *bool { return decide(a.FP.Cover && !b.FP.Cover, !a.FP.Cover && b.FP.Cover) })
+		case "size":
+			rules = append(rules, func(a, b *dupBook) *bool { return decide(a.Size > b.Size, a.Size < b.Size) })
+		case "text":
+			rules = append(rules, func(a, b *dupBook) *bool { return decide(a.FP.Words > b.FP.Words, a.FP.Words < b.FP.Words) })
+		case "unpacked":
+			rules = append(rules, func(a, b *dupBook) *bool {
+				return decide(len(a.archive) == 0 && len(b.archive) > 0, len(a.archive) > 0 && len(b.archive) == 0)
+			})
+		default:
+			return nil, fmt.Errorf("unknown preference rule %s", name)
+		}
+	}
+	return rules, nil
+}
+
+// findDuplicates groups books into clusters of duplicates, books without duplicates are not returned.
+func findDuplicates(books []*dupBook, byID, byTitle, byText bool, similarity float64) [][]*dupBook {
+
+	uf := make(unionFind, len(books))
+	for i := range uf {
+		uf[i] = i
+	}
+
+	ids, keys := make(map[string]int), make(map[string]int)
+	buckets := make(map[uint64][]int)
+	for i, b := range books {
+		if byID && len(b.FP.ID) > 0 {
+			if j, ok := ids[b.FP.ID]; ok {
+				uf.union(j, i)
+			} else {
+				ids[b.FP.ID] = i
+			}
+		}
+		if byTitle && len(b.FP.Key) > 0 {
+			if j, ok := keys[b.FP.Key]; ok {
+				uf.union(j, i)
+			} else {
+				keys[b.FP.Key] = i
+			}
+		}
+		if byText {
+			for _, band := range b.FP.Bands() {
+				for _, j := range buckets[band] {
+					if uf.find(i) != uf.find(j) && b.FP.Similarity(books[j].FP) >= similarity {
+						uf.union(j, i)
+					}
+				}
+				buckets[band] = append(buckets[band], i)
+			}
+		}
+	}
+
+	groups := make(map[int][]*dupBook)
+	var order []int
+	for i, b := range books {
+		root := uf.find(i)
+		if _, ok := groups[root]; !ok {
+			order = append(order, root)
+		}
+		groups[root] = append(groups[root], b)
+	}
+	var res [][]*dupBook
+	for _, root := range order {
+		if len(groups[root]) > 1 {
+			res = append(res, groups[root])
+		}
+	}
+	return res
+}
+
+// describeMatch explains why book is considered duplicate of preferred one.
+func describeMatch(pref, b *dupBook) []string {
+	var res []string
+	if len(b.FP.ID) > 0 && b.FP.ID == pref.FP.ID {
+		res = append(res, "id")
+	}
+	if len(b.FP.Key) > 0 && b.FP.Key == pref.FP.Key {
+		res = append(res, "title")
+	}
+	if s := b.FP.Similarity(pref.FP); s > 0 {
+		res = append(res, fmt.Sprintf("text %.0f%%", s*100))
+	}
+	return res
+}
+
+// writeDuplicates prints clusters in human readable form.
+func writeDuplicates(w io.Writer, clusters []*dupCluster) {
+
+	describe := func(b *dupBook) string {
+		var props []string
+		if b.FP.Version > 0 {
+			props = append(props, fmt.Sprintf("version %g", b.FP.Version))
+		}
+		if b.FP.Cover {
+			props = append(props, "cover")
+		}
+		props = append(props, fmt.Sprintf("%d words", b.FP.Words), fmt.Sprintf("%d bytes", b.Size))
+		return fmt.Sprintf("%s (%s)", b.Source, strings.Join(props, ", "))
+	}
+
+	for i, c := range clusters {
+		fmt.Fprintf(w, "Group %d:\n", i+1)
+		fmt.Fprintf(w, "  + %s\n", describe(c.Preferred))
+		for _, d := range c.Duplicates {
+			fmt.Fprintf(w, "  - %s [%s]\n", describe(d), strings.Join(d.Match, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// archiveEntries returns number of files in archive.
+func archiveEntries(fname string) (int, error) {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	count := 0
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Dedupe is "dedupe" command body.
+func Dedupe(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "dedupe: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 1 {
+		env.Log.Warn("Mailformed command line, too many sources", zap.Strings("ignoring", ctx.Args().Slice()[1:]))
+	}
+
+	var byID, byTitle, byText bool
+	for _, m := range strings.Split(ctx.String("match"), ";") {
+		switch strings.ToLower(strings.TrimSpace(m)) {
+		case "":
+		case "id":
+			byID = true
+		case "title":
+			byTitle = true
+		case "text":
+			byText = true
+		default:
+			return cli.Exit(fmt.Errorf("%sunknown match criteria %s, should be id, title or text", errPrefix, m), errCode)
+		}
+	}
+	if !byID && !byTitle && !byText {
+		return cli.Exit(errors.New(errPrefix+"no match criteria has been specified"), errCode)
+	}
+	similarity := ctx.Float64("similarity")
+	if similarity <= 0 || similarity > 1 {
+		return cli.Exit(fmt.Errorf("%ssimilarity should be in (0, 1] range: %g", errPrefix, similarity), errCode)
+	}
+	rules, err := preferenceRules(ctx.String("prefer"))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	action := strings.ToLower(ctx.String("action"))
+	dest := ctx.String("to")
+	switch action {
+	case "report", "delete":
+	case "move":
+		if len(dest) == 0 {
+			return cli.Exit(errors.New(errPrefix+"destination for duplicates has not been specified"), errCode)
+		}
+		if dest, err = filepath.Abs(dest); err != nil {
+			return cli.Exit(fmt.Errorf("%snormalizing destination path failed: %w", errPrefix, err), errCode)
+		}
+	default:
+		return cli.Exit(fmt.Errorf("%sunknown action %s, should be report, move or delete", errPrefix, action), errCode)
+	}
+
+	var books []*dupBook
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		if b.kind != kindFB2 {
+			return nil
+		}
+		r, err := b.open()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		defer r.Close()
+
+		p, err := processor.NewFB2(selectReader(r, b.enc), b.enc == encUnknown, b.src, "", false, false, true, processor.OEpub, env)
+		if err != nil {
+			env.Log.Error("Unable to parse book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		defer p.Clean()
+		if len(b.file) > 0 {
+			p.SetSourceFile(b.file)
+		}
+		fp, err := p.Fingerprint()
+		if err != nil {
+			env.Log.Error("Unable to fingerprint book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		books = append(books, &dupBook{Source: b.location(), Size: b.size, FP: fp, src: b.src, file: b.file, archive: b.archive})
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	var clusters []*dupCluster
+	for _, group := range findDuplicates(books, byID, byTitle, byText, similarity) {
+		sort.SliceStable(group, func(i, j int) bool {
+			for _, rule := range rules {
+				if better := rule(group[i], group[j]); better != nil {
+					return *better
+				}
+			}
+			return false
+		})
+		c := &dupCluster{Preferred: group[0], Duplicates: group[1:]}
+		for _, d := range c.Duplicates {
+			d.Match = describeMatch(c.Preferred, d)
+		}
+		clusters = append(clusters, c)
+	}
+
+	if ctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if clusters == nil {
+			clusters = []*dupCluster{}
+		}
+		if err := enc.Encode(clusters); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to write results: %w", errPrefix, err), errCode)
+		}
+	} else {
+		writeDuplicates(os.Stdout, clusters)
+	}
+
+	var removed int
+	if action != "report" {
+		handled := make(map[string]bool)
+		for _, c := range clusters {
+			for _, d := range c.Duplicates {
+				fname, rel := d.file, d.src
+				if len(d.archive) > 0 {
+					// archive could be removed only when it contains nothing else
+					if n, err := archiveEntries(d.archive); err != nil || n != 1 {
+						env.Log.Warn("Duplicate is in archive with other files, skipping", zap.String("file", d.Source), zap.Error(err))
+						continue
+					}
+					fname, rel = d.archive, filepath.Join(filepath.Dir(d.src), filepath.Base(d.archive))
+				}
+				if handled[fname] {
+					continue
+				}
+				handled[fname] = true
+
+				if action == "delete" {
+					err = os.Remove(fname)
+				} else {
+					to := filepath.Join(dest, rel)
+					if err = os.MkdirAll(filepath.Dir(to), 0755); err == nil {
+						if _, err := os.Lstat(to); err == nil {
+							to = uniqueName(to, func(name string) bool {
+								_, err := os.Lstat(name)
+								return err == nil
+							})
+						}
+						err = moveFile(fname, to)
+					}
+				}
+				if err != nil {
+					env.Log.Error("Unable to "+action+" duplicate", zap.String("file", fname), zap.Error(err))
+					continue
+				}
+				removed++
+				env.Log.Debug("Duplicate removed", zap.String("action", action), zap.String("file", fname))
+			}
+		}
+	}
+
+	var dups int
+	for _, c := range clusters {
+		dups += len(c.Duplicates)
+	}
+	env.Log.Info("Duplicates found", zap.Int("books", len(books)), zap.Int("groups", len(clusters)), zap.Int("duplicates", dups), zap.Int("removed", removed))
+	return nil
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"fb2converter/processor"
+)
+
+// writeVolume creates book which is part of a series, all volumes share title.
+func writeVolume(t *testing.T, fname, id, lang string, num int, text string) {
+	if err := os.WriteFile(fname, []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><genre>prose_classic</genre><author><first-name>Лев</first-name><last-name>Толстой</last-name></author>
+<book-title>Собрание сочинений</book-title><lang>%s</lang><sequence name="Собрание сочинений в 22 томах" number="%d"/></title-info>
+<document-info><id>%s</id></document-info></description>
+<body><section><p>%s</p></section></body></FictionBook>
+`, lang, num, id, text)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindDuplicatesVolumes(t *testing.T) {
+
+	env, _ := testEnv(t)
+	dir := t.TempDir()
+
+	words := func(prefix string) string {
+		var res []string
+		for i := 0; i < 300; i++ {
+			res = append(res, fmt.Sprintf("%s%d", prefix, i))
+		}
+		return strings.Join(res, " ")
+	}
+	for _, v := range []struct {
+		name, id, lang string
+		num            int
+		text           string
+	}{
+		{"vol1.fb2", "id1", "ru", 1, words("первый")},
+		{"vol2.fb2", "id2", "ru", 2, words("второй")},
+		{"vol3.fb2", "id3", "ru", 3, words("третий")},
+		// same volume from another source, only title and series match
+		{"vol1-copy.fb2", "id4", "ru", 1, words("другой")},
+		// translation is a different book
+		{"vol1-en.fb2", "id5", "en", 1, words("english")},
+	} {
+		writeVolume(t, filepath.Join(dir, v.name), v.id, v.lang, v.num, v.text)
+	}
+
+	var books []*dupBook
+	for _, name := range []string{"vol1.fb2", "vol2.fb2", "vol3.fb2", "vol1-copy.fb2", "vol1-en.fb2"} {
+		fname := filepath.Join(dir, name)
+		f, err := os.Open(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := processor.NewFB2(f, false, fname, "", false, false, true, processor.OEpub, env)
+		if err != nil {
+			f.Close()
+			t.Fatal(err)
+		}
+		fp, err := p.Fingerprint()
+		p.Clean()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		books = append(books, &dupBook{Source: name, FP: fp})
+	}
+
+	groups := findDuplicates(books, true, true, true, 0.8)
+	var res []string
+	for _, g := range groups {
+		var names []string
+		for _, b := range g {
+			names = append(names, b.Source)
+		}
+		sort.Strings(names)
+		res = append(res, strings.Join(names, " "))
+	}
+	if len(res) != 1 || res[0] != "vol1-copy.fb2 vol1.fb2" {
+		t.Errorf("unexpected duplicates: %q", res)
+	}
+}
@@ -0,0 +1,233 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// collectionsFor returns names of collections book belongs to according to grouping rules.
+func collectionsFor(info *processor.BookInfo, by []string) []string {
+
+	var res []string
+	for _, g := range by {
+		switch g {
+		case "series":
+			for _, s := range info.Series {
+				if name := strings.TrimSpace(s.Name); len(name) > 0 {
+					res = append(res, name)
+					break
+				}
+			}
+		case "author":
+			for _, a := range info.Authors {
+				if a = strings.TrimSpace(a); len(a) > 0 {
+					res = append(res, a)
+				}
+			}
+		case "genre":
+			genres := info.GenreNames
+			if len(genres) == 0 {
+				genres = info.Genres
+			}
+			for _, g := range genres {
+				if g = strings.TrimSpace(g); len(g) > 0 {
+					res = append(res, g)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// DeviceSync is "devicesync" command body.
+func DeviceSync(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "devicesync: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	dst := ctx.Args().Get(1)
+	if len(dst) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no device has been specified"), errCode)
+	}
+	if dst, err = filepath.Abs(dst); err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing device path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 2 {
+		env.Log.Warn("Mailformed command line, too many arguments", zap.Strings("ignoring", ctx.Args().Slice()[2:]))
+	}
+
+	format := processor.ParseFmtString(ctx.String("to"))
+	if format != processor.OAzw3 && format != processor.OMobi {
+		return cli.Exit(fmt.Errorf("%sunsupported output format %q, should be one of: %s, %s", errPrefix, ctx.String("to"), processor.OAzw3, processor.OMobi), errCode)
+	}
+	var groups []string
+	for _, g := range strings.Split(ctx.String("collections"), ";") {
+		switch g = strings.ToLower(strings.TrimSpace(g)); g {
+		case "", "none":
+		case "series", "author", "genre":
+			groups = append(groups, g)
+		default:
+			return cli.Exit(fmt.Errorf("%sunknown collection grouping %q, should be one of: series, author, genre, none", errPrefix, g), errCode)
+		}
+	}
+	overwrite := ctx.Bool("ow")
+
+	if err := validateConfig(env); err != nil {
+		return cli.Exit(fmt.Errorf("%sconfiguration is not valid, run \"checkconfig\" command for details: %w", errPrefix, err), errCode)
+	}
+
+	dev, err := processor.NewKindleDevice(dst, env.Log)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	docs := dev.Documents()
+
+	var (
+		synced                  []string
+		collections             = make(map[string][]string)
+		converted, copied, kept int
+	)
+	env.Log.Info("Device synchronization starting", zap.String("source", src), zap.String("device", dst), zap.Stringer("format", format))
+	defer func(start time.Time) {
+		env.Log.Info("Device synchronization completed", zap.Duration("elapsed", time.Since(start)),
+			zap.Int("converted", converted), zap.Int("copied", copied), zap.Int("unchanged", kept))
+	}(time.Now())
+
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		var (
+			fname string
+			info  *processor.BookInfo
+		)
+		switch b.kind {
+		case kindFB2:
+			r, err := b.open()
+			if err != nil {
+				env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+			d, err := processor.ReadDescription(selectReader(r, b.enc), b.enc == encUnknown, b.src, b.file, false, env)
+			r.Close()
+			if err != nil {
+				env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+			fname, info = d.ConvertedName(docs, false, format), d.BookInfo
+
+			if !overwrite && fileExists(fname) {
+				env.Log.Debug("Book is already on device", zap.String("file", fname))
+				kept++
+				break
+			}
+			if r, err = b.open(); err == nil {
+				err = processBook(r, b.enc, b.src, b.file, docs, false, false, true, format, env)
+				r.Close()
+			}
+			if err != nil {
+				env.Log.Error("Conversion has failed", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+			converted++
+
+		case kindKindle:
+			data, err := b.readAll()
+			if err != nil {
+				env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+			if info, err = processor.InspectKindle(data); err != nil {
+				env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+			fname = processor.OrganizedNameFromInfo(info, b.src, docs, false, bookExtension(b.src), env)
+
+			if sameContent(fname, data) || (!overwrite && fileExists(fname)) {
+				env.Log.Debug("Book is already on device", zap.String("file", fname))
+				kept++
+				break
+			}
+			if err := os.MkdirAll(filepath.Dir(fname), 0755); err == nil {
+				err = writeFile(fname, 0644, bytes.NewReader(data))
+			}
+			if err != nil {
+				env.Log.Error("Unable to copy book", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+			env.Log.Info("Book copied", zap.String("from", b.location()), zap.String("to", fname))
+			copied++
+
+		default:
+			env.Log.Debug("Skipping file, not FB2 or Kindle book", zap.String("file", b.location()))
+			return nil
+		}
+
+		synced = append(synced, fname)
+		for _, name := range collectionsFor(info, groups) {
+			collections[name] = append(collections[name], fname)
+		}
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	width, height, stretch := ctx.Int("width"), ctx.Int("height"), ctx.Bool("stretch")
+	for _, fname := range synced {
+		if _, err := processor.ProduceThumbnail(fname, dev.Thumbnails(), width, height, stretch, env.Log); err != nil {
+			env.Log.Warn("Unable to create thumbnail", zap.String("file", fname), zap.Error(err))
+		}
+	}
+
+	books, err := dev.Books()
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to list books on device: %w", errPrefix, err), errCode)
+	}
+	dev.TrackThumbnails(synced, books)
+	prune := ctx.Bool("prune")
+	if prune {
+		removed, err := dev.RemoveOrphanThumbnails(books)
+		if err != nil {
+			env.Log.Error("Unable to remove orphan thumbnails", zap.Error(err))
+		} else if len(removed) > 0 {
+			env.Log.Info("Orphan thumbnails removed", zap.Int("count", len(removed)))
+		}
+	}
+	if len(groups) > 0 || prune {
+		if err := dev.UpdateCollections(collections, books, prune); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to update collections: %w", errPrefix, err), errCode)
+		}
+		env.Log.Info("Collections updated", zap.Int("collections", len(collections)))
+	}
+	if err := dev.Save(); err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to save synchronization state: %w", errPrefix, err), errCode)
+	}
+	return nil
+}
+
+func fileExists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
+}
@@ -0,0 +1,191 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+type bookInfo struct {
+	Source string `json:"source"`
+	Size   int64  `json:"size"`
+	*processor.BookInfo
+}
+
+// inspectBook collects information for a single book of any supported kind.
+func inspectBook(b *bookSource, nodirs bool, format processor.OutputFmt, env *state.LocalEnv) (*processor.BookInfo, error) {
+
+	if b.kind == kindFB2 {
+		r, err := b.open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		p, err := processor.NewFB2(selectReader(r, b.enc), b.enc == encUnknown, b.src, "", nodirs, false, true, format, env)
+		if err != nil {
+			return nil, err
+		}
+		defer p.Clean()
+
+		p.SetSourceFile(b.file)
+		return p.Inspect()
+	}
+
+	data, err := b.readAll()
+	if err != nil {
+		return nil, err
+	}
+	switch b.kind {
+	case kindEPUB:
+		return processor.InspectEPUB(bytes.NewReader(data), int64(len(data)))
+	case kindKindle:
+		return processor.InspectKindle(data)
+	default:
+		return nil, errors.New("unsupported book format")
+	}
+}
+
+// writeInfo prints book information in human readable form.
+func writeInfo(w io.Writer, bi *bookInfo) {
+
+	line := func(name string, value any) {
+		switch v := value.(type) {
+		case string:
+			if len(v) == 0 {
+				return
+			}
+		case []string:
+			if len(v) == 0 {
+				return
+			}
+			value = strings.Join(v, "; ")
+		}
+		fmt.Fprintf(w, "  %-12s %v\n", name+":", value)
+	}
+
+	fmt.Fprintln(w, bi.Source)
+	line("Format", bi.Format)
+	line("Size", bi.Size)
+	line("ID", bi.ID)
+	line("Title", bi.Title)
+	line("Authors", bi.Authors)
+	line("Translators", bi.Translators)
+	for _, s := range bi.Series {
+		v := s.Name
+		if s.Num > 0 {
+			v = fmt.Sprintf("%s #%d", s.Name, s.Num)
+		}
+		if s.Level > 0 {
+			v += fmt.Sprintf(" (level %d)", s.Level)
+		}
+		if s.Publisher {
+			v += " (publisher)"
+		}
+		line("Series", v)
+	}
+	line("Genres", bi.Genres)
+	line("Genre names", bi.GenreNames)
+	line("Language", bi.Language)
+	line("Date", bi.Date)
+	line("Publisher", bi.Publisher)
+	line("ISBN", bi.ISBN)
+	line("Cover", bi.Cover)
+	line("Images", bi.Images)
+	for _, b := range bi.Bodies {
+		name := b.Name
+		if len(name) == 0 {
+			name = "main"
+		}
+		if b.Notes {
+			name += " (notes)"
+		}
+		line("Body", fmt.Sprintf("%s, sections: %d, characters: %d", name, b.Sections, b.Size))
+	}
+	line("Output name", bi.OutputName)
+	if k := bi.Kindle; k != nil {
+		line("ASIN", k.ASIN)
+		line("CDE type", k.CDEType)
+		line("CDE key", k.CDEKey)
+		line("MOBI header", k.Version)
+		if k.KF8Offset >= 0 {
+			line("KF8 offset", k.KF8Offset)
+		}
+		line("Encrypted", k.Encrypted)
+	}
+	fmt.Fprintln(w)
+}
+
+// Info is "info" command body.
+func Info(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "info: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 1 {
+		env.Log.Warn("Mailformed command line, too many sources", zap.Strings("ignoring", ctx.Args().Slice()[1:]))
+	}
+
+	format := processor.ParseFmtString(ctx.String("to"))
+	if format == processor.UnsupportedOutputFmt {
+		env.Log.Warn("Unknown output format requested, switching to epub", zap.String("format", ctx.String("to")))
+		format = processor.OEpub
+	}
+	nodirs := ctx.Bool("nodirs")
+	asJSON := ctx.Bool("json")
+
+	var books []*bookInfo
+	err = walkSources(src, env, func(b *bookSource) error {
+		info, err := inspectBook(b, nodirs, format, env)
+		if err != nil {
+			env.Log.Warn("Unable to inspect book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		bi := &bookInfo{Source: b.location(), Size: b.size, BookInfo: info}
+		if asJSON {
+			books = append(books, bi)
+		} else {
+			writeInfo(os.Stdout, bi)
+		}
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if books == nil {
+			books = []*bookInfo{}
+		}
+		if err := enc.Encode(books); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to write results: %w", errPrefix, err), errCode)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// KindleMeta is "kindlemeta" command body.
+func KindleMeta(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "kindlemeta: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 1 {
+		env.Log.Warn("Mailformed command line, too many sources", zap.Strings("ignoring", ctx.Args().Slice()[1:]))
+	}
+
+	mi, err := metaFromFlags(ctx)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	meta := &processor.KindleMeta{
+		MetaInfo:  *mi,
+		Publisher: ctx.String("publisher"),
+		CDEType:   ctx.String("cdetype"),
+	}
+	if asin := ctx.String("asin"); len(asin) > 0 {
+		meta.ASIN = asin
+	}
+	if len(meta.Title) == 0 && len(meta.Authors) == 0 && len(meta.Lang) == 0 && len(meta.ASIN) == 0 && len(meta.CoverImage) == 0 &&
+		len(meta.SeqName) == 0 && meta.SeqNum == 0 && len(meta.Publisher) == 0 && len(meta.CDEType) == 0 {
+		return cli.Exit(errors.New(errPrefix+"nothing to change, no meta information has been specified"), errCode)
+	}
+
+	count := 0
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		if b.kind != kindKindle {
+			env.Log.Debug("Skipping file, not Kindle book", zap.String("file", b.location()))
+			return nil
+		}
+		if len(b.archive) > 0 {
+			env.Log.Warn("Skipping Kindle book in archive", zap.String("file", b.location()))
+			return nil
+		}
+		data, err := b.readAll()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+
+		_, err = writeSafely(b.file, func(w io.Writer) (bool, error) {
+			res, err := processor.EditKindle(data, b.src, meta, env)
+			if err != nil {
+				return false, err
+			}
+			_, err = w.Write(res)
+			return err == nil, err
+		})
+		if err != nil {
+			env.Log.Error("Unable to update book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		count++
+		env.Log.Info("Book updated", zap.String("file", b.location()))
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	env.Log.Info("Meta information updated", zap.Int("books", count))
+	return nil
+}
@@ -0,0 +1,210 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/opds"
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// opdsDir is where static catalog is placed inside converted library.
+const opdsDir = "opds"
+
+// parseFormats converts ";" separated list of output formats, empty list means all known formats.
+func parseFormats(list string) ([]processor.OutputFmt, error) {
+	var res []processor.OutputFmt
+	for _, name := range strings.Split(list, ";") {
+		if name = strings.TrimSpace(name); len(name) == 0 {
+			continue
+		}
+		f := processor.ParseFmtString(name)
+		if f == processor.UnsupportedOutputFmt {
+			return nil, fmt.Errorf("unknown output format %s", name)
+		}
+		res = append(res, f)
+	}
+	if len(res) == 0 {
+		for _, name := range processor.Formats() {
+			res = append(res, processor.ParseFmtString(name))
+		}
+	}
+	return res, nil
+}
+
+// opdsBook makes catalog entry from book description.
+func opdsBook(info *processor.Description) *opds.Book {
+	b := &opds.Book{
+		ID:         info.ID,
+		Title:      info.Title,
+		Authors:    info.Authors,
+		Language:   info.Language,
+		Date:       info.Date,
+		Annotation: info.Annotation,
+	}
+	if len(info.Series) > 0 {
+		b.Series, b.Number = info.Series[0].Name, info.Series[0].Num
+	}
+	for i, g := range info.Genres {
+		c := opds.Category{Term: g}
+		if i < len(info.GenreNames) && info.GenreNames[i] != g {
+			c.Label = info.GenreNames[i]
+		}
+		b.Genres = append(b.Genres, c)
+	}
+	return b
+}
+
+// Opds is "opds" command body.
+func Opds(ctx *cli.Context) (err error) {
+
+	const (
+		errPrefix = "opds: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	if src, err = filepath.Abs(src); err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	dst := ctx.Args().Get(1)
+	if len(dst) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no converted library has been specified"), errCode)
+	}
+	if dst, err = filepath.Abs(dst); err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing destination path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 2 {
+		env.Log.Warn("Mailformed command line, too many arguments", zap.Strings("ignoring", ctx.Args().Slice()[2:]))
+	}
+
+	formats, err := parseFormats(ctx.String("formats"))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	nodirs := ctx.Bool("nodirs")
+
+	root := filepath.Join(dst, opdsDir)
+	for _, dir := range []string{"covers", "thumbnails"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to create catalog directory: %w", errPrefix, err), errCode)
+		}
+	}
+
+	var books []*opds.Book
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		if b.kind != kindFB2 {
+			return nil
+		}
+		r, err := b.open()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		defer r.Close()
+
+		info, err := processor.ReadDescription(selectReader(r, b.enc), b.enc == encUnknown, b.src, b.file, true, env)
+		if err != nil {
+			env.Log.Warn("Unable to read book description", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+
+		book := opdsBook(info)
+		for _, f := range formats {
+			fname := info.ConvertedName(dst, nodirs, f)
+			fi, err := os.Stat(fname)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			rel, err := filepath.Rel(dst, fname)
+			if err != nil {
+				continue
+			}
+			book.Files = append(book.Files, opds.File{Href: path.Join("..", filepath.ToSlash(rel)), Type: opds.MediaType(fname)})
+			if fi.ModTime().After(book.Updated) {
+				book.Updated = fi.ModTime()
+			}
+		}
+		if len(book.Files) == 0 {
+			env.Log.Debug("Book has not been converted, skipping", zap.String("file", b.location()))
+			return nil
+		}
+
+		if len(info.CoverImage) > 0 {
+			n := len(books) + 1
+			cover := fmt.Sprintf("covers/%d%s", n, imageExtension(info.CoverImage))
+			if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(cover)), info.CoverImage, 0644); err != nil {
+				env.Log.Warn("Unable to save cover image", zap.String("file", b.location()), zap.Error(err))
+			} else {
+				book.Cover = cover
+			}
+			thumb := fmt.Sprintf("thumbnails/%d.jpg", n)
+			if err := saveThumbnail(filepath.Join(root, filepath.FromSlash(thumb)), info.CoverImage); err != nil {
+				env.Log.Warn("Unable to create cover thumbnail", zap.String("file", b.location()), zap.Error(err))
+			} else {
+				book.Thumbnail = thumb
+			}
+		}
+		books = append(books, book)
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	title := ctx.String("title")
+	if len(title) == 0 {
+		title = filepath.Base(dst)
+	}
+	c := &opds.Catalog{Title: title, ID: "urn:fb2c:" + filepath.Base(dst), PageSize: ctx.Int("page-size"), Books: books}
+	var feeds int
+	err = c.Build(func(name string, f *opds.Feed) (err error) {
+		fname := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(fname)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := out.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		feeds++
+		return f.Write(out)
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to write catalog: %w", errPrefix, err), errCode)
+	}
+
+	env.Log.Info("OPDS catalog created", zap.String("location", filepath.Join(root, "index.xml")), zap.Int("books", len(books)), zap.Int("feeds", feeds))
+	return nil
+}
+
+// imageExtension guesses image file extension by its content.
+func imageExtension(data []byte) string {
+	switch {
+	case len(data) > 8 && string(data[1:4]) == "PNG":
+		return ".png"
+	case len(data) > 6 && string(data[:3]) == "GIF":
+		return ".gif"
+	default:
+		return ".jpg"
+	}
+}
@@ -0,0 +1,376 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/opds"
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// servedBook is a book available for download from OPDS server.
+type servedBook struct {
+	source  *bookSource
+	modTime time.Time                      // of book file or archive it is in
+	names   map[processor.OutputFmt]string // download file names
+}
+
+// opdsServer serves OPDS catalog of FB2 books converting them on download.
+type opdsServer struct {
+	env     *state.LocalEnv
+	books   []*servedBook
+	feeds   map[string]*bytes.Buffer
+	formats map[string]processor.OutputFmt
+	cache   *diskCache
+	work    string
+	cfgHash string
+
+	convert sync.Mutex // conversions are done one at a time
+}
+
+// book finds book by its index in request path like "/covers/12.jpg".
+func (s *opdsServer) book(name string) *servedBook {
+	n, err := strconv.Atoi(strings.TrimSuffix(name, filepath.Ext(name)))
+	if err != nil || n < 1 || n > len(s.books) {
+		return nil
+	}
+	return s.books[n-1]
+}
+
+// cover reads book cover image.
+func (s *opdsServer) cover(b *servedBook) ([]byte, error) {
+	r, err := b.source.reopen()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	info, err := processor.ReadDescription(selectReader(r, b.source.enc), b.source.enc == encUnknown, b.source.src, b.source.file, true, s.env)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.CoverImage) == 0 {
+		return nil, errors.New("book has no cover")
+	}
+	return info.CoverImage, nil
+}
+
+func (s *opdsServer) serveFeed(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/opds/")
+	if len(name) == 0 {
+		name = "index.xml"
+	}
+	feed, ok := s.feeds[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml;profile=opds-catalog;charset=utf-8")
+	_, _ = w.Write(feed.Bytes())
+}
+
+func (s *opdsServer) serveImage(w http.ResponseWriter, r *http.Request) {
+
+	thumbnail := strings.HasPrefix(r.URL.Path, "/thumbnails/")
+	b := s.book(filepath.Base(r.URL.Path))
+	if b == nil {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := s.cover(b)
+	if err != nil {
+		s.env.Log.Warn("Unable to read cover image", zap.String("file", b.source.location()), zap.Error(err))
+		http.NotFound(w, r)
+		return
+	}
+	if thumbnail {
+		img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+		if err != nil {
+			s.env.Log.Warn("Unable to decode cover image", zap.String("file", b.source.location()), zap.Error(err))
+			http.NotFound(w, r)
+			return
+		}
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, imaging.Fit(img, 240, 360, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(80)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+	}
+	http.ServeContent(w, r, filepath.Base(r.URL.Path), b.modTime, bytes.NewReader(data))
+}
+
+// serveBook handles "/books/N/FORMAT" requests converting book when it is not in cache.
+func (s *opdsServer) serveBook(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/books/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	b := s.book(parts[0])
+	format, ok := s.formats[strings.ToLower(parts[1])]
+	if b == nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%d\n%d\n%s\n%s", b.source.location(), b.source.size, b.modTime.UnixNano(), format, s.cfgHash)
+	key := hex.EncodeToString(h.Sum(nil)) + "." + format.Extension()
+
+	f, err := s.cache.open(key)
+	if err == nil && f == nil {
+		f, err = s.convertBook(b, format, key)
+	}
+	if err != nil {
+		s.env.Log.Error("Unable to convert book", zap.String("file", b.source.location()), zap.Stringer("format", format), zap.Error(err))
+		http.Error(w, "unable to convert book", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", opds.MediaType(b.names[format]))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": b.names[format]}))
+	http.ServeContent(w, r, "", b.modTime, f)
+}
+
+// convertBook converts book with processBook and puts result into cache.
+func (s *opdsServer) convertBook(b *servedBook, format processor.OutputFmt, key string) (*os.File, error) {
+
+	s.convert.Lock()
+	defer s.convert.Unlock()
+
+	// could have been converted while we were waiting
+	if f, err := s.cache.open(key); err != nil || f != nil {
+		return f, err
+	}
+
+	if err := os.RemoveAll(s.work); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.work, 0755); err != nil {
+		return nil, err
+	}
+	r, err := b.source.reopen()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if err := processBook(r, b.source.enc, b.source.src, b.source.file, s.work, true, false, true, format, s.env); err != nil {
+		return nil, err
+	}
+
+	// processBook does not tell where result is, but it is the only file produced
+	var result string
+	err = filepath.Walk(s.work, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			result = path
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, errors.New("conversion produced nothing")
+	}
+	if _, err := s.cache.put(key, result); err != nil {
+		return nil, err
+	}
+	return s.cache.open(key)
+}
+
+// index collects books from src and builds catalog feeds, it returns number of books found.
+func (s *opdsServer) index(src string, formats []processor.OutputFmt, title string, pageSize int) (int, error) {
+
+	env := s.env
+	var books []*opds.Book
+	err := walkSources(src, env, func(b *bookSource) error {
+
+		if b.kind != kindFB2 {
+			return nil
+		}
+		r, err := b.open()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		defer r.Close()
+
+		info, err := processor.ReadDescription(selectReader(r, b.enc), b.enc == encUnknown, b.src, b.file, true, env)
+		if err != nil {
+			env.Log.Warn("Unable to read book description", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+
+		file := b.file
+		if len(b.archive) > 0 {
+			file = b.archive
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			env.Log.Warn("Unable to access book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+		// nothing but book description is kept
+		b.open = nil
+
+		n := len(s.books) + 1
+		served := &servedBook{source: b, modTime: fi.ModTime(), names: make(map[processor.OutputFmt]string)}
+		book := opdsBook(info)
+		book.Updated = fi.ModTime()
+		for _, f := range formats {
+			served.names[f] = filepath.Base(info.ConvertedName("", true, f))
+			book.Files = append(book.Files, opds.File{Href: fmt.Sprintf("/books/%d/%s", n, f), Type: opds.MediaType(served.names[f])})
+		}
+		if len(info.CoverImage) > 0 {
+			book.Cover = fmt.Sprintf("/covers/%d%s", n, imageExtension(info.CoverImage))
+			book.Thumbnail = fmt.Sprintf("/thumbnails/%d.jpg", n)
+		}
+		s.books = append(s.books, served)
+		books = append(books, book)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	c := &opds.Catalog{Title: title, ID: "urn:fb2c:" + filepath.Base(src), PageSize: pageSize, Books: books}
+	err = c.Build(func(name string, f *opds.Feed) error {
+		buf := new(bytes.Buffer)
+		s.feeds[name] = buf
+		return f.Write(buf)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to build catalog: %w", err)
+	}
+	return len(books), nil
+}
+
+// handler routes requests to catalog feeds, cover images and books.
+func (s *opdsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/opds/", s.serveFeed)
+	mux.HandleFunc("/covers/", s.serveImage)
+	mux.HandleFunc("/thumbnails/", s.serveImage)
+	mux.HandleFunc("/books/", s.serveBook)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/opds/index.xml", http.StatusFound)
+	})
+	return mux
+}
+
+// OpdsServe is "opds-serve" command body.
+func OpdsServe(ctx *cli.Context) (err error) {
+
+	const (
+		errPrefix = "opds-serve: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	if src, err = filepath.Abs(src); err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 1 {
+		env.Log.Warn("Mailformed command line, too many sources", zap.Strings("ignoring", ctx.Args().Slice()[1:]))
+	}
+
+	formats, err := parseFormats(ctx.String("formats"))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	if err := validateConfig(env); err != nil {
+		return cli.Exit(fmt.Errorf("%sconfiguration is not valid, run \"checkconfig\" command for details: %w", errPrefix, err), errCode)
+	}
+
+	dir := ctx.String("cache-dir")
+	if len(dir) == 0 {
+		if dir, err = os.UserCacheDir(); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to find cache directory: %w", errPrefix, err), errCode)
+		}
+		dir = filepath.Join(dir, "fb2c", "opds")
+	}
+	if ctx.Int64("cache-size") <= 0 {
+		return cli.Exit(errors.New(errPrefix+"cache size should be positive"), errCode)
+	}
+	cache, err := newDiskCache(dir, ctx.Int64("cache-size")*1024*1024)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to prepare cache: %w", errPrefix, err), errCode)
+	}
+
+	// converted books depend on configuration, so it is part of the cache key
+	cfg, err := json.Marshal(env.Cfg)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to serialize configuration: %w", errPrefix, err), errCode)
+	}
+	sum := sha1.Sum(cfg)
+
+	s := &opdsServer{
+		env:     env,
+		feeds:   make(map[string]*bytes.Buffer),
+		formats: make(map[string]processor.OutputFmt),
+		cache:   cache,
+		work:    filepath.Join(dir, ".work"),
+		cfgHash: hex.EncodeToString(sum[:]),
+	}
+	for _, f := range formats {
+		s.formats[f.String()] = f
+	}
+
+	title := ctx.String("title")
+	if len(title) == 0 {
+		title = filepath.Base(src)
+	}
+	count, err := s.index(src, formats, title, ctx.Int("page-size"))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	srv := &http.Server{Addr: ctx.String("listen"), Handler: s.handler()}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		shutCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutCtx)
+	}()
+
+	env.Log.Info("OPDS server starting", zap.String("listen", srv.Addr), zap.Int("books", count), zap.String("cache", dir))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	env.Log.Info("OPDS server stopped")
+	return nil
+}
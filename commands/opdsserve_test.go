@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fb2converter/processor"
+)
+
+func TestOpdsServer(t *testing.T) {
+
+	src := t.TempDir()
+	var cover bytes.Buffer
+	if err := jpeg.Encode(&cover, image.NewGray(image.Rect(0, 0, 600, 800)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.fb2"), []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><genre>sf</genre><author><first-name>Ivan</first-name><last-name>Petrov</last-name></author>
+<book-title>Covered</book-title><coverpage><image l:href="#cover.jpg"/></coverpage><lang>en</lang></title-info>
+<document-info><id>covered</id></document-info></description>
+<body><section><p>text</p></section></body>
+<binary id="cover.jpg" content-type="image/jpeg">%s</binary></FictionBook>
+`, base64.StdEncoding.EncodeToString(cover.Bytes()))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFB2(t, filepath.Join(src, "b.fb2"), "Plain", "text")
+
+	env, _ := testEnv(t)
+	dir := t.TempDir()
+	cache, err := newDiskCache(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &opdsServer{
+		env:     env,
+		feeds:   make(map[string]*bytes.Buffer),
+		formats: map[string]processor.OutputFmt{"epub": processor.OEpub},
+		cache:   cache,
+		work:    filepath.Join(dir, ".work"),
+	}
+	count, err := s.index(src, []processor.OutputFmt{processor.OEpub}, "Library", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("%d books indexed, expected 2", count)
+	}
+
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	if resp, _ := get("/"); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/opds/index.xml" {
+		t.Errorf("unexpected root response: %s, %s", resp.Status, resp.Header.Get("Location"))
+	}
+	if resp, body := get("/opds/index.xml"); resp.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/atom+xml") || !bytes.Contains(body, []byte("<title>Library</title>")) {
+		t.Errorf("unexpected root feed: %s\n%s", resp.Status, body)
+	}
+	if resp, body := get("/opds/all.xml"); resp.StatusCode != http.StatusOK ||
+		!bytes.Contains(body, []byte(`href="/books/1/epub"`)) || !bytes.Contains(body, []byte(`href="/covers/1.jpg"`)) {
+		t.Errorf("unexpected acquisition feed: %s\n%s", resp.Status, body)
+	}
+
+	for path, status := range map[string]int{
+		"/opds/missing.xml": http.StatusNotFound,
+		"/covers/1.jpg":     http.StatusOK,
+		"/covers/2.jpg":     http.StatusNotFound, // book has no cover
+		"/thumbnails/1.jpg": http.StatusOK,
+		"/books/1/mobi":     http.StatusNotFound, // format is not offered
+		"/books/3/epub":     http.StatusNotFound,
+		"/books/x/epub":     http.StatusNotFound,
+		"/other":            http.StatusNotFound,
+	} {
+		if resp, _ := get(path); resp.StatusCode != status {
+			t.Errorf("%s: %s, expected %d", path, resp.Status, status)
+		}
+	}
+	resp, body := get("/thumbnails/1.jpg")
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(body)); err != nil || cfg.Width > 240 || cfg.Height > 360 {
+		t.Errorf("unexpected thumbnail: %+v, %v", cfg, err)
+	}
+
+	resp, body = get("/books/1/epub")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/epub+zip" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Disposition"), "attachment") || !bytes.HasPrefix(body, []byte("PK")) {
+		t.Fatalf("unexpected download: %s, %v", resp.Status, resp.Header)
+	}
+	if cache.order.Len() != 1 {
+		t.Errorf("converted book was not cached: %d", cache.order.Len())
+	}
+	// second download comes from cache, source is not needed
+	if err := os.Remove(filepath.Join(src, "a.fb2")); err != nil {
+		t.Fatal(err)
+	}
+	if resp, again := get("/books/1/epub"); resp.StatusCode != http.StatusOK || !bytes.Equal(again, body) {
+		t.Errorf("book was not served from cache: %s", resp.Status)
+	}
+	if resp, _ := get("/books/2/epub"); resp.StatusCode != http.StatusOK || cache.order.Len() != 2 {
+		t.Errorf("second book was not converted: %s", resp.Status)
+	}
+}
@@ -0,0 +1,417 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// organizeOp is a single undo log record. "extract" is a copy of the book from archive, "mkdir" is a directory created
+// in destination.
+type organizeOp struct {
+	Op          string `json:"op"`
+	From        string `json:"from,omitempty"`
+	To          string `json:"to"`
+	Overwritten bool   `json:"overwritten,omitempty"`
+}
+
+// bookExtension returns book file extension without leading dot, multi-part extensions are preserved.
+func bookExtension(name string) string {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".kepub.epub") {
+		return "kepub.epub"
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
+
+// sameContent checks if file has exactly the data.
+func sameContent(fname string, data []byte) bool {
+	fi, err := os.Stat(fname)
+	if err != nil || fi.Size() != int64(len(data)) {
+		return false
+	}
+	existing, err := os.ReadFile(fname)
+	return err == nil && bytes.Equal(existing, data)
+}
+
+// contentHash identifies book content without keeping it in memory.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uniqueName adds number to the file name until it does not collide with anything.
+func uniqueName(fname string, taken func(string) bool) string {
+	ext := filepath.Ext(fname)
+	if strings.HasSuffix(strings.ToLower(fname), ".kepub.epub") {
+		ext = fname[len(fname)-len(".kepub.epub"):]
+	}
+	base := strings.TrimSuffix(fname, ext)
+	for i := 1; ; i++ {
+		if name := fmt.Sprintf("%s (%d)%s", base, i, ext); !taken(name) {
+			return name
+		}
+	}
+}
+
+// moveFile renames file falling back to copy and remove when rename is not possible (different devices).
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	if err := copyFile(from, to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// copyFile copies file content and permissions.
+func copyFile(from, to string) error {
+	fi, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(to, fi.Mode().Perm(), in)
+}
+
+// writeFile creates file with content read from r.
+func writeFile(fname string, perm os.FileMode, r io.Reader) error {
+	out, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(fname)
+		return err
+	}
+	return out.Close()
+}
+
+// undoOrganize reverts operations recorded in undo log, in reverse order.
+func undoOrganize(fname string, dryRun bool, env *state.LocalEnv) error {
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	var ops []*organizeOp
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		op := &organizeOp{}
+		if err := json.Unmarshal(sc.Bytes(), op); err != nil {
+			f.Close()
+			return fmt.Errorf("bad undo log record at line %d: %w", n, err)
+		}
+		ops = append(ops, op)
+	}
+	f.Close()
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		if dryRun {
+			env.Log.Info("Would undo", zap.String("op", op.Op), zap.String("from", op.From), zap.String("to", op.To))
+			continue
+		}
+		var err error
+		switch op.Op {
+		case "copy", "extract", "link":
+			err = os.Remove(op.To)
+		case "move":
+			if _, err = os.Stat(op.From); err == nil {
+				err = errors.New("original location is occupied")
+			} else if err = os.MkdirAll(filepath.Dir(op.From), 0755); err == nil {
+				err = moveFile(op.To, op.From)
+			}
+		case "mkdir":
+			// only empty directories are removed
+			err = os.Remove(op.To)
+		default:
+			err = fmt.Errorf("unknown operation %s", op.Op)
+		}
+		if err != nil {
+			env.Log.Warn("Unable to undo", zap.String("op", op.Op), zap.String("file", op.To), zap.Error(err))
+			continue
+		}
+		if op.Overwritten {
+			env.Log.Warn("File was overwritten, previous content cannot be restored", zap.String("file", op.To))
+		}
+		env.Log.Debug("Undone", zap.String("op", op.Op), zap.String("from", op.From), zap.String("to", op.To))
+	}
+	return nil
+}
+
+// Organize is "organize" command body.
+func Organize(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "organize: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+	dryRun := ctx.Bool("dry-run")
+
+	if fname := ctx.String("undo"); len(fname) > 0 {
+		if ctx.Args().Len() > 0 {
+			env.Log.Warn("Mailformed command line, sources are not used with undo", zap.Strings("ignoring", ctx.Args().Slice()))
+		}
+		if err := undoOrganize(fname, dryRun, env); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to undo: %w", errPrefix, err), errCode)
+		}
+		return nil
+	}
+
+	if ctx.Args().Len() < 2 {
+		return cli.Exit(errors.New(errPrefix+"both source and destination must be specified"), errCode)
+	}
+	if ctx.Args().Len() > 2 {
+		env.Log.Warn("Mailformed command line, too many arguments", zap.Strings("ignoring", ctx.Args().Slice()[2:]))
+	}
+	src, err := filepath.Abs(ctx.Args().Get(0))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	dst, err := filepath.Abs(ctx.Args().Get(1))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing destination path failed: %w", errPrefix, err), errCode)
+	}
+
+	mode := strings.ToLower(ctx.String("mode"))
+	switch mode {
+	case "copy", "move", "link":
+	default:
+		return cli.Exit(fmt.Errorf("%sunknown mode %s, should be copy, move or link", errPrefix, mode), errCode)
+	}
+	collision := strings.ToLower(ctx.String("on-collision"))
+	switch collision {
+	case "skip", "rename", "overwrite":
+	default:
+		return cli.Exit(fmt.Errorf("%sunknown collision handling %s, should be skip, rename or overwrite", errPrefix, collision), errCode)
+	}
+	nodirs := ctx.Bool("nodirs")
+	if len(env.Cfg.Doc.FileNameFormat) == 0 {
+		env.Log.Warn("file_name_format is not configured, books will keep their names")
+	}
+
+	var (
+		undo    *json.Encoder
+		records int
+	)
+	if !dryRun {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to create destination directory: %w", errPrefix, err), errCode)
+		}
+		fname := ctx.String("undo-log")
+		if len(fname) == 0 {
+			fname = filepath.Join(dst, "fb2c-organize-"+time.Now().Format("20060102-150405")+".undo")
+		}
+		_, err := os.Stat(fname)
+		existed := err == nil
+		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to create undo log: %w", errPrefix, err), errCode)
+		}
+		defer func() {
+			f.Close()
+			if records == 0 && !existed {
+				os.Remove(fname)
+			}
+		}()
+		undo = json.NewEncoder(f)
+		undo.SetEscapeHTML(false)
+		env.Log.Info("Writing undo log", zap.String("file", fname))
+	}
+	record := func(op *organizeOp) {
+		if err := undo.Encode(op); err != nil {
+			env.Log.Error("Unable to write undo log", zap.Error(err))
+			return
+		}
+		records++
+	}
+
+	// files written (content hash) and vacated by moves during this run, so dry run makes the same decisions real run
+	// would make without touching anything
+	var (
+		planned = make(map[string]string)
+		vacated = make(map[string]bool)
+	)
+	taken := func(name string) bool {
+		if _, ok := planned[name]; ok {
+			return true
+		}
+		if vacated[name] {
+			return false
+		}
+		_, err := os.Lstat(name)
+		return err == nil
+	}
+	same := func(name string, data []byte) bool {
+		if sum, ok := planned[name]; ok {
+			return sum == contentHash(data)
+		}
+		return !vacated[name] && sameContent(name, data)
+	}
+	mkdirs := func(dir string) error {
+		var created []string
+		for d := dir; d != dst && d != filepath.Dir(d); d = filepath.Dir(d) {
+			if _, err := os.Stat(d); err == nil {
+				break
+			}
+			created = append(created, d)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			record(&organizeOp{Op: "mkdir", To: created[i]})
+		}
+		return nil
+	}
+
+	var count, skipped int
+	archiveWarned := false
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		ext := bookExtension(b.src)
+		data, err := b.readAll()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+
+		var name string
+		if b.kind == kindFB2 {
+			p, err := processor.NewFB2(selectReader(bytes.NewReader(data), b.enc), b.enc == encUnknown, b.src, dst, nodirs, false, true, processor.OEpub, env)
+			if err == nil {
+				if len(b.file) > 0 {
+					p.SetSourceFile(b.file)
+				}
+				name, err = p.OrganizedName(ext)
+				p.Clean()
+			}
+			if err != nil {
+				env.Log.Error("Unable to parse book", zap.String("file", b.location()), zap.Error(err))
+				return nil
+			}
+		} else {
+			var info *processor.BookInfo
+			if b.kind == kindEPUB {
+				info, err = processor.InspectEPUB(bytes.NewReader(data), int64(len(data)))
+			} else {
+				info, err = processor.InspectKindle(data)
+			}
+			if err != nil {
+				env.Log.Warn("Unable to read book metadata, keeping original name", zap.String("file", b.location()), zap.Error(err))
+				info = &processor.BookInfo{}
+			}
+			name = processor.OrganizedNameFromInfo(info, b.src, dst, nodirs, ext, env)
+		}
+
+		op := &organizeOp{Op: mode, From: b.location(), To: name}
+		if len(b.archive) > 0 {
+			if mode != "copy" && !archiveWarned {
+				env.Log.Warn("Books in archives could only be copied", zap.String("mode", mode))
+				archiveWarned = true
+			}
+			op.Op = "extract"
+		} else if op.From == op.To {
+			env.Log.Debug("Book is already in place", zap.String("file", b.location()))
+			return nil
+		}
+
+		if taken(name) {
+			_, placed := planned[name]
+			switch {
+			case same(name, data):
+				env.Log.Debug("Same book is already in place", zap.String("file", b.location()), zap.String("to", name))
+				skipped++
+				return nil
+			case collision == "skip":
+				env.Log.Warn("Destination exists, skipping", zap.String("file", b.location()), zap.String("to", name))
+				skipped++
+				return nil
+			case collision == "rename":
+				op.To = uniqueName(name, taken)
+			case placed:
+				// never overwrite books placed during this run
+				op.To = uniqueName(name, taken)
+			default:
+				op.Overwritten = true
+			}
+		}
+		planned[op.To] = contentHash(data)
+
+		if dryRun {
+			if op.Op == "move" {
+				vacated[op.From] = true
+			}
+			env.Log.Info("Would "+op.Op, zap.String("from", op.From), zap.String("to", op.To), zap.Bool("overwrite", op.Overwritten))
+			count++
+			return nil
+		}
+
+		if err := mkdirs(filepath.Dir(op.To)); err != nil {
+			env.Log.Error("Unable to create directory", zap.String("dir", filepath.Dir(op.To)), zap.Error(err))
+			return nil
+		}
+		if op.Overwritten {
+			if err := os.Remove(op.To); err != nil {
+				env.Log.Error("Unable to overwrite", zap.String("file", op.To), zap.Error(err))
+				return nil
+			}
+		}
+		switch op.Op {
+		case "copy":
+			err = copyFile(b.file, op.To)
+		case "extract":
+			err = writeFile(op.To, 0644, bytes.NewReader(data))
+		case "move":
+			err = moveFile(b.file, op.To)
+		case "link":
+			err = os.Link(b.file, op.To)
+		}
+		if err != nil {
+			env.Log.Error("Unable to "+op.Op+" book", zap.String("from", op.From), zap.String("to", op.To), zap.Error(err))
+			return nil
+		}
+		if op.Op == "move" {
+			vacated[op.From] = true
+		}
+		record(op)
+		count++
+		env.Log.Debug("Book organized", zap.String("op", op.Op), zap.String("from", op.From), zap.String("to", op.To))
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	env.Log.Info("Books organized", zap.Int("books", count), zap.Int("skipped", skipped), zap.String("mode", mode), zap.Bool("dry run", dryRun))
+	return nil
+}
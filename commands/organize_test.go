@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"fb2converter/config"
+	"fb2converter/state"
+)
+
+// testEnv prepares program environment with configuration overrides, log is kept in returned buffer as JSON lines.
+func testEnv(t *testing.T, overrides ...string) (*state.LocalEnv, *bytes.Buffer) {
+
+	conf, err := config.BuildConfig("", nil, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	log := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel))
+	return &state.LocalEnv{Cfg: conf, Log: log}, &buf
+}
+
+// runCommand runs command body the way application does.
+func runCommand(env *state.LocalEnv, action cli.ActionFunc, flags []cli.Flag, args ...string) error {
+	app := &cli.App{
+		Name:           "fb2c",
+		Flags:          []cli.Flag{&cli.GenericFlag{Name: state.FlagName, Hidden: true, Value: env}},
+		Commands:       []*cli.Command{{Name: "cmd", Action: action, Flags: flags}},
+		ExitErrHandler: func(*cli.Context, error) {},
+	}
+	return app.Run(append([]string{"fb2c", "cmd"}, args...))
+}
+
+// logRecords returns log records with given message prefix.
+func logRecords(t *testing.T, buf *bytes.Buffer, prefix string) []map[string]interface{} {
+	var res []map[string]interface{}
+	sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for sc.Scan() {
+		rec := make(map[string]interface{})
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := rec["msg"].(string); strings.HasPrefix(msg, prefix) {
+			res = append(res, rec)
+		}
+	}
+	return res
+}
+
+func writeFB2(t *testing.T, fname, title, text string) {
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname, []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><genre>sf</genre><author><first-name>Ivan</first-name><last-name>Petrov</last-name></author>
+<book-title>%s</book-title><lang>en</lang></title-info><document-info><id>%s</id></document-info></description>
+<body><section><p>%s</p></section></body></FictionBook>
+`, title, title, text)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var organizeFlags = []cli.Flag{
+	&cli.StringFlag{Name: "mode", Value: "copy"},
+	&cli.StringFlag{Name: "on-collision", Value: "rename"},
+	&cli.BoolFlag{Name: "nodirs"},
+	&cli.BoolFlag{Name: "dry-run"},
+	&cli.StringFlag{Name: "undo-log"},
+	&cli.StringFlag{Name: "undo"},
+}
+
+// listFiles returns all files under root relative to it.
+func listFiles(t *testing.T, root string) []string {
+	var res []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			res = append(res, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestOrganize(t *testing.T) {
+
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "library")
+	writeFB2(t, filepath.Join(src, "a.fb2"), "Title", "first")
+	// same name, different content
+	writeFB2(t, filepath.Join(src, "b.fb2"), "Title", "second")
+	// exact copy of the first book
+	writeFB2(t, filepath.Join(src, "e.fb2"), "Title", "first")
+	writeFB2(t, filepath.Join(src, "d.fb2"), "Other", "third")
+	// existing book of a different content occupies the name
+	writeFB2(t, filepath.Join(dst, "Petrov Ivan", "Other.fb2"), "Other", "existing")
+
+	env, log := testEnv(t, "document.file_name_format=#author/#title")
+	if err := runCommand(env, Organize, organizeFlags, "--dry-run", src, dst); err != nil {
+		t.Fatal(err)
+	}
+	var planned []string
+	for _, rec := range logRecords(t, log, "Would ") {
+		planned = append(planned, fmt.Sprintf("%s %s", rec["from"], rec["to"]))
+	}
+	if files := listFiles(t, dst); len(files) != 1 {
+		t.Fatalf("dry run changed destination: %v", files)
+	}
+
+	undoLog := filepath.Join(t.TempDir(), "organize.undo")
+	if err := runCommand(env, Organize, organizeFlags, "--mode", "move", "--undo-log", undoLog, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"Petrov Ivan/Other (1).fb2",
+		"Petrov Ivan/Other.fb2",
+		"Petrov Ivan/Title (1).fb2",
+		"Petrov Ivan/Title.fb2",
+	}
+	if files := listFiles(t, dst); !reflect.DeepEqual(files, expected) {
+		t.Errorf("unexpected library: %v", files)
+	}
+	// identical copy is not moved
+	if files := listFiles(t, src); !reflect.DeepEqual(files, []string{"e.fb2"}) {
+		t.Errorf("unexpected source left: %v", files)
+	}
+
+	data, err := os.ReadFile(undoLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var performed []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		op := &organizeOp{}
+		if err := json.Unmarshal([]byte(line), op); err != nil {
+			t.Fatal(err)
+		}
+		if op.Op != "mkdir" {
+			performed = append(performed, fmt.Sprintf("%s %s", op.From, op.To))
+		}
+	}
+	if !reflect.DeepEqual(planned, performed) {
+		t.Errorf("dry run does not match real run:\n%s\n%s", strings.Join(planned, "\n"), strings.Join(performed, "\n"))
+	}
+
+	if err := runCommand(env, Organize, organizeFlags, "--undo", undoLog); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, src); !reflect.DeepEqual(files, []string{"a.fb2", "b.fb2", "d.fb2", "e.fb2"}) {
+		t.Errorf("books were not moved back: %v", files)
+	}
+	if files := listFiles(t, dst); !reflect.DeepEqual(files, []string{"Petrov Ivan/Other.fb2"}) {
+		t.Errorf("unexpected library after undo: %v", files)
+	}
+}
+
+func TestOrganizeCollisions(t *testing.T) {
+
+	src, dst := t.TempDir(), t.TempDir()
+	writeFB2(t, filepath.Join(src, "a.fb2"), "Title", "new")
+	writeFB2(t, filepath.Join(dst, "Title.fb2"), "Title", "existing")
+
+	env, _ := testEnv(t, "document.file_name_format=#title")
+	if err := runCommand(env, Organize, organizeFlags, "--on-collision", "skip", "--undo-log", filepath.Join(t.TempDir(), "undo"), src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, dst); !reflect.DeepEqual(files, []string{"Title.fb2"}) {
+		t.Errorf("existing book was not kept: %v", files)
+	}
+
+	if err := runCommand(env, Organize, organizeFlags, "--on-collision", "overwrite", "--undo-log", filepath.Join(t.TempDir(), "undo"), src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "Title.fb2")); !bytes.Contains(data, []byte("new")) {
+		t.Error("existing book was not overwritten")
+	}
+	if files := listFiles(t, dst); !reflect.DeepEqual(files, []string{"Title.fb2"}) {
+		t.Errorf("unexpected library: %v", files)
+	}
+}
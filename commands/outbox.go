@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/delivery"
+	"fb2converter/state"
+)
+
+// Outbox is "outbox" command body.
+func Outbox(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "outbox: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+	if ctx.Args().Len() > 0 {
+		env.Log.Warn("Mailformed command line, too many arguments", zap.Strings("ignoring", ctx.Args().Slice()))
+	}
+	if ctx.Bool("send") && ctx.Bool("clear") {
+		return cli.Exit(errors.New(errPrefix+"--send and --clear are mutually exclusive"), errCode)
+	}
+
+	if ctx.Bool("send") {
+		mailer, err := delivery.NewMailer(env.Cfg, env.Log, env.Rpt)
+		if err != nil {
+			return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+		}
+		// nothing is queued, so mailer only sends outbox
+		if err := mailer.Close(); err != nil {
+			return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+		}
+	}
+
+	entries, err := delivery.Outbox(env.Cfg)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to read outbox: %w", errPrefix, err), errCode)
+	}
+
+	if ctx.Bool("clear") {
+		for _, e := range entries {
+			if err := os.RemoveAll(e.Dir); err != nil {
+				return cli.Exit(fmt.Errorf("%sunable to remove outbox entry: %w", errPrefix, err), errCode)
+			}
+			env.Log.Info("Removed from outbox", zap.String("file", e.File))
+		}
+		return nil
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(ctx.App.Writer, "%s\t%s\tattempts: %d\t%s\n", e.Queued.Format("2006-01-02 15:04:05"), e.File, e.Attempts, e.Error)
+	}
+	if len(entries) == 0 {
+		env.Log.Info("Outbox is empty")
+	}
+	return nil
+}
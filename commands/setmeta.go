@@ -0,0 +1,269 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+	"fb2converter/processor"
+	"fb2converter/state"
+)
+
+// writeSafely replaces file content with whatever write produces. Content goes to temporary file next to the original
+// which is renamed over it only if write succeeded and reported that something was written.
+func writeSafely(fname string, write func(w io.Writer) (bool, error)) (ok bool, err error) {
+
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fname), "."+filepath.Base(fname)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		tmp.Close()
+		if err != nil || !ok {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	ok, err = write(tmp)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err = tmp.Sync(); err != nil {
+		return false, err
+	}
+	if err = tmp.Close(); err != nil {
+		return false, err
+	}
+	if err = os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err = os.Rename(tmp.Name(), fname); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rewriteArchive replaces specified archive entries with content of the files, the rest is copied as is.
+func rewriteArchive(fname string, entries map[string]string) error {
+
+	_, err := writeSafely(fname, func(w io.Writer) (bool, error) {
+
+		zr, err := zip.OpenReader(fname)
+		if err != nil {
+			return false, err
+		}
+		defer zr.Close()
+
+		zw := zip.NewWriter(w)
+		for _, f := range zr.File {
+			src, ok := entries[f.Name]
+			if !ok {
+				if err := zw.Copy(f); err != nil {
+					return false, fmt.Errorf("unable to copy %s: %w", f.Name, err)
+				}
+				continue
+			}
+			out, err := zw.CreateHeader(&zip.FileHeader{
+				Name:          f.Name,
+				Comment:       f.Comment,
+				NonUTF8:       f.NonUTF8,
+				Method:        zip.Deflate,
+				Modified:      time.Now(),
+				ExternalAttrs: f.ExternalAttrs,
+			})
+			if err != nil {
+				return false, err
+			}
+			in, err := os.Open(src)
+			if err != nil {
+				return false, err
+			}
+			_, err = io.Copy(out, in)
+			in.Close()
+			if err != nil {
+				return false, fmt.Errorf("unable to write %s: %w", f.Name, err)
+			}
+		}
+		if err := zw.SetComment(zr.Comment); err != nil {
+			return false, err
+		}
+		return true, zw.Close()
+	})
+	return err
+}
+
+// metaFromFlags collects meta information specified on command line.
+func metaFromFlags(ctx *cli.Context) (*config.MetaInfo, error) {
+
+	m := &config.MetaInfo{}
+	if fname := ctx.String("from"); len(fname) > 0 {
+		from, err := config.ReadMetaInfo(fname)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read meta information from %s: %w", fname, err)
+		}
+		m = from
+	}
+
+	flags := &config.MetaInfo{
+		ID:         ctx.String("id"),
+		Title:      ctx.String("title"),
+		Lang:       ctx.String("lang"),
+		SeqName:    ctx.String("sequence"),
+		SeqNum:     ctx.Int("number"),
+		Date:       ctx.String("date"),
+		Annotation: strings.ReplaceAll(ctx.String("annotation"), `\n`, "\n"),
+	}
+	// lists are the same as in overwrites CSV
+	for _, g := range strings.Split(ctx.String("genres"), ";") {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			flags.Genres = append(flags.Genres, g)
+		}
+	}
+	for _, a := range strings.Split(ctx.String("authors"), ";") {
+		if an := config.ParseAuthorString(a); an != nil {
+			flags.Authors = append(flags.Authors, an)
+		}
+	}
+	if ctx.Bool("remove-cover") {
+		flags.CoverImage = "remove cover"
+	} else if fname := ctx.String("cover"); len(fname) > 0 {
+		fname, err := filepath.Abs(fname)
+		if err != nil {
+			return nil, fmt.Errorf("wrong cover image has been specified: %w", err)
+		}
+		flags.CoverImage = fname
+	}
+	m.Merge(flags)
+	return m, nil
+}
+
+// SetMeta is "setmeta" command body.
+func SetMeta(ctx *cli.Context) error {
+
+	const (
+		errPrefix = "setmeta: "
+		errCode   = 1
+	)
+
+	env := ctx.Generic(state.FlagName).(*state.LocalEnv)
+
+	src := ctx.Args().Get(0)
+	if len(src) == 0 {
+		return cli.Exit(errors.New(errPrefix+"no input source has been specified"), errCode)
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%snormalizing source path failed: %w", errPrefix, err), errCode)
+	}
+	if ctx.Args().Len() > 1 {
+		env.Log.Warn("Mailformed command line, too many sources", zap.Strings("ignoring", ctx.Args().Slice()[1:]))
+	}
+
+	flags, err := metaFromFlags(ctx)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+	useOverwrites := ctx.Bool("overwrites")
+	if !useOverwrites && flags.IsEmpty() {
+		return cli.Exit(errors.New(errPrefix+"nothing to change, no meta information has been specified"), errCode)
+	}
+	if len(flags.ASIN) > 0 {
+		env.Log.Warn("ASIN cannot be stored in FB2, ignoring", zap.String("asin", flags.ASIN))
+	}
+
+	meta := func(ref *config.BookRef) (*config.MetaInfo, error) {
+		res := &config.MetaInfo{}
+		if useOverwrites {
+			m, err := env.Cfg.GetOverwrite(ref)
+			if err != nil {
+				return nil, err
+			}
+			if m != nil {
+				res = m
+			}
+		}
+		res.Merge(flags)
+		return res, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "fb2c-setmeta-")
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%sunable to create temporary directory: %w", errPrefix, err), errCode)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// books in archives are collected first, every archive is rewritten only once
+	archives := make(map[string]map[string]string)
+	count := 0
+
+	err = walkSources(src, env, func(b *bookSource) error {
+
+		if b.kind != kindFB2 {
+			env.Log.Debug("Skipping file, not FB2", zap.String("file", b.location()))
+			return nil
+		}
+		// book is read completely, so it is not kept open while being replaced
+		data, err := b.readAll()
+		if err != nil {
+			env.Log.Error("Unable to read book", zap.String("file", b.location()), zap.Error(err))
+			return nil
+		}
+
+		ref := &config.BookRef{Path: b.src, File: b.file}
+		update := func(w io.Writer) (bool, error) {
+			return processor.SetMeta(selectReader(bytes.NewReader(data), b.enc), b.enc == encUnknown, ref, meta, w)
+		}
+
+		var ok bool
+		if len(b.archive) == 0 {
+			ok, err = writeSafely(b.file, update)
+		} else {
+			var f *os.File
+			if f, err = os.CreateTemp(tmpDir, "*.fb2"); err == nil {
+				ok, err = update(f)
+				f.Close()
+				if ok && err == nil {
+					if archives[b.archive] == nil {
+						archives[b.archive] = make(map[string]string)
+					}
+					archives[b.archive][b.entry] = f.Name()
+				}
+			}
+		}
+		if err != nil {
+			env.Log.Error("Unable to update book", zap.String("file", b.location()), zap.Error(err))
+		} else if ok {
+			count++
+			env.Log.Info("Book updated", zap.String("file", b.location()))
+		} else {
+			env.Log.Debug("Nothing to change", zap.String("file", b.location()))
+		}
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s%w", errPrefix, err), errCode)
+	}
+
+	for name, entries := range archives {
+		if err := rewriteArchive(name, entries); err != nil {
+			return cli.Exit(fmt.Errorf("%sunable to update archive %s: %w", errPrefix, name, err), errCode)
+		}
+		env.Log.Info("Archive updated", zap.String("archive", name), zap.Int("books", len(entries)))
+	}
+	env.Log.Info("Meta information updated", zap.Int("books", count))
+	return nil
+}
@@ -0,0 +1,224 @@
+package commands
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"fb2converter/archive"
+	"fb2converter/state"
+)
+
+type bookKind int
+
+const (
+	kindUnknown bookKind = iota
+	kindFB2
+	kindEPUB
+	kindKindle
+)
+
+// bookKindByName detects possible book kind by file extension.
+func bookKindByName(name string) bookKind {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".fb2":
+		return kindFB2
+	case ".epub", ".kepub":
+		return kindEPUB
+	case ".mobi", ".azw3", ".azw", ".prc":
+		return kindKindle
+	default:
+		return kindUnknown
+	}
+}
+
+// bookSource is a single book found by walkSources. "src" has the same meaning as for processBook, "file" is actual
+// book file when book is not inside archive, "archive" and "entry" are archive path and file name in it otherwise.
+type bookSource struct {
+	src     string
+	file    string
+	archive string
+	entry   string
+	kind    bookKind
+	size    int64
+	enc     srcEncoding
+	open    func() (io.ReadCloser, error)
+}
+
+// location returns human readable book location.
+func (b *bookSource) location() string {
+	if len(b.archive) > 0 {
+		return b.archive + string(filepath.Separator) + b.entry
+	}
+	return b.file
+}
+
+// readAll returns complete book content.
+func (b *bookSource) readAll() ([]byte, error) {
+	r, err := b.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// reopen returns complete book content same as open, but does not depend on archive being walked, so it could be used
+// after walkSources returns.
+func (b *bookSource) reopen() (io.ReadCloser, error) {
+	if len(b.archive) == 0 {
+		return os.Open(b.file)
+	}
+	zr, err := zip.OpenReader(b.archive)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.FileHeader.Name == b.entry {
+			r, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return &archivedBook{ReadCloser: r, zr: zr}, nil
+		}
+	}
+	zr.Close()
+	return nil, fmt.Errorf("%s was not found in archive %s", b.entry, b.archive)
+}
+
+// archivedBook closes archive along with the book.
+type archivedBook struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (a *archivedBook) Close() error {
+	err := a.ReadCloser.Close()
+	if zerr := a.zr.Close(); err == nil {
+		err = zerr
+	}
+	return err
+}
+
+// walkSources resolves source path the same way "convert" does (file, directory, archive with optional path inside) and calls
+// fn for every recognized book. Books which cannot be processed are logged and skipped, error returned by fn stops the walk.
+func walkSources(src string, env *state.LocalEnv, fn func(b *bookSource) error) error {
+
+	fromFile := func(path, rel string) (*bookSource, error) {
+		b := &bookSource{src: rel, file: path, kind: bookKindByName(path)}
+		if b.kind == kindFB2 {
+			ok, enc, err := isBookFile(path)
+			if err != nil || !ok {
+				return nil, err
+			}
+			b.enc = enc
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		b.size = fi.Size()
+		b.open = func() (io.ReadCloser, error) { return os.Open(path) }
+		return b, nil
+	}
+
+	fromArchive := func(path, pathIn, pathOut string) error {
+		return archive.Walk(path, pathIn, func(archive string, f *zip.File) error {
+			b := &bookSource{
+				src:     filepath.Join(pathOut, f.FileHeader.Name),
+				archive: archive,
+				entry:   f.FileHeader.Name,
+				kind:    bookKindByName(f.FileHeader.Name),
+				size:    int64(f.UncompressedSize64),
+				open:    f.Open,
+			}
+			switch b.kind {
+			case kindUnknown:
+				return nil
+			case kindFB2:
+				ok, enc, err := isBookInArchive(f)
+				if err != nil {
+					env.Log.Warn("Skipping file in archive", zap.String("archive", archive), zap.String("path", f.FileHeader.Name), zap.Error(err))
+					return nil
+				}
+				if !ok {
+					return nil
+				}
+				b.enc = enc
+			}
+			return fn(b)
+		})
+	}
+
+	var head, tail string
+	for head = src; len(head) != 0; head, tail = filepath.Split(head) {
+
+		head = strings.TrimSuffix(head, string(filepath.Separator))
+
+		fi, err := os.Stat(head)
+		if err != nil {
+			// does not exists - probably path in archive
+			continue
+		}
+
+		if fi.Mode().IsDir() {
+			if len(tail) != 0 {
+				return fmt.Errorf("input source was not found (%s) => (%s)", head, strings.TrimPrefix(src, head))
+			}
+			return filepath.Walk(head, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					env.Log.Warn("Skipping path", zap.String("path", path), zap.Error(err))
+					return nil
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				rel := strings.TrimPrefix(strings.TrimPrefix(path, head), string(filepath.Separator))
+				if ok, err := isArchiveFile(path); err != nil {
+					env.Log.Warn("Skipping file", zap.String("file", path), zap.Error(err))
+				} else if ok {
+					if err := fromArchive(path, "", filepath.Dir(rel)); err != nil {
+						env.Log.Error("Unable to process archive", zap.String("file", path), zap.Error(err))
+					}
+				} else if bookKindByName(path) != kindUnknown {
+					b, err := fromFile(path, rel)
+					if err != nil {
+						env.Log.Warn("Skipping file", zap.String("file", path), zap.Error(err))
+					} else if b != nil {
+						return fn(b)
+					}
+				}
+				return nil
+			})
+		}
+
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("unexpected path mode for (%s) => (%s)", head, strings.TrimPrefix(src, head))
+		}
+
+		ok, err := isArchiveFile(head)
+		if err != nil {
+			return fmt.Errorf("unable to check archive type: %w", err)
+		}
+		if ok {
+			return fromArchive(head, strings.TrimPrefix(strings.TrimPrefix(src, head), string(filepath.Separator)), "")
+		}
+		if len(tail) == 0 && bookKindByName(head) != kindUnknown {
+			b, err := fromFile(head, filepath.Base(head))
+			if err != nil {
+				return fmt.Errorf("unable to check file type: %w", err)
+			}
+			if b != nil {
+				return fn(b)
+			}
+		}
+		return fmt.Errorf("input was not recognized as book (%s)", head)
+	}
+	return fmt.Errorf("input source was not found (%s)", src)
+}
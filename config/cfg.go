@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -22,11 +24,17 @@ import (
 	"fb2converter/go-micro/config/encoder/toml"
 	"fb2converter/go-micro/config/encoder/yaml"
 	"fb2converter/go-micro/config/source"
+	"fb2converter/go-micro/config/source/env"
 	"fb2converter/go-micro/config/source/file"
+	"fb2converter/go-micro/config/source/flag"
 	"fb2converter/go-micro/config/source/memory"
 	"fb2converter/reporter"
+	"fb2converter/static"
 )
 
+// DirDevices is location of built-in device profiles in static assets.
+const DirDevices = "devices"
+
 // Internal constants defining if program was invoked via MyHomeLib wrappers.
 const (
 	MhlNone int = iota
@@ -59,9 +67,72 @@ type SMTPConfig struct {
 	Server          string `json:"smtp_server"`
 	Port            int    `json:"smtp_port"`
 	User            string `json:"smtp_user"`
-	Password        string `json:"smtp_password"`
+	Password        Secret `json:"smtp_password"`
+	PasswordFile    string `json:"password_file"`
+	PasswordEnv     string `json:"password_env"`
+	PasswordCmd     string `json:"password_cmd"`
 	From            string `json:"from_mail"`
 	To              string `json:"to_mail"`
+	TLS             string `json:"smtp_tls"`
+	MaxSize         int    `json:"max_message_size"`
+	MaxBooks        int    `json:"max_books_per_message"`
+	Retries         int    `json:"retries"`
+	RetryDelay      int    `json:"retry_delay"`
+	Outbox          string `json:"outbox"`
+	Downscale       bool   `json:"downscale_images"`
+}
+
+// Supported SMTP connection security modes.
+const (
+	TLSAuto     = "auto"     // SSL for port 465, STARTTLS when server supports it otherwise
+	TLSSSL      = "ssl"      // implicit TLS
+	TLSStartTLS = "starttls" // STARTTLS is required
+	TLSNone     = "none"     // plain connection, never upgraded
+)
+
+// Target describes additional destination converted books are delivered to.
+type Target struct {
+	Name            string            `json:"name"`
+	Type            string            `json:"type"`
+	Formats         []string          `json:"formats"` // output formats delivered to target, all if empty
+	DeleteOnSuccess bool              `json:"delete_delivered_book"`
+	Path            string            `json:"path"` // directory for "path" target
+	URL             string            `json:"url"`  // collection for "webdav" target, endpoint for "webhook"
+	User            string            `json:"user"`
+	Password        Secret            `json:"password"`
+	Field           string            `json:"field"` // name of multipart form field for "webhook"
+	Headers         map[string]string `json:"headers"`
+	Command         []string          `json:"command"` // program and its arguments for "command" target
+	Timeout         int               `json:"timeout"` // seconds
+}
+
+// Supported delivery target types.
+const (
+	TargetPath    = "path"    // copy to directory, usually mounted device
+	TargetWebDAV  = "webdav"  // upload with PUT creating collections as needed
+	TargetWebhook = "webhook" // POST as multipart form
+	TargetCommand = "command" // run program with book path
+)
+
+// String returns name target is referred by in logs and messages.
+func (t *Target) String() string {
+	if len(t.Name) > 0 {
+		return t.Name
+	}
+	return t.Type
+}
+
+// Accepts checks if books of output format should be delivered to target.
+func (t *Target) Accepts(format string) bool {
+	if len(t.Formats) == 0 {
+		return true
+	}
+	for _, f := range t.Formats {
+		if strings.EqualFold(f, format) {
+			return true
+		}
+	}
+	return false
 }
 
 // AuthorName is parsed author name from book metainfo.
@@ -97,11 +168,15 @@ type MetaInfo struct {
 	SeqNum     int           `json:"sequence_number"`
 	Date       string        `json:"date"`
 	CoverImage string        `json:"cover_image"`
+	Annotation string        `json:"annotation"`
 }
 
-type confMetaOverwrite struct {
-	Name string   `json:"name"`
-	Meta MetaInfo `json:"meta"`
+// GenreInfo describes single FB2 genre.
+type GenreInfo struct {
+	Group string            `json:"group"`
+	BISAC string            `json:"bisac"`
+	Thema string            `json:"thema"`
+	Names map[string]string `json:"names"`
 }
 
 // IsValid checks if we have enough smtp parameters to attempt sending mail.
@@ -125,8 +200,10 @@ type Doc struct {
 	ChapterPerFile        bool     `json:"chapter_per_file"`
 	ChapterLevel          int      `json:"chapter_level"`
 	SeqNumPos             int      `json:"series_number_positions"`
+	SeriesPrimary         string   `json:"series_primary"`
 	RemovePNGTransparency bool     `json:"remove_png_transparency"`
 	ImagesScaleFactor     float64  `json:"images_scale_factor"`
+	ImagesGrayscale       bool     `json:"images_grayscale"`
 	Stylesheet            string   `json:"style"`
 	CharsPerPage          int      `json:"characters_per_page"`
 	PagesPerFile          int      `json:"pages_per_file"`
@@ -171,6 +248,12 @@ type Doc struct {
 		Placement string `json:"stamp_placement"`
 		Font      string `json:"stamp_font"`
 	} `json:"cover"`
+	Genres struct {
+		Subjects string               `json:"subjects"`
+		Language string               `json:"language"`
+		File     string               `json:"file"`
+		Table    map[string]GenreInfo `json:"table"`
+	} `json:"genres"`
 	Vignettes struct {
 		Create bool                         `json:"create"`
 		Images map[string]map[string]string `json:"images"`
@@ -178,6 +261,25 @@ type Doc struct {
 	//
 	Transformations map[string]map[string]string `json:"transform"`
 	//
+	HTML struct {
+		EmbedImages bool `json:"embed_images"`
+	} `json:"html"`
+	PDF struct {
+		PageWidth      float64 `json:"page_width"`
+		PageHeight     float64 `json:"page_height"`
+		MarginTop      float64 `json:"margin_top"`
+		MarginBottom   float64 `json:"margin_bottom"`
+		MarginLeft     float64 `json:"margin_left"`
+		MarginRight    float64 `json:"margin_right"`
+		FontSize       float64 `json:"font_size"`
+		LineSpacing    float64 `json:"line_spacing"`
+		FontRegular    string  `json:"font_regular"`
+		FontBold       string  `json:"font_bold"`
+		FontItalic     string  `json:"font_italic"`
+		FontBoldItalic string  `json:"font_bold_italic"`
+		Notes          string  `json:"notes_placement"`
+	} `json:"pdf"`
+	//
 	Kindlegen struct {
 		Path             string `json:"path"`
 		CompressionLevel int    `json:"compression_level"`
@@ -186,6 +288,7 @@ type Doc struct {
 		RemovePersonal   bool   `json:"remove_personal_label"`
 		PageMap          string `json:"generate_apnx"`
 		ForceASIN        bool   `json:"force_asin_on_azw3"`
+		NativeMOBI       bool   `json:"native_mobi7"`
 	} `json:"kindlegen"`
 }
 
@@ -199,17 +302,23 @@ const (
 // Config keeps all configuration values.
 type Config struct {
 	// Internal implementation - keep it local, could be replaced
-	Path string
-	cfg  config.Config
+	Path    string
+	Device  string   // name of device profile configuration is based on, if any
+	Presets []string // names of applied presets
+	cfg     config.Config
+	smtp    *secretValue // lazily resolved send to kindle password
+	secrets []string     // secret values from all configuration sources, including overridden ones
 
 	// Actual configuration used everywhere - immutable
-	ConsoleLogger Logger
-	FileLogger    Logger
-	Doc           Doc
-	SMTPConfig    SMTPConfig
-	Fb2Mobi       Fb2Mobi
-	Fb2Epub       Fb2Epub
-	Overwrites    map[string]MetaInfo
+	ConsoleLogger    Logger
+	FileLogger       Logger
+	Doc              Doc
+	SMTPConfig       SMTPConfig
+	Fb2Mobi          Fb2Mobi
+	Fb2Epub          Fb2Epub
+	Overwrites       []*Overwrite
+	OverwriteSources OverwriteSources
+	Targets          []*Target
 }
 
 var defaultConfig = []byte(`{
@@ -240,6 +349,20 @@ var defaultConfig = []byte(`{
         }
       }
     },
+    "html": {
+      "embed_images": true
+    },
+    "pdf": {
+      "page_width": 148,
+      "page_height": 210,
+      "margin_top": 15,
+      "margin_bottom": 15,
+      "margin_left": 12,
+      "margin_right": 12,
+      "font_size": 11,
+      "line_spacing": 1.25,
+      "notes_placement": "bottom"
+    },
     "kindlegen": {
       "compression_level": 1,
       "remove_personal_label": true,
@@ -274,6 +397,13 @@ var defaultConfig = []byte(`{
       "mode": "append"
     }
   },
+  "sendtokindle": {
+    "smtp_tls": "auto",
+    "max_message_size": 50,
+    "max_books_per_message": 1,
+    "retries": 3,
+    "retry_delay": 5
+  },
   "fb2mobi": {
     "output_format": "mobi"
   },
@@ -282,8 +412,64 @@ var defaultConfig = []byte(`{
   }
 }`)
 
-// BuildConfig loads configuration.
-func BuildConfig(fnames ...string) (*Config, error) {
+// Devices returns names of built-in device profiles.
+func Devices() []string {
+	var names []string
+	if dir, err := static.AssetDir(DirDevices); err == nil {
+		for _, a := range dir {
+			if strings.HasSuffix(a, ".toml") {
+				names = append(names, strings.TrimSuffix(a, ".toml"))
+			}
+		}
+	}
+	return names
+}
+
+// presetSections lists configuration sections named preset is allowed to overlay.
+var presetSections = map[string]bool{"document": true, "logger": true, "sendtokindle": true}
+
+// presetSources returns configuration sources for named presets from "presets" section in the order they are requested.
+func presetSources(c config.Config, names []string) ([]source.Source, error) {
+
+	var all map[string]map[string]json.RawMessage
+	if err := c.Get("presets").Scan(&all); err != nil {
+		return nil, fmt.Errorf("unable to read presets: %w", err)
+	}
+
+	var res []source.Source
+	for _, name := range names {
+		preset, ok := all[name]
+		if !ok {
+			known := make([]string, 0, len(all))
+			for n := range all {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown preset %s, available: [%s]", name, strings.Join(known, ", "))
+		}
+		for section := range preset {
+			if !presetSections[section] {
+				return nil, fmt.Errorf("preset %s: section %s could not be overlaid", name, section)
+			}
+		}
+		data, err := json.Marshal(preset)
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %w", name, err)
+		}
+		res = append(res, memory.NewSource(memory.WithJSON(data)))
+	}
+	return res, nil
+}
+
+// BuildConfig loads configuration. Sources are merged in order of increasing precedence:
+//
+//	built-in defaults
+//	device profile (when device is not empty)
+//	configuration files, in order
+//	named presets from configuration files, in order
+//	environment variables (FB2C_DOCUMENT__TOC__TYPE=flat)
+//	overrides ("document.toc.type=flat"), in order
+func BuildConfig(device string, presets, overrides []string, fnames ...string) (*Config, error) {
 
 	var err error
 	// base configuration directory, always calculated from the path of the first configuration file
@@ -293,6 +479,15 @@ func BuildConfig(fnames ...string) (*Config, error) {
 		memory.NewSource(memory.WithJSON(defaultConfig)),
 	}
 
+	if len(device) > 0 {
+		device = strings.ToLower(device)
+		data, err := static.Asset(path.Join(DirDevices, device+".toml"))
+		if err != nil {
+			return nil, fmt.Errorf("unknown device profile %s, should be one of: %s", device, strings.Join(Devices(), ", "))
+		}
+		configSources = append(configSources, memory.NewSource(memory.WithChangeSet(&source.ChangeSet{Data: data, Format: "toml"})))
+	}
+
 	var wasStdin bool
 	for i, fname := range fnames {
 		switch {
@@ -341,7 +536,22 @@ func BuildConfig(fnames ...string) (*Config, error) {
 		return nil, fmt.Errorf("unable to parse configuration %v", fnames)
 	}
 
-	conf := Config{cfg: c, Path: base, Overwrites: make(map[string]MetaInfo)}
+	// presets are defined by configuration files, so it has to be read before presets could be applied
+	sources, err := presetSources(c, presets)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources,
+		env.NewSource(env.WithConverter(convertValue)),
+		flag.NewSource(flag.WithValues(overrides), flag.WithConverter(convertValue)),
+	)
+	configSources = append(configSources, sources...)
+	c = config.NewConfig()
+	if err = c.Load(configSources...); err != nil {
+		return nil, fmt.Errorf("unable to apply configuration overrides: %w", err)
+	}
+
+	conf := Config{cfg: c, Path: base, Device: device, Presets: presets, smtp: &secretValue{}, secrets: collectSecrets(configSources)}
 	if err := c.Get("logger", "console").Scan(&conf.ConsoleLogger); err != nil {
 		return nil, fmt.Errorf("unable to read console logger configuration: %w", err)
 	}
@@ -361,14 +571,29 @@ func BuildConfig(fnames ...string) (*Config, error) {
 		return nil, fmt.Errorf("unable to read send to kindle cnfiguration: %w", err)
 	}
 
-	var metas []confMetaOverwrite
-	if err := c.Get("overwrites").Scan(&metas); err != nil {
+	if err := c.Get("delivery").Scan(&conf.Targets); err != nil {
+		return nil, fmt.Errorf("unable to read delivery targets configuration: %w", err)
+	}
+
+	if err := c.Get("overwrites").Scan(&conf.Overwrites); err != nil {
 		return nil, fmt.Errorf("unable to read meta information overwrites: %w", err)
 	}
-	for _, meta := range metas {
-		name := filepath.ToSlash(meta.Name)
-		if _, exists := conf.Overwrites[name]; !exists {
-			conf.Overwrites[name] = meta.Meta
+	if err := c.Get("overwrites_sources").Scan(&conf.OverwriteSources); err != nil {
+		return nil, fmt.Errorf("unable to read meta information overwrites sources: %w", err)
+	}
+	for _, fname := range conf.OverwriteSources.Files {
+		if !filepath.IsAbs(fname) && len(base) > 0 {
+			fname = filepath.Join(base, fname)
+		}
+		ows, err := loadOverwrites(fname)
+		if err != nil {
+			return nil, err
+		}
+		conf.Overwrites = append(conf.Overwrites, ows...)
+	}
+	for i, o := range conf.Overwrites {
+		if err := o.prepare(); err != nil {
+			return nil, fmt.Errorf("meta information overwrite %d: %w", i+1, err)
 		}
 	}
 
@@ -421,36 +646,6 @@ func (conf *Config) GetTransformation(name string) *Transformation {
 	return nil
 }
 
-// GetOverwrite returns pointer to information to be used instead of parsed data.
-func (conf *Config) GetOverwrite(name string) *MetaInfo {
-
-	if len(conf.Overwrites) == 0 {
-		return nil
-	}
-
-	// start from most specific
-
-	// NOTE: all path separators were converted to slash before being added to map
-	name = filepath.ToSlash(name)
-	for {
-		if i, ok := conf.Overwrites[name]; ok {
-			return &i
-		}
-		parts := strings.SplitN(name, "/", 1)
-		if len(parts) <= 1 {
-			break
-		}
-		name = parts[1]
-	}
-
-	// not found - see if we have generic overwrite
-	name = "*"
-	if i, ok := conf.Overwrites[name]; ok {
-		return &i
-	}
-	return nil
-}
-
 // GetKindlegenPath provides platform specific path to the kindlegen executable.
 func (conf *Config) GetKindlegenPath() (string, error) {
 
@@ -480,20 +675,7 @@ func (conf *Config) GetKindlegenPath() (string, error) {
 func (conf *Config) GetActualBytes() ([]byte, error) {
 
 	// For convinience create temporary configuration structure with actual values
-	a := struct {
-		B struct {
-			Cl Logger `json:"console"`
-			Fl Logger `json:"file"`
-		} `json:"logger"`
-		D Doc        `json:"document"`
-		E SMTPConfig `json:"sendtokindle"`
-		F Fb2Mobi    `json:"fb2mobi"`
-		G Fb2Epub    `json:"fb2epub"`
-		H []struct {
-			Name string   `json:"name"`
-			Meta MetaInfo `json:"meta"`
-		} `json:"overwrites"`
-	}{}
+	a := actualConfig{}
 	a.B.Cl = conf.ConsoleLogger
 	a.B.Fl = conf.FileLogger
 	a.D = conf.Doc
@@ -501,12 +683,12 @@ func (conf *Config) GetActualBytes() ([]byte, error) {
 	a.F = conf.Fb2Mobi
 	a.G = conf.Fb2Epub
 
-	for k, v := range conf.Overwrites {
-		s := struct {
-			Name string   `json:"name"`
-			Meta MetaInfo `json:"meta"`
-		}{Name: filepath.FromSlash(k), Meta: v}
-		a.H = append(a.H, s)
+	a.I = conf.OverwriteSources
+	a.J = conf.Targets
+	for _, o := range conf.Overwrites {
+		s := *o
+		s.Name, s.Glob = filepath.FromSlash(o.Name), filepath.FromSlash(o.Glob)
+		a.H = append(a.H, &s)
 	}
 
 	// Marshall it to json
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeviceProfile(t *testing.T) {
+
+	devices := Devices()
+	if len(devices) == 0 {
+		t.Fatal("no device profiles")
+	}
+	for _, d := range devices {
+		conf, err := BuildConfig(d, nil, nil)
+		if err != nil {
+			t.Fatalf("device %s: %v", d, err)
+		}
+		if conf.Device != d || conf.Doc.Cover.Width == 0 || conf.Doc.Cover.Height == 0 {
+			t.Errorf("device %s: cover size is not set", d)
+		}
+	}
+
+	conf, err := BuildConfig("Kobo-Libra", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Doc.Cover.Width != 1264 || !conf.Doc.ImagesGrayscale || conf.Doc.Notes.Mode != "float" {
+		t.Errorf("profile was not applied: %+v", conf.Doc.Cover)
+	}
+	// defaults not mentioned in profile are kept
+	if conf.Doc.Notes.Format != "[{#body_number.}#number]" {
+		t.Errorf("default was lost: %s", conf.Doc.Notes.Format)
+	}
+
+	fname := filepath.Join(t.TempDir(), "fb2c.toml")
+	if err := os.WriteFile(fname, []byte("[document.cover]\nwidth = 999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if conf, err = BuildConfig("kobo-libra", nil, nil, fname); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Doc.Cover.Width != 999 || conf.Doc.Cover.Height != 1680 {
+		t.Errorf("configuration file does not override profile: %+v", conf.Doc.Cover)
+	}
+
+	if _, err := BuildConfig("no-such-device", nil, nil); err == nil {
+		t.Error("unknown device accepted")
+	}
+}
+
+func TestPresets(t *testing.T) {
+
+	fname := filepath.Join(t.TempDir(), "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(`
+[document]
+characters_per_page = 2000
+
+[presets.large.document]
+characters_per_page = 3000
+[presets.large.document.cover]
+width = 1860
+height = 2480
+
+[presets.small.document.cover]
+width = 600
+
+[presets.quiet.logger.console]
+level = "none"
+
+[presets.bad.fb2mobi]
+output_format = "azw3"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := BuildConfig("", nil, nil, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Doc.CharsPerPage != 2000 || conf.Doc.Cover.Width != 1264 {
+		t.Errorf("preset applied without being requested")
+	}
+
+	conf, err = BuildConfig("", []string{"large", "small", "quiet"}, nil, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Doc.CharsPerPage != 3000 || conf.Doc.Cover.Width != 600 || conf.Doc.Cover.Height != 2480 || conf.ConsoleLogger.Level != "none" {
+		t.Errorf("presets were not applied in order: %d %+v %s", conf.Doc.CharsPerPage, conf.Doc.Cover, conf.ConsoleLogger.Level)
+	}
+	if conf.FileLogger.Level != "debug" {
+		t.Errorf("preset changed unrelated value: %s", conf.FileLogger.Level)
+	}
+
+	if _, err := BuildConfig("", []string{"unknown"}, nil, fname); err == nil {
+		t.Error("unknown preset accepted")
+	}
+	if _, err := BuildConfig("", []string{"bad"}, nil, fname); err == nil {
+		t.Error("preset overlaying fb2mobi section accepted")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+
+	fname := filepath.Join(t.TempDir(), "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(`
+[document.toc]
+type = "kindle"
+
+[presets.p.document.toc]
+type = "normal"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("FB2C_DOCUMENT__TOC__TYPE", "flat")
+	t.Setenv("FB2C_DOCUMENT__COVER__WIDTH", "100")
+	t.Setenv("FB2C_SENDTOKINDLE__SMTP_PASSWORD", "123456")
+	t.Setenv("FB2C_DEBUG", "yes")
+
+	conf, err := BuildConfig("", []string{"p"}, JoinOverrides([]string{
+		"document.cover.width=200",
+		"document.notes.body_names=notes", "comments",
+		"document.images_grayscale=true",
+		`document.transform.dashes={"from":"-"`, `"to":"—"}`,
+	}), fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Doc.TOC.Type != "flat" {
+		t.Errorf("environment does not override preset: %s", conf.Doc.TOC.Type)
+	}
+	if conf.Doc.Cover.Width != 200 || conf.Doc.Cover.Height != 1680 {
+		t.Errorf("command line does not override environment: %+v", conf.Doc.Cover)
+	}
+	if conf.SMTPConfig.Password != "123456" {
+		t.Errorf("string value was not kept as is: %s", conf.SMTPConfig.Password)
+	}
+	if n := conf.Doc.Notes.BodyNames; len(n) != 2 || n[1] != "comments" {
+		t.Errorf("bad list value: %v", n)
+	}
+	if tr := conf.GetTransformation("dashes"); !conf.Doc.ImagesGrayscale || tr == nil || tr.To != "—" {
+		t.Errorf("bad complex value: %v", tr)
+	}
+
+	for _, bad := range []string{"document.toc.typo=flat", "document.cover.width=wide", "document.toc=flat", "document"} {
+		if _, err := BuildConfig("", nil, []string{bad}); err == nil {
+			t.Errorf("bad override %s accepted", bad)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	conf, err := BuildConfig("", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := conf.Check(); len(ps) != 0 {
+		t.Errorf("default configuration has problems: %v", ps)
+	}
+
+	fname := filepath.Join(t.TempDir(), "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(`
+[logger.file]
+mode = "rewrite"
+
+[document]
+Style = "my.css"
+stlye = "my.css"
+
+[document.transform.dashes]
+from = "-"
+
+[[overwrites]]
+name = "a.fb2"
+[overwrites.meta]
+titel = "Title"
+
+[presets.p.document.toc]
+tipe = "flat"
+
+[presets.p.fb2mobi]
+output_format = "azw3"
+
+[sendtokindle]
+to_mail = "me@kindle.com"
+smtp_port = 25
+
+[[delivery]]
+type = "ftp"
+
+[[delivery]]
+type = "webdav"
+url = "nas.local/books"
+
+[[delivery]]
+type = "path"
+path = "/no/such/device"
+formats = ["epub"]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if conf, err = BuildConfig("", nil, nil, fname); err != nil {
+		t.Fatal(err)
+	}
+	ps := conf.Check()
+
+	expected := map[string]bool{
+		"document.stlye":              false,
+		"logger.file.mode":            false,
+		"overwrites[0].meta.titel":    false,
+		"presets.p.document.toc.tipe": false,
+		"presets.p.fb2mobi":           false,
+		"sendtokindle.smtp_server":    true,
+		"sendtokindle.smtp_user":      true,
+		"sendtokindle.smtp_password":  true,
+		"sendtokindle.from_mail":      true,
+		"delivery[0].type":            false,
+		"delivery[1].url":             false,
+		"delivery[2].path":            true,
+	}
+	for _, p := range ps {
+		warning, ok := expected[p.Key]
+		if !ok {
+			t.Errorf("unexpected problem %s", p)
+			continue
+		}
+		if warning != p.Warning {
+			t.Errorf("wrong severity %s", p)
+		}
+		delete(expected, p.Key)
+	}
+	for key := range expected {
+		t.Errorf("problem with %s was not found", key)
+	}
+	if ps.Errors() != 7 || ps.Err() == nil {
+		t.Errorf("wrong number of errors: %v", ps)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "smtp.password"), []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(`
+[sendtokindle]
+smtp_password = "in-file"
+password_file = "smtp.password"
+
+[presets.p.sendtokindle]
+smtp_password = "in-preset"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := BuildConfig("", nil, []string{"sendtokindle.smtp_password=on-command-line"}, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pwd, err := conf.SMTPPassword(); err != nil || pwd != "on-command-line" {
+		t.Errorf("wrong password %q: %v", pwd, err)
+	}
+	found := make(map[string]bool)
+	for _, s := range conf.Secrets() {
+		found[s] = true
+	}
+	for _, s := range []string{"in-file", "in-preset", "on-command-line"} {
+		if !found[s] {
+			t.Errorf("secret %s was not collected: %v", s, conf.Secrets())
+		}
+	}
+	data, err := conf.GetActualBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "on-command-line") || !strings.Contains(string(data), `"smtp_password": "[redacted]"`) {
+		t.Errorf("password was not redacted: %s", data)
+	}
+	if args := RedactArgs([]string{"--set", "sendtokindle.smtp_password=x"}); args[1] != "sendtokindle.smtp_password="+Redacted {
+		t.Errorf("argument was not redacted: %v", args)
+	}
+
+	c := SMTPConfig{PasswordFile: "smtp.password", PasswordEnv: "FB2C_TEST_PASSWORD", PasswordCmd: "echo from-command"}
+	if pwd, err := c.readPassword(dir); err != nil || pwd != "from-file" {
+		t.Errorf("wrong password from file %q: %v", pwd, err)
+	}
+	c.PasswordFile = ""
+	if _, err := c.readPassword(dir); err == nil {
+		t.Error("unset environment variable was not reported")
+	}
+	t.Setenv("FB2C_TEST_PASSWORD", "from-env")
+	if pwd, err := c.readPassword(dir); err != nil || pwd != "from-env" {
+		t.Errorf("wrong password from environment %q: %v", pwd, err)
+	}
+	c.PasswordEnv = ""
+	if pwd, err := c.readPassword(dir); err != nil || pwd != "from-command" {
+		t.Errorf("wrong password from command %q: %v", pwd, err)
+	}
+}
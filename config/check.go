@@ -0,0 +1,298 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// Problem describes single configuration issue.
+type Problem struct {
+	Key     string // dotted path to configuration value, empty when issue is not related to a particular key
+	Message string
+	Warning bool // configuration could still be used, but probably not the way it was intended
+}
+
+func (p Problem) String() string {
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	if len(p.Key) == 0 {
+		return kind + ": " + p.Message
+	}
+	return kind + ": " + p.Key + ": " + p.Message
+}
+
+// Problems is a list of configuration issues in order they were found.
+type Problems []Problem
+
+// Errorf adds configuration error for key.
+func (ps *Problems) Errorf(key, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf adds configuration warning for key.
+func (ps *Problems) Warnf(key, format string, args ...interface{}) {
+	*ps = append(*ps, Problem{Key: key, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Errors returns number of problems which are not warnings.
+func (ps Problems) Errors() int {
+	var n int
+	for _, p := range ps {
+		if !p.Warning {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns error listing all configuration errors or nil if there are none.
+func (ps Problems) Err() error {
+	var msgs []string
+	for _, p := range ps {
+		if !p.Warning {
+			msgs = append(msgs, strings.TrimPrefix(p.String(), "error: "))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// Check validates parts of configuration which do not require any knowledge of how it is used: keys which are not part
+// of configuration schema, logger settings, kindlegen options and send to kindle parameters. Book processor adds its
+// own checks on top.
+func (conf *Config) Check() Problems {
+
+	var ps Problems
+
+	var tree map[string]interface{}
+	if err := json.Unmarshal(conf.cfg.Bytes(), &tree); err != nil {
+		ps.Errorf("", "unable to read configuration: %v", err)
+		return ps
+	}
+	for _, k := range sortedKeys(tree) {
+		if k == "presets" {
+			checkPresets(&ps, tree[k])
+			continue
+		}
+		f, ok := field(reflect.TypeOf(actualConfig{}), k)
+		if !ok {
+			ps.Errorf(k, "unknown configuration key")
+			continue
+		}
+		checkKeys(&ps, k, tree[k], f.Type)
+	}
+
+	for _, l := range []struct {
+		key   string
+		level string
+	}{
+		{"logger.console.level", conf.ConsoleLogger.Level},
+		{"logger.file.level", conf.FileLogger.Level},
+	} {
+		switch l.level {
+		case "none", "normal", "debug":
+		default:
+			ps.Errorf(l.key, "unknown value %q, should be one of: none, normal, debug", l.level)
+		}
+	}
+	switch conf.FileLogger.Mode {
+	case "append", "overwrite":
+	default:
+		ps.Errorf("logger.file.mode", "unknown value %q, should be one of: append, overwrite", conf.FileLogger.Mode)
+	}
+
+	// value is corrected when configuration is built, so look at the original
+	if l := conf.cfg.Get("document", "kindlegen", "compression_level").Int(1); l < 0 || l > 2 {
+		ps.Warnf("document.kindlegen.compression_level", "value %d is out of range 0-2, 1 will be used", l)
+	}
+
+	conf.SMTPConfig.check(&ps, "sendtokindle", conf.Path)
+	for i, t := range conf.Targets {
+		t.check(&ps, fmt.Sprintf("delivery[%d]", i), conf.Path)
+	}
+	return ps
+}
+
+// check reports delivery target parameters which would make delivery impossible. Output formats are checked by book
+// processor.
+func (t *Target) check(ps *Problems, key, base string) {
+
+	switch strings.ToLower(t.Type) {
+	case TargetPath:
+		if len(t.Path) == 0 {
+			ps.Errorf(key+".path", "destination directory is not specified")
+			break
+		}
+		dir := t.Path
+		if !filepath.IsAbs(dir) && len(base) > 0 {
+			dir = filepath.Join(base, dir)
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			// devices are not always mounted
+			ps.Warnf(key+".path", "directory %q does not exist, delivery will fail until it is available", dir)
+		}
+	case TargetWebDAV, TargetWebhook:
+		if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			ps.Errorf(key+".url", "%q is not a valid http(s) URL", t.URL)
+		}
+	case TargetCommand:
+		if len(t.Command) == 0 {
+			ps.Errorf(key+".command", "command is not specified")
+			break
+		}
+		if _, err := exec.LookPath(t.Command[0]); err != nil {
+			ps.Warnf(key+".command", "unable to find %q, delivery will fail", t.Command[0])
+		}
+	default:
+		ps.Errorf(key+".type", "unknown value %q, should be one of: %s, %s, %s, %s", t.Type, TargetPath, TargetWebDAV, TargetWebhook, TargetCommand)
+	}
+	if t.Timeout < 0 {
+		ps.Errorf(key+".timeout", "should not be negative, got %d", t.Timeout)
+	}
+}
+
+// MaxKindleAttachments is the number of documents Amazon accepts in a single e-mail.
+const MaxKindleAttachments = 25
+
+// check reports send to kindle parameters which would make sending impossible. Connection parameters are not reported
+// if sending was never configured.
+func (c *SMTPConfig) check(ps *Problems, key, base string) {
+
+	switch strings.ToLower(c.TLS) {
+	case TLSAuto, TLSSSL, TLSStartTLS, TLSNone:
+	default:
+		ps.Errorf(key+".smtp_tls", "unknown value %q, should be one of: %s, %s, %s, %s", c.TLS, TLSAuto, TLSSSL, TLSStartTLS, TLSNone)
+	}
+	if c.MaxSize <= 0 {
+		ps.Errorf(key+".max_message_size", "should be positive, got %d", c.MaxSize)
+	}
+	if c.MaxBooks <= 0 || c.MaxBooks > MaxKindleAttachments {
+		ps.Errorf(key+".max_books_per_message", "should be in range 1-%d, got %d", MaxKindleAttachments, c.MaxBooks)
+	}
+	if c.Retries < 0 {
+		ps.Errorf(key+".retries", "should not be negative, got %d", c.Retries)
+	}
+	if c.RetryDelay < 0 {
+		ps.Errorf(key+".retry_delay", "should not be negative, got %d", c.RetryDelay)
+	}
+
+	if len(c.Server) == 0 && len(c.User) == 0 && len(c.From) == 0 && len(c.To) == 0 {
+		return
+	}
+	if !govalidator.IsDNSName(c.Server) {
+		ps.Warnf(key+".smtp_server", "%q is not a valid server name, send to kindle will not work", c.Server)
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		ps.Warnf(key+".smtp_port", "%d is not a valid port, send to kindle will not work", c.Port)
+	}
+	if len(c.User) == 0 {
+		ps.Warnf(key+".smtp_user", "user is not specified, send to kindle will not work")
+	}
+	switch sources := c.passwordSources(); {
+	case len(sources) == 0:
+		ps.Warnf(key+".smtp_password", "password is not specified, send to kindle will not work if server requires authentication")
+	case sources[0] == "password_file" || sources[0] == "password_env":
+		// command is never executed here, it may require user interaction
+		if _, err := c.readPassword(base); err != nil {
+			ps.Warnf(key+"."+sources[0], "%v, send to kindle will not work", err)
+		}
+		fallthrough
+	default:
+		for _, s := range sources[1:] {
+			ps.Warnf(key+"."+s, "ignored, %s takes precedence", sources[0])
+		}
+	}
+	if !govalidator.IsEmail(c.From) {
+		ps.Warnf(key+".from_mail", "%q is not a valid e-mail, send to kindle will not work", c.From)
+	}
+	if !govalidator.IsEmail(c.To) {
+		ps.Warnf(key+".to_mail", "%q is not a valid e-mail, send to kindle will not work", c.To)
+	}
+}
+
+// checkPresets verifies that presets only overlay allowed sections and have the same structure as configuration itself.
+func checkPresets(ps *Problems, value interface{}) {
+	presets, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, name := range sortedKeys(presets) {
+		sections, ok := presets[name].(map[string]interface{})
+		if !ok {
+			ps.Errorf("presets."+name, "preset should be a table")
+			continue
+		}
+		for _, section := range sortedKeys(sections) {
+			key := "presets." + name + "." + section
+			f, ok := field(reflect.TypeOf(actualConfig{}), section)
+			if !ok || !presetSections[f.Tag.Get("json")] {
+				ps.Errorf(key, "section could not be overlaid by preset")
+				continue
+			}
+			checkKeys(ps, key, sections[section], f.Type)
+		}
+	}
+}
+
+// checkKeys walks configuration tree reporting keys which are not present in configuration structure. Values of wrong
+// type are not reported here - configuration would not be loaded at all in such case.
+func checkKeys(ps *Problems, key string, value interface{}, t reflect.Type) {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			f, ok := field(t, k)
+			if !ok {
+				ps.Errorf(key+"."+k, "unknown configuration key")
+				continue
+			}
+			checkKeys(ps, key+"."+k, m[k], f.Type)
+		}
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			checkKeys(ps, key+"."+k, m[k], t.Elem())
+		}
+	case reflect.Slice:
+		l, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		for i, v := range l {
+			checkKeys(ps, fmt.Sprintf("%s[%d]", key, i), v, t.Elem())
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -12,6 +12,11 @@ func kindlegen() string {
 	return "kindlegen"
 }
 
+// shell provides OS specific command interpreter used to run configured commands
+func shell() []string {
+	return []string{"sh", "-c"}
+}
+
 // CleanFileName removes not allowed characters form file name.
 func CleanFileName(in string) string {
 	out := strings.TrimLeft(strings.Map(func(sym rune) rune {
@@ -13,6 +13,11 @@ func kindlegen() string {
 	return "kindlegen.exe"
 }
 
+// shell provides OS specific command interpreter used to run configured commands
+func shell() []string {
+	return []string{"cmd", "/C"}
+}
+
 // CleanFileName removes not allowed characters form file name.
 func CleanFileName(in string) string {
 	out := strings.Map(func(sym rune) rune {
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// actualConfig mirrors configuration tree, it is used to produce actual configuration and to find types of individual keys.
+type actualConfig struct {
+	B struct {
+		Cl Logger `json:"console"`
+		Fl Logger `json:"file"`
+	} `json:"logger"`
+	D Doc              `json:"document"`
+	E SMTPConfig       `json:"sendtokindle"`
+	F Fb2Mobi          `json:"fb2mobi"`
+	G Fb2Epub          `json:"fb2epub"`
+	H []*Overwrite     `json:"overwrites"`
+	I OverwriteSources `json:"overwrites_sources"`
+	J []*Target        `json:"delivery"`
+}
+
+// keyType finds type of configuration value by its path. Presets have the same structure as configuration itself.
+func keyType(path []string) (reflect.Type, error) {
+
+	if len(path) > 0 && path[0] == "presets" {
+		if len(path) < 3 {
+			return nil, errors.New("preset values should be set individually")
+		}
+		path = path[2:]
+	}
+
+	t := reflect.TypeOf(actualConfig{})
+	for i, k := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			f, found := field(t, k)
+			if !found {
+				return nil, fmt.Errorf("unknown configuration key %s", strings.Join(path[:i+1], "."))
+			}
+			t = f.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("configuration key %s has no %s", strings.Join(path[:i], "."), k)
+		}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, nil
+}
+
+// field finds structure field by its json name. Case is ignored the same way unmarshaling does it.
+func field(t reflect.Type, name string) (reflect.StructField, bool) {
+	for j := 0; j < t.NumField(); j++ {
+		f := t.Field(j)
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// convertValue is used by environment and command line configuration sources to turn text into value of proper type.
+// Lists of strings could be specified either as JSON arrays or comma separated, other composite values as JSON.
+func convertValue(path []string, value string) (interface{}, error) {
+
+	t, err := keyType(path)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(strings.TrimSpace(value))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case reflect.Slice:
+		if v := strings.TrimSpace(value); t.Elem().Kind() == reflect.String && !strings.HasPrefix(v, "[") {
+			res := []interface{}{}
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); len(s) > 0 {
+					res = append(res, s)
+				}
+			}
+			return res, nil
+		}
+	}
+	var res interface{}
+	if err := json.Unmarshal([]byte(value), &res); err != nil {
+		return nil, fmt.Errorf("value should be JSON %s: %w", t.Kind(), err)
+	}
+	return res, nil
+}
+
+var reOverrideKey = regexp.MustCompile(`^\s*[\w.-]+\s*=`)
+
+// JoinOverrides restores "key=value" pairs which were split on commas by command line parser: anything which does not
+// start with a key is a continuation of previous value.
+func JoinOverrides(values []string) []string {
+	var res []string
+	for _, v := range values {
+		if len(res) > 0 && !reOverrideKey.MatchString(v) {
+			res[len(res)-1] += "," + v
+			continue
+		}
+		res = append(res, v)
+	}
+	return res
+}
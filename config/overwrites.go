@@ -0,0 +1,594 @@
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Overwrite describes which books meta-info overwrite is applicable to. All specified conditions must be satisfied.
+type Overwrite struct {
+	Name       string   `json:"name"`
+	Glob       string   `json:"glob,omitempty"`
+	Regex      string   `json:"regex,omitempty"`
+	BookID     string   `json:"book_id,omitempty"`
+	BookTitle  string   `json:"book_title,omitempty"`
+	BookAuthor string   `json:"book_author,omitempty"`
+	Meta       MetaInfo `json:"meta"`
+	re         *regexp.Regexp
+}
+
+// OverwriteSources specifies where else overwrites could come from.
+type OverwriteSources struct {
+	Files    []string `json:"files"`
+	Sidecars bool     `json:"sidecars"`
+}
+
+// BookRef has everything known about the book to select proper overwrite.
+type BookRef struct {
+	Path    string // path relative to source as processor sees it
+	File    string // actual file, empty if book was not read from file system directly
+	ID      string // document-info/id as is
+	UUID    string // book UUID
+	Title   string
+	Authors []*AuthorName
+}
+
+// Names of per-book sidecar files.
+const (
+	SidecarSuffix = ".meta.toml"
+	CalibreOPF    = "metadata.opf"
+)
+
+// isExact is true for overwrites which are selected by path only.
+func (o *Overwrite) isExact() bool {
+	return len(o.Glob) == 0 && len(o.Regex) == 0 && len(o.BookID) == 0 && len(o.BookTitle) == 0 && len(o.BookAuthor) == 0
+}
+
+func (o *Overwrite) prepare() (err error) {
+	o.Name = filepath.ToSlash(o.Name)
+	o.Glob = filepath.ToSlash(o.Glob)
+	if len(o.Glob) > 0 {
+		if _, err = path.Match(o.Glob, ""); err != nil {
+			return fmt.Errorf("bad glob \"%s\": %w", o.Glob, err)
+		}
+	}
+	if len(o.Regex) > 0 {
+		if o.re, err = regexp.Compile(o.Regex); err != nil {
+			return fmt.Errorf("bad regex \"%s\": %w", o.Regex, err)
+		}
+	}
+	return nil
+}
+
+// pathSuffixes returns path and all its shorter variants: "a/b/c.fb2", "b/c.fb2", "c.fb2".
+func pathSuffixes(name string) []string {
+	var res []string
+	for {
+		res = append(res, name)
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) <= 1 {
+			break
+		}
+		name = parts[1]
+	}
+	return res
+}
+
+func (o *Overwrite) matches(b *BookRef) bool {
+
+	name := filepath.ToSlash(b.Path)
+	if len(o.Name) > 0 && o.Name != "*" {
+		found := false
+		for _, s := range pathSuffixes(name) {
+			if s == o.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(o.Glob) > 0 {
+		found := false
+		for _, s := range pathSuffixes(name) {
+			if ok, _ := path.Match(o.Glob, s); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if o.re != nil && !o.re.MatchString(name) {
+		return false
+	}
+	if len(o.BookID) > 0 && !strings.EqualFold(o.BookID, strings.TrimSpace(b.ID)) && !strings.EqualFold(o.BookID, b.UUID) {
+		return false
+	}
+	if len(o.BookTitle) > 0 && !strings.EqualFold(strings.TrimSpace(o.BookTitle), strings.TrimSpace(b.Title)) {
+		return false
+	}
+	if len(o.BookAuthor) > 0 && !matchAuthor(o.BookAuthor, b.Authors) {
+		return false
+	}
+	return true
+}
+
+// matchAuthor checks if every word of the pattern is part of some book author name, case insensitive.
+func matchAuthor(pattern string, authors []*AuthorName) bool {
+
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(pattern, ",", " ")))
+	if len(words) == 0 {
+		return false
+	}
+	for _, an := range authors {
+		parts := map[string]bool{
+			strings.ToLower(an.First):  true,
+			strings.ToLower(an.Middle): true,
+			strings.ToLower(an.Last):   true,
+		}
+		found := true
+		for _, w := range words {
+			if !parts[w] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge replaces values with non-empty ones from another meta information.
+func (m *MetaInfo) Merge(from *MetaInfo) {
+	for _, f := range []struct{ dst, src *string }{
+		{&m.ID, &from.ID},
+		{&m.ASIN, &from.ASIN},
+		{&m.Title, &from.Title},
+		{&m.Lang, &from.Lang},
+		{&m.SeqName, &from.SeqName},
+		{&m.Date, &from.Date},
+		{&m.CoverImage, &from.CoverImage},
+		{&m.Annotation, &from.Annotation},
+	} {
+		if len(*f.src) > 0 {
+			*f.dst = *f.src
+		}
+	}
+	if len(from.Genres) > 0 {
+		m.Genres = from.Genres
+	}
+	if len(from.Authors) > 0 {
+		m.Authors = from.Authors
+	}
+	if from.SeqNum > 0 {
+		m.SeqNum = from.SeqNum
+	}
+}
+
+// IsEmpty checks if there is anything to replace.
+func (m *MetaInfo) IsEmpty() bool {
+	return len(m.ID) == 0 && len(m.ASIN) == 0 && len(m.Title) == 0 && len(m.Lang) == 0 && len(m.Genres) == 0 &&
+		len(m.Authors) == 0 && len(m.SeqName) == 0 && m.SeqNum == 0 && len(m.Date) == 0 && len(m.CoverImage) == 0 &&
+		len(m.Annotation) == 0
+}
+
+// ParseAuthorString splits author name into parts, it understands "Last, First Middle" and "First Middle Last".
+func ParseAuthorString(s string) *AuthorName {
+
+	var an AuthorName
+	if last, rest, ok := strings.Cut(s, ","); ok {
+		an.Last = strings.TrimSpace(last)
+		if f := strings.Fields(rest); len(f) > 0 {
+			an.First, an.Middle = f[0], strings.Join(f[1:], " ")
+		}
+	} else {
+		switch f := strings.Fields(s); len(f) {
+		case 0:
+		case 1:
+			an.Last = f[0]
+		default:
+			an.First, an.Middle, an.Last = f[0], strings.Join(f[1:len(f)-1], " "), f[len(f)-1]
+		}
+	}
+	if len(an.First)+len(an.Middle)+len(an.Last) == 0 {
+		return nil
+	}
+	return &an
+}
+
+// absCover makes relative cover image path relative to the directory where overwrite came from.
+func absCover(m *MetaInfo, dir string) {
+	if len(m.CoverImage) > 0 && m.CoverImage != "remove cover" && !filepath.IsAbs(m.CoverImage) {
+		m.CoverImage = filepath.Join(dir, m.CoverImage)
+	}
+}
+
+// readOverwritesJSON reads array of overwrites in the same format as configuration "overwrites".
+func readOverwritesJSON(r io.Reader) ([]*Overwrite, error) {
+	var res []*Overwrite
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// readOverwritesCSV reads overwrites table, first line must have column names - the same as configuration keys.
+// Lists (genres, authors) are separated by ";", authors could be written as "Last, First Middle" or "First Middle Last".
+func readOverwritesCSV(r io.Reader) ([]*Overwrite, error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read header: %w", err)
+	}
+
+	var res []*Overwrite
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		o := &Overwrite{}
+		for i, v := range rec {
+			if i >= len(header) {
+				break
+			}
+			if v = strings.TrimSpace(v); len(v) == 0 {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(header[i])) {
+			case "name":
+				o.Name = v
+			case "glob":
+				o.Glob = v
+			case "regex":
+				o.Regex = v
+			case "book_id":
+				o.BookID = v
+			case "book_title":
+				o.BookTitle = v
+			case "book_author":
+				o.BookAuthor = v
+			case "id":
+				o.Meta.ID = v
+			case "asin":
+				o.Meta.ASIN = v
+			case "title":
+				o.Meta.Title = v
+			case "language":
+				o.Meta.Lang = v
+			case "genres":
+				for _, g := range strings.Split(v, ";") {
+					if g = strings.TrimSpace(g); len(g) > 0 {
+						o.Meta.Genres = append(o.Meta.Genres, g)
+					}
+				}
+			case "authors":
+				for _, a := range strings.Split(v, ";") {
+					if an := ParseAuthorString(a); an != nil {
+						o.Meta.Authors = append(o.Meta.Authors, an)
+					}
+				}
+			case "sequence":
+				o.Meta.SeqName = v
+			case "sequence_number":
+				if o.Meta.SeqNum, err = strconv.Atoi(v); err != nil {
+					return nil, fmt.Errorf("line %d: bad sequence number: %w", line, err)
+				}
+			case "date":
+				o.Meta.Date = v
+			case "cover_image":
+				o.Meta.CoverImage = v
+			case "annotation":
+				o.Meta.Annotation = v
+			default:
+				return nil, fmt.Errorf("unknown column \"%s\"", header[i])
+			}
+		}
+		res = append(res, o)
+	}
+	return res, nil
+}
+
+// loadOverwrites reads overwrites from external file, CSV or JSON depending on file extension.
+func loadOverwrites(fname string) ([]*Overwrite, error) {
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []*Overwrite
+	if strings.EqualFold(filepath.Ext(fname), ".csv") {
+		res, err = readOverwritesCSV(f)
+	} else {
+		res, err = readOverwritesJSON(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read overwrites from %s: %w", fname, err)
+	}
+	for _, o := range res {
+		absCover(&o.Meta, filepath.Dir(fname))
+	}
+	return res, nil
+}
+
+// readSidecar reads meta information from TOML file, keys are the same as in overwrites "meta" section.
+func readSidecar(fname string) (*MetaInfo, error) {
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	// reuse json names
+	if data, err = json.Marshal(raw); err != nil {
+		return nil, err
+	}
+	var m MetaInfo
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	absCover(&m, filepath.Dir(fname))
+	return &m, nil
+}
+
+// readCalibreOPF reads meta information from metadata.opf calibre keeps in book directory.
+func readCalibreOPF(fname string) (*MetaInfo, error) {
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	var opf struct {
+		Metadata struct {
+			Title    string `xml:"title"`
+			Language string `xml:"language"`
+			Date     string `xml:"date"`
+			Creators []struct {
+				Role string `xml:"role,attr"`
+				Name string `xml:",chardata"`
+			} `xml:"creator"`
+			Subjects    []string `xml:"subject"`
+			Description string   `xml:"description"`
+			Identifiers []struct {
+				Scheme string `xml:"scheme,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"identifier"`
+			Meta []struct {
+				Name    string `xml:"name,attr"`
+				Content string `xml:"content,attr"`
+			} `xml:"meta"`
+		} `xml:"metadata"`
+		Guide struct {
+			References []struct {
+				Type string `xml:"type,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"reference"`
+		} `xml:"guide"`
+	}
+	if err := xml.Unmarshal(data, &opf); err != nil {
+		return nil, err
+	}
+
+	md := opf.Metadata
+	m := &MetaInfo{
+		Title:      strings.TrimSpace(md.Title),
+		Lang:       strings.TrimSpace(md.Language),
+		Genres:     md.Subjects,
+		Annotation: htmlToText(md.Description),
+	}
+	// calibre uses "0101-01-01" for unknown dates
+	if d, _, _ := strings.Cut(strings.TrimSpace(md.Date), "T"); len(d) > 0 && !strings.HasPrefix(d, "0101") {
+		m.Date = d
+	}
+	for _, c := range md.Creators {
+		if len(c.Role) > 0 && c.Role != "aut" {
+			continue
+		}
+		if an := ParseAuthorString(c.Name); an != nil {
+			m.Authors = append(m.Authors, an)
+		}
+	}
+	for _, id := range md.Identifiers {
+		v := strings.TrimSpace(id.Value)
+		// calibre uuid is not book identity, FB2 id is kept
+		switch strings.ToLower(id.Scheme) {
+		case "amazon", "mobi-asin", "asin":
+			m.ASIN = v
+		}
+	}
+	for _, meta := range md.Meta {
+		switch meta.Name {
+		case "calibre:series":
+			m.SeqName = meta.Content
+		case "calibre:series_index":
+			if f, err := strconv.ParseFloat(meta.Content, 64); err == nil && f > 0 {
+				m.SeqNum = int(f)
+			}
+		}
+	}
+	for _, r := range opf.Guide.References {
+		if r.Type == "cover" && len(r.Href) > 0 {
+			m.CoverImage = r.Href
+			break
+		}
+	}
+	absCover(m, filepath.Dir(fname))
+	return m, nil
+}
+
+var (
+	reHTMLBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	reHTMLTags   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// htmlToText converts calibre HTML description to plain text paragraphs separated by new lines.
+func htmlToText(s string) string {
+	s = reHTMLTags.ReplaceAllString(reHTMLBreaks.ReplaceAllString(s, "\n"), "")
+	var lines []string
+	for _, l := range strings.Split(html.UnescapeString(s), "\n") {
+		if l = strings.TrimSpace(l); len(l) > 0 {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReadMetaInfo reads meta information from file: TOML (the same as sidecar), JSON (the same as overwrites "meta" section)
+// or OPF (calibre metadata). Relative cover image path is relative to the file location.
+func ReadMetaInfo(fname string) (*MetaInfo, error) {
+
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".opf":
+		return readCalibreOPF(fname)
+	case ".json":
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		var m MetaInfo
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		absCover(&m, filepath.Dir(fname))
+		return &m, nil
+	default:
+		return readSidecar(fname)
+	}
+}
+
+// calibreLayout checks if directory is calibre book directory, which always keeps a single book (possibly in several
+// formats). Directories with many FB2 books have nothing to do with "metadata.opf" which may be found there.
+func calibreLayout(dir string) bool {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	var books int
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if name := strings.ToLower(e.Name()); strings.HasSuffix(name, ".fb2") || strings.HasSuffix(name, ".zip") {
+			books++
+		}
+	}
+	return books == 1
+}
+
+// sidecar looks for meta information files next to the book: "<book file>.meta.toml" has precedence over calibre
+// "metadata.opf", which is only used when book directory has calibre layout.
+func sidecar(fname string) (*MetaInfo, string, error) {
+
+	for _, f := range []struct {
+		name string
+		read func(string) (*MetaInfo, error)
+	}{
+		{fname + SidecarSuffix, readSidecar},
+		{filepath.Join(filepath.Dir(fname), CalibreOPF), readCalibreOPF},
+	} {
+		if _, err := os.Stat(f.name); err != nil {
+			continue
+		}
+		if filepath.Base(f.name) == CalibreOPF && !calibreLayout(filepath.Dir(fname)) {
+			continue
+		}
+		m, err := f.read(f.name)
+		if err != nil {
+			return nil, f.name, err
+		}
+		return m, f.name, nil
+	}
+	return nil, "", nil
+}
+
+// GetOverwrite returns pointer to information to be used instead of parsed data. Overwrites are searched from most
+// specific to less specific: exact path (and its shorter variants), pattern overwrites in order of definition, "*".
+// When sidecars are enabled and book has one its values are applied on top of the found overwrite.
+func (conf *Config) GetOverwrite(b *BookRef) (*MetaInfo, error) {
+
+	var res *MetaInfo
+
+	// NOTE: all path separators were converted to slash before being added
+	name := filepath.ToSlash(b.Path)
+	for _, s := range pathSuffixes(name) {
+		for _, o := range conf.Overwrites {
+			if o.Name == s && o.isExact() {
+				res = &o.Meta
+				break
+			}
+		}
+		if res != nil {
+			break
+		}
+	}
+	if res == nil {
+		for _, o := range conf.Overwrites {
+			if !o.isExact() && o.matches(b) {
+				res = &o.Meta
+				break
+			}
+		}
+	}
+	if res == nil {
+		for _, o := range conf.Overwrites {
+			if o.Name == "*" && o.isExact() {
+				res = &o.Meta
+				break
+			}
+		}
+	}
+
+	if conf.OverwriteSources.Sidecars && len(b.File) > 0 {
+		m, fname, err := sidecar(b.File)
+		if err != nil {
+			return res, fmt.Errorf("unable to read meta information from %s: %w", fname, err)
+		}
+		if m != nil {
+			merged := MetaInfo{}
+			if res != nil {
+				merged = *res
+			}
+			merged.Merge(m)
+			res = &merged
+		}
+	}
+
+	if res == nil {
+		return nil, nil
+	}
+	// callers must not modify configuration
+	cp := *res
+	return &cp, nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetOverwrite(t *testing.T) {
+
+	ows, err := readOverwritesCSV(strings.NewReader(`name,glob,regex,book_id,book_title,book_author,title,authors,genres
+*,,,,,,all,,
+aaa/bbb.fb2,,,,,,exact,,
+,ccc/*.fb2,,,,,glob,"Doe, John Q; Jane Roe",sf;sf_space
+,,^ddd/[0-9]+\.fb2$,,,,regex,,
+,,,42,,,id,,
+,,,,Some Title,,title,,
+,,,,,charles dickens,author,,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{Overwrites: ows}
+	for _, o := range conf.Overwrites {
+		if err := o.prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if a := ows[2].Meta.Authors; len(a) != 2 || a[0].Last != "Doe" || a[0].First != "John" || a[0].Middle != "Q" || a[1].First != "Jane" || a[1].Last != "Roe" {
+		t.Errorf("bad authors: %v", a)
+	}
+	if g := ows[2].Meta.Genres; len(g) != 2 || g[1] != "sf_space" {
+		t.Errorf("bad genres: %v", g)
+	}
+
+	for _, c := range []struct {
+		ref   BookRef
+		title string
+	}{
+		{BookRef{Path: "x/aaa/bbb.fb2"}, "exact"},
+		{BookRef{Path: "aaa/bbb.fb2", Title: "Some Title"}, "exact"},
+		{BookRef{Path: "x/ccc/book.fb2"}, "glob"},
+		{BookRef{Path: "ddd/123.fb2"}, "regex"},
+		{BookRef{Path: "x/ddd/123.fb2"}, "all"},
+		{BookRef{Path: "book.fb2", ID: "42"}, "id"},
+		{BookRef{Path: "book.fb2", Title: "some title"}, "title"},
+		{BookRef{Path: "book.fb2", Authors: []*AuthorName{{First: "Charles", Last: "Dickens"}}}, "author"},
+		{BookRef{Path: "book.fb2", Authors: []*AuthorName{{First: "Charles", Last: "Darwin"}}}, "all"},
+	} {
+		m, err := conf.GetOverwrite(&c.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil || m.Title != c.title {
+			t.Errorf("%+v: expected %q, got %+v", c.ref, c.title, m)
+		}
+	}
+}
+
+func TestSidecars(t *testing.T) {
+
+	dir := t.TempDir()
+	book := filepath.Join(dir, "book.fb2")
+
+	opf := `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier opf:scheme="uuid">250ddd1e-86e8-41c0-a881-0e0c96c4da7c</dc:identifier>
+    <dc:title>Calibre Title</dc:title>
+    <dc:creator opf:role="aut">Charles John Huffam Dickens</dc:creator>
+    <dc:date>1861-08-01T00:00:00+00:00</dc:date>
+    <dc:language>en</dc:language>
+    <meta name="calibre:series" content="Series"/>
+    <meta name="calibre:series_index" content="2.0"/>
+  </metadata>
+  <guide><reference type="cover" title="Cover" href="cover.jpg"/></guide>
+</package>`
+	for name, data := range map[string]string{CalibreOPF: opf, "book.fb2": "", "book.epub": "", "cover.jpg": ""} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := &Config{
+		Overwrites:       []*Overwrite{{Name: "*", Meta: MetaInfo{Title: "all", ASIN: "B06XWTFSKH"}}},
+		OverwriteSources: OverwriteSources{Sidecars: true},
+	}
+	m, err := conf.GetOverwrite(&BookRef{Path: "book.fb2", File: book})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// calibre uuid must not replace book id
+	if m.Title != "Calibre Title" || m.ASIN != "B06XWTFSKH" || len(m.ID) != 0 ||
+		m.Date != "1861-08-01" || m.Lang != "en" || m.SeqName != "Series" || m.SeqNum != 2 ||
+		m.CoverImage != filepath.Join(dir, "cover.jpg") ||
+		len(m.Authors) != 1 || m.Authors[0].First != "Charles" || m.Authors[0].Middle != "John Huffam" || m.Authors[0].Last != "Dickens" {
+		t.Errorf("bad calibre overwrite: %+v", m)
+	}
+	if conf.Overwrites[0].Meta.Title != "all" {
+		t.Error("configuration was modified")
+	}
+
+	// directory with many books is not calibre book directory
+	other := filepath.Join(dir, "other.fb2.zip")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range []string{book, other} {
+		if m, err = conf.GetOverwrite(&BookRef{Path: filepath.Base(fname), File: fname}); err != nil {
+			t.Fatal(err)
+		}
+		if m.Title != "all" || len(m.Authors) != 0 || len(m.SeqName) != 0 {
+			t.Errorf("calibre metadata applied to %s in directory with many books: %+v", filepath.Base(fname), m)
+		}
+	}
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+
+	sidecar := `title = "Sidecar Title"
+sequence_number = 5
+authors = [ { first_name = "First", last_name = "Last" } ]
+`
+	if err := os.WriteFile(book+SidecarSuffix, []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = conf.GetOverwrite(&BookRef{Path: "book.fb2", File: book}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Sidecar Title" || m.SeqNum != 5 || m.ASIN != "B06XWTFSKH" || len(m.Authors) != 1 || m.Authors[0].Last != "Last" {
+		t.Errorf("bad sidecar overwrite: %+v", m)
+	}
+}
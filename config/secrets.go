@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"fb2converter/go-micro/config/encoder"
+	jsonenc "fb2converter/go-micro/config/encoder/json"
+	"fb2converter/go-micro/config/encoder/toml"
+	"fb2converter/go-micro/config/encoder/yaml"
+	"fb2converter/go-micro/config/source"
+)
+
+// Redacted is shown instead of secret values.
+const Redacted = "[redacted]"
+
+// Secret is configuration value which should never be shown: it is redacted when configuration is dumped or logged.
+type Secret string
+
+func (s Secret) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return Redacted
+}
+
+// MarshalJSON hides secret in configuration dumps and in log fields.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+type secretValue struct {
+	once  sync.Once
+	value string
+	err   error
+}
+
+// SMTPPassword returns password for send to kindle from the first source configured: smtp_password, password_file
+// (relative to configuration directory), password_env or output of password_cmd. Password is only read once, so
+// command is never executed more than once per run.
+func (conf *Config) SMTPPassword() (string, error) {
+	if conf.smtp == nil {
+		return conf.SMTPConfig.readPassword(conf.Path)
+	}
+	conf.smtp.once.Do(func() {
+		conf.smtp.value, conf.smtp.err = conf.SMTPConfig.readPassword(conf.Path)
+	})
+	return conf.smtp.value, conf.smtp.err
+}
+
+// passwordSources lists configured password sources in order of precedence.
+func (c *SMTPConfig) passwordSources() []string {
+	var res []string
+	for _, s := range []struct {
+		key   string
+		value string
+	}{
+		{"smtp_password", string(c.Password)},
+		{"password_file", c.PasswordFile},
+		{"password_env", c.PasswordEnv},
+		{"password_cmd", c.PasswordCmd},
+	} {
+		if len(s.value) > 0 {
+			res = append(res, s.key)
+		}
+	}
+	return res
+}
+
+func (c *SMTPConfig) readPassword(base string) (string, error) {
+
+	switch {
+	case len(c.Password) > 0:
+		return string(c.Password), nil
+	case len(c.PasswordFile) > 0:
+		fname := c.PasswordFile
+		if !filepath.IsAbs(fname) && len(base) > 0 {
+			fname = filepath.Join(base, fname)
+		}
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return "", fmt.Errorf("unable to read password file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case len(c.PasswordEnv) > 0:
+		value, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("password environment variable %s is not set", c.PasswordEnv)
+		}
+		return value, nil
+	case len(c.PasswordCmd) > 0:
+		sh := shell()
+		cmd := exec.Command(sh[0], append(sh[1:], c.PasswordCmd)...)
+		// password managers may need to interact with user
+		cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("password command failed: %w", err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return "", nil
+}
+
+// secretKeys are configuration keys holding secrets.
+var secretKeys = map[string]bool{"smtp_password": true, "password": true}
+
+// Secrets returns values of all secrets found in configuration sources, including values overridden by later sources.
+func (conf *Config) Secrets() []string {
+	return conf.secrets
+}
+
+// collectSecrets loads every source separately, so secret could be found even when it was overridden.
+func collectSecrets(sources []source.Source) []string {
+
+	var (
+		res  []string
+		walk func(v interface{})
+	)
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				if s, ok := e.(string); ok && len(s) > 0 && secretKeys[strings.ToLower(k)] {
+					res = append(res, s)
+					continue
+				}
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	for _, src := range sources {
+		cs, err := src.Read()
+		if err != nil {
+			continue
+		}
+		// the same way BuildConfig selects encoder for configuration file
+		var enc encoder.Encoder
+		switch strings.ToLower(cs.Format) {
+		case "yml", "yaml":
+			enc = yaml.NewEncoder()
+		case "toml":
+			enc = toml.NewEncoder()
+		default:
+			enc = jsonenc.NewEncoder()
+		}
+		var tree interface{}
+		if err := enc.Decode(cs.Data, &tree); err == nil {
+			walk(tree)
+		}
+	}
+	return res
+}
+
+var reSecretArg = regexp.MustCompile(`(?i)((?:smtp_)?password\s*=).*`)
+
+// RedactArgs hides secrets which could be set from command line, so arguments could be safely logged.
+func RedactArgs(args []string) []string {
+	res := make([]string, len(args))
+	for i, a := range args {
+		res[i] = reSecretArg.ReplaceAllString(a, "${1}"+Redacted)
+	}
+	return res
+}
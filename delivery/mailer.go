@@ -0,0 +1,331 @@
+// Package delivery sends converted books to readers.
+package delivery
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gosimple/slug"
+	"go.uber.org/zap"
+	"gopkg.in/gomail.v2"
+
+	"fb2converter/config"
+	"fb2converter/processor"
+	"fb2converter/reporter"
+)
+
+// Mailer delivers books to Kindle by e-mail. Books are sent in batches limited by number of attachments and their total
+// size, failed deliveries are retried with increasing delay and then kept in outbox to be sent later.
+type Mailer struct {
+	// TLSConfig is used for SSL and STARTTLS connections, when nil server certificate is verified against server name.
+	TLSConfig *tls.Config
+
+	cfg    *config.Config
+	log    *zap.Logger
+	rpt    *reporter.Report
+	outbox string // empty when failed books should not be kept
+	tmp    string // downscaled books and message copies when debugging
+	sleep  func(time.Duration)
+
+	pending []*item
+	size    int64
+	queued  map[string]bool // outbox entries created during this run
+	count   int             // messages sent
+}
+
+// item is a book waiting to be sent.
+type item struct {
+	file  string // converted book
+	root  string // directory where removal of empty directories stops, empty if directories should be kept
+	send  string // file to attach, either book itself or its downscaled copy
+	size  int64
+	to    string // recipient, books from outbox go where they were originally sent
+	entry string // outbox entry directory, empty if book is not in outbox
+}
+
+// NewMailer checks send to kindle configuration and prepares mailer.
+func NewMailer(cfg *config.Config, log *zap.Logger, rpt *reporter.Report) (*Mailer, error) {
+
+	if !cfg.SMTPConfig.IsValid() {
+		return nil, errors.New("configuration for Send To Kindle is incorrect")
+	}
+
+	outbox, err := outboxDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "fb2c-stk-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary directory: %w", err)
+	}
+	rpt.Store("stk", tmp)
+
+	return &Mailer{
+		cfg:    cfg,
+		log:    log,
+		rpt:    rpt,
+		outbox: outbox,
+		tmp:    tmp,
+		sleep:  time.Sleep,
+		queued: make(map[string]bool),
+	}, nil
+}
+
+// limit returns maximum total size of attachments in a single message.
+func (m *Mailer) limit() int64 {
+	return int64(m.cfg.SMTPConfig.MaxSize) * 1024 * 1024
+}
+
+// Add queues book for sending, when batch is full it is sent. root is the directory where empty directories left after
+// book removal (delete_sent_book) should stop, empty if directories should not be touched.
+func (m *Mailer) Add(fname, root string) error {
+
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return err
+	}
+	it := &item{file: fname, root: root, send: fname, size: fi.Size(), to: m.cfg.SMTPConfig.To}
+
+	if it.size > m.limit() {
+		if !m.cfg.SMTPConfig.Downscale {
+			return fmt.Errorf("book is too large to be sent (%d bytes, limit is %d), consider enabling downscale_images", it.size, m.limit())
+		}
+		it.send = filepath.Join(m.tmp, fmt.Sprintf("%d", time.Now().UnixNano()), filepath.Base(fname))
+		if err := os.MkdirAll(filepath.Dir(it.send), 0700); err != nil {
+			return err
+		}
+		if err := shrinkEPUB(fname, it.send, m.limit()); err != nil {
+			return fmt.Errorf("book is too large to be sent (%d bytes, limit is %d): %w", it.size, m.limit(), err)
+		}
+		if fi, err = os.Stat(it.send); err != nil {
+			return err
+		}
+		m.log.Info("Images were downscaled to fit into message", zap.String("file", fname), zap.Int64("size", it.size), zap.Int64("downscaled", fi.Size()))
+		it.size = fi.Size()
+	}
+
+	var res error
+	if len(m.pending) > 0 && m.size+it.size > m.limit() {
+		res = m.flush()
+	}
+	m.pending = append(m.pending, it)
+	m.size += it.size
+	if len(m.pending) >= m.cfg.SMTPConfig.MaxBooks {
+		if err := m.flush(); err != nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// Close sends all queued books, then attempts to send books left in outbox by previous runs.
+func (m *Mailer) Close() error {
+
+	defer func() {
+		if m.rpt == nil {
+			os.RemoveAll(m.tmp)
+		}
+	}()
+
+	err := m.flush()
+	if oerr := m.SendOutbox(); err == nil {
+		err = oerr
+	}
+	if m.count > 0 {
+		m.log.Info("Send to Kindle completed", zap.Int("messages", m.count))
+	}
+	return err
+}
+
+// flush sends all pending books in a single message, books which could not be sent are moved to outbox.
+func (m *Mailer) flush() error {
+
+	if len(m.pending) == 0 {
+		return nil
+	}
+	items := m.pending
+	m.pending, m.size = nil, 0
+
+	err := m.deliver(items)
+	if err == nil {
+		for _, it := range items {
+			m.done(it)
+		}
+		return nil
+	}
+	if len(m.outbox) == 0 {
+		return fmt.Errorf("send to Kindle failed: %w", err)
+	}
+	for _, it := range items {
+		if len(it.entry) > 0 {
+			m.requeue(it, err)
+			continue
+		}
+		if qerr := m.enqueue(it, err); qerr != nil {
+			m.log.Error("Unable to put book into outbox", zap.String("file", it.file), zap.Error(qerr))
+		}
+	}
+	return fmt.Errorf("send to Kindle failed, %d book(s) kept in outbox %s: %w", len(items), m.outbox, err)
+}
+
+// deliver sends message repeating attempts on temporary errors.
+func (m *Mailer) deliver(items []*item) error {
+
+	delay := time.Duration(m.cfg.SMTPConfig.RetryDelay) * time.Second
+	for attempt := 1; ; attempt++ {
+		err := m.send(items)
+		if err == nil || permanent(err) || attempt > m.cfg.SMTPConfig.Retries {
+			return err
+		}
+		m.log.Warn("Send to Kindle failed, retrying", zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		m.sleep(delay)
+		delay *= 2
+	}
+}
+
+// send composes single message with all books attached and sends it.
+func (m *Mailer) send(items []*item) error {
+
+	cfg := m.cfg.SMTPConfig
+
+	names := make([]string, 0, len(items))
+	msg := gomail.NewMessage(gomail.SetCharset("UTF-8"), gomail.SetEncoding(gomail.Base64))
+	msg.SetAddressHeader("From", cfg.From, "fb2converter ")
+	// all books in batch have the same recipient
+	to := items[0].to
+	msg.SetAddressHeader("To", to, "kindle device")
+	msg.SetBody("text/plain", "This email has been sent by fb2converter")
+	for _, it := range items {
+
+		// NOTE: Content-Type and Content-Disposition headers require special encoding (rfc2231/rfc5987/rfc8187)
+
+		ext := filepath.Ext(it.send)
+		fullname := strings.TrimSuffix(filepath.Base(it.send), ext)
+		safename := slug.Make(fullname)
+
+		msg.Attach(it.send,
+			gomail.Rename(safename+ext),
+			gomail.SetHeader(
+				map[string][]string{
+					"Content-Type":        {`application/epub+zip; name="` + mime.BEncoding.Encode("UTF-8", fullname+ext) + `"`},
+					"Content-Disposition": {`attachment; ` + processor.EncodeContentDispFilename(safename+ext, fullname+ext)},
+				},
+			),
+		)
+		names = append(names, fullname+ext)
+	}
+	msg.SetHeader("Subject", "Sent to Kindle: "+strings.Join(names, ", "))
+
+	m.log.Debug("Sending content to Kindle - starting", zap.String("from", cfg.From), zap.String("to", to), zap.Strings("files", names))
+	defer func(start time.Time) {
+		m.log.Debug("Sending content to Kindle - done", zap.Duration("elapsed", time.Since(start)))
+	}(time.Now())
+
+	// debugging
+	if m.rpt != nil {
+		var sf gomail.SendFunc = func(from string, to []string, msg io.WriterTo) error {
+			out, err := os.Create(filepath.Join(m.tmp, fmt.Sprintf("%03d.mail", m.count+1)))
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+			_, err = msg.WriteTo(out)
+			return err
+		}
+		if err := gomail.Send(sf, msg); err != nil {
+			return err
+		}
+	}
+
+	c, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// gomail loses SMTP error type which is needed to decide if sending should be repeated
+	var serr error
+	var sf gomail.SendFunc = func(from string, to []string, msg io.WriterTo) error {
+		serr = sendMail(c, from, to, msg)
+		return serr
+	}
+	if err := gomail.Send(sf, msg); err != nil {
+		if serr != nil {
+			return serr
+		}
+		return err
+	}
+	if err := c.Quit(); err != nil {
+		m.log.Debug("Unable to close SMTP session", zap.Error(err))
+	}
+	m.count++
+	m.log.Info("Sent to Kindle", zap.Strings("files", names))
+	return nil
+}
+
+func sendMail(c *smtp.Client, from string, to []string, msg io.WriterTo) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// done cleans up after book was sent.
+func (m *Mailer) done(it *item) {
+
+	if it.send != it.file && len(it.entry) == 0 {
+		os.RemoveAll(filepath.Dir(it.send))
+	}
+	if len(it.entry) > 0 {
+		if err := os.RemoveAll(it.entry); err != nil {
+			m.log.Warn("Unable to remove book from outbox", zap.String("location", it.entry), zap.Error(err))
+		}
+	}
+	if m.cfg.SMTPConfig.DeleteOnSuccess {
+		removeBook(m.log, it.file, it.root)
+	}
+}
+
+// removeBook deletes delivered book along with directories left empty up to root, empty root means directories should
+// be kept.
+func removeBook(log *zap.Logger, file, root string) {
+
+	if _, err := os.Stat(file); err != nil {
+		// nothing to delete
+		return
+	}
+	log.Debug("Deleting after send", zap.String("location", file))
+	if err := os.Remove(file); err != nil {
+		log.Warn("Unable to delete after send", zap.String("location", file), zap.Error(err))
+	}
+	if len(root) == 0 {
+		return
+	}
+	// remove all empty directories in the path following root
+	for outDir := filepath.Dir(file); outDir != root && strings.HasPrefix(outDir, root); outDir = filepath.Dir(outDir) {
+		if err := os.Remove(outDir); err != nil {
+			log.Warn("Unable to delete after send", zap.String("location", outDir), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package delivery
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+)
+
+// smtpServer is minimal SMTP server accepting everything, replies to DATA could be scripted.
+type smtpServer struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	replies  []string // replies to end of DATA, "250 OK" when exhausted
+	messages []string
+	rcpts    []string
+	auths    int
+}
+
+func newSMTPServer(t *testing.T, replies ...string) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, replies: replies}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			s.mu.Lock()
+			s.auths++
+			s.mu.Unlock()
+			reply("235 Authenticated")
+		case strings.HasPrefix(cmd, "RCPT"):
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, strings.TrimSpace(line[len("RCPT TO:"):]))
+			s.mu.Unlock()
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "MAIL"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			s.mu.Lock()
+			res := "250 OK"
+			if len(s.replies) > 0 {
+				res, s.replies = s.replies[0], s.replies[1:]
+			}
+			if strings.HasPrefix(res, "250") {
+				s.messages = append(s.messages, msg.String())
+			}
+			s.mu.Unlock()
+			reply(res)
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// attachments returns number of attachments in every accepted message.
+func (s *smtpServer) attachments() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []int
+	for _, m := range s.messages {
+		res = append(res, strings.Count(m, "Content-Disposition: attachment"))
+	}
+	return res
+}
+
+func (s *smtpServer) authCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auths
+}
+
+func newTestMailer(t *testing.T, port int, mode, extra string) *Mailer {
+
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(fmt.Sprintf(`
+[sendtokindle]
+smtp_server = "localhost"
+smtp_port = %d
+smtp_user = "user"
+smtp_password = "secret"
+from_mail = "from@example.com"
+to_mail = "to@example.com"
+smtp_tls = %q
+outbox = "outbox"
+%s
+`, port, mode, extra)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.BuildConfig("", nil, nil, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMailer(conf, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.sleep = func(time.Duration) {}
+	return m
+}
+
+func writeBook(t *testing.T, name string, size int) string {
+	fname := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fname, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestMailerBatches(t *testing.T) {
+
+	srv := newSMTPServer(t)
+	m := newTestMailer(t, srv.port(), "none", "max_books_per_message = 2")
+
+	for i := 0; i < 3; i++ {
+		if err := m.Add(writeBook(t, fmt.Sprintf("book%d.epub", i), 1024), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(srv.attachments()); got != "[2 1]" {
+		t.Errorf("attachments per message: %s, expected [2 1]", got)
+	}
+}
+
+func TestMailerRetry(t *testing.T) {
+
+	srv := newSMTPServer(t, "451 Try again later", "451 Try again later")
+	m := newTestMailer(t, srv.port(), "none", "retries = 2")
+
+	if err := m.Add(writeBook(t, "book.epub", 1024), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.attachments()); got != 1 {
+		t.Errorf("%d messages accepted, expected 1", got)
+	}
+}
+
+func TestMailerOutbox(t *testing.T) {
+
+	srv := newSMTPServer(t, "554 Rejected")
+	m := newTestMailer(t, srv.port(), "none", "")
+
+	book := writeBook(t, "book.epub", 1024)
+	if err := m.Add(book, ""); err == nil {
+		t.Fatal("permanent failure was not reported")
+	}
+	// book which has just failed is not retried on close
+	m.Close()
+	entries, err := Outbox(m.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].File != book || entries[0].To != "to@example.com" || entries[0].Attempts != 1 ||
+		!strings.Contains(entries[0].Error, "Rejected") {
+		t.Fatalf("unexpected outbox: %+v", entries)
+	}
+
+	// book goes where it was sent originally even if configuration changed since
+	m.cfg.SMTPConfig.To = "other@example.com"
+	m, err = NewMailer(m.cfg, zap.NewNop(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := Outbox(m.cfg); len(entries) != 0 {
+		t.Errorf("outbox is not empty after successful send: %+v", entries)
+	}
+	if got := len(srv.attachments()); got != 1 {
+		t.Errorf("%d messages accepted, expected 1", got)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if got := strings.Join(srv.rcpts, ", "); got != "<to@example.com>, <to@example.com>" {
+		t.Errorf("unexpected recipients: %s", got)
+	}
+}
+
+func TestMailerPlainAuth(t *testing.T) {
+
+	// server does not offer STARTTLS, password must not be sent unless plain connection was explicitly requested
+	srv := newSMTPServer(t)
+	m := newTestMailer(t, srv.port(), "auto", "")
+	if err := m.Add(writeBook(t, "book.epub", 1024), ""); err == nil || !strings.Contains(err.Error(), "plain connection") {
+		t.Errorf("unexpected result for plain connection: %v", err)
+	}
+	m.Close()
+	if srv.authCount() != 0 || len(srv.attachments()) != 0 {
+		t.Errorf("password was sent over plain connection")
+	}
+
+	m = newTestMailer(t, srv.port(), "none", "")
+	if err := m.Add(writeBook(t, "book.epub", 1024), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.authCount() != 1 || len(srv.attachments()) != 1 {
+		t.Errorf("book was not sent over explicitly requested plain connection")
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+
+	a := &loginAuth{username: "user", password: "secret"}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.com", TLS: false, Auth: []string{"LOGIN"}}); err == nil {
+		t.Error("LOGIN over unencrypted connection was allowed")
+	}
+	if proto, _, err := a.Start(&smtp.ServerInfo{Name: "mail.example.com", TLS: true, Auth: []string{"LOGIN"}}); err != nil || proto != "LOGIN" {
+		t.Errorf("unexpected result for encrypted connection: %s, %v", proto, err)
+	}
+	if _, _, err := (insecureAuth{a}).Start(&smtp.ServerInfo{Name: "mail.example.com", Auth: []string{"LOGIN"}}); err != nil {
+		t.Errorf("explicitly allowed plain connection was refused: %v", err)
+	}
+}
+
+func TestMailerTooLarge(t *testing.T) {
+
+	srv := newSMTPServer(t)
+	m := newTestMailer(t, srv.port(), "none", "max_message_size = 1")
+
+	if err := m.Add(writeBook(t, "book.epub", 2*1024*1024), ""); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("unexpected result for large book: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.attachments()); got != 0 {
+		t.Errorf("%d messages accepted, expected none", got)
+	}
+}
+
+func TestShrinkEPUB(t *testing.T) {
+
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "book.epub"), filepath.Join(dir, "small.epub")
+
+	// noise does not compress well
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 1200))
+	for y := 0; y < 1200; y++ {
+		for x := 0; x < 1200; x++ {
+			img.Set(x, y, color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255})
+		}
+	}
+
+	out, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(out)
+	mt, _ := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	mt.Write([]byte("application/epub+zip"))
+	f, _ := w.Create("OEBPS/images/cover.jpg")
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	out.Close()
+
+	const limit = 512 * 1024
+	if fi, _ := os.Stat(src); fi.Size() <= limit {
+		t.Fatalf("test book is too small: %d", fi.Size())
+	}
+	if err := shrinkEPUB(src, dst, limit); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(dst); fi.Size() > limit {
+		t.Errorf("book was not shrunk enough: %d", fi.Size())
+	}
+
+	r, err := zip.OpenReader(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.File[0].Name != "mimetype" || r.File[0].Method != zip.Store {
+		t.Error("mimetype is not first uncompressed entry")
+	}
+	rc, _ := r.File[1].Open()
+	defer rc.Close()
+	cfg, _, err := image.DecodeConfig(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width >= 1200 {
+		t.Errorf("image was not downscaled: %d", cfg.Width)
+	}
+}
@@ -0,0 +1,205 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+)
+
+// entryFile keeps outbox entry description next to the book copy.
+const entryFile = "entry.json"
+
+// Entry describes book which could not be sent and is waiting in outbox.
+type Entry struct {
+	Dir      string    `json:"-"`              // outbox entry directory
+	Book     string    `json:"-"`              // copy of the book to be sent
+	File     string    `json:"file"`           // original location of the book
+	To       string    `json:"to"`             // recipient book was sent to
+	Root     string    `json:"root,omitempty"` // where removal of empty directories stops
+	Queued   time.Time `json:"queued"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+}
+
+// outboxDir returns outbox location creating it if necessary, empty if outbox is disabled.
+func outboxDir(cfg *config.Config) (string, error) {
+
+	dir := cfg.SMTPConfig.Outbox
+	switch {
+	case strings.EqualFold(dir, "none"):
+		return "", nil
+	case len(dir) == 0:
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to find outbox location: %w", err)
+		}
+		dir = filepath.Join(cache, "fb2c", "outbox")
+	case !filepath.IsAbs(dir) && len(cfg.Path) > 0:
+		dir = filepath.Join(cfg.Path, dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("unable to create outbox: %w", err)
+	}
+	return dir, nil
+}
+
+// Outbox returns books waiting in outbox in order they were queued.
+func Outbox(cfg *config.Config) ([]*Entry, error) {
+
+	dir, err := outboxDir(cfg)
+	if err != nil || len(dir) == 0 {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		e, err := readEntry(filepath.Join(dir, d.Name()))
+		if err != nil {
+			// not ours or damaged - leave it alone
+			continue
+		}
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Queued.Before(res[j].Queued) })
+	return res, nil
+}
+
+func readEntry(dir string) (*Entry, error) {
+
+	data, err := os.ReadFile(filepath.Join(dir, entryFile))
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Dir: dir}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Type().IsRegular() && f.Name() != entryFile {
+			e.Book = filepath.Join(dir, f.Name())
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("outbox entry %s has no book", dir)
+}
+
+func (e *Entry) write() error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(e.Dir, entryFile), data, 0600)
+}
+
+// enqueue puts copy of the book into outbox.
+func (m *Mailer) enqueue(it *item, reason error) error {
+
+	dir, err := os.MkdirTemp(m.outbox, time.Now().Format("20060102T150405-"))
+	if err != nil {
+		return err
+	}
+	e := &Entry{Dir: dir, Book: filepath.Join(dir, filepath.Base(it.send)), File: it.file, To: it.to, Root: it.root, Queued: time.Now(), Attempts: 1, Error: reason.Error()}
+	if err := copyFile(it.send, e.Book); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := e.write(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	m.queued[dir] = true
+	m.log.Warn("Book was put into outbox", zap.String("file", it.file), zap.String("location", dir))
+	return nil
+}
+
+// requeue records failed attempt to send book from outbox.
+func (m *Mailer) requeue(it *item, reason error) {
+	e, err := readEntry(it.entry)
+	if err == nil {
+		e.Attempts++
+		e.Error = reason.Error()
+		err = e.write()
+	}
+	if err != nil {
+		m.log.Warn("Unable to update outbox entry", zap.String("location", it.entry), zap.Error(err))
+	}
+}
+
+// SendOutbox attempts to send books left in outbox by previous runs.
+func (m *Mailer) SendOutbox() error {
+
+	if len(m.outbox) == 0 {
+		return nil
+	}
+	entries, err := Outbox(m.cfg)
+	if err != nil {
+		return fmt.Errorf("unable to read outbox: %w", err)
+	}
+
+	var res error
+	for _, e := range entries {
+		if m.queued[e.Dir] {
+			// just failed, no reason to try again
+			continue
+		}
+		fi, err := os.Stat(e.Book)
+		if err != nil {
+			continue
+		}
+		to := e.To
+		if len(to) == 0 {
+			// entry written before recipient was recorded
+			to = m.cfg.SMTPConfig.To
+		}
+		it := &item{file: e.File, root: e.Root, send: e.Book, size: fi.Size(), to: to, entry: e.Dir}
+		if len(m.pending) > 0 && (m.size+it.size > m.limit() || len(m.pending) >= m.cfg.SMTPConfig.MaxBooks || m.pending[0].to != it.to) {
+			if err := m.flush(); err != nil {
+				res = err
+			}
+		}
+		m.pending = append(m.pending, it)
+		m.size += it.size
+	}
+	if err := m.flush(); err != nil {
+		res = err
+	}
+	return res
+}
+
+func copyFile(from, to string) error {
+
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,120 @@
+package delivery
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// shrinkStep is applied to image dimensions on every pass.
+	shrinkStep = 0.75
+	// shrinkPasses limits how small images could become - about 1/10 of original size.
+	shrinkPasses = 8
+	// shrinkQuality is used to re-encode JPEG images.
+	shrinkQuality = 75
+)
+
+// shrinkEPUB writes copy of EPUB book with images scaled down until it fits into limit.
+func shrinkEPUB(src, dst string, limit int64) error {
+
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	scale := 1.0
+	for pass := 0; pass < shrinkPasses; pass++ {
+		scale *= shrinkStep
+		if err := rewriteEPUB(&r.Reader, dst, scale); err != nil {
+			return err
+		}
+		fi, err := os.Stat(dst)
+		if err != nil {
+			return err
+		}
+		if fi.Size() <= limit {
+			return nil
+		}
+	}
+	os.Remove(dst)
+	return fmt.Errorf("unable to fit book into %d bytes by downscaling images", limit)
+}
+
+// rewriteEPUB copies archive as is resizing all images it could decode by scale.
+func rewriteEPUB(r *zip.Reader, dst string, scale float64) (err error) {
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	w := zip.NewWriter(out)
+	for _, f := range r.File {
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".jpg", ".jpeg", ".png", ".gif":
+			if done, err := resizeImage(w, f, scale); err != nil {
+				return err
+			} else if done {
+				continue
+			}
+		}
+		// NOTE: keeps "mimetype" first and uncompressed as EPUB requires
+		if err := w.Copy(f); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// resizeImage writes scaled image into archive, returns false if image could not be decoded and should be copied as is.
+func resizeImage(w *zip.Writer, f *zip.File, scale float64) (bool, error) {
+
+	rc, err := f.Open()
+	if err != nil {
+		return false, err
+	}
+	img, format, err := image.Decode(rc)
+	rc.Close()
+	if err != nil {
+		return false, nil
+	}
+
+	width := int(float64(img.Bounds().Dx()) * scale)
+	if width < 1 {
+		width = 1
+	}
+	img = imaging.Resize(img, width, 0, imaging.Lanczos)
+
+	out, err := w.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.Modified})
+	if err != nil {
+		return false, err
+	}
+	return true, encodeImage(out, img, format)
+}
+
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: shrinkQuality})
+	}
+}
@@ -0,0 +1,154 @@
+package delivery
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"fb2converter/config"
+)
+
+// dialTimeout limits time to establish connection with SMTP server.
+const dialTimeout = 30 * time.Second
+
+// dial connects to SMTP server using requested connection security and authenticates if user is configured.
+func (m *Mailer) dial() (*smtp.Client, error) {
+
+	cfg := m.cfg.SMTPConfig
+	addr := net.JoinHostPort(cfg.Server, strconv.Itoa(cfg.Port))
+
+	mode := strings.ToLower(cfg.TLS)
+	if mode == config.TLSAuto && cfg.Port == 465 {
+		mode = config.TLSSSL
+	}
+	tlsConfig := m.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: cfg.Server}
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	d := &net.Dialer{Timeout: dialTimeout}
+	if mode == config.TLSSSL {
+		conn, err = tls.DialWithDialer(d, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	secure := mode == config.TLSSSL
+	if mode == config.TLSAuto || mode == config.TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Close()
+				return nil, err
+			}
+			secure = true
+		} else if mode == config.TLSStartTLS {
+			c.Close()
+			return nil, errors.New("server does not support STARTTLS")
+		}
+	}
+
+	if len(cfg.User) == 0 {
+		return c, nil
+	}
+	ok, mechs := c.Extension("AUTH")
+	if !ok {
+		return c, nil
+	}
+	password, err := m.cfg.SMTPPassword()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	// password may come from outside of configuration, make sure it does not end up in debug report
+	m.rpt.Redact(password)
+
+	var auth smtp.Auth
+	switch {
+	case strings.Contains(mechs, "CRAM-MD5"):
+		auth = smtp.CRAMMD5Auth(cfg.User, password)
+	case strings.Contains(mechs, "LOGIN") && !strings.Contains(mechs, "PLAIN"):
+		auth = &loginAuth{username: cfg.User, password: password}
+	default:
+		auth = smtp.PlainAuth("", cfg.User, password, cfg.Server)
+	}
+	if !secure {
+		if mode != config.TLSNone {
+			c.Close()
+			return nil, fmt.Errorf("server does not support encryption, refusing to send password over plain connection (set smtp_tls to %q to allow it)", config.TLSNone)
+		}
+		// user explicitly asked for plain connection
+		auth = insecureAuth{auth}
+	}
+	if err := c.Auth(auth); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// insecureAuth allows authentication over unencrypted connection which net/smtp refuses to do for remote servers.
+type insecureAuth struct {
+	smtp.Auth
+}
+
+func (a insecureAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	s := *server
+	s.TLS = true
+	return a.Auth.Start(&s)
+}
+
+// loginAuth implements LOGIN authentication mechanism which is not supported by net/smtp.
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// same rule as net/smtp PlainAuth uses
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", []byte{}, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// permanent detects SMTP errors which would not go away if sending is repeated.
+func permanent(err error) bool {
+	var te *textproto.Error
+	return errors.As(err, &te) && te.Code >= 500
+}
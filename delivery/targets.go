@@ -0,0 +1,263 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+)
+
+// defaultTimeout limits single delivery when target does not specify its own timeout.
+const defaultTimeout = 5 * time.Minute
+
+// Targets delivers converted books to destinations from "delivery" configuration section. Unlike sending to Kindle
+// delivery is synchronous - book is delivered to all targets accepting its format before the next one is converted.
+type Targets struct {
+	// Keep disables removal of delivered books, it is set when books are still needed after delivery (send to Kindle).
+	Keep bool
+
+	cfg *config.Config
+	log *zap.Logger
+}
+
+// NewTargets prepares delivery to configured targets.
+func NewTargets(cfg *config.Config, log *zap.Logger) (*Targets, error) {
+
+	if len(cfg.Targets) == 0 {
+		return nil, errors.New("no delivery targets configured")
+	}
+	return &Targets{cfg: cfg, log: log}, nil
+}
+
+// Deliver hands book to every target accepting format. Book is removed only when all such targets succeeded and at least
+// one of them asked for removal. root is the directory where removal of empty directories stops, empty if directories
+// should not be touched, it also defines book name relative to target location.
+func (t *Targets) Deliver(fname, root, format string) error {
+
+	name := filepath.Base(fname)
+	if len(root) > 0 {
+		if rel, err := filepath.Rel(root, fname); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+		}
+	}
+
+	var (
+		remove bool
+		failed []string
+	)
+	for _, tgt := range t.cfg.Targets {
+		if !tgt.Accepts(format) {
+			continue
+		}
+		start := time.Now()
+		if err := t.deliver(tgt, fname, name, format); err != nil {
+			t.log.Warn("Delivery failed", zap.Stringer("target", tgt), zap.String("file", fname), zap.Error(err))
+			failed = append(failed, fmt.Sprintf("%s: %v", tgt, err))
+			continue
+		}
+		t.log.Info("Delivered", zap.Stringer("target", tgt), zap.String("file", fname), zap.Duration("elapsed", time.Since(start)))
+		remove = remove || tgt.DeleteOnSuccess
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("delivery failed: %s", strings.Join(failed, "; "))
+	}
+	if remove && !t.Keep {
+		removeBook(t.log, fname, root)
+	}
+	return nil
+}
+
+func (t *Targets) deliver(tgt *config.Target, fname, name, format string) error {
+
+	timeout := defaultTimeout
+	if tgt.Timeout > 0 {
+		timeout = time.Duration(tgt.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch strings.ToLower(tgt.Type) {
+	case config.TargetPath:
+		return t.copyTo(tgt, fname, name)
+	case config.TargetWebDAV:
+		return t.upload(ctx, tgt, fname, name)
+	case config.TargetWebhook:
+		return t.post(ctx, tgt, fname, name, format)
+	case config.TargetCommand:
+		return t.run(ctx, tgt, fname, name, format)
+	}
+	return fmt.Errorf("unknown delivery target type %q", tgt.Type)
+}
+
+// copyTo copies book into directory, partially copied book never appears under its final name.
+func (t *Targets) copyTo(tgt *config.Target, fname, name string) error {
+
+	dir := tgt.Path
+	if !filepath.IsAbs(dir) && len(t.cfg.Path) > 0 {
+		dir = filepath.Join(t.cfg.Path, dir)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("destination %s is not available", dir)
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := copyFile(fname, dst+".part"); err != nil {
+		os.Remove(dst + ".part")
+		return err
+	}
+	return os.Rename(dst+".part", dst)
+}
+
+// upload puts book to WebDAV server creating missing collections.
+func (t *Targets) upload(ctx context.Context, tgt *config.Target, fname, name string) error {
+
+	base := strings.TrimSuffix(tgt.URL, "/")
+	parts := strings.Split(name, "/")
+	for i := range parts[:len(parts)-1] {
+		// 405 means collection already exists
+		if err := t.request(ctx, tgt, "MKCOL", base+escapePath(parts[:i+1])+"/", nil, "", http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.request(ctx, tgt, http.MethodPut, base+escapePath(parts), f, "application/octet-stream", http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// post sends book as multipart form, along with its name and format.
+func (t *Targets) post(ctx context.Context, tgt *config.Target, fname, name, format string) error {
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	field := tgt.Field
+	if len(field) == 0 {
+		field = "file"
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := mw.WriteField("name", name)
+		if err == nil {
+			err = mw.WriteField("format", format)
+		}
+		if err == nil {
+			var part io.Writer
+			if part, err = mw.CreateFormFile(field, path.Base(name)); err == nil {
+				_, err = io.Copy(part, f)
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	err = t.request(ctx, tgt, http.MethodPost, tgt.URL, pr, mw.FormDataContentType())
+	// make sure writer is not left blocked when request failed early
+	pr.Close()
+	return err
+}
+
+// request performs HTTP request, any 2xx status is success unless expected statuses are listed.
+func (t *Targets) request(ctx context.Context, tgt *config.Target, method, addr string, body io.Reader, ctype string, expected ...int) error {
+
+	req, err := http.NewRequestWithContext(ctx, method, addr, body)
+	if err != nil {
+		return err
+	}
+	if f, ok := body.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil {
+			req.ContentLength = fi.Size()
+		}
+	}
+	if len(ctype) > 0 {
+		req.Header.Set("Content-Type", ctype)
+	}
+	for k, v := range tgt.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(tgt.User) > 0 {
+		req.SetBasicAuth(tgt.User, string(tgt.Password))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if len(expected) == 0 && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: %s", method, req.URL.Redacted(), resp.Status)
+}
+
+// run executes command replacing {file}, {name} and {format} in its arguments, book path is appended when {file} is
+// not used.
+func (t *Targets) run(ctx context.Context, tgt *config.Target, fname, name, format string) error {
+
+	if len(tgt.Command) == 0 {
+		return errors.New("command is not specified")
+	}
+	r := strings.NewReplacer("{file}", fname, "{name}", name, "{format}", format)
+	args := make([]string, 0, len(tgt.Command)+1)
+	hasFile := false
+	for _, a := range tgt.Command[1:] {
+		hasFile = hasFile || strings.Contains(a, "{file}")
+		args = append(args, r.Replace(a))
+	}
+	if !hasFile {
+		args = append(args, fname)
+	}
+
+	out, err := exec.CommandContext(ctx, tgt.Command[0], args...).CombinedOutput()
+	if len(out) > 0 {
+		t.log.Debug("Delivery command output", zap.Stringer("target", tgt), zap.ByteString("output", out))
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); len(msg) > 0 {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// escapePath builds URL path from name elements.
+func escapePath(parts []string) string {
+	var b strings.Builder
+	for _, p := range parts {
+		b.WriteString("/")
+		b.WriteString(url.PathEscape(p))
+	}
+	return b.String()
+}
@@ -0,0 +1,209 @@
+package delivery
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+)
+
+func newTestTargets(t *testing.T, targets string) *Targets {
+
+	fname := filepath.Join(t.TempDir(), "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(targets), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.BuildConfig("", nil, nil, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := conf.Check(); ps.Errors() > 0 {
+		t.Fatal(ps.Err())
+	}
+	tgts, err := NewTargets(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tgts
+}
+
+// writeConverted creates book inside destination directory the way converter does.
+func writeConverted(t *testing.T, root, name string) string {
+	fname := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname, []byte("book content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestTargetPath(t *testing.T) {
+
+	device, root := t.TempDir(), t.TempDir()
+	tgts := newTestTargets(t, fmt.Sprintf(`
+[[delivery]]
+type = "path"
+path = %q
+formats = ["epub"]
+delete_delivered_book = true
+`, device))
+
+	// format is not accepted, book stays
+	book := writeConverted(t, root, "Author/Title.azw3")
+	if err := tgts.Deliver(book, root, "azw3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(book); err != nil {
+		t.Error("book of not accepted format was removed")
+	}
+
+	book = writeConverted(t, root, "Series/Author/Title.epub")
+	if err := tgts.Deliver(book, root, "epub"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(device, "Series", "Author", "Title.epub")); err != nil || string(data) != "book content" {
+		t.Errorf("book was not copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "Series")); !os.IsNotExist(err) {
+		t.Error("delivered book or its directories were not removed")
+	}
+
+	// device is gone, book must not be removed
+	book = writeConverted(t, root, "Title.epub")
+	os.RemoveAll(device)
+	if err := tgts.Deliver(book, root, "epub"); err == nil {
+		t.Error("delivery to missing directory succeeded")
+	}
+	if _, err := os.Stat(book); err != nil {
+		t.Error("book was removed after failed delivery")
+	}
+}
+
+func TestTargetWebDAV(t *testing.T) {
+
+	var (
+		mu       sync.Mutex
+		requests []string
+		body     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if user, pass, ok := r.BasicAuth(); !ok || user != "reader" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		switch r.Method {
+		case "MKCOL":
+			if r.URL.Path == "/dav/Author/" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	tgts := newTestTargets(t, fmt.Sprintf(`
+[[delivery]]
+type = "webdav"
+url = "%s/dav/"
+user = "reader"
+password = "secret"
+`, srv.URL))
+
+	if err := tgts.Deliver(writeConverted(t, root, "Author/Series 1/Title.epub"), root, "epub"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "MKCOL /dav/Author/, MKCOL /dav/Author/Series%201/, PUT /dav/Author/Series%201/Title.epub"
+	if got := strings.Join(requests, ", "); got != expected {
+		t.Errorf("unexpected requests: %s", got)
+	}
+	if body != "book content" {
+		t.Errorf("unexpected content: %q", body)
+	}
+}
+
+func TestTargetWebhook(t *testing.T) {
+
+	var name, format, file, token string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Token")
+		f, _, err := r.FormFile("book")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		name, format, file = r.FormValue("name"), r.FormValue("format"), string(data)
+	}))
+	defer srv.Close()
+
+	tgts := newTestTargets(t, fmt.Sprintf(`
+[[delivery]]
+type = "webhook"
+url = %q
+field = "book"
+headers = { X-Token = "abc" }
+`, srv.URL))
+
+	root := t.TempDir()
+	if err := tgts.Deliver(writeConverted(t, root, "Author/Title.epub"), root, "epub"); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Author/Title.epub" || format != "epub" || file != "book content" || token != "abc" {
+		t.Errorf("unexpected upload: name %q, format %q, content %q, token %q", name, format, file, token)
+	}
+
+	tgts.cfg.Targets[0].Field = "wrong"
+	if err := tgts.Deliver(writeConverted(t, root, "Title.epub"), root, "epub"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("unexpected result for rejected upload: %v", err)
+	}
+}
+
+func TestTargetCommand(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("requires cp")
+	}
+
+	dst, root := t.TempDir(), t.TempDir()
+	tgts := newTestTargets(t, fmt.Sprintf(`
+[[delivery]]
+type = "command"
+command = ["cp", "{file}", %q]
+
+[[delivery]]
+type = "command"
+command = ["false"]
+formats = ["kepub"]
+`, filepath.Join(dst, "{format}-copy")))
+
+	if err := tgts.Deliver(writeConverted(t, root, "Title.epub"), root, "epub"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "epub-copy")); err != nil || string(data) != "book content" {
+		t.Errorf("command was not executed: %v", err)
+	}
+	if err := tgts.Deliver(writeConverted(t, root, "Title.kepub.epub"), root, "kepub"); err == nil {
+		t.Error("failed command was not reported")
+	}
+}
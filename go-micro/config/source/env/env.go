@@ -0,0 +1,72 @@
+// Package env is an environment variables source. Variable name without prefix is split into configuration key path by
+// separator and lower cased, so with default options FB2C_DOCUMENT__TOC__TYPE sets "document.toc.type". Variables with
+// single path element are ignored.
+package env
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"fb2converter/go-micro/config/source"
+)
+
+var (
+	DefaultPrefix    = "FB2C_"
+	DefaultSeparator = "__"
+)
+
+type env struct {
+	prefix    string
+	separator string
+	convert   source.Converter
+	opts      source.Options
+}
+
+func (e *env) Read() (*source.ChangeSet, error) {
+	tree := make(source.Tree)
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, e.prefix) {
+			continue
+		}
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, e.prefix)), e.separator)
+		if len(path) < 2 {
+			continue
+		}
+		var v interface{} = value
+		if e.convert != nil {
+			var err error
+			if v, err = e.convert(path, value); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %w", name, err)
+			}
+		}
+		if err := tree.Set(path, v); err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return tree.ChangeSet(e.String())
+}
+
+func (e *env) Watch() (source.Watcher, error) {
+	return source.NewNoopWatcher()
+}
+
+func (e *env) String() string {
+	return "env"
+}
+
+func NewSource(opts ...source.Option) source.Source {
+	options := source.NewOptions(opts...)
+	e := &env{opts: options, prefix: DefaultPrefix, separator: DefaultSeparator}
+	if p, ok := options.Context.Value(prefixKey{}).(string); ok {
+		e.prefix = p
+	}
+	if s, ok := options.Context.Value(separatorKey{}).(string); ok {
+		e.separator = s
+	}
+	if c, ok := options.Context.Value(converterKey{}).(source.Converter); ok {
+		e.convert = c
+	}
+	return e
+}
@@ -0,0 +1,35 @@
+package env
+
+import (
+	"context"
+
+	"fb2converter/go-micro/config/source"
+)
+
+type prefixKey struct{}
+type separatorKey struct{}
+type converterKey struct{}
+
+// WithPrefix sets prefix of environment variables to consider
+func WithPrefix(p string) source.Option {
+	return withValue(prefixKey{}, p)
+}
+
+// WithSeparator sets separator of key path elements in variable name
+func WithSeparator(s string) source.Option {
+	return withValue(separatorKey{}, s)
+}
+
+// WithConverter sets function to convert values to proper types, by default all values are strings
+func WithConverter(c source.Converter) source.Option {
+	return withValue(converterKey{}, c)
+}
+
+func withValue(key, value interface{}) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, key, value)
+	}
+}
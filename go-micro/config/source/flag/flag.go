@@ -0,0 +1,58 @@
+// Package flag is a command line source. Values are "key.path=value" pairs, as given to repeated command line option.
+package flag
+
+import (
+	"fmt"
+	"strings"
+
+	"fb2converter/go-micro/config/source"
+)
+
+type flag struct {
+	values  []string
+	convert source.Converter
+	opts    source.Options
+}
+
+func (f *flag) Read() (*source.ChangeSet, error) {
+	tree := make(source.Tree)
+	for _, kv := range f.values {
+		key, value, ok := strings.Cut(kv, "=")
+		key = strings.TrimSpace(key)
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("bad value \"%s\", should be key=value", kv)
+		}
+		path := strings.Split(key, ".")
+		var v interface{} = value
+		if f.convert != nil {
+			var err error
+			if v, err = f.convert(path, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		if err := tree.Set(path, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return tree.ChangeSet(f.String())
+}
+
+func (f *flag) Watch() (source.Watcher, error) {
+	return source.NewNoopWatcher()
+}
+
+func (f *flag) String() string {
+	return "flag"
+}
+
+func NewSource(opts ...source.Option) source.Source {
+	options := source.NewOptions(opts...)
+	f := &flag{opts: options}
+	if v, ok := options.Context.Value(valuesKey{}).([]string); ok {
+		f.values = v
+	}
+	if c, ok := options.Context.Value(converterKey{}).(source.Converter); ok {
+		f.convert = c
+	}
+	return f
+}
@@ -0,0 +1,29 @@
+package flag
+
+import (
+	"context"
+
+	"fb2converter/go-micro/config/source"
+)
+
+type valuesKey struct{}
+type converterKey struct{}
+
+// WithValues sets "key.path=value" pairs
+func WithValues(v []string) source.Option {
+	return withValue(valuesKey{}, v)
+}
+
+// WithConverter sets function to convert values to proper types, by default all values are strings
+func WithConverter(c source.Converter) source.Option {
+	return withValue(converterKey{}, c)
+}
+
+func withValue(key, value interface{}) source.Option {
+	return func(o *source.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, key, value)
+	}
+}
@@ -0,0 +1,52 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Converter turns textual value of the configuration key into typed value.
+type Converter func(path []string, value string) (interface{}, error)
+
+// Tree collects individual key values into nested configuration tree.
+type Tree map[string]interface{}
+
+// Set puts value into the tree creating intermediate levels as necessary.
+func (t Tree) Set(path []string, value interface{}) error {
+	if len(path) == 0 {
+		return fmt.Errorf("empty key")
+	}
+	node := t
+	for i, k := range path[:len(path)-1] {
+		next, ok := node[k]
+		if !ok {
+			m := make(Tree)
+			node[k] = m
+			node = m
+			continue
+		}
+		if node, ok = next.(Tree); !ok {
+			return fmt.Errorf("key %s already has value", strings.Join(path[:i+1], "."))
+		}
+	}
+	node[path[len(path)-1]] = value
+	return nil
+}
+
+// ChangeSet returns tree as json change set.
+func (t Tree) ChangeSet(src string) (*ChangeSet, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ChangeSet{
+		Format:    "json",
+		Source:    src,
+		Data:      b,
+		Timestamp: time.Now(),
+	}
+	cs.Checksum = cs.Sum()
+	return cs, nil
+}
@@ -0,0 +1,421 @@
+// Package opds builds OPDS 1.2 catalogs (Atom feeds) for a set of books.
+package opds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OPDS media types and link relations.
+const (
+	NavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	AcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+
+	RelStart       = "start"
+	RelUp          = "up"
+	RelSelf        = "self"
+	RelNext        = "next"
+	RelPrevious    = "previous"
+	RelSubsection  = "subsection"
+	RelAcquisition = "http://opds-spec.org/acquisition"
+	RelImage       = "http://opds-spec.org/image"
+	RelThumbnail   = "http://opds-spec.org/image/thumbnail"
+)
+
+// Link is Atom link.
+type Link struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+// Author is Atom person.
+type Author struct {
+	Name string `xml:"name"`
+}
+
+// Category is Atom category.
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// Content is Atom text construct.
+type Content struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+// Entry is Atom entry, either navigation or book.
+type Entry struct {
+	ID         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Authors    []Author   `xml:"author,omitempty"`
+	Language   string     `xml:"dc:language,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Categories []Category `xml:"category,omitempty"`
+	Summary    *Content   `xml:"summary,omitempty"`
+	Content    *Content   `xml:"content,omitempty"`
+	Links      []Link     `xml:"link"`
+}
+
+// Feed is Atom feed.
+type Feed struct {
+	XMLName   xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	XmlnsDC   string   `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string   `xml:"xmlns:opds,attr"`
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Updated   string   `xml:"updated"`
+	Links     []Link   `xml:"link"`
+	Entries   []*Entry `xml:"entry"`
+}
+
+// Write writes feed as XML document.
+func (f *Feed) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// File is a book file available for download.
+type File struct {
+	Href string // relative to catalog root, absolute path (both unescaped) or URL
+	Type string // media type
+}
+
+// Book is catalog entry.
+type Book struct {
+	ID         string
+	Title      string
+	Authors    []string
+	Series     string
+	Number     int
+	Genres     []Category
+	Language   string
+	Date       string
+	Publisher  string
+	Annotation string
+	Updated    time.Time
+	Cover      string // hrefs are relative to catalog root, absolute paths or URLs
+	Thumbnail  string
+	Files      []File
+}
+
+// Catalog is a set of books with navigation by author, series, genre and language. All feeds are produced at once, so
+// they could be saved as a static tree or kept in memory.
+type Catalog struct {
+	Title    string
+	ID       string // used as a prefix for feed ids
+	PageSize int    // books per acquisition feed page, 0 - everything on a single page
+	Books    []*Book
+}
+
+const timeFormat = time.RFC3339
+
+// href makes link from feed to target, both relative to catalog root. Paths are escaped, URLs are kept as is.
+func href(from, to string) string {
+	if strings.Contains(to, "://") {
+		return to
+	}
+	to = escapePath(to)
+	if strings.HasPrefix(to, "/") {
+		return to
+	}
+	depth := strings.Count(from, "/")
+	return strings.Repeat("../", depth) + to
+}
+
+// escapePath escapes every path element, colon is escaped too, so relative path is never taken for URL scheme.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		parts[i] = strings.ReplaceAll(url.PathEscape(parts[i]), ":", "%3A")
+	}
+	return strings.Join(parts, "/")
+}
+
+// group is a named set of books with its own acquisition feed.
+type group struct {
+	title string
+	books []*Book
+}
+
+// Build produces all catalog feeds, calling fn for each with its path relative to catalog root. Root navigation
+// feed is "index.xml".
+func (c *Catalog) Build(fn func(name string, f *Feed) error) error {
+
+	now := time.Now().UTC().Format(timeFormat)
+
+	newFeed := func(name, title, kind string) *Feed {
+		f := &Feed{
+			XmlnsDC:   "http://purl.org/dc/terms/",
+			XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+			ID:        c.ID + ":" + strings.TrimSuffix(name, ".xml"),
+			Title:     title,
+			Updated:   now,
+			Links: []Link{
+				{Rel: RelSelf, Href: path.Base(name), Type: kind},
+				{Rel: RelStart, Href: href(name, "index.xml"), Type: NavigationType},
+			},
+		}
+		return f
+	}
+
+	acquisition := func(name, title, up string, books []*Book) error {
+		pages := 1
+		if c.PageSize > 0 && len(books) > c.PageSize {
+			pages = (len(books) + c.PageSize - 1) / c.PageSize
+		}
+		pageName := func(n int) string {
+			if n == 1 {
+				return name
+			}
+			return strings.TrimSuffix(name, ".xml") + "-" + strconv.Itoa(n) + ".xml"
+		}
+		for n := 1; n <= pages; n++ {
+			fname := pageName(n)
+			f := newFeed(fname, title, AcquisitionType)
+			f.Links = append(f.Links, Link{Rel: RelUp, Href: href(fname, up), Type: NavigationType})
+			if n > 1 {
+				f.Links = append(f.Links, Link{Rel: RelPrevious, Href: path.Base(pageName(n - 1)), Type: AcquisitionType})
+			}
+			if n < pages {
+				f.Links = append(f.Links, Link{Rel: RelNext, Href: path.Base(pageName(n + 1)), Type: AcquisitionType})
+			}
+			page := books
+			if pages > 1 {
+				page = books[(n-1)*c.PageSize:]
+				if len(page) > c.PageSize {
+					page = page[:c.PageSize]
+				}
+			}
+			for _, b := range page {
+				f.Entries = append(f.Entries, c.bookEntry(fname, b))
+			}
+			if err := fn(fname, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	navigation := func(name, title string, groups []*group) error {
+		f := newFeed(name, title, NavigationType)
+		f.Links = append(f.Links, Link{Rel: RelUp, Href: href(name, "index.xml"), Type: NavigationType})
+		dir := strings.TrimSuffix(name, ".xml")
+		for i, g := range groups {
+			sub := fmt.Sprintf("%s/%d.xml", dir, i+1)
+			f.Entries = append(f.Entries, &Entry{
+				ID:      c.ID + ":" + strings.TrimSuffix(sub, ".xml"),
+				Title:   g.title,
+				Updated: now,
+				Content: &Content{Type: "text", Text: fmt.Sprintf("%d books", len(g.books))},
+				Links:   []Link{{Rel: RelSubsection, Href: href(name, sub), Type: AcquisitionType}},
+			})
+			if err := acquisition(sub, g.title, name, g.books); err != nil {
+				return err
+			}
+		}
+		return fn(name, f)
+	}
+
+	byTitle := append([]*Book{}, c.Books...)
+	sort.SliceStable(byTitle, func(i, j int) bool { return less(byTitle[i].Title, byTitle[j].Title) })
+
+	sections := []struct {
+		name, title string
+		groups      []*group
+	}{
+		{"authors.xml", "By author", groupBooks(byTitle, func(b *Book) []string { return b.Authors })},
+		{"series.xml", "By series", groupBooks(byTitle, func(b *Book) []string {
+			if len(b.Series) == 0 {
+				return nil
+			}
+			return []string{b.Series}
+		})},
+		{"genres.xml", "By genre", groupBooks(byTitle, func(b *Book) []string {
+			var res []string
+			for _, g := range b.Genres {
+				if len(g.Label) > 0 {
+					res = append(res, g.Label)
+				} else {
+					res = append(res, g.Term)
+				}
+			}
+			return res
+		})},
+		{"languages.xml", "By language", groupBooks(byTitle, func(b *Book) []string {
+			if len(b.Language) == 0 {
+				return nil
+			}
+			return []string{b.Language}
+		})},
+	}
+	// books in series are naturally ordered by number
+	for _, g := range sections[1].groups {
+		sort.SliceStable(g.books, func(i, j int) bool { return g.books[i].Number < g.books[j].Number })
+	}
+
+	root := newFeed("index.xml", c.Title, NavigationType)
+	root.Entries = append(root.Entries, &Entry{
+		ID:      c.ID + ":all",
+		Title:   "All books",
+		Updated: now,
+		Content: &Content{Type: "text", Text: fmt.Sprintf("%d books", len(byTitle))},
+		Links:   []Link{{Rel: RelSubsection, Href: "all.xml", Type: AcquisitionType}},
+	})
+	if err := acquisition("all.xml", "All books", "index.xml", byTitle); err != nil {
+		return err
+	}
+	for _, s := range sections {
+		if len(s.groups) == 0 {
+			continue
+		}
+		root.Entries = append(root.Entries, &Entry{
+			ID:      c.ID + ":" + strings.TrimSuffix(s.name, ".xml"),
+			Title:   s.title,
+			Updated: now,
+			Content: &Content{Type: "text", Text: fmt.Sprintf("%d entries", len(s.groups))},
+			Links:   []Link{{Rel: RelSubsection, Href: s.name, Type: NavigationType}},
+		})
+		if err := navigation(s.name, s.title, s.groups); err != nil {
+			return err
+		}
+	}
+	return fn("index.xml", root)
+}
+
+// bookEntry makes acquisition entry for the book placed into feed.
+func (c *Catalog) bookEntry(feed string, b *Book) *Entry {
+
+	e := &Entry{
+		ID:         b.ID,
+		Title:      b.Title,
+		Updated:    b.Updated.UTC().Format(timeFormat),
+		Language:   b.Language,
+		Issued:     b.Date,
+		Publisher:  b.Publisher,
+		Categories: b.Genres,
+	}
+	if !strings.Contains(e.ID, ":") {
+		e.ID = "urn:uuid:" + e.ID
+	}
+	for _, a := range b.Authors {
+		e.Authors = append(e.Authors, Author{Name: a})
+	}
+	var summary []string
+	if len(b.Series) > 0 {
+		s := b.Series
+		if b.Number > 0 {
+			s += " #" + strconv.Itoa(b.Number)
+		}
+		summary = append(summary, s)
+	}
+	if len(b.Annotation) > 0 {
+		summary = append(summary, b.Annotation)
+	}
+	if len(summary) > 0 {
+		e.Summary = &Content{Type: "text", Text: strings.Join(summary, "\n\n")}
+	}
+	if len(b.Cover) > 0 {
+		e.Links = append(e.Links, Link{Rel: RelImage, Href: href(feed, b.Cover), Type: imageType(b.Cover)})
+	}
+	if len(b.Thumbnail) > 0 {
+		e.Links = append(e.Links, Link{Rel: RelThumbnail, Href: href(feed, b.Thumbnail), Type: imageType(b.Thumbnail)})
+	}
+	for _, f := range b.Files {
+		e.Links = append(e.Links, Link{Rel: RelAcquisition, Href: href(feed, f.Href), Type: f.Type})
+	}
+	return e
+}
+
+// imageType guesses image media type by its name.
+func imageType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// less compares names case insensitively.
+func less(a, b string) bool {
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+// groupBooks splits books into named groups sorted by name, book could belong to several groups.
+func groupBooks(books []*Book, keys func(*Book) []string) []*group {
+	index := make(map[string]*group)
+	for _, b := range books {
+		for _, k := range keys(b) {
+			if k = strings.TrimSpace(k); len(k) == 0 {
+				continue
+			}
+			g, ok := index[k]
+			if !ok {
+				g = &group{title: k}
+				index[k] = g
+			}
+			g.books = append(g.books, b)
+		}
+	}
+	res := make([]*group, 0, len(index))
+	for _, g := range index {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return less(res[i].title, res[j].title) })
+	return res
+}
+
+// MediaType returns media type of the book file by its name.
+func MediaType(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".kepub.epub"), strings.HasSuffix(lower, ".epub"):
+		return "application/epub+zip"
+	case strings.HasSuffix(lower, ".azw3"):
+		return "application/x-mobi8-ebook"
+	case strings.HasSuffix(lower, ".mobi"):
+		return "application/x-mobipocket-ebook"
+	case strings.HasSuffix(lower, ".fb2"):
+		return "application/x-fictionbook+xml"
+	case strings.HasSuffix(lower, ".fb2.zip"):
+		return "application/x-zip-compressed-fb2"
+	case strings.HasSuffix(lower, ".pdf"):
+		return "application/pdf"
+	case strings.HasSuffix(lower, ".txt"):
+		return "text/plain"
+	case strings.HasSuffix(lower, ".md"):
+		return "text/markdown"
+	case strings.HasSuffix(lower, ".html"):
+		return "text/html"
+	case strings.HasSuffix(lower, ".htmlz"):
+		return "application/zip"
+	default:
+		return "application/octet-stream"
+	}
+}
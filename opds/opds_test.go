@@ -0,0 +1,79 @@
+package opds
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatalogBuild(t *testing.T) {
+
+	books := []*Book{
+		{ID: "1", Title: "B", Authors: []string{"Author"}, Series: "S", Number: 2, Language: "en", Updated: time.Now(),
+			Cover: "covers/1.jpg", Files: []File{{Href: "../Author/B.epub", Type: MediaType("B.epub")}}},
+		{ID: "2", Title: "a", Authors: []string{"Author", "Other"}, Series: "S", Number: 1, Language: "ru", Updated: time.Now(),
+			Genres: []Category{{Term: "sf", Label: "Fiction"}}, Files: []File{{Href: "/books/a.azw3", Type: MediaType("a.azw3")}}},
+		{ID: "3", Title: "c", Updated: time.Now()},
+	}
+	feeds := make(map[string]*Feed)
+	err := (&Catalog{Title: "Library", ID: "urn:test", PageSize: 2, Books: books}).Build(func(name string, f *Feed) error {
+		if _, exists := feeds[name]; exists {
+			t.Errorf("feed %s produced twice", name)
+		}
+		feeds[name] = f
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"index.xml", "all.xml", "all-2.xml", "authors.xml", "authors/1.xml", "authors/2.xml",
+		"series.xml", "series/1.xml", "genres.xml", "genres/1.xml", "languages.xml", "languages/1.xml", "languages/2.xml"} {
+		if feeds[name] == nil {
+			t.Errorf("feed %s was not produced", name)
+		}
+	}
+	if len(feeds) != 13 {
+		t.Errorf("unexpected number of feeds: %d", len(feeds))
+	}
+	if all := feeds["all.xml"]; len(all.Entries) != 2 || all.Entries[0].Title != "a" || all.Entries[1].Title != "B" {
+		t.Errorf("unexpected first page of all books")
+	}
+	if s := feeds["series/1.xml"]; len(s.Entries) != 2 || s.Entries[0].ID != "urn:uuid:2" {
+		t.Errorf("series books are not ordered by number")
+	}
+
+	var buf bytes.Buffer
+	if err := feeds["authors/1.xml"].Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/terms/" xmlns:opds="http://opds-spec.org/2010/catalog">`,
+		`<link rel="up" href="../authors.xml" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`,
+		`<link rel="http://opds-spec.org/image" href="../covers/1.jpg" type="image/jpeg"></link>`,
+		`<link rel="http://opds-spec.org/acquisition" href="../../Author/B.epub" type="application/epub+zip"></link>`,
+		`<link rel="http://opds-spec.org/acquisition" href="/books/a.azw3" type="application/x-mobi8-ebook"></link>`,
+		`<dc:language>ru</dc:language>`,
+		"<summary type=\"text\">S #1</summary>",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %q in\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestHref(t *testing.T) {
+
+	for _, c := range []struct{ from, to, res string }{
+		{"index.xml", "covers/1.jpg", "covers/1.jpg"},
+		{"authors/1.xml", "../Author/my book #1.epub", "../../Author/my%20book%20%231.epub"},
+		{"all.xml", "../Автор/a?b%c:d.epub", "../%D0%90%D0%B2%D1%82%D0%BE%D1%80/a%3Fb%25c%3Ad.epub"},
+		{"series/1.xml", "/books/1/my book #1.epub", "/books/1/my%20book%20%231.epub"},
+		{"series/1.xml", "http://example.com/a%20b.epub", "http://example.com/a%20b.epub"},
+	} {
+		if res := href(c.from, c.to); res != c.res {
+			t.Errorf("href(%q, %q) = %q, expected %q", c.from, c.to, res, c.res)
+		}
+	}
+}
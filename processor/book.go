@@ -46,12 +46,26 @@ type Book struct {
 	Title      string
 	Lang       language.Tag
 	Cover      string
-	Genres     []string
+	Genres     []string // FB2 genre codes
+	GenreNames []string // display names for Genres
+	GenreGroup string   // display name of the top level genre for the first genre
+	Subjects   []string // what goes to metadata as book subjects
 	Authors    []*config.AuthorName
-	SeqName    string
+	SeqName    string // primary sequence
 	SeqNum     int
+	Sequences  []*Sequence // all sequences in the order of appearance
 	Annotation string
 	Date       string
+	// additional description
+	Translators []*config.AuthorName
+	Keywords    []string
+	Publisher   string            // publish-info
+	City        string            // publish-info
+	Year        string            // publish-info
+	ISBN        string            // publish-info
+	SrcTitle    string            // src-title-info, original title
+	SrcLang     string            // src-title-info, original language
+	CustomInfo  map[string]string // custom-info, by info-type
 	// book structure
 	TOC            []*tocEntry       // collected TOC entries
 	Files          []*dataFile       // generated content
@@ -82,23 +96,41 @@ func NewBook(u uuid.UUID, name string) *Book {
 		LinksLocations: make(map[string]string),
 		NoteBodyTitles: make(map[string]*note),
 		Notes:          make(map[string]*note),
+		CustomInfo:     make(map[string]string),
 		context:        newContext(),
 	}
 }
 
+// Sequence is a single series book belongs to.
+type Sequence struct {
+	Name      string `json:"name"`
+	Num       int    `json:"number,omitempty"`
+	Level     int    `json:"level,omitempty"`     // nesting level, 0 for top sequence
+	Publisher bool   `json:"publisher,omitempty"` // publisher series from publish-info
+}
+
 // BookAuthors returns authors as a single string.
 func (b *Book) BookAuthors(format string, short bool) string {
-	if len(b.Authors) == 0 {
+	return b.joinNames(b.Authors, format, short)
+}
+
+// BookTranslators returns translators as a single string.
+func (b *Book) BookTranslators(format string, short bool) string {
+	return b.joinNames(b.Translators, format, short)
+}
+
+func (b *Book) joinNames(names []*config.AuthorName, format string, short bool) string {
+	if len(names) == 0 {
 		return ""
 	}
-	if short && len(b.Authors) > 1 {
+	if short && len(names) > 1 {
 		if b.Lang == language.Russian {
-			return ReplaceKeywords(format, CreateAuthorKeywordsMap(b.Authors[0])) + " и др"
+			return ReplaceKeywords(format, CreateAuthorKeywordsMap(names[0])) + " и др"
 		}
-		return ReplaceKeywords(format, CreateAuthorKeywordsMap(b.Authors[0])) + ", et al"
+		return ReplaceKeywords(format, CreateAuthorKeywordsMap(names[0])) + ", et al"
 	}
-	res := make([]string, 0, len(b.Authors))
-	for _, an := range b.Authors {
+	res := make([]string, 0, len(names))
+	for _, an := range names {
 		res = append(res, ReplaceKeywords(format, CreateAuthorKeywordsMap(an)))
 	}
 	return strings.Join(res, ", ")
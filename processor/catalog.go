@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"regexp"
+
+	"go.uber.org/zap"
+
+	"fb2converter/state"
+)
+
+var (
+	reDescriptionEnd = regexp.MustCompile(`</(?:[\w.-]+:)?description\s*>`)
+	reRootStart      = regexp.MustCompile(`<((?:[\w.-]+:)?FictionBook)[\s>]`)
+	reBinaryStart    = regexp.MustCompile(`<(?:[\w.-]+:)?binary\s[^>]*>`)
+	reIDAttr         = regexp.MustCompile(`\sid\s*=\s*["']([^"']*)["']`)
+	reBinaryEnd      = regexp.MustCompile(`</(?:[\w.-]+:)?binary\s*>`)
+)
+
+// readHead reads FB2 document until the end of its description and returns well-formed document which has nothing
+// but the description along with reader for the rest of the document.
+func readHead(r io.Reader) ([]byte, io.Reader, error) {
+
+	var (
+		head []byte
+		buf  = make([]byte, 16*1024)
+	)
+	for {
+		// closing tag could be split between reads
+		from := len(head) - 32
+		if from < 0 {
+			from = 0
+		}
+		n, err := r.Read(buf)
+		head = append(head, buf[:n]...)
+		if loc := reDescriptionEnd.FindIndex(head[from:]); loc != nil {
+			end := from + loc[1]
+			root := reRootStart.FindSubmatch(head)
+			if root == nil {
+				return nil, nil, errors.New("unable to find FictionBook element")
+			}
+			rest := io.MultiReader(bytes.NewReader(head[end:]), r)
+			return append(head[:end:end], []byte("</"+string(root[1])+">")...), rest, nil
+		}
+		if err == io.EOF {
+			return nil, nil, errors.New("unable to find book description")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// findBinary looks for binary with id in the rest of FB2 document without parsing it, so body of the book does not
+// have to be valid or even decoded. Returns nil if binary was not found.
+func findBinary(r io.Reader, id string) ([]byte, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		loc := reBinaryStart.FindIndex(data)
+		if loc == nil {
+			return nil, nil
+		}
+		tag, rest := data[loc[0]:loc[1]], data[loc[1]:]
+		end := reBinaryEnd.FindIndex(rest)
+		if end == nil {
+			return nil, nil
+		}
+		if m := reIDAttr.FindSubmatch(tag); m != nil && string(m[1]) == id {
+			return base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(rest[:end[0]]), nil)))
+		}
+		data = rest[end[1]:]
+	}
+	return nil, nil
+}
+
+// Description is book information read by ReadDescription.
+type Description struct {
+	*BookInfo
+	CoverImage []byte // cover image as found in the book, only when requested
+
+	book *Book
+	src  string
+	env  *state.LocalEnv
+}
+
+// ConvertedName returns name converted book would have, see "convert" command for meaning of dst and nodirs.
+func (d *Description) ConvertedName(dst string, nodirs bool, format OutputFmt) string {
+	return outputName(d.book, d.src, dst, nodirs, true, format.Extension(), d.env)
+}
+
+// ReadDescription parses only description of FB2 document, which is much faster than parsing complete book. The rest
+// of the document is scanned for cover image, which is kept when withCover is set. "file" is actual book file if
+// known, so meta information overwrites could be applied.
+func ReadDescription(r io.Reader, unknownEncoding bool, src, file string, withCover bool, env *state.LocalEnv) (*Description, error) {
+
+	head, rest, err := readHead(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewFB2(bytes.NewReader(head), unknownEncoding, src, "", false, false, true, OEpub, env)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Clean()
+	if len(file) > 0 {
+		p.SetSourceFile(file)
+	}
+
+	if err := p.processDescription(); err != nil {
+		return nil, err
+	}
+	p.processGenres()
+
+	d := &Description{BookInfo: p.descriptionInfo(), book: p.Book, src: src, env: env}
+	d.Annotation = p.Book.Annotation
+	d.BookInfo.OutputName = ""
+
+	if len(p.Book.Cover) > 0 {
+		cover, err := findBinary(rest, p.Book.Cover)
+		if err != nil {
+			env.Log.Debug("Unable to read cover image", zap.String("file", src), zap.Error(err))
+		}
+		d.BookInfo.Cover = len(cover) > 0
+		if withCover {
+			d.CoverImage = cover
+		}
+	}
+	return d, nil
+}
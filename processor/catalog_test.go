@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadHead(t *testing.T) {
+
+	const book = `<?xml version="1.0" encoding="UTF-8"?>
+<fb:FictionBook xmlns:fb="http://www.gribuser.ru/xml/fictionbook/2.0">
+<fb:description><fb:title-info><fb:book-title>Title</fb:book-title></fb:title-info></fb:description>
+<fb:body><p>broken <b>text</p></fb:body>
+<fb:binary id="a.jpg" content-type="image/jpeg">AAAA</fb:binary>
+<fb:binary content-type="image/jpeg" id="cover.jpg">
+aGVs
+bG8=
+</fb:binary>
+</fb:FictionBook>`
+
+	head, rest, err := readHead(io.LimitReader(strings.NewReader(book), int64(len(book))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(head), "</fb:description></fb:FictionBook>") || strings.Contains(string(head), "body") {
+		t.Errorf("unexpected head: %s", head)
+	}
+
+	data, err := findBinary(rest, "cover.jpg")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("unexpected binary: %q, %v", data, err)
+	}
+	if data, err := findBinary(strings.NewReader(book), "none"); err != nil || data != nil {
+		t.Errorf("unexpected binary: %q, %v", data, err)
+	}
+	if _, _, err := readHead(strings.NewReader("<FictionBook><body/></FictionBook>")); err == nil {
+		t.Error("book without description should not be accepted")
+	}
+}
@@ -0,0 +1,222 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+
+	"fb2converter/config"
+	"fb2converter/static"
+)
+
+// CheckConfig validates configuration the way book processor would use it: enumerated values, numeric ranges and files
+// configuration refers to. It allows to find problems before any book is processed rather than in the middle of a batch.
+func CheckConfig(cfg *config.Config) config.Problems {
+
+	ps := cfg.Check()
+	doc := &cfg.Doc
+
+	enum := func(key, value string, supported bool, last int, name func(int) string) {
+		if supported {
+			return
+		}
+		names := make([]string, 0, last)
+		for i := 0; i < last; i++ {
+			names = append(names, name(i))
+		}
+		ps.Errorf(key, "unknown value %q, should be one of: %s", value, strings.Join(names, ", "))
+	}
+	optional := func(key, value string, supported bool, last int, name func(int) string) {
+		if len(value) > 0 {
+			enum(key, value, supported, last, name)
+		}
+	}
+
+	enum("document.notes.mode", doc.Notes.Mode, ParseNotesString(doc.Notes.Mode) != UnsupportedNotesFmt,
+		int(UnsupportedNotesFmt), func(i int) string { return NotesFmt(i).String() })
+	enum("document.toc.type", doc.TOC.Type, ParseTOCTypeString(doc.TOC.Type) != UnsupportedTOCType,
+		int(UnsupportedTOCType), func(i int) string { return TOCType(i).String() })
+	enum("document.toc.page_placement", doc.TOC.Placement, ParseTOCPlacementString(doc.TOC.Placement) != UnsupportedTOCPlacement,
+		int(UnsupportedTOCPlacement), func(i int) string { return TOCPlacement(i).String() })
+	enum("document.kindlegen.generate_apnx", doc.Kindlegen.PageMap, ParseAPNXGenerationSring(doc.Kindlegen.PageMap) != UnsupportedAPNXGeneration,
+		int(UnsupportedAPNXGeneration), func(i int) string { return APNXGeneration(i).String() })
+	optional("document.cover.stamp_placement", doc.Cover.Placement, ParseStampPlacementString(doc.Cover.Placement) != UnsupportedStampPlacement,
+		int(UnsupportedStampPlacement), func(i int) string { return StampPlacement(i).String() })
+	optional("document.cover.resize", doc.Cover.Resize, ParseCoverProcessingString(doc.Cover.Resize) != UnsupportedCoverProcessing,
+		int(UnsupportedCoverProcessing), func(i int) string { return CoverProcessing(i).String() })
+	optional("document.series_primary", doc.SeriesPrimary, ParseSeriesSelectionString(doc.SeriesPrimary) != UnsupportedSeriesSelection,
+		int(UnsupportedSeriesSelection), func(i int) string { return SeriesSelection(i).String() })
+	optional("document.genres.subjects", doc.Genres.Subjects, ParseGenreSubjectsString(doc.Genres.Subjects) != UnsupportedGenreSubjects,
+		int(UnsupportedGenreSubjects), func(i int) string { return GenreSubjects(i).String() })
+
+	pdfNotes := []string{pdfNotesBottom, pdfNotesChapterEnd}
+	enum("document.pdf.notes_placement", doc.PDF.Notes, doc.PDF.Notes == pdfNotesBottom || doc.PDF.Notes == pdfNotesChapterEnd,
+		len(pdfNotes), func(i int) string { return pdfNotes[i] })
+
+	mobi, epub := []string{OMobi.String(), OAzw3.String()}, []string{OEpub.String(), OKepub.String()}
+	enum("fb2mobi.output_format", cfg.Fb2Mobi.OutputFormat, isOneOf(cfg.Fb2Mobi.OutputFormat, mobi),
+		len(mobi), func(i int) string { return mobi[i] })
+	enum("fb2epub.output_format", cfg.Fb2Epub.OutputFormat, isOneOf(cfg.Fb2Epub.OutputFormat, epub),
+		len(epub), func(i int) string { return epub[i] })
+
+	all := Formats()
+	for i, t := range cfg.Targets {
+		for j, f := range t.Formats {
+			enum(fmt.Sprintf("delivery[%d].formats[%d]", i, j), f, ParseFmtString(f) != UnsupportedOutputFmt,
+				len(all), func(i int) string { return all[i] })
+		}
+	}
+
+	if n := ParseNotesString(doc.Notes.Mode); doc.Notes.Renumber && n != NFloat && n != NFloatOld && n != NFloatNew {
+		ps.Warnf("document.notes.renumber", "notes can be renumbered in floating modes only, ignored")
+	}
+
+	// numeric values
+	if doc.CharsPerPage <= 0 {
+		ps.Errorf("document.characters_per_page", "should be positive, got %d", doc.CharsPerPage)
+	}
+	if doc.PagesPerFile <= 0 {
+		ps.Errorf("document.pages_per_file", "should be positive, got %d", doc.PagesPerFile)
+	}
+	if doc.ImagesScaleFactor < 0 {
+		ps.Errorf("document.images_scale_factor", "should not be negative, got %g", doc.ImagesScaleFactor)
+	}
+	if doc.Cover.Width <= 0 {
+		ps.Errorf("document.cover.width", "should be positive, got %d", doc.Cover.Width)
+	}
+	if doc.Cover.Height <= 0 {
+		ps.Errorf("document.cover.height", "should be positive, got %d", doc.Cover.Height)
+	}
+	for _, v := range []struct {
+		key      string
+		value    float64
+		positive bool
+	}{
+		{"page_width", doc.PDF.PageWidth, true},
+		{"page_height", doc.PDF.PageHeight, true},
+		{"font_size", doc.PDF.FontSize, true},
+		{"line_spacing", doc.PDF.LineSpacing, true},
+		{"margin_top", doc.PDF.MarginTop, false},
+		{"margin_bottom", doc.PDF.MarginBottom, false},
+		{"margin_left", doc.PDF.MarginLeft, false},
+		{"margin_right", doc.PDF.MarginRight, false},
+	} {
+		switch {
+		case v.positive && v.value <= 0:
+			ps.Errorf("document.pdf."+v.key, "should be positive, got %g", v.value)
+		case v.value < 0:
+			ps.Errorf("document.pdf."+v.key, "should not be negative, got %g", v.value)
+		}
+	}
+	if doc.PDF.PageWidth-doc.PDF.MarginLeft-doc.PDF.MarginRight <= 0 || doc.PDF.PageHeight-doc.PDF.MarginTop-doc.PDF.MarginBottom <= 0 {
+		ps.Errorf("document.pdf", "margins leave no space for text on %gx%g page", doc.PDF.PageWidth, doc.PDF.PageHeight)
+	}
+
+	// files
+	resolve := func(fname string) string {
+		if !filepath.IsAbs(fname) {
+			return filepath.Join(cfg.Path, fname)
+		}
+		return fname
+	}
+	readable := func(key, fname string) {
+		if _, err := os.ReadFile(resolve(fname)); err != nil {
+			ps.Errorf(key, "unable to read file: %v", err)
+		}
+	}
+
+	// stylesheet and default cover image are only used when configuration was read from file
+	if len(doc.Stylesheet) > 0 {
+		if len(cfg.Path) == 0 {
+			ps.Warnf("document.style", "ignored when configuration is not read from file, default stylesheet will be used")
+		} else {
+			readable("document.style", doc.Stylesheet)
+		}
+	}
+	if len(doc.Cover.ImagePath) > 0 {
+		if len(cfg.Path) == 0 {
+			ps.Warnf("document.cover.image_path", "ignored when configuration is not read from file, default cover will be used")
+		} else {
+			readable("document.cover.image_path", doc.Cover.ImagePath)
+		}
+	}
+	if len(doc.Cover.Font) > 0 {
+		if data, err := os.ReadFile(resolve(doc.Cover.Font)); err != nil {
+			ps.Errorf("document.cover.stamp_font", "unable to read file: %v", err)
+		} else if _, err := truetype.Parse(data); err != nil {
+			ps.Errorf("document.cover.stamp_font", "unable to load font: %v", err)
+		}
+	}
+	for _, f := range []struct{ key, fname string }{
+		{"font_regular", doc.PDF.FontRegular},
+		{"font_bold", doc.PDF.FontBold},
+		{"font_italic", doc.PDF.FontItalic},
+		{"font_bold_italic", doc.PDF.FontBoldItalic},
+	} {
+		if len(f.fname) > 0 {
+			readable("document.pdf."+f.key, f.fname)
+		}
+	}
+	if fname := doc.Genres.File; len(fname) > 0 {
+		if !filepath.IsAbs(fname) && len(cfg.Path) > 0 {
+			fname = filepath.Join(cfg.Path, fname)
+		}
+		if _, err := readGenreTable(fname); err != nil {
+			ps.Errorf("document.genres.file", "unable to load genre table: %v", err)
+		}
+	}
+	if doc.Vignettes.Create {
+		levels := make([]string, 0, len(doc.Vignettes.Images))
+		for level := range doc.Vignettes.Images {
+			levels = append(levels, level)
+		}
+		sort.Strings(levels)
+		for _, level := range levels {
+			vignettes := make([]string, 0, len(doc.Vignettes.Images[level]))
+			for vignette := range doc.Vignettes.Images[level] {
+				vignettes = append(vignettes, vignette)
+			}
+			sort.Strings(vignettes)
+			for _, vignette := range vignettes {
+				key := fmt.Sprintf("document.vignettes.images.%s.%s", level, vignette)
+				switch vignette {
+				case config.VigBeforeTitle, config.VigAfterTitle, config.VigChapterEnd:
+				default:
+					ps.Warnf(key, "unknown vignette, should be one of: %s, %s, %s", config.VigBeforeTitle, config.VigAfterTitle, config.VigChapterEnd)
+					continue
+				}
+				fname := doc.Vignettes.Images[level][vignette]
+				if strings.EqualFold(fname, "none") {
+					continue
+				}
+				if len(cfg.Path) > 0 {
+					if _, err := os.Stat(resolve(fname)); err == nil {
+						continue
+					}
+				}
+				if _, err := static.Asset(fname); err != nil {
+					ps.Errorf(key, "unable to find vignette image %s", fname)
+				}
+			}
+		}
+	}
+
+	// kindlegen is only needed for azw3 and mobi with KF8 part
+	if _, err := cfg.GetKindlegenPath(); err != nil {
+		ps.Warnf("document.kindlegen.path", "%v, azw3 could not be produced", err)
+	}
+	return ps
+}
+
+func isOneOf(value string, names []string) bool {
+	for _, n := range names {
+		if strings.EqualFold(value, n) {
+			return true
+		}
+	}
+	return false
+}
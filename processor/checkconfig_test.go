@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fb2converter/config"
+)
+
+func TestCheckConfig(t *testing.T) {
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "my.css"), []byte("body {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, "fb2c.toml")
+	if err := os.WriteFile(fname, []byte(`
+[document]
+style = "my.css"
+characters_per_page = 0
+
+[document.notes]
+mode = "floatt"
+
+[document.cover]
+resize = "stretchy"
+image_path = "cover.jpg"
+
+[document.pdf]
+margin_left = 140
+notes_placement = "end"
+
+[document.vignettes.images.h1]
+chapter_end = "none"
+after_title = "profiles/vignettes/title_after.png"
+before_title = "title.png"
+
+[[delivery]]
+type = "command"
+command = ["true"]
+formats = ["epub", "azw4"]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.BuildConfig("", nil, nil, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"document.characters_per_page":              false,
+		"document.notes.mode":                       false,
+		"document.cover.resize":                     false,
+		"document.cover.image_path":                 false,
+		"document.pdf":                              false,
+		"document.pdf.notes_placement":              false,
+		"document.vignettes.images.h1.before_title": false,
+		"delivery[0].formats[1]":                    false,
+	}
+	for _, p := range CheckConfig(cfg) {
+		if p.Key == "document.kindlegen.path" || p.Key == "delivery[0].command" {
+			// depends on environment
+			continue
+		}
+		warning, ok := expected[p.Key]
+		if !ok {
+			t.Errorf("unexpected problem %s", p)
+			continue
+		}
+		if warning != p.Warning {
+			t.Errorf("wrong severity %s", p)
+		}
+		delete(expected, p.Key)
+	}
+	for key := range expected {
+		t.Errorf("problem with %s was not found", key)
+	}
+}
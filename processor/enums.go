@@ -1,32 +1,48 @@
 package processor
 
 import (
+	"strconv"
 	"strings"
 )
 
 // OutputFmt specification of requested output type.
 type OutputFmt int
 
-// Supported output formats
+// Built in output formats, more could be added with RegisterFinalizer
 const (
 	OEpub                OutputFmt = iota // epub
 	OKepub                                // kepub
 	OAzw3                                 // azw3
 	OMobi                                 // mobi
+	OHtml                                 // html
+	OHtmlz                                // htmlz
+	OTxt                                  // txt
+	OMd                                   // md
+	OPdf                                  // pdf
 	UnsupportedOutputFmt                  //
 )
 
-// ParseFmtString converts string to enum value. Case insensitive.
+// ParseFmtString converts string to enum value. Case insensitive, formats registered with RegisterFinalizer are
+// recognized as well.
 func ParseFmtString(format string) OutputFmt {
 
-	for i := OEpub; i < UnsupportedOutputFmt; i++ {
-		if strings.EqualFold(i.String(), format) {
-			return i
-		}
+	formats.RLock()
+	defer formats.RUnlock()
+
+	if e, ok := formats.byName[strings.ToLower(format)]; ok {
+		return e.format
 	}
 	return UnsupportedOutputFmt
 }
 
+// String returns name of the output format.
+func (f OutputFmt) String() string {
+	if e := lookupFormat(f); e != nil {
+		return e.name
+	}
+	return "OutputFmt(" + strconv.Itoa(int(f)) + ")"
+}
+
 // NotesFmt specification of requested notes presentation.
 type NotesFmt int
 
@@ -162,3 +178,48 @@ func ParseCoverProcessingString(format string) CoverProcessing {
 	}
 	return UnsupportedCoverProcessing
 }
+
+// SeriesSelection specifies which of the book sequences is the primary one
+type SeriesSelection int
+
+// Supported primary series selection rules
+const (
+	SeriesFirst                SeriesSelection = iota // first
+	SeriesNested                                      // nested
+	SeriesPublisher                                   // publisher
+	UnsupportedSeriesSelection                        //
+)
+
+// ParseSeriesSelectionString converts string to enum value. Case insensitive.
+func ParseSeriesSelectionString(format string) SeriesSelection {
+
+	for i := SeriesFirst; i < UnsupportedSeriesSelection; i++ {
+		if strings.EqualFold(i.String(), format) {
+			return i
+		}
+	}
+	return UnsupportedSeriesSelection
+}
+
+// GenreSubjects specifies what is used as book subjects in metadata
+type GenreSubjects int
+
+// Supported genre subjects
+const (
+	SubjectName              GenreSubjects = iota // name
+	SubjectCode                                   // code
+	SubjectBISAC                                  // bisac
+	SubjectThema                                  // thema
+	UnsupportedGenreSubjects                      //
+)
+
+// ParseGenreSubjectsString converts string to enum value. Case insensitive.
+func ParseGenreSubjectsString(format string) GenreSubjects {
+
+	for i := SubjectName; i < UnsupportedGenreSubjects; i++ {
+		if strings.EqualFold(i.String(), format) {
+			return i
+		}
+	}
+	return UnsupportedGenreSubjects
+}
@@ -1,30 +1,9 @@
-// Code generated by "stringer -linecomment -type OutputFmt,NotesFmt,TOCPlacement,TOCType,APNXGeneration,StampPlacement,CoverProcessing -output processor/enums_string.go processor/enums.go"; DO NOT EDIT.
+// Code generated by "stringer -linecomment -type NotesFmt,TOCPlacement,TOCType,APNXGeneration,StampPlacement,CoverProcessing,SeriesSelection,GenreSubjects -output processor/enums_string.go processor/enums.go"; DO NOT EDIT.
 
 package processor
 
 import "strconv"
 
-func _() {
-	// An "invalid array index" compiler error signifies that the constant values have changed.
-	// Re-run the stringer command to generate them again.
-	var x [1]struct{}
-	_ = x[OEpub-0]
-	_ = x[OKepub-1]
-	_ = x[OAzw3-2]
-	_ = x[OMobi-3]
-	_ = x[UnsupportedOutputFmt-4]
-}
-
-const _OutputFmt_name = "epubkepubazw3mobi"
-
-var _OutputFmt_index = [...]uint8{0, 4, 9, 13, 17, 17}
-
-func (i OutputFmt) String() string {
-	if i < 0 || i >= OutputFmt(len(_OutputFmt_index)-1) {
-		return "OutputFmt(" + strconv.FormatInt(int64(i), 10) + ")"
-	}
-	return _OutputFmt_name[_OutputFmt_index[i]:_OutputFmt_index[i+1]]
-}
 func _() {
 	// An "invalid array index" compiler error signifies that the constant values have changed.
 	// Re-run the stringer command to generate them again.
@@ -149,3 +128,44 @@ func (i CoverProcessing) String() string {
 	}
 	return _CoverProcessing_name[_CoverProcessing_index[i]:_CoverProcessing_index[i+1]]
 }
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[SeriesFirst-0]
+	_ = x[SeriesNested-1]
+	_ = x[SeriesPublisher-2]
+	_ = x[UnsupportedSeriesSelection-3]
+}
+
+const _SeriesSelection_name = "firstnestedpublisher"
+
+var _SeriesSelection_index = [...]uint8{0, 5, 11, 20, 20}
+
+func (i SeriesSelection) String() string {
+	if i < 0 || i >= SeriesSelection(len(_SeriesSelection_index)-1) {
+		return "SeriesSelection(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _SeriesSelection_name[_SeriesSelection_index[i]:_SeriesSelection_index[i+1]]
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[SubjectName-0]
+	_ = x[SubjectCode-1]
+	_ = x[SubjectBISAC-2]
+	_ = x[SubjectThema-3]
+	_ = x[UnsupportedGenreSubjects-4]
+}
+
+const _GenreSubjects_name = "namecodebisacthema"
+
+var _GenreSubjects_index = [...]uint8{0, 4, 8, 13, 18, 18}
+
+func (i GenreSubjects) String() string {
+	if i < 0 || i >= GenreSubjects(len(_GenreSubjects_index)-1) {
+		return "GenreSubjects(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _GenreSubjects_name[_GenreSubjects_index[i]:_GenreSubjects_index[i+1]]
+}
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	fixzip "github.com/hidez8891/zip"
-	"go.uber.org/zap"
 )
 
 func zipRemoveDataDescriptors(from, to string) error {
@@ -130,18 +129,8 @@ func (p *Processor) writeEPUB(fname string) error {
 // FinalizeEPUB produces epub file out of previously saved temporary files.
 func (p *Processor) FinalizeEPUB(fname string) error {
 
-	if _, err := os.Stat(fname); err == nil {
-		if !p.overwrite {
-			return fmt.Errorf("output file already exists: %s", fname)
-		}
-		p.env.Log.Warn("Overwriting existing file", zap.String("file", fname))
-		if err = os.Remove(fname); err != nil {
-			return err
-		}
-	} else if !os.IsNotExist(err) {
+	if err := p.PrepareOutputFile(fname); err != nil {
 		return err
-	} else if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
-		return fmt.Errorf("unable to create output directory: %w", err)
 	}
 
 	if p.env.Cfg.Doc.FixZip {
@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"fb2converter/state"
+)
+
+// Finalizer produces resulting book out of content previously generated by Processor and saved into its working
+// directory. Output formats are registered with RegisterFinalizer and selected by name.
+type Finalizer interface {
+	// Extension returns file name extension of the resulting book without leading dot.
+	Extension() string
+	// Finalize produces resulting book in fname.
+	Finalize(p *Processor, fname string) error
+}
+
+type finalizerFunc struct {
+	ext string
+	fn  func(p *Processor, fname string) error
+}
+
+func (f *finalizerFunc) Extension() string {
+	return f.ext
+}
+
+func (f *finalizerFunc) Finalize(p *Processor, fname string) error {
+	return f.fn(p, fname)
+}
+
+// NewFinalizer returns Finalizer which calls fn to produce book with specified extension.
+func NewFinalizer(ext string, fn func(p *Processor, fname string) error) Finalizer {
+	return &finalizerFunc{ext: ext, fn: fn}
+}
+
+type formatEntry struct {
+	name   string
+	format OutputFmt
+	fin    Finalizer
+}
+
+type formatRegistry struct {
+	sync.RWMutex
+	byName   map[string]*formatEntry
+	byFormat map[OutputFmt]*formatEntry
+	next     OutputFmt
+}
+
+var formatNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// formats always has built in output formats with their predefined enum values, everything registered later gets
+// values after UnsupportedOutputFmt.
+var formats = func() *formatRegistry {
+	r := &formatRegistry{
+		byName:   make(map[string]*formatEntry),
+		byFormat: make(map[OutputFmt]*formatEntry),
+		next:     UnsupportedOutputFmt + 1,
+	}
+	for _, e := range []*formatEntry{
+		{"epub", OEpub, NewFinalizer("epub", (*Processor).FinalizeEPUB)},
+		{"kepub", OKepub, NewFinalizer("kepub.epub", (*Processor).FinalizeKEPUB)},
+		{"azw3", OAzw3, NewFinalizer("azw3", (*Processor).FinalizeAZW3)},
+		{"mobi", OMobi, NewFinalizer("mobi", (*Processor).FinalizeMOBI)},
+		{"html", OHtml, NewFinalizer("html", (*Processor).FinalizeHTML)},
+		{"htmlz", OHtmlz, NewFinalizer("htmlz", (*Processor).FinalizeHTMLZ)},
+		{"txt", OTxt, NewFinalizer("txt", (*Processor).FinalizeTXT)},
+		{"md", OMd, NewFinalizer("md", (*Processor).FinalizeMD)},
+		{"pdf", OPdf, NewFinalizer("pdf", (*Processor).FinalizePDF)},
+	} {
+		r.byName[e.name] = e
+		r.byFormat[e.format] = e
+	}
+	return r
+}()
+
+// RegisterFinalizer makes new output format available under specified name (case insensitive) and returns enum
+// value assigned to it. Content for registered formats is prepared the same way as for epub.
+func RegisterFinalizer(name string, f Finalizer) (OutputFmt, error) {
+
+	name = strings.ToLower(name)
+	if !formatNamePattern.MatchString(name) {
+		return UnsupportedOutputFmt, fmt.Errorf("invalid output format name: \"%s\"", name)
+	}
+	if f == nil {
+		return UnsupportedOutputFmt, errors.New("finalizer could not be nil")
+	}
+
+	formats.Lock()
+	defer formats.Unlock()
+
+	if _, exists := formats.byName[name]; exists {
+		return UnsupportedOutputFmt, fmt.Errorf("output format \"%s\" is already registered", name)
+	}
+	e := &formatEntry{name: name, format: formats.next, fin: f}
+	formats.byName[name] = e
+	formats.byFormat[e.format] = e
+	formats.next++
+	return e.format, nil
+}
+
+// unregisterFinalizer removes output format registered earlier, built in formats cannot be removed.
+func unregisterFinalizer(format OutputFmt) {
+
+	if format <= UnsupportedOutputFmt {
+		return
+	}
+
+	formats.Lock()
+	defer formats.Unlock()
+
+	if e, ok := formats.byFormat[format]; ok {
+		delete(formats.byName, e.name)
+		delete(formats.byFormat, format)
+	}
+}
+
+// Formats returns names of all known output formats, built in first.
+func Formats() []string {
+
+	formats.RLock()
+	defer formats.RUnlock()
+
+	entries := make([]*formatEntry, 0, len(formats.byName))
+	for _, e := range formats.byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].format < entries[j].format })
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	return names
+}
+
+func lookupFormat(format OutputFmt) *formatEntry {
+	formats.RLock()
+	defer formats.RUnlock()
+	return formats.byFormat[format]
+}
+
+// Extension returns file name extension for the output format.
+func (f OutputFmt) Extension() string {
+	if e := lookupFormat(f); e != nil {
+		return e.fin.Extension()
+	}
+	return ""
+}
+
+// WorkDir returns directory where generated content (OPF, XHTML files, images, stylesheet) is saved before it is
+// passed to Finalizer.
+func (p *Processor) WorkDir() string {
+	return filepath.Join(p.tmpDir, DirContent)
+}
+
+// Env returns program environment processor works in.
+func (p *Processor) Env() *state.LocalEnv {
+	return p.env
+}
+
+// PrepareOutputFile makes sure that output file could be written: removes existing one if overwriting is allowed or
+// creates necessary directories.
+func (p *Processor) PrepareOutputFile(fname string) error {
+
+	if _, err := os.Stat(fname); err == nil {
+		if !p.overwrite {
+			return fmt.Errorf("output file already exists: %s", fname)
+		}
+		p.env.Log.Warn("Overwriting existing file", zap.String("file", fname))
+		if err = os.Remove(fname); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return fmt.Errorf("unable to create output directory: %w", err)
+	}
+	return nil
+}
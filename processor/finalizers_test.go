@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"testing"
+)
+
+func TestFinalizerRegistry(t *testing.T) {
+
+	for _, name := range Formats() {
+		f := ParseFmtString(name)
+		if f == UnsupportedOutputFmt || f.String() != name {
+			t.Errorf("built in format %s is not registered properly: %d, %s", name, f, f)
+		}
+	}
+	if ext := OKepub.Extension(); ext != "kepub.epub" {
+		t.Errorf("wrong kepub extension: %s", ext)
+	}
+
+	fin := NewFinalizer("tst", func(p *Processor, fname string) error { return nil })
+	f, err := RegisterFinalizer("Test", fin)
+	if err != nil {
+		t.Fatalf("unable to register finalizer: %v", err)
+	}
+	t.Cleanup(func() { unregisterFinalizer(f) })
+	if f <= UnsupportedOutputFmt {
+		t.Errorf("registered format got built in value: %d", f)
+	}
+	if res := ParseFmtString("TEST"); res != f {
+		t.Errorf("registered format is not recognized: %d != %d", res, f)
+	}
+	if f.String() != "test" || f.Extension() != "tst" {
+		t.Errorf("wrong registered format name or extension: %s, %s", f, f.Extension())
+	}
+	if _, err := RegisterFinalizer("epub", fin); err == nil {
+		t.Error("built in format was registered again")
+	}
+	if _, err := RegisterFinalizer("bad name", fin); err == nil {
+		t.Error("invalid format name was accepted")
+	}
+	if res := ParseFmtString("unknown"); res != UnsupportedOutputFmt {
+		t.Errorf("unknown format was recognized: %s", res)
+	}
+
+	unregisterFinalizer(OEpub)
+	if res := ParseFmtString("epub"); res != OEpub {
+		t.Error("built in format was unregistered")
+	}
+}
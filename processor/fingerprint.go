@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	shingleSize   = 5  // words in a single shingle
+	signatureSize = 64 // number of min hashes in text signature
+	lshBands      = 16 // signature bands used to find duplicate candidates
+)
+
+// Fingerprint is a set of FB2 book properties used for duplicate detection.
+type Fingerprint struct {
+	ID        string   `json:"id,omitempty"`      // document-info/id as found in the book
+	Key       string   `json:"key,omitempty"`     // normalized title, authors, language and sequence
+	Version   float64  `json:"version,omitempty"` // document-info/version
+	Cover     bool     `json:"cover"`
+	Words     int      `json:"words"`
+	Signature []uint64 `json:"-"` // min hashes of body text shingles, nil for books without text
+}
+
+// normalizeText lowers case and leaves only letters and digits separated by single spaces, "ё" is treated as "е".
+func normalizeText(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ReplaceAll(strings.ToLower(s), "ё", "е"), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// mix is a 64 bit finalizer from MurmurHash3, used to derive independent hashes for min hashing.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// textSignature calculates min hash signature of word shingles of the text.
+func textSignature(words []string) []uint64 {
+
+	if len(words) < shingleSize {
+		return nil
+	}
+	sig := make([]uint64, signatureSize)
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	h := fnv.New64a()
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sh := h.Sum64()
+		for j := range sig {
+			if v := mix(sh ^ uint64(j)*0x9e3779b97f4a7c15); v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+	return sig
+}
+
+// Similarity estimates how much of the text two books share (Jaccard index of text shingles), 0 when either book
+// has no text.
+func (f *Fingerprint) Similarity(o *Fingerprint) float64 {
+	if len(f.Signature) != signatureSize || len(o.Signature) != signatureSize {
+		return 0
+	}
+	var same int
+	for i := range f.Signature {
+		if f.Signature[i] == o.Signature[i] {
+			same++
+		}
+	}
+	return float64(same) / signatureSize
+}
+
+// Bands returns locality sensitive hashes of the text signature, books with similar text are likely to share at
+// least one of them.
+func (f *Fingerprint) Bands() []uint64 {
+	if len(f.Signature) != signatureSize {
+		return nil
+	}
+	const rows = signatureSize / lshBands
+	res := make([]uint64, 0, lshBands)
+	for b := 0; b < lshBands; b++ {
+		h := uint64(b)
+		for _, v := range f.Signature[b*rows : (b+1)*rows] {
+			h = mix(h ^ v)
+		}
+		res = append(res, h)
+	}
+	return res
+}
+
+// Fingerprint parses FB2 book and calculates its fingerprint. Notes bodies are not part of the text signature.
+func (p *Processor) Fingerprint() (*Fingerprint, error) {
+
+	if err := p.processDescription(); err != nil {
+		return nil, err
+	}
+
+	f := &Fingerprint{ID: p.rawID}
+
+	var authors []string
+	for _, an := range p.Book.Authors {
+		if a := normalizeText(an.Last + " " + an.First); len(a) > 0 {
+			authors = append(authors, a)
+		}
+	}
+	sort.Strings(authors)
+	if title := normalizeText(p.Book.Title); len(title) > 0 {
+		// volumes of collected works and such often share title, sequence tells them apart
+		f.Key = title + " / " + strings.Join(authors, ", ") + " / " + p.Book.Lang.String()
+		if seq := normalizeText(p.Book.SeqName); len(seq) > 0 {
+			f.Key += " / " + seq + " #" + strconv.Itoa(p.Book.SeqNum)
+		}
+	}
+
+	if e := p.doc.FindElement("./FictionBook/description/document-info/version"); e != nil {
+		f.Version, _ = strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(e.Text(), ",", ".")), 64)
+	}
+	if len(p.Book.Cover) > 0 {
+		for _, e := range p.doc.FindElements("./FictionBook/binary") {
+			if getAttrValue(e, "id") == p.Book.Cover {
+				f.Cover = true
+				break
+			}
+		}
+	}
+
+	var words []string
+	for _, e := range p.doc.FindElements("./FictionBook/body") {
+		if IsOneOf(getAttrValue(e, "name"), p.env.Cfg.Doc.Notes.BodyNames) {
+			continue
+		}
+		words = append(words, strings.Fields(normalizeText(extractText(e, true)))...)
+	}
+	f.Words = len(words)
+	f.Signature = textSignature(words)
+	return f, nil
+}
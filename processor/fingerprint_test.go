@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeText(t *testing.T) {
+	if s := normalizeText("  Ёжик, в ТУМАНЕ!  (2-е изд.)"); s != "ежик в тумане 2 е изд" {
+		t.Errorf("unexpected normalized text: %q", s)
+	}
+}
+
+func TestTextSignature(t *testing.T) {
+
+	words := func(n, from int) []string {
+		var res []string
+		for i := from; i < from+n; i++ {
+			res = append(res, fmt.Sprintf("w%d", i))
+		}
+		return res
+	}
+
+	a := &Fingerprint{Signature: textSignature(words(2000, 0))}
+	b := &Fingerprint{Signature: textSignature(append(words(2000, 0), words(20, 5000)...))}
+	c := &Fingerprint{Signature: textSignature(words(2000, 10000))}
+
+	if s := a.Similarity(b); s < 0.8 {
+		t.Errorf("almost the same texts are not similar: %f", s)
+	}
+	if s := a.Similarity(c); s > 0.2 {
+		t.Errorf("different texts are similar: %f", s)
+	}
+	if s := a.Similarity(&Fingerprint{Signature: textSignature(strings.Fields("too short"))}); s != 0 {
+		t.Errorf("text without signature is similar: %f", s)
+	}
+
+	shared := false
+	bb := b.Bands()
+	for i, band := range a.Bands() {
+		if band == bb[i] {
+			shared = true
+		}
+	}
+	if !shared {
+		t.Error("similar texts do not share any band")
+	}
+}
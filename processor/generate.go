@@ -467,10 +467,29 @@ func (p *Processor) generateOPF() error {
 		meta.AddNext("dc:creator", attr("opf:role", "aut")).SetText(a)
 	}
 
-	meta.AddNext("dc:publisher")
+	for _, an := range p.Book.Translators {
+		a := ReplaceKeywords(p.env.Cfg.Doc.AuthorFormatMeta, CreateAuthorKeywordsMap(an))
+		if p.env.Cfg.Doc.TransliterateMeta {
+			a = slug.Make(a)
+		}
+		meta.AddNext("dc:contributor", attr("opf:role", "trl")).SetText(a)
+	}
 
-	for _, g := range p.Book.Genres {
-		meta.AddNext("dc:subject").SetText(g)
+	if len(p.Book.ISBN) > 0 {
+		meta.AddNext("dc:identifier", attr("opf:scheme", "ISBN")).SetText(p.Book.ISBN)
+	}
+	if len(p.Book.Publisher) > 0 {
+		meta.AddNext("dc:publisher").SetText(p.Book.Publisher)
+	}
+	if len(p.Book.Year) > 0 {
+		meta.AddNext("dc:date", attr("opf:event", "publication")).SetText(p.Book.Year)
+	}
+
+	for _, s := range p.Book.Subjects {
+		meta.AddNext("dc:subject").SetText(s)
+	}
+	for _, k := range p.Book.Keywords {
+		meta.AddNext("dc:subject").SetText(k)
 	}
 
 	if len(p.Book.Annotation) > 0 {
@@ -488,6 +507,26 @@ func (p *Processor) generateOPF() error {
 			meta.AddNext("meta", attr("name", "calibre:series_index"), attr("content", strconv.Itoa(p.Book.SeqNum)))
 		}
 	}
+	// The rest of series go to "fb2:series" and "fb2:series_index" metas, the same way calibre keeps the first one
+	for _, s := range p.additionalSequences() {
+		meta.AddNext("meta", attr("name", "fb2:series"), attr("content", s.Name))
+		if s.Num > 0 {
+			meta.AddNext("meta", attr("name", "fb2:series_index"), attr("content", strconv.Itoa(s.Num)))
+		}
+	}
+	// Keep the rest of FB2 description, there are no direct Dublin Core equivalents
+	for _, m := range []struct{ name, value string }{
+		{"fb2:src-title", p.Book.SrcTitle},
+		{"fb2:src-lang", p.Book.SrcLang},
+		{"fb2:publish-city", p.Book.City},
+	} {
+		if len(m.value) > 0 {
+			meta.AddNext("meta", attr("name", m.name), attr("content", m.value))
+		}
+	}
+	for _, t := range sortedKeys(p.Book.CustomInfo) {
+		meta.AddNext("meta", attr("name", "fb2:custom:"+t), attr("content", p.Book.CustomInfo[t]))
+	}
 
 	// Manifest generation
 
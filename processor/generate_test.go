@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+	"fb2converter/state"
+)
+
+func TestOPFSeries(t *testing.T) {
+
+	conf, err := config.BuildConfig("", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Processor{
+		format: OEpub,
+		env:    &state.LocalEnv{Cfg: conf, Log: zap.NewNop()},
+		Book:   NewBook(uuid.New(), "book.fb2"),
+	}
+	p.Book.Title = "Title"
+	p.Book.SeqName, p.Book.SeqNum = "Cycle", 2
+	p.Book.Sequences = []*Sequence{{Name: "Cycle", Num: 2}, {Name: "Publisher Series", Num: 15}, {Name: "Other"}}
+
+	if err := p.generateOPF(); err != nil {
+		t.Fatal(err)
+	}
+	var opf *dataFile
+	for _, f := range p.Book.Files {
+		if f.id == "content" {
+			opf = f
+		}
+	}
+	if opf == nil {
+		t.Fatal("OPF was not generated")
+	}
+	pkg := opf.doc.SelectElement("package")
+	if v := pkg.SelectAttrValue("version", ""); v != "2.0" {
+		t.Fatalf("unexpected OPF version %s", v)
+	}
+
+	var metas []string
+	for _, m := range pkg.FindElements("./metadata/meta") {
+		if m.SelectAttr("property") != nil || m.SelectAttr("refines") != nil {
+			t.Errorf("EPUB 3 metadata in OPF 2.0: %s", m.Text())
+		}
+		if name := m.SelectAttrValue("name", ""); name != "cover" {
+			metas = append(metas, name+"="+m.SelectAttrValue("content", ""))
+		}
+	}
+	expected := []string{
+		"calibre:series=Cycle", "calibre:series_index=2",
+		"fb2:series=Publisher Series", "fb2:series_index=15",
+		"fb2:series=Other",
+	}
+	if len(metas) != len(expected) {
+		t.Fatalf("unexpected metadata: %v", metas)
+	}
+	for i := range expected {
+		if metas[i] != expected[i] {
+			t.Errorf("unexpected metadata: %v", metas)
+			break
+		}
+	}
+}
+
+func TestPrimarySequence(t *testing.T) {
+
+	title := &Sequence{Name: "Cycle", Num: 2}
+	nested := &Sequence{Name: "Subcycle", Num: 1, Level: 1}
+	publisher := &Sequence{Name: "Publisher Series", Num: 15, Publisher: true}
+
+	for _, c := range []struct {
+		primary   SeriesSelection
+		sequences []*Sequence
+		expected  *Sequence
+	}{
+		{SeriesFirst, []*Sequence{title, nested, publisher}, title},
+		{SeriesNested, []*Sequence{title, nested, publisher}, nested},
+		{SeriesPublisher, []*Sequence{title, nested, publisher}, publisher},
+		{SeriesPublisher, []*Sequence{title, nested}, title},
+		// publisher series alone is only used when requested
+		{SeriesFirst, []*Sequence{publisher}, nil},
+		{SeriesNested, []*Sequence{publisher}, nil},
+		{SeriesPublisher, []*Sequence{publisher}, publisher},
+	} {
+		p := &Processor{seriesPrimary: c.primary, Book: NewBook(uuid.New(), "book.fb2")}
+		p.Book.Sequences = c.sequences
+		if res := p.primarySequence(); res != c.expected {
+			t.Errorf("%s: unexpected primary sequence %+v", c.primary, res)
+		}
+	}
+}
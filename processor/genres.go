@@ -0,0 +1,184 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+	"fb2converter/static"
+)
+
+// genreTable maps FB2 genre code to its description.
+type genreTable map[string]*config.GenreInfo
+
+var builtinGenres struct {
+	once  sync.Once
+	table genreTable
+	err   error
+}
+
+// decodeGenreTable reads genre table in TOML format.
+func decodeGenreTable(data []byte) (map[string]config.GenreInfo, error) {
+
+	var res map[string]config.GenreInfo
+	if _, err := toml.Decode(string(data), &res); err != nil {
+		return nil, fmt.Errorf("bad genre table: %w", err)
+	}
+	return res, nil
+}
+
+// merge adds entries from another table, non-empty values replace existing ones, name translations are merged.
+// Codes are case insensitive. Existing entries are copied before modification, so tables could share them.
+func (t genreTable) merge(from map[string]config.GenreInfo) {
+
+	for code, src := range from {
+		code = strings.ToLower(code)
+		dst := &config.GenreInfo{}
+		if old, ok := t[code]; ok {
+			*dst = *old
+		}
+		t[code] = dst
+		if len(src.Group) > 0 {
+			dst.Group = strings.ToLower(src.Group)
+		}
+		if len(src.BISAC) > 0 {
+			dst.BISAC = src.BISAC
+		}
+		if len(src.Thema) > 0 {
+			dst.Thema = src.Thema
+		}
+		if len(src.Names) > 0 {
+			names := make(map[string]string, len(dst.Names)+len(src.Names))
+			for l, n := range dst.Names {
+				names[l] = n
+			}
+			for l, n := range src.Names {
+				names[strings.ToLower(l)] = n
+			}
+			dst.Names = names
+		}
+	}
+}
+
+// name returns display name of the genre in requested language, english name or code itself.
+func (t genreTable) name(code, lang string) string {
+
+	if gi, ok := t[strings.ToLower(code)]; ok {
+		if n, ok := gi.Names[lang]; ok && len(n) > 0 {
+			return n
+		}
+		if n, ok := gi.Names["en"]; ok && len(n) > 0 {
+			return n
+		}
+	}
+	return code
+}
+
+// loadGenres builds genre table out of built in one and configuration overrides.
+func (p *Processor) loadGenres() genreTable {
+
+	builtinGenres.once.Do(func() {
+		data, err := static.Asset("genres.toml")
+		if err == nil {
+			var raw map[string]config.GenreInfo
+			if raw, err = decodeGenreTable(data); err == nil {
+				builtinGenres.table = make(genreTable, len(raw))
+				builtinGenres.table.merge(raw)
+			}
+		}
+		builtinGenres.err = err
+	})
+
+	t := make(genreTable)
+	if builtinGenres.err != nil {
+		p.env.Log.Warn("Unable to load built in genre table, ignoring", zap.Error(builtinGenres.err))
+	} else {
+		for code, gi := range builtinGenres.table {
+			t[code] = gi
+		}
+	}
+
+	cfg := p.env.Cfg.Doc.Genres
+	if fname := cfg.File; len(fname) > 0 {
+		if !filepath.IsAbs(fname) && len(p.env.Cfg.Path) > 0 {
+			fname = filepath.Join(p.env.Cfg.Path, fname)
+		}
+		ext, err := readGenreTable(fname)
+		if err != nil {
+			p.env.Log.Warn("Unable to load genre table, ignoring", zap.String("file", fname), zap.Error(err))
+		} else {
+			t.merge(ext)
+		}
+	}
+	t.merge(cfg.Table)
+	return t
+}
+
+func readGenreTable(fname string) (map[string]config.GenreInfo, error) {
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGenreTable(data)
+}
+
+// processGenres translates FB2 genre codes to display names and book subjects.
+func (p *Processor) processGenres() {
+
+	p.env.Log.Debug("Resolving genres - start")
+	defer func(start time.Time) {
+		p.env.Log.Debug("Resolving genres - done",
+			zap.Duration("elapsed", time.Since(start)),
+			zap.Strings("names", p.Book.GenreNames),
+			zap.Strings("subjects", p.Book.Subjects),
+		)
+	}(time.Now())
+
+	t := p.loadGenres()
+
+	lang := strings.ToLower(p.env.Cfg.Doc.Genres.Language)
+	if len(lang) == 0 {
+		base, _ := p.Book.Lang.Base()
+		lang = base.String()
+	}
+
+	p.Book.GenreNames, p.Book.Subjects, p.Book.GenreGroup = nil, nil, ""
+	for i, code := range p.Book.Genres {
+		name := t.name(code, lang)
+		p.Book.GenreNames = append(p.Book.GenreNames, name)
+
+		subj := name
+		if gi, ok := t[strings.ToLower(code)]; ok {
+			switch p.genreSubjects {
+			case SubjectBISAC:
+				if len(gi.BISAC) > 0 {
+					subj = gi.BISAC
+				}
+			case SubjectThema:
+				if len(gi.Thema) > 0 {
+					subj = gi.Thema
+				}
+			}
+		}
+		if p.genreSubjects == SubjectCode {
+			subj = code
+		}
+		p.Book.Subjects = AppendIfMissing(p.Book.Subjects, subj)
+
+		if i == 0 {
+			group := strings.ToLower(code)
+			if gi, ok := t[group]; ok && len(gi.Group) > 0 {
+				group = gi.Group
+			}
+			p.Book.GenreGroup = t.name(group, lang)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"testing"
+
+	"fb2converter/config"
+	"fb2converter/static"
+)
+
+func TestGenreTable(t *testing.T) {
+
+	data, err := static.Asset("genres.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := decodeGenreTable(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtin := make(genreTable)
+	builtin.merge(raw)
+
+	for code, gi := range builtin {
+		if len(gi.Names["en"]) == 0 {
+			t.Errorf("genre %s has no english name", code)
+		}
+		if _, ok := builtin[gi.Group]; len(gi.Group) > 0 && !ok {
+			t.Errorf("genre %s refers to unknown group %s", code, gi.Group)
+		}
+	}
+
+	tbl := make(genreTable)
+	for k, v := range builtin {
+		tbl[k] = v
+	}
+	tbl.merge(map[string]config.GenreInfo{
+		"SF_Fantasy": {Names: map[string]string{"de": "Fantasy-Roman"}},
+		"my_genre":   {Names: map[string]string{"en": "Mine"}},
+	})
+
+	for _, c := range []struct{ code, lang, name string }{
+		{"sf_fantasy", "ru", "Фэнтези"},
+		{"sf_fantasy", "de", "Fantasy-Roman"},
+		{"sf_fantasy", "fr", "Fantasy"},
+		{"my_genre", "ru", "Mine"},
+		{"unknown_genre", "en", "unknown_genre"},
+	} {
+		if n := tbl.name(c.code, c.lang); n != c.name {
+			t.Errorf("%s/%s: expected %q, got %q", c.code, c.lang, c.name, n)
+		}
+	}
+	if _, ok := builtin["sf_fantasy"].Names["de"]; ok {
+		t.Error("built in table was modified by merge")
+	}
+}
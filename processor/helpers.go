@@ -14,6 +14,7 @@ import (
 	"github.com/h2non/filetype"
 	"go.uber.org/zap"
 
+	"fb2converter/config"
 	"fb2converter/static"
 )
 
@@ -51,6 +52,12 @@ func (p *Processor) getStylesheet() (*dataFile, error) {
 		if d.data, err = static.Asset(path.Join(DirProfile, fname)); err != nil {
 			return nil, fmt.Errorf("unable to get default stylesheet: %w", err)
 		}
+		// device profile may adjust default stylesheet
+		if len(p.env.Cfg.Device) > 0 {
+			if css, err := static.Asset(path.Join(config.DirDevices, p.env.Cfg.Device+".css")); err == nil {
+				d.data = append(append(d.data, '\n'), css...)
+			}
+		}
 	}
 	d.fname = "stylesheet.css"
 	d.relpath = DirContent
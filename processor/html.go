@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/etree"
+)
+
+// html parsers do not understand self-closing tags, everything but void elements has to be closed explicitly
+var htmlVoidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr"}
+
+var cssURLPattern = regexp.MustCompile(`url\(\s*["']?([^"'\(\)\s]+)["']?\s*\)`)
+
+// generateHTML assembles single XHTML document from all previously generated content files. All references to resources
+// (images, vignettes, fonts) are passed to resolve, which returns reference to be used in resulting document.
+func (p *Processor) generateHTML(resolve func(rel string) string) *etree.Document {
+
+	p.env.Log.Debug("Generating HTML - start")
+	defer func(start time.Time) {
+		p.env.Log.Debug("Generating HTML - done", zap.Duration("elapsed", time.Since(start)))
+	}(time.Now())
+
+	doc := etree.NewDocument()
+	doc.WriteSettings = etree.WriteSettings{CanonicalText: true, CanonicalAttrVal: true}
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	doc.CreateDirective("DOCTYPE html")
+
+	ns := []*etree.Attr{attr("xmlns", `http://www.w3.org/1999/xhtml`), attr("xml:lang", p.Book.Lang.String())}
+	if p.notesMode == NFloatNew {
+		ns = append(ns, attr("xmlns:epub", `http://www.idpf.org/2007/ops`))
+	}
+	html := doc.Element.AddNext("html", ns...)
+
+	head := html.AddNext("head")
+	head.AddNext("meta", attr("http-equiv", "Content-Type"), attr("content", `text/html; charset=utf-8`))
+	head.AddNext("title").SetText(p.Book.Title)
+	if authors := p.Book.BookAuthors(p.env.Cfg.Doc.AuthorFormatMeta, false); len(authors) > 0 {
+		head.AddNext("meta", attr("name", "author"), attr("content", authors))
+	}
+	for _, d := range p.Book.Data {
+		if d.id != "style" {
+			continue
+		}
+		css := cssURLPattern.ReplaceAllStringFunc(string(d.data), func(m string) string {
+			return `url("` + resolve(cssURLPattern.FindStringSubmatch(m)[1]) + `")`
+		})
+		// stylesheet should survive both xml and html parsers
+		style := head.AddNext("style", attr("type", "text/css"))
+		style.CreateCharData("/*")
+		style.CreateDirective("[CDATA[*/\n" + css + "\n/*]]")
+		style.CreateCharData("*/")
+	}
+
+	// every content file becomes division with its own anchor
+	isContent := func(f *dataFile) bool {
+		return f.doc != nil && f.ct == "application/xhtml+xml" && filepath.Ext(f.fname) == ".xhtml" && f.transient&dataNotForSpline == 0
+	}
+	anchors := make(map[string]string)
+	for _, f := range p.Book.Files {
+		if isContent(f) {
+			anchors[f.fname] = "file_" + strings.TrimSuffix(f.fname, filepath.Ext(f.fname))
+		}
+		if f.id == "toc" {
+			// generated TOC page is replaced by navigation built from book TOC
+			anchors[f.fname] = "toc"
+		}
+	}
+
+	body := html.AddNext("body")
+	if p.tocPlacement != TOCAfter {
+		p.generateHTMLNav(body)
+	}
+	for _, f := range p.Book.Files {
+		if !isContent(f) || f.id == "toc" {
+			continue
+		}
+		from := f.doc.FindElement("./html/body")
+		if from == nil {
+			continue
+		}
+		div := body.AddNext("div", attr("id", anchors[f.fname]), attr("class", "htmlfile"))
+		for _, c := range from.ChildElements() {
+			div.AddChild(c.Copy())
+		}
+	}
+	if p.tocPlacement == TOCAfter {
+		p.generateHTMLNav(body)
+	}
+
+	for _, e := range body.FindElements(".//*") {
+		for i, a := range e.Attr {
+			switch {
+			case a.Space == "" && a.Key == "href" && e.Tag == "a":
+				u, err := url.Parse(a.Value)
+				if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 || !strings.HasSuffix(u.Path, ".xhtml") {
+					continue
+				}
+				if len(u.Fragment) > 0 {
+					e.Attr[i].Value = "#" + u.Fragment
+				} else if id, ok := anchors[u.Path]; ok {
+					e.Attr[i].Value = "#" + id
+				}
+			case (a.Key == "src" && a.Space == "" && e.Tag == "img") || (a.Key == "href" && a.Space == "xlink" && e.Tag == "image"):
+				e.Attr[i].Value = resolve(a.Value)
+			}
+		}
+	}
+
+	for _, e := range html.FindElements(".//*") {
+		if len(e.Child) == 0 && !IsOneOf(e.Tag, htmlVoidElements) {
+			e.SetText("")
+		}
+	}
+	return doc
+}
+
+// generateHTMLNav adds linked TOC made of book TOC entries regardless of TOC page placement. Links are left pointing
+// to content files and are resolved together with the rest of the document.
+func (p *Processor) generateHTMLNav(body *etree.Element) {
+
+	if len(p.Book.TOC) == 0 {
+		return
+	}
+
+	nav := body.AddNext("nav", attr("id", "toc"), attr("class", "toc"))
+	nav.AddNext("div", attr("class", "h1")).SetText(p.env.Cfg.Doc.TOC.Title)
+
+	type level struct {
+		level int
+		li    *etree.Element
+	}
+	var stack []level
+	top := nav.AddNext("ul")
+	for _, te := range p.Book.TOC {
+		if te.level.Int() > p.env.Cfg.Doc.TOC.MaxLevel {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= te.level.Int() {
+			stack = stack[:len(stack)-1]
+		}
+		list := top
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1].li
+			if list = parent.SelectElement("ul"); list == nil {
+				list = parent.AddNext("ul")
+			}
+		}
+		li := list.AddNext("li")
+		li.AddNext("a", attr("href", te.ref)).SetText(AllLines(te.title))
+		stack = append(stack, level{level: te.level.Int(), li: li})
+	}
+}
+
+// FinalizeHTML produces single html file out of previously saved temporary files.
+func (p *Processor) FinalizeHTML(fname string) error {
+
+	if err := p.PrepareOutputFile(fname); err != nil {
+		return err
+	}
+
+	// side-by-side resources are kept in directory named after resulting file, the way browsers save pages
+	filesDir := strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname)) + "_files"
+	if !p.env.Cfg.Doc.HTML.EmbedImages {
+		if err := os.RemoveAll(filepath.Join(filepath.Dir(fname), filesDir)); err != nil {
+			return fmt.Errorf("unable to clean resources directory: %w", err)
+		}
+	}
+
+	resolved := make(map[string]string)
+	doc := p.generateHTML(func(rel string) string {
+		if res, ok := resolved[rel]; ok {
+			return res
+		}
+		res := rel
+		src := filepath.Join(p.tmpDir, DirContent, filepath.FromSlash(rel))
+		data, err := os.ReadFile(src)
+		if err != nil {
+			p.env.Log.Warn("Unable to find HTML resource, leaving reference as is", zap.String("ref", rel), zap.Error(err))
+			resolved[rel] = res
+			return res
+		}
+		if p.env.Cfg.Doc.HTML.EmbedImages {
+			ct := mime.TypeByExtension(filepath.Ext(src))
+			if len(ct) == 0 {
+				ct = "application/octet-stream"
+			}
+			res = "data:" + ct + ";base64," + base64.StdEncoding.EncodeToString(data)
+		} else {
+			dst := filepath.Join(filepath.Dir(fname), filesDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+				p.env.Log.Warn("Unable to create resources directory", zap.String("dir", filepath.Dir(dst)), zap.Error(err))
+			} else if err := os.WriteFile(dst, data, 0644); err != nil {
+				p.env.Log.Warn("Unable to copy HTML resource", zap.String("file", dst), zap.Error(err))
+			} else {
+				res = path.Join(url.PathEscape(filesDir), rel)
+			}
+		}
+		resolved[rel] = res
+		return res
+	})
+
+	if err := doc.WriteToFile(fname); err != nil {
+		return fmt.Errorf("unable to write HTML (%s): %w", fname, err)
+	}
+	return nil
+}
+
+// FinalizeHTMLZ produces zip archive with index.html and all necessary resources out of previously saved temporary files.
+func (p *Processor) FinalizeHTMLZ(fname string) error {
+
+	if err := p.PrepareOutputFile(fname); err != nil {
+		return err
+	}
+
+	var resources []string
+	doc := p.generateHTML(func(rel string) string {
+		if !IsOneOf(rel, resources) {
+			resources = append(resources, rel)
+		}
+		return rel
+	})
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("unable to create HTMLZ (%s): %w", fname, err)
+	}
+	defer f.Close()
+
+	htmlz := zip.NewWriter(f)
+	defer htmlz.Close()
+
+	t := time.Now()
+
+	w, err := htmlz.CreateHeader(&zip.FileHeader{Name: "index.html", Method: zip.Deflate, Modified: t})
+	if err != nil {
+		return fmt.Errorf("unable to add index.html to HTMLZ: %w", err)
+	}
+	if _, err := doc.WriteTo(w); err != nil {
+		return fmt.Errorf("unable to write index.html to HTMLZ: %w", err)
+	}
+
+	copyFile := func(src, name string) error {
+		r, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		w, err := htmlz.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: t})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	for _, rel := range resources {
+		if err := copyFile(filepath.Join(p.tmpDir, DirContent, filepath.FromSlash(rel)), rel); err != nil {
+			p.env.Log.Warn("Unable to add HTML resource to HTMLZ, skipping", zap.String("ref", rel), zap.Error(err))
+		}
+	}
+
+	// keep book metadata for library managers
+	if err := copyFile(filepath.Join(p.tmpDir, DirContent, "content.opf"), "metadata.opf"); err != nil {
+		return fmt.Errorf("unable to add metadata to HTMLZ: %w", err)
+	}
+
+	// closing writes central directory, archive is unreadable without it
+	if err := htmlz.Close(); err != nil {
+		return fmt.Errorf("unable to finalize HTMLZ (%s): %w", fname, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to finalize HTMLZ (%s): %w", fname, err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package processor
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+	"fb2converter/etree"
+	"fb2converter/state"
+)
+
+// htmlProcessor prepares processor state as left by conversion: two content files, stylesheet referring to
+// background image and one more image in working directory.
+func htmlProcessor(t *testing.T, overrides ...string) *Processor {
+	t.Helper()
+
+	conf, err := config.BuildConfig("", nil, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	for name, data := range map[string]string{
+		"images/cover.png":  "png",
+		"images/bg.png":     "bg",
+		"content.opf":       "<package/>",
+		"images/unused.png": "unused",
+	} {
+		fname := filepath.Join(tmp, DirContent, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &Processor{
+		format:    OHtml,
+		tmpDir:    tmp,
+		notesMode: NDefault,
+		env:       &state.LocalEnv{Cfg: conf, Log: zap.NewNop()},
+		Book:      NewBook(uuid.New(), "book.fb2"),
+	}
+	p.Book.Title = "Книга"
+	p.Book.Data = append(p.Book.Data, &dataFile{id: "style", fname: "stylesheet.css",
+		data: []byte(`body { background: url(images/bg.png); }`)})
+	for name, body := range map[string]string{
+		"index0.xhtml": `<p><img src="images/cover.png"/></p><p><a href="index1.xhtml#n1">[1]</a><a href="index1.xhtml">next</a><a href="http://example.com/index.xhtml">link</a></p>`,
+		"index1.xhtml": `<p id="n1">note<span/></p>`,
+	} {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromString(`<html><head/><body>` + body + `</body></html>`); err != nil {
+			t.Fatal(err)
+		}
+		p.Book.Files = append(p.Book.Files, &dataFile{fname: name, ct: "application/xhtml+xml", doc: doc})
+	}
+	sort.Slice(p.Book.Files, func(i, j int) bool { return p.Book.Files[i].fname < p.Book.Files[j].fname })
+	return p
+}
+
+func checkHTML(t *testing.T, data []byte, resources ...string) {
+	t.Helper()
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		t.Fatalf("resulting HTML is not well formed: %v", err)
+	}
+	if title := doc.FindElement("./html/head/title"); title == nil || title.Text() != "Книга" {
+		t.Error("no title in HTML")
+	}
+	divs := doc.FindElements("./html/body/div[@class='htmlfile']")
+	if len(divs) != 2 || divs[0].SelectAttrValue("id", "") != "file_index0" || divs[1].SelectAttrValue("id", "") != "file_index1" {
+		t.Fatalf("unexpected content divisions: %d", len(divs))
+	}
+	var links []string
+	for _, a := range doc.FindElements("//a") {
+		links = append(links, a.SelectAttrValue("href", ""))
+	}
+	if strings.Join(links, " ") != "#n1 #file_index1 http://example.com/index.xhtml" {
+		t.Errorf("unexpected links: %v", links)
+	}
+	if span := doc.FindElement("//span"); span == nil || !strings.Contains(string(data), "<span></span>") {
+		t.Error("non void element was left self-closing")
+	}
+	if src := doc.FindElement("//img").SelectAttrValue("src", ""); src != resources[0] {
+		t.Errorf("unexpected image reference %s", src)
+	}
+	if style := doc.FindElement("./html/head/style"); style == nil || !strings.Contains(string(data), `url("`+resources[1]+`")`) {
+		t.Error("stylesheet reference was not resolved")
+	}
+}
+
+func TestFinalizeHTML(t *testing.T) {
+
+	t.Run("embedded", func(t *testing.T) {
+		fname := filepath.Join(t.TempDir(), "out", "book.html")
+		if err := htmlProcessor(t).FinalizeHTML(fname); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHTML(t, data, "data:image/png;base64,cG5n", "data:image/png;base64,Ymc=")
+		if _, err := os.Stat(filepath.Join(filepath.Dir(fname), "book_files")); !os.IsNotExist(err) {
+			t.Error("resources directory was created for embedded images")
+		}
+	})
+
+	t.Run("side by side", func(t *testing.T) {
+		dir := t.TempDir()
+		fname := filepath.Join(dir, "my book.html")
+		stale := filepath.Join(dir, "my book_files", "stale.png")
+		if err := os.MkdirAll(filepath.Dir(stale), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(stale, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		p := htmlProcessor(t, "document.html.embed_images=false")
+		if err := p.FinalizeHTML(fname); err == nil {
+			t.Fatal("existing file was overwritten")
+		}
+		p.overwrite = true
+		if err := p.FinalizeHTML(fname); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHTML(t, data, "my%20book_files/images/cover.png", "my%20book_files/images/bg.png")
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Error("stale resource was not removed")
+		}
+		for _, name := range []string{"images/cover.png", "images/bg.png"} {
+			if _, err := os.Stat(filepath.Join(dir, "my book_files", filepath.FromSlash(name))); err != nil {
+				t.Errorf("resource %s was not copied: %v", name, err)
+			}
+		}
+	})
+}
+
+func TestFinalizeHTMLZ(t *testing.T) {
+
+	fname := filepath.Join(t.TempDir(), "out", "book.htmlz")
+	if err := htmlProcessor(t).FinalizeHTMLZ(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]string)
+	var names []string
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+		names = append(names, f.Name)
+	}
+	// index.html goes first, only referenced resources are kept with their relative paths
+	if strings.Join(names, " ") != "index.html images/bg.png images/cover.png metadata.opf" {
+		t.Fatalf("unexpected archive layout: %v", names)
+	}
+	checkHTML(t, []byte(files["index.html"]), "images/cover.png", "images/bg.png")
+	if files["images/cover.png"] != "png" || files["images/bg.png"] != "bg" || files["metadata.opf"] != "<package/>" {
+		t.Errorf("unexpected archive content: %v", files)
+	}
+}
+
+func TestHTMLNav(t *testing.T) {
+
+	for _, c := range []struct {
+		placement TOCPlacement
+		overrides []string
+		nav       string
+		first     bool
+	}{
+		{TOCNone, nil, "Книга=#file_index0 (Глава 1=#n1 (Раздел=#file_index1))", true},
+		{TOCBefore, []string{"document.toc.page_maxlevel=2"}, "Книга=#file_index0 (Глава 1=#n1)", true},
+		{TOCAfter, nil, "Книга=#file_index0 (Глава 1=#n1 (Раздел=#file_index1))", false},
+	} {
+		p := htmlProcessor(t, c.overrides...)
+		p.tocPlacement = c.placement
+		p.Book.TOC = []*tocEntry{
+			{ref: "index0.xhtml", title: "Книга", level: 0},
+			{ref: "index1.xhtml#n1", title: "Глава\n1", level: 2},
+			{ref: "index1.xhtml", title: "Раздел", level: 3},
+		}
+		if c.placement != TOCNone {
+			// generated TOC page must not be duplicated
+			doc := etree.NewDocument()
+			if err := doc.ReadFromString(`<html><body><div class="toc"><a href="index0.xhtml">page</a></div></body></html>`); err != nil {
+				t.Fatal(err)
+			}
+			p.Book.Files = append(p.Book.Files, &dataFile{id: "toc", fname: "toc.xhtml", ct: "application/xhtml+xml", doc: doc})
+		}
+
+		body := p.generateHTML(func(rel string) string { return rel }).FindElement("./html/body")
+		nav := body.SelectElement("nav")
+		if nav == nil {
+			t.Fatalf("%s: no TOC in HTML", c.placement)
+		}
+		if first := body.ChildElements()[0] == nav; first != c.first {
+			t.Errorf("%s: TOC is misplaced", c.placement)
+		}
+		if divs := body.SelectElements("div"); len(divs) != 2 {
+			t.Errorf("%s: unexpected content divisions: %d", c.placement, len(divs))
+		}
+
+		var list func(ul *etree.Element) string
+		list = func(ul *etree.Element) string {
+			var items []string
+			for _, li := range ul.SelectElements("li") {
+				a := li.SelectElement("a")
+				item := a.Text() + "=" + a.SelectAttrValue("href", "")
+				if inner := li.SelectElement("ul"); inner != nil {
+					item += " (" + list(inner) + ")"
+				}
+				items = append(items, item)
+			}
+			return strings.Join(items, ", ")
+		}
+		if res := list(nav.SelectElement("ul")); res != c.nav {
+			t.Errorf("%s: unexpected TOC: %s", c.placement, res)
+		}
+	}
+}
@@ -29,6 +29,7 @@ const (
 	imageKindle binImageProcessingFlags = 1 << iota
 	imageOpaquePNG
 	imageScale
+	imageGrayscale
 	imageChanged
 )
 
@@ -113,6 +114,17 @@ func (b *binImage) flush(path string) error {
 			}
 		}
 
+		// Grayscale
+		if b.flags&imageGrayscale != 0 {
+			if _, gray := b.img.(*image.Gray); !gray {
+				grayImg := image.NewGray(b.img.Bounds())
+				// flatten onto white first, otherwise transparent areas turn black
+				draw.Draw(grayImg, grayImg.Bounds(), image.White, image.Point{}, draw.Src)
+				draw.Draw(grayImg, grayImg.Bounds(), b.img, b.img.Bounds().Min, draw.Over)
+				b.img = grayImg
+			}
+		}
+
 		targetType := b.imgType
 
 		// Unsupported format
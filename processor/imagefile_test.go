@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestImageGrayscaleTransparency(t *testing.T) {
+
+	// transparent image with opaque dark square in the middle
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for y := 5; y < 15; y++ {
+		for x := 5; x < 15; x++ {
+			img.Set(x, y, color.NRGBA{R: 20, G: 20, B: 20, A: 255})
+		}
+	}
+
+	dir := t.TempDir()
+	b := &binImage{log: zap.NewNop(), id: "img", fname: "img.png", flags: imageGrayscale, img: img, imgType: "png"}
+	if err := b.flush(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "img.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	res, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.(*image.Gray); !ok {
+		t.Errorf("image was not converted to grayscale: %T", res)
+	}
+	if c := color.GrayModel.Convert(res.At(0, 0)).(color.Gray); c.Y != 255 {
+		t.Errorf("transparent pixel became %d, expected white", c.Y)
+	}
+	if c := color.GrayModel.Convert(res.At(10, 10)).(color.Gray); c.Y != 20 {
+		t.Errorf("opaque pixel became %d, expected 20", c.Y)
+	}
+}
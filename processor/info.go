@@ -0,0 +1,263 @@
+package processor
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"fb2converter/processor/internal/mobi"
+)
+
+// BookInfo is book metadata and structure as found in the book file.
+type BookInfo struct {
+	Format      string        `json:"format"`
+	ID          string        `json:"id,omitempty"`
+	Title       string        `json:"title,omitempty"`
+	Authors     []string      `json:"authors,omitempty"`
+	Translators []string      `json:"translators,omitempty"`
+	Series      []*Sequence   `json:"series,omitempty"`
+	Genres      []string      `json:"genres,omitempty"`
+	GenreNames  []string      `json:"genre_names,omitempty"`
+	Language    string        `json:"language,omitempty"`
+	Date        string        `json:"date,omitempty"`
+	Publisher   string        `json:"publisher,omitempty"`
+	ISBN        string        `json:"isbn,omitempty"`
+	Annotation  string        `json:"annotation,omitempty"`
+	Cover       bool          `json:"cover"`
+	Images      int           `json:"images"`
+	Bodies      []*BodyInfo   `json:"bodies,omitempty"`
+	OutputName  string        `json:"output_name,omitempty"`
+	Kindle      *KindleHeader `json:"kindle,omitempty"`
+}
+
+// BodyInfo describes single FB2 body.
+type BodyInfo struct {
+	Name     string `json:"name,omitempty"`
+	Notes    bool   `json:"notes"`
+	Sections int    `json:"sections"`
+	Size     int    `json:"size"` // characters of text
+}
+
+// KindleHeader has identifiers important for Kindle devices.
+type KindleHeader struct {
+	ASIN      string `json:"asin,omitempty"`
+	CDEType   string `json:"cdetype,omitempty"`
+	CDEKey    string `json:"cdekey,omitempty"`
+	Version   int    `json:"mobi_version"`
+	KF8Offset int    `json:"kf8_offset"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// Inspect parses book description and collects information about FB2 document without converting it.
+func (p *Processor) Inspect() (*BookInfo, error) {
+
+	if err := p.processDescription(); err != nil {
+		return nil, err
+	}
+	p.processGenres()
+
+	info := p.descriptionInfo()
+	for _, e := range p.doc.FindElements("./FictionBook/binary") {
+		info.Images++
+		if id := getAttrValue(e, "id"); len(p.Book.Cover) > 0 && id == p.Book.Cover {
+			info.Cover = true
+		}
+	}
+	for _, e := range p.doc.FindElements("./FictionBook/body") {
+		name := getAttrValue(e, "name")
+		info.Bodies = append(info.Bodies, &BodyInfo{
+			Name:     name,
+			Notes:    IsOneOf(name, p.env.Cfg.Doc.Notes.BodyNames),
+			Sections: len(e.FindElements(".//section")),
+			Size:     len([]rune(getTextFragment(e))),
+		})
+	}
+	return info, nil
+}
+
+// descriptionInfo fills book information from already parsed description.
+func (p *Processor) descriptionInfo() *BookInfo {
+
+	b := p.Book
+	info := &BookInfo{
+		Format:     "fb2",
+		ID:         b.ID.String(),
+		Title:      b.Title,
+		Series:     b.Sequences,
+		Genres:     b.Genres,
+		GenreNames: b.GenreNames,
+		Language:   b.Lang.String(),
+		Date:       b.Date,
+		Publisher:  b.Publisher,
+		ISBN:       b.ISBN,
+		OutputName: p.prepareOutputName(),
+	}
+	for _, an := range b.Authors {
+		info.Authors = append(info.Authors, ReplaceKeywords(p.env.Cfg.Doc.AuthorFormat, CreateAuthorKeywordsMap(an)))
+	}
+	for _, an := range b.Translators {
+		info.Translators = append(info.Translators, ReplaceKeywords(p.env.Cfg.Doc.AuthorFormat, CreateAuthorKeywordsMap(an)))
+	}
+	return info
+}
+
+// InspectEPUB reads metadata from OPF of the EPUB book.
+func InspectEPUB(r io.ReaderAt, size int64) (*BookInfo, error) {
+
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(name string) ([]byte, error) {
+		for _, f := range z.File {
+			if f.Name == name {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(rc)
+			}
+		}
+		return nil, fmt.Errorf("%s not found", name)
+	}
+
+	data, err := read("META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var container struct {
+		Rootfiles []struct {
+			Path string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("bad container: %w", err)
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("no rootfile in container")
+	}
+	opfName := container.Rootfiles[0].Path
+	if data, err = read(opfName); err != nil {
+		return nil, err
+	}
+
+	var opf struct {
+		Metadata struct {
+			Title       string   `xml:"title"`
+			Creators    []string `xml:"creator"`
+			Language    string   `xml:"language"`
+			Date        string   `xml:"date"`
+			Publisher   string   `xml:"publisher"`
+			Subjects    []string `xml:"subject"`
+			Identifiers []struct {
+				ID     string `xml:"id,attr"`
+				Scheme string `xml:"scheme,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"identifier"`
+			Meta []struct {
+				Name    string `xml:"name,attr"`
+				Content string `xml:"content,attr"`
+			} `xml:"meta"`
+		} `xml:"metadata"`
+		Unique string `xml:"unique-identifier,attr"`
+		Items  []struct {
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+			ID         string `xml:"id,attr"`
+		} `xml:"manifest>item"`
+	}
+	if err := xml.Unmarshal(data, &opf); err != nil {
+		return nil, fmt.Errorf("bad OPF %s: %w", opfName, err)
+	}
+
+	md := opf.Metadata
+	info := &BookInfo{
+		Format:    "epub",
+		Title:     strings.TrimSpace(md.Title),
+		Authors:   md.Creators,
+		Genres:    md.Subjects,
+		Language:  md.Language,
+		Date:      md.Date,
+		Publisher: md.Publisher,
+	}
+	for _, id := range md.Identifiers {
+		switch {
+		case strings.EqualFold(id.Scheme, "ISBN"):
+			info.ISBN = strings.TrimSpace(id.Value)
+		case id.ID == opf.Unique || len(info.ID) == 0:
+			info.ID = strings.TrimPrefix(strings.TrimSpace(id.Value), "urn:uuid:")
+		}
+	}
+	var coverID string
+	seq := &Sequence{}
+	for _, m := range md.Meta {
+		switch m.Name {
+		case "cover":
+			coverID = m.Content
+		case "calibre:series":
+			seq.Name = m.Content
+		case "calibre:series_index":
+			if f, err := strconv.ParseFloat(m.Content, 64); err == nil {
+				seq.Num = int(f)
+			}
+		}
+	}
+	if len(seq.Name) > 0 {
+		info.Series = append(info.Series, seq)
+	}
+	for _, it := range opf.Items {
+		if !strings.HasPrefix(it.MediaType, "image/") {
+			continue
+		}
+		info.Images++
+		if it.ID == coverID || strings.Contains(it.Properties, "cover-image") {
+			info.Cover = true
+		}
+	}
+	// there are books with cover in guide only
+	if !info.Cover && len(coverID) > 0 {
+		_, err := read(path.Join(path.Dir(opfName), coverID))
+		info.Cover = err == nil
+	}
+	return info, nil
+}
+
+// InspectKindle reads metadata from MOBI/AZW3 headers.
+func InspectKindle(data []byte) (*BookInfo, error) {
+
+	mi, err := mobi.ReadInfo(data)
+	if err != nil {
+		return nil, err
+	}
+	info := &BookInfo{
+		Format:    "mobi",
+		Title:     mi.Title,
+		Authors:   mi.Authors,
+		Genres:    mi.Subjects,
+		Language:  mi.Language,
+		Date:      mi.Date,
+		Publisher: mi.Publisher,
+		Cover:     mi.Cover,
+		Images:    mi.Images,
+		Kindle: &KindleHeader{
+			ASIN:      mi.ASIN,
+			CDEType:   mi.CDEType,
+			CDEKey:    mi.CDEKey,
+			Version:   mi.Version,
+			KF8Offset: mi.KF8Offset,
+			Encrypted: mi.Encrypted,
+		},
+	}
+	if mi.Version >= 8 {
+		info.Format = "azw3"
+	}
+	return info, nil
+}
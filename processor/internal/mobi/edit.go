@@ -0,0 +1,208 @@
+package mobi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Edit is a set of changes to Kindle book metadata, empty values are left untouched.
+type Edit struct {
+	Title     string
+	Authors   []string
+	Publisher string
+	Language  string
+	ASIN      string
+	CDEType   string
+	Cover     []byte // new cover image, any format image package understands
+}
+
+const textEncoding = 28
+
+// encodeString converts string to the text encoding of the book.
+func encodeString(rec0 []byte, s string) []byte {
+	if getInt32(rec0, textEncoding) == 1252 {
+		if b, err := charmap.Windows1252.NewEncoder().Bytes([]byte(s)); err == nil {
+			return b
+		}
+	}
+	return []byte(s)
+}
+
+// setExth replaces all EXTH records with the same id by new values.
+func setExth(rec0 []byte, id int, values ...[]byte) []byte {
+	for len(readExth(rec0, id)) > 0 {
+		rec0 = delExth(rec0, id)
+	}
+	// addExth puts record first, keep original order
+	for i := len(values) - 1; i >= 0; i-- {
+		rec0 = addExth(rec0, id, values[i])
+	}
+	return rec0
+}
+
+// setFullName replaces book title stored after EXTH header.
+func setFullName(rec0, title []byte) []byte {
+
+	ofs, l := getInt32(rec0, titleOffset), getInt32(rec0, titleOffset+4)
+
+	var b bytes.Buffer
+	b.Write(rec0[:ofs])
+	b.Write(title)
+	b.Write(rec0[ofs+l:])
+	if pad := 4 - b.Len()%4; pad < 4 {
+		b.Write(make([]byte, pad))
+	}
+	res := b.Bytes()
+	putInt32(res, titleOffset+4, len(title))
+	return res
+}
+
+// editHeader applies changes to a single MOBI header (rec0 or KF8 header of combo file).
+func editHeader(rec0 []byte, e *Edit) []byte {
+
+	str := func(s string) []byte { return encodeString(rec0, strings.TrimSpace(s)) }
+
+	if len(e.Title) > 0 {
+		rec0 = setExth(rec0, exthTitle, str(e.Title))
+		rec0 = setFullName(rec0, str(e.Title))
+	}
+	if len(e.Authors) > 0 {
+		var authors [][]byte
+		for _, a := range e.Authors {
+			authors = append(authors, str(a))
+		}
+		rec0 = setExth(rec0, exthAuthor, authors...)
+	}
+	if len(e.Publisher) > 0 {
+		rec0 = setExth(rec0, exthPublisher, str(e.Publisher))
+	}
+	if len(e.Language) > 0 {
+		rec0 = setExth(rec0, exthLanguage, str(e.Language))
+		if code := languageCode(e.Language); code != 0 {
+			putInt32(rec0, titleOffset+8, code)
+		}
+	}
+	if len(e.ASIN) > 0 {
+		rec0 = setExth(rec0, exthASIN, str(e.ASIN))
+		rec0 = setExth(rec0, exthCDEContentKey, str(e.ASIN))
+	}
+	if len(e.CDEType) > 0 {
+		rec0 = setExth(rec0, exthCDEType, str(e.CDEType))
+	}
+	return rec0
+}
+
+// replaceCover puts new cover image and its thumbnail in place of existing ones.
+func replaceCover(data []byte, headers [][]byte, cover []byte) ([]byte, error) {
+
+	img, _, err := image.Decode(bytes.NewReader(cover))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode cover image: %w", err)
+	}
+	encode := func(img image.Image) ([]byte, error) {
+		var buf = new(bytes.Buffer)
+		if err := imaging.Encode(buf, img, imaging.JPEG, imaging.JPEGQuality(75)); err != nil {
+			return nil, err
+		}
+		buf, _ = SetJpegDPI(buf, DpiPxPerInch, 300, 300)
+		return buf.Bytes(), nil
+	}
+	flat, err := encode(img)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode cover image: %w", err)
+	}
+	thumb, err := encode(imaging.Thumbnail(img, 330, 470, imaging.Lanczos))
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode cover thumbnail: %w", err)
+	}
+
+	// images are shared by both parts of combo file and indexed from the first image of MOBI 7 part
+	firstimage := getInt32(readSection(data, 0), firstRescRecord)
+	nsec := getUInt16(data, numberOfPdbRecords)
+	replaced := make(map[int]bool)
+	for _, rec0 := range headers {
+		for _, r := range []struct {
+			id  int
+			img []byte
+		}{
+			{exthCoverOffset, flat},
+			{exthThumbOffset, thumb},
+		} {
+			exth := readExth(rec0, r.id)
+			if len(exth) == 0 {
+				continue
+			}
+			idx := firstimage + getInt32(exth[0], 0)
+			if idx <= 0 || idx >= nsec || replaced[idx] {
+				continue
+			}
+			data = writeSection(data, idx, r.img)
+			replaced[idx] = true
+		}
+	}
+	if len(replaced) == 0 {
+		return nil, errors.New("book has no cover image to replace")
+	}
+	return data, nil
+}
+
+// EditMeta changes metadata in MOBI 7 and KF8 headers of the book and returns new book content.
+func EditMeta(data []byte, e *Edit) (res []byte, err error) {
+
+	defer func() {
+		// malformed books lead to out of range access
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("malformed mobi file: %v", r)
+		}
+	}()
+
+	if len(data) < firstPdbRecord || string(data[60:68]) != "BOOKMOBI" {
+		return nil, errors.New("not a mobi file")
+	}
+
+	rec0 := readSection(data, 0)
+	if getUInt16(rec0, cryptoType) != 0 {
+		return nil, errors.New("encrypted book")
+	}
+	if getInt32(rec0, exthFlags)&0x40 == 0 {
+		return nil, errors.New("book has no EXTH header")
+	}
+
+	res = make([]byte, len(data))
+	copy(res, data)
+
+	kf8 := -1
+	if kf8off := readExth(rec0, exthKF8Offset); len(kf8off) > 0 {
+		if kf8 = getInt32(kf8off[0], 0); kf8 <= 0 || kf8 >= getUInt16(data, numberOfPdbRecords) {
+			kf8 = -1
+		}
+	}
+
+	headers := [][]byte{rec0}
+	if kf8 > 0 {
+		headers = append(headers, readSection(data, kf8))
+	}
+	if len(e.Cover) > 0 {
+		if res, err = replaceCover(res, headers, e.Cover); err != nil {
+			return nil, err
+		}
+	}
+
+	// KF8 header goes first, so changes of record 0 size do not affect its location
+	if kf8 > 0 {
+		res = writeSection(res, kf8, editHeader(readSection(res, kf8), e))
+	}
+	res = writeSection(res, 0, editHeader(readSection(res, 0), e))
+
+	if len(e.Title) > 0 {
+		name := pdbName(e.Title)
+		copy(res[:32], append(name, make([]byte, 32-len(name))...))
+	}
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package mobi
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestEditMeta(t *testing.T) {
+
+	jpg := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	w := NewWriter(&Meta{Title: "Old Title", Authors: []string{"Old Author"}, Language: "en"}, uuid.New(), "", false, zap.NewNop())
+	idx, err := w.AddImage(jpg(60, 80))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCover(idx)
+	w.SetText([]byte("<html><body><p>text</p></body></html>"), -1)
+	data, err := w.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := EditMeta(data, &Edit{
+		Title:     "Новое название",
+		Authors:   []string{"First Author", "Second Author"},
+		Publisher: "Publisher",
+		Language:  "ru",
+		ASIN:      "B000000000",
+		CDEType:   "PDOC",
+		Cover:     jpg(600, 800),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadInfo(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Title != "Новое название" || !reflect.DeepEqual(info.Authors, []string{"First Author", "Second Author"}) ||
+		info.Publisher != "Publisher" || info.Language != "ru" || info.ASIN != "B000000000" || info.CDEKey != "B000000000" ||
+		info.CDEType != "PDOC" || !info.Cover {
+		t.Errorf("unexpected book info: %+v", info)
+	}
+	if getInt32(readSection(res, 0), titleOffset+8) != languageCode("ru") {
+		t.Error("locale was not changed")
+	}
+	if len(res) <= len(data) {
+		t.Error("cover image was not replaced")
+	}
+
+	if _, err := EditMeta(data[:100], &Edit{Title: "x"}); err == nil {
+		t.Error("malformed book should not be accepted")
+	}
+}
@@ -0,0 +1,270 @@
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Flat NCX index of legacy mobi - see calibre.ebooks.mobi.writer2.indexer for details.
+
+const (
+	indxHeaderLength = 192
+	maxCNCXString    = 500
+	cncxRecordLimit  = 0x10000 - 1024
+)
+
+// TOCEntry is single entry of Kindle table of contents, Pos is byte offset of the entry in uncompressed text.
+type TOCEntry struct {
+	Title string
+	Pos   int
+}
+
+type indexEntry struct {
+	offset, length, label int
+}
+
+// encint encodes variable width integer, 7 bits per byte. Forward encoding sets high bit in the last byte,
+// backward in the first one.
+func encint(value int, forward bool) []byte {
+	var b []byte
+	for {
+		b = append(b, byte(value&0x7f))
+		value >>= 7
+		if value == 0 {
+			break
+		}
+	}
+	if forward {
+		b[0] |= 0x80
+	} else {
+		b[len(b)-1] |= 0x80
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// encodeTrailingData returns data followed by backward encoded size of the whole entry.
+func encodeTrailingData(data []byte) []byte {
+	for lsize := 1; ; lsize++ {
+		if size := encint(len(data)+lsize, false); len(size) == lsize {
+			return append(append([]byte{}, data...), size...)
+		}
+	}
+}
+
+// encodeIndexNumber encodes entry index as hex string prefixed with its length.
+func encodeIndexNumber(n int) []byte {
+	s := fmt.Sprintf("%X", n)
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func alignBlock(b []byte) []byte {
+	if pad := (4 - len(b)%4) % 4; pad > 0 {
+		b = append(b, make([]byte, pad)...)
+	}
+	return b
+}
+
+// buildIndexEntries sorts TOC, removes entries pointing to the same location and calculates lengths.
+// Labels are stored in CNCX records, which are returned as well.
+func buildIndexEntries(toc []TOCEntry, textLength int) ([]indexEntry, [][]byte) {
+
+	sorted := make([]TOCEntry, 0, len(toc))
+	seen := make(map[int]bool)
+	for _, t := range toc {
+		if t.Pos < 0 || t.Pos >= textLength || seen[t.Pos] {
+			continue
+		}
+		seen[t.Pos] = true
+		sorted = append(sorted, t)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Pos < sorted[j].Pos })
+
+	var (
+		entries []indexEntry
+		records [][]byte
+		buf     bytes.Buffer
+		offset  int
+	)
+	for i, t := range sorted {
+		label := []byte(t.Title)
+		if len(label) > maxCNCXString {
+			label = label[:maxCNCXString]
+		}
+		raw := append(encint(len(label), true), label...)
+		if buf.Len()+len(raw) > cncxRecordLimit {
+			records = append(records, alignBlock(append([]byte{}, buf.Bytes()...)))
+			buf.Reset()
+			offset = len(records) * 0x10000
+		}
+		buf.Write(raw)
+
+		next := textLength
+		if i < len(sorted)-1 {
+			next = sorted[i+1].Pos
+		}
+		entries = append(entries, indexEntry{offset: t.Pos, length: next - t.Pos, label: offset})
+		offset += len(raw)
+	}
+	if buf.Len() > 0 {
+		records = append(records, alignBlock(append([]byte{}, buf.Bytes()...)))
+	}
+	return entries, records
+}
+
+// tagxFlatBook is TAGX block for primary index of a flat book: offset, length, label and depth - single control byte.
+func tagxFlatBook() []byte {
+	var b bytes.Buffer
+	tags := []struct{ tag, values, mask, eof byte }{
+		{1, 1, 0x01, 0}, {2, 1, 0x02, 0}, {3, 1, 0x04, 0}, {4, 1, 0x08, 0}, {0, 0, 0, 1},
+	}
+	b.WriteString("TAGX")
+	binary.Write(&b, binary.BigEndian, uint32(12+4*len(tags)))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	for _, t := range tags {
+		b.Write([]byte{t.tag, t.values, t.mask, t.eof})
+	}
+	return b.Bytes()
+}
+
+// buildIndexRecords produces INDX header record and single INDX record with all entries.
+func buildIndexRecords(entries []indexEntry, ncncx int) ([][]byte, error) {
+
+	var (
+		body    bytes.Buffer
+		offsets []int
+	)
+	for i, e := range entries {
+		offsets = append(offsets, body.Len())
+		body.Write(encodeIndexNumber(i))
+		body.WriteByte(0x0f) // all four tags are present
+		body.Write(encint(e.offset, true))
+		body.Write(encint(e.length, true))
+		body.Write(encint(e.label, true))
+		body.Write(encint(0, true)) // depth
+	}
+	block := alignBlock(body.Bytes())
+
+	idxt := []byte("IDXT")
+	for _, ofs := range offsets {
+		idxt = binary.BigEndian.AppendUint16(idxt, uint16(indxHeaderLength+ofs))
+	}
+	idxt = alignBlock(idxt)
+
+	var rec bytes.Buffer
+	rec.WriteString("INDX")
+	binary.Write(&rec, binary.BigEndian, uint32(indxHeaderLength))
+	binary.Write(&rec, binary.BigEndian, uint32(0))
+	binary.Write(&rec, binary.BigEndian, uint32(1)) // index record
+	binary.Write(&rec, binary.BigEndian, uint32(0))
+	binary.Write(&rec, binary.BigEndian, uint32(indxHeaderLength+len(block)))
+	binary.Write(&rec, binary.BigEndian, uint32(len(offsets)))
+	rec.Write(bytes.Repeat([]byte{0xff}, 8))
+	rec.Write(make([]byte, 156))
+	rec.Write(block)
+	rec.Write(idxt)
+	if rec.Len() > 0x10000 {
+		return nil, fmt.Errorf("too many entries (%d) in the TOC", len(entries))
+	}
+
+	tagx := tagxFlatBook()
+	var hdr bytes.Buffer
+	hdr.WriteString("INDX")
+	binary.Write(&hdr, binary.BigEndian, uint32(indxHeaderLength))
+	hdr.Write(make([]byte, 8))
+	binary.Write(&hdr, binary.BigEndian, uint32(2)) // generation
+	binary.Write(&hdr, binary.BigEndian, uint32(0)) // IDXT offset, filled later
+	binary.Write(&hdr, binary.BigEndian, uint32(1)) // number of index records
+	binary.Write(&hdr, binary.BigEndian, uint32(65001))
+	hdr.Write(bytes.Repeat([]byte{0xff}, 4))
+	binary.Write(&hdr, binary.BigEndian, uint32(len(entries)))
+	hdr.Write(make([]byte, 12)) // ORDT, LIGT, LIGT entries
+	binary.Write(&hdr, binary.BigEndian, uint32(ncncx))
+	hdr.Write(make([]byte, 124))
+	binary.Write(&hdr, binary.BigEndian, uint32(indxHeaderLength)) // TAGX offset
+	hdr.Write(make([]byte, 8))
+	hdr.Write(tagx)
+	hdr.Write(encodeIndexNumber(len(entries) - 1))
+	binary.Write(&hdr, binary.BigEndian, uint16(len(entries)))
+	if pad := (4 - hdr.Len()%4) % 4; pad > 0 {
+		hdr.Write(make([]byte, pad))
+	}
+	idxtOffset := hdr.Len()
+	hdr.WriteString("IDXT")
+	binary.Write(&hdr, binary.BigEndian, uint16(indxHeaderLength+len(tagx)))
+	hdr.WriteByte(0)
+	header := alignBlock(hdr.Bytes())
+	binary.BigEndian.PutUint32(header[20:], uint32(idxtOffset))
+
+	return [][]byte{header, rec.Bytes()}, nil
+}
+
+// trailingIndexBytes calculates trailing byte sequences (TBS) for every text record of a flat book, so device knows
+// which index entries start, end or span the record.
+func trailingIndexBytes(entries []indexEntry, records int) [][]byte {
+
+	encodeTBS := func(val int, flags map[int]int) []byte {
+		f := 0
+		for k := range flags {
+			f |= k
+		}
+		b := encint(val<<3|f, true)
+		if _, ok := flags[0b010]; ok {
+			b = append(b, encint(flags[0b010], true)...)
+		}
+		if n, ok := flags[0b100]; ok {
+			b = append(b, byte(n))
+		}
+		return b
+	}
+
+	res := make([][]byte, records)
+	for i := 0; i < records; i++ {
+		start, end := i*textRecordSize, (i+1)*textRecordSize
+
+		var starts, completes, ends []int
+		spans := -1
+		for n, e := range entries {
+			next := e.offset + e.length
+			if e.offset >= end {
+				break
+			}
+			if next <= start {
+				continue
+			}
+			switch {
+			case e.offset >= start && next <= end:
+				completes = append(completes, n)
+			case e.offset >= start:
+				starts = append(starts, n)
+			case next <= end:
+				ends = append(ends, n)
+			default:
+				spans = n
+			}
+		}
+
+		var tbs []byte
+		switch {
+		case spans >= 0:
+			tbs = encodeTBS(spans, map[int]int{0b010: 0, 0b001: 0})
+		case len(completes) == 0 && len(starts) == 1 && len(ends) == 0:
+			tbs = encodeTBS(starts[0], map[int]int{0b010: 0})
+		case len(completes) == 0 && len(ends) == 1 && len(starts) == 0:
+			tbs = encodeTBS(ends[0], map[int]int{0b010: 0})
+		case len(starts)+len(completes)+len(ends) > 0:
+			nodes := append(append(append([]int{}, starts...), completes...), ends...)
+			sort.Ints(nodes)
+			tbs = encodeTBS(nodes[0], map[int]int{0b010: 0, 0b100: len(nodes)})
+		}
+		res[i] = encodeTrailingData(tbs)
+	}
+	return res
+}
@@ -0,0 +1,112 @@
+package mobi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Info is metadata of Kindle book as found in its headers.
+type Info struct {
+	Title     string
+	Authors   []string
+	Publisher string
+	Language  string
+	Subjects  []string
+	Date      string
+	ASIN      string
+	CDEType   string
+	CDEKey    string
+	Version   int // mobi header version, 8 for KF8 only books
+	KF8Offset int // record number of KF8 header in combo books, -1 if absent
+	Encrypted bool
+	Cover     bool
+	Images    int
+}
+
+const exthFlags = mobiHeaderBase + 0x70
+
+var (
+	imageSignatures = [][]byte{{0xff, 0xd8, 0xff}, []byte("GIF8"), []byte("\x89PNG"), []byte("BM")}
+	boundaryRecord  = []byte("BOUNDARY")
+)
+
+// ReadInfo parses PDB and MOBI headers of the book.
+func ReadInfo(data []byte) (info *Info, err error) {
+
+	defer func() {
+		// malformed books lead to out of range access
+		if r := recover(); r != nil {
+			info, err = nil, fmt.Errorf("malformed mobi file: %v", r)
+		}
+	}()
+
+	if len(data) < firstPdbRecord || string(data[60:68]) != "BOOKMOBI" {
+		return nil, errors.New("not a mobi file")
+	}
+
+	rec0 := readSection(data, 0)
+	info = &Info{
+		Version:   getInt32(rec0, mobiVersion),
+		KF8Offset: -1,
+		Encrypted: getUInt16(rec0, cryptoType) != 0,
+	}
+
+	if ofs, l := getInt32(rec0, titleOffset), getInt32(rec0, titleOffset+4); ofs > 0 && ofs+l <= len(rec0) {
+		info.Title = string(rec0[ofs : ofs+l])
+	}
+	if getInt32(rec0, exthFlags)&0x40 == 0 {
+		return info, nil
+	}
+
+	first := func(id int) string {
+		if exth := readExth(rec0, id); len(exth) > 0 {
+			return strings.TrimSpace(string(exth[0]))
+		}
+		return ""
+	}
+	all := func(id int) (res []string) {
+		for _, v := range readExth(rec0, id) {
+			res = append(res, strings.TrimSpace(string(v)))
+		}
+		return
+	}
+
+	if t := first(exthTitle); len(t) > 0 {
+		info.Title = t
+	}
+	info.Authors = all(exthAuthor)
+	info.Publisher = first(exthPublisher)
+	info.Language = first(exthLanguage)
+	info.Subjects = all(exthSubject)
+	info.Date = first(exthPubDate)
+	info.Cover = len(readExth(rec0, exthCoverOffset)) > 0
+
+	var asin, cdetype, cdekey []byte
+	asin, cdetype, cdekey = readIDs(rec0, asin, cdetype, cdekey)
+	if kf8off := readExth(rec0, exthKF8Offset); len(kf8off) > 0 {
+		if kf8 := getInt32(kf8off[0], 0); kf8 > 0 && kf8 < getUInt16(data, numberOfPdbRecords) {
+			info.KF8Offset = kf8
+			// always prefer data from KF8
+			asin, cdetype, cdekey = readIDs(readSection(data, kf8), asin, cdetype, cdekey)
+		}
+	}
+	info.ASIN, info.CDEType, info.CDEKey = string(asin), string(cdetype), string(cdekey)
+
+	// images are kept together starting with first resource record
+	nsec := getUInt16(data, numberOfPdbRecords)
+	for i := getInt32(rec0, firstRescRecord); i > 0 && i < nsec; i++ {
+		sec := readSection(data, i)
+		if bytes.HasPrefix(sec, boundaryRecord) {
+			break
+		}
+		for _, sig := range imageSignatures {
+			if bytes.HasPrefix(sec, sig) {
+				info.Images++
+				break
+			}
+		}
+	}
+	return info, nil
+}
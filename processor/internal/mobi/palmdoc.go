@@ -0,0 +1,103 @@
+package mobi
+
+import (
+	"bytes"
+)
+
+// PalmDOC compression works on text records of limited size, mobi always uses 4096 bytes.
+const textRecordSize = 4096
+
+// palmdocCompress compresses single text record using PalmDOC LZ77 variant. It follows calibre's compress_doc()
+// to the letter: back references of 3 to 10 bytes within 2047 bytes window, "space + character" pairs and runs of
+// up to 8 binary bytes.
+func palmdocCompress(data []byte) []byte {
+
+	out := make([]byte, 0, len(data))
+
+	isLiteral := func(ch byte) bool {
+		return ch == 0 || (ch > 8 && ch < 0x80)
+	}
+
+	for i, n := 0, len(data); i < n; {
+		if i > 10 && n-i > 10 {
+			start := i - 2047
+			if start < 0 {
+				start = 0
+			}
+			matched := false
+			for j := 10; j > 2; j-- {
+				if pos := bytes.LastIndex(data[start:i], data[i:i+j]); pos >= 0 {
+					dist := i - start - pos
+					code := 0x8000 | ((dist << 3) & 0x3ff8) | (j - 3)
+					out = append(out, byte(code>>8), byte(code))
+					i += j
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+		}
+
+		ch := data[i]
+		i++
+		if ch == ' ' && i+1 < n {
+			if next := data[i]; next >= 0x40 && next < 0x80 {
+				out = append(out, next^0x80)
+				i++
+				continue
+			}
+		}
+		if isLiteral(ch) {
+			out = append(out, ch)
+			continue
+		}
+		j := i
+		seq := []byte{ch}
+		for j < n && len(seq) < 8 && !isLiteral(data[j]) {
+			seq = append(seq, data[j])
+			j++
+		}
+		out = append(out, byte(len(seq)))
+		out = append(out, seq...)
+		i += len(seq) - 1
+	}
+	return out
+}
+
+// palmdocDecompress reverses palmdocCompress.
+func palmdocDecompress(data []byte) []byte {
+
+	out := make([]byte, 0, textRecordSize)
+	for i := 0; i < len(data); {
+		ch := data[i]
+		i++
+		switch {
+		case ch >= 1 && ch <= 8:
+			if i+int(ch) > len(data) {
+				return out
+			}
+			out = append(out, data[i:i+int(ch)]...)
+			i += int(ch)
+		case ch < 0x80:
+			out = append(out, ch)
+		case ch >= 0xc0:
+			out = append(out, ' ', ch^0x80)
+		default:
+			if i >= len(data) {
+				return out
+			}
+			code := int(ch)<<8 | int(data[i])
+			i++
+			dist, length := (code>>3)&0x7ff, (code&7)+3
+			if dist == 0 || dist > len(out) {
+				return out
+			}
+			for k := 0; k < length; k++ {
+				out = append(out, out[len(out)-dist])
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package mobi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPalmdocRoundTrip(t *testing.T) {
+
+	cases := [][]byte{
+		[]byte(""),
+		[]byte("a"),
+		[]byte("<p>Hello, world!</p>"),
+		[]byte(strings.Repeat("<p>The quick brown fox jumps over the lazy dog.</p>", 100)[:textRecordSize]),
+		[]byte(strings.Repeat("<p>Съешь же ещё этих мягких французских булок, да выпей чаю.</p>", 40)[:textRecordSize-3]),
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0x80, 0x81, 0xff, 0xfe, 0xfd, 0xfc, 0xfb, 0xfa, 0xf9, 0xf8, 0xf7, ' ', 'A', ' ', 0x7f},
+	}
+
+	for i, in := range cases {
+		compressed := palmdocCompress(in)
+		if out := palmdocDecompress(compressed); !bytes.Equal(in, out) {
+			t.Fatalf("case #%d: round trip failed, got %q", i, out)
+		}
+		if len(in) > 1000 && len(compressed) >= len(in) {
+			t.Errorf("case #%d: text was not compressed: %d >= %d", i, len(compressed), len(in))
+		}
+	}
+}
+
+func TestEncint(t *testing.T) {
+
+	cases := []struct {
+		val      int
+		forward  bool
+		expected []byte
+	}{
+		{0, true, []byte{0x80}},
+		{0x7f, true, []byte{0xff}},
+		{0x80, true, []byte{0x01, 0x80}},
+		{0x80, false, []byte{0x81, 0x00}},
+		{5, false, []byte{0x85}},
+	}
+
+	for i, c := range cases {
+		if res := encint(c.val, c.forward); !bytes.Equal(res, c.expected) {
+			t.Errorf("case #%d: expected %x, got %x", i, c.expected, res)
+		}
+	}
+}
@@ -105,18 +105,7 @@ func (r *Reader) produceThumbnail(data []byte) {
 		return '_'
 	}, data[0:32])
 
-	exth := readExth(rec0, exthASIN)
-	if len(exth) > 0 {
-		r.asin = exth[0]
-	}
-	exth = readExth(rec0, exthCDEType)
-	if len(exth) > 0 {
-		r.cdetype = exth[0]
-	}
-	exth = readExth(rec0, exthCDEContentKey)
-	if len(exth) > 0 {
-		r.cdekey = exth[0]
-	}
+	r.asin, r.cdetype, r.cdekey = readIDs(rec0, r.asin, r.cdetype, r.cdekey)
 
 	firstimage := getInt32(rec0, firstRescRecord)
 	exthCover := readExth(rec0, exthCoverOffset)
@@ -180,17 +169,20 @@ func (r *Reader) produceThumbnail(data []byte) {
 
 	if combo {
 		// always prefer data from KF8
-		exth = readExth(kfrec0, exthASIN)
-		if len(exth) > 0 {
-			r.asin = exth[0]
-		}
-		exth = readExth(kfrec0, exthCDEType)
-		if len(exth) > 0 {
-			r.cdetype = exth[0]
-		}
-		exth = readExth(kfrec0, exthCDEContentKey)
-		if len(exth) > 0 {
-			r.cdekey = exth[0]
-		}
+		r.asin, r.cdetype, r.cdekey = readIDs(kfrec0, r.asin, r.cdetype, r.cdekey)
+	}
+}
+
+// readIDs returns ASIN, cdetype and cdekey from EXTH header, keeping previous values for absent records.
+func readIDs(rec0, asin, cdetype, cdekey []byte) ([]byte, []byte, []byte) {
+	if exth := readExth(rec0, exthASIN); len(exth) > 0 {
+		asin = exth[0]
+	}
+	if exth := readExth(rec0, exthCDEType); len(exth) > 0 {
+		cdetype = exth[0]
+	}
+	if exth := readExth(rec0, exthCDEContentKey); len(exth) > 0 {
+		cdekey = exth[0]
 	}
+	return asin, cdetype, cdekey
 }
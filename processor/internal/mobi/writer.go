@@ -0,0 +1,426 @@
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	// image decoders
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Legacy MOBI 7 (Mobipocket 6) book layout mostly follows calibre.ebooks.mobi.writer2, which is known to work on old
+// Kindles and third party readers.
+
+// additional exth records of interest
+const (
+	exthAuthor      = 100
+	exthPublisher   = 101
+	exthDescription = 103
+	exthISBN        = 104
+	exthSubject     = 105
+	exthPubDate     = 106
+	exthFakeCover   = 203
+	exthTitle       = 503
+	exthLanguage    = 524
+)
+
+const mobiHeaderSize = 0xe8
+
+var (
+	flisRecord = []byte{'F', 'L', 'I', 'S', 0, 0, 0, 8, 0, 0x41, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 1, 0, 3, 0, 0, 0, 3, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+	eofRecord  = []byte{0xe9, 0x8e, 0x0d, 0x0a}
+)
+
+// Meta is book metadata stored in EXTH header.
+type Meta struct {
+	Title       string
+	Language    string
+	Authors     []string
+	Publisher   string
+	Description string
+	ISBN        string
+	Subjects    []string
+	Date        string
+}
+
+// Writer produces legacy MOBI 7 file out of text in MOBI HTML dialect and images.
+type Writer struct {
+	log    *zap.Logger
+	meta   *Meta
+	asin   []byte
+	ebok   bool
+	text   []byte
+	start  int
+	images [][]byte
+	cover  int
+	toc    []TOCEntry
+}
+
+// NewWriter returns pointer to Writer, which will use provided metadata. When nonPersonal is requested book is marked
+// as "EBOK" and gets ASIN - either provided one or generated from book id.
+func NewWriter(meta *Meta, u uuid.UUID, asin string, nonPersonal bool, log *zap.Logger) *Writer {
+	w := &Writer{log: log, meta: meta, ebok: nonPersonal, start: -1, cover: -1}
+	if len(asin) == 0 {
+		w.asin = convertToRadix32(strings.Replace(u.String(), "-", "", -1), 10)
+	} else {
+		w.asin = []byte(asin)
+	}
+	return w
+}
+
+// AddImage stores image to be embedded into book and returns its "recindex". Images other than JPEG and GIF are
+// converted to JPEG since old devices do not support anything else.
+func (w *Writer) AddImage(data []byte) (int, error) {
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode image: %w", err)
+	}
+	if format != "jpeg" && format != "gif" {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return 0, fmt.Errorf("unable to decode image: %w", err)
+		}
+		flat := imaging.New(img.Bounds().Dx(), img.Bounds().Dy(), color.White)
+		flat = imaging.Overlay(flat, img, image.Point{}, 1.0)
+		var buf = new(bytes.Buffer)
+		if err := imaging.Encode(buf, flat, imaging.JPEG, imaging.JPEGQuality(75)); err != nil {
+			return 0, fmt.Errorf("unable to encode image: %w", err)
+		}
+		buf, _ = SetJpegDPI(buf, DpiPxPerInch, 300, 300)
+		data = buf.Bytes()
+	}
+	w.images = append(w.images, data)
+	return len(w.images), nil
+}
+
+// SetCover marks previously added image as book cover.
+func (w *Writer) SetCover(recindex int) {
+	w.cover = recindex - 1
+}
+
+// SetText sets book text in MOBI HTML dialect. All filepos references are expected to be already resolved.
+// Start is offset of the "start reading" location, negative if not known.
+func (w *Writer) SetText(text []byte, start int) {
+	w.text, w.start = text, start
+}
+
+// SetTOC sets Kindle table of contents, which will be stored as flat NCX index.
+func (w *Writer) SetTOC(toc []TOCEntry) {
+	w.toc = toc
+}
+
+// SaveResult assembles book and saves it to the requested location.
+func (w *Writer) SaveResult(fname string) error {
+	data, err := w.build()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, data, 0644)
+}
+
+// textRecords splits text into PalmDOC compressed records. Multibyte characters crossing record boundary are
+// recorded in trailing entries.
+func (w *Writer) textRecords(tbs [][]byte) [][]byte {
+
+	var records [][]byte
+	for i, n := 0, 0; i < len(w.text); i, n = i+textRecordSize, n+1 {
+		end := i + textRecordSize
+		if end > len(w.text) {
+			end = len(w.text)
+		}
+		chunk := w.text[i:end]
+
+		// find bytes of the last character continuing into the next record
+		var overlap []byte
+		for k := len(chunk) - 1; k >= 0 && k >= len(chunk)-4; k-- {
+			if utf8.RuneStart(chunk[k]) {
+				if r, size := utf8.DecodeRune(w.text[i+k:]); r != utf8.RuneError && k+size > len(chunk) {
+					overlap = w.text[end : i+k+size]
+				}
+				break
+			}
+		}
+
+		rec := palmdocCompress(chunk)
+		rec = append(rec, overlap...)
+		rec = append(rec, byte(len(overlap)))
+		if tbs != nil {
+			rec = append(rec, tbs[n]...)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func (w *Writer) exth() []byte {
+
+	var (
+		recs bytes.Buffer
+		num  int
+	)
+	add := func(id int, data []byte) {
+		binary.Write(&recs, binary.BigEndian, uint32(id))
+		binary.Write(&recs, binary.BigEndian, uint32(len(data)+8))
+		recs.Write(data)
+		num++
+	}
+	addString := func(id int, s string) {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			add(id, []byte(s))
+		}
+	}
+	addInt := func(id, val int) {
+		add(id, putInt32(nil, 0, val))
+	}
+
+	for _, a := range w.meta.Authors {
+		addString(exthAuthor, a)
+	}
+	addString(exthPublisher, w.meta.Publisher)
+	addString(exthDescription, w.meta.Description)
+	addString(exthISBN, w.meta.ISBN)
+	for _, s := range w.meta.Subjects {
+		addString(exthSubject, s)
+	}
+	addString(exthPubDate, w.meta.Date)
+	if w.ebok {
+		add(exthASIN, w.asin)
+		add(exthCDEType, []byte("EBOK"))
+		add(exthCDEContentKey, w.asin)
+	}
+	addString(exthTitle, w.meta.Title)
+	addString(exthLanguage, w.meta.Language)
+	if w.start >= 0 {
+		addInt(exthStartReading, w.start)
+	}
+	if w.cover >= 0 {
+		addInt(exthCoverOffset, w.cover)
+		addInt(exthThumbOffset, len(w.images)-1)
+		addInt(exthFakeCover, 0)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("EXTH")
+	binary.Write(&b, binary.BigEndian, uint32(recs.Len()+12))
+	binary.Write(&b, binary.BigEndian, uint32(num))
+	b.Write(recs.Bytes())
+	return alignBlock(b.Bytes())
+}
+
+// thumbnail adds to the book properly sized cover thumbnail, it always goes last.
+func (w *Writer) thumbnail() {
+
+	if w.cover < 0 || w.cover >= len(w.images) {
+		w.cover = -1
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(w.images[w.cover]))
+	if err != nil {
+		w.log.Warn("Unable to decode cover image, thumbnail will not be created", zap.Error(err))
+		w.cover = -1
+		return
+	}
+	var buf = new(bytes.Buffer)
+	if err := imaging.Encode(buf, imaging.Thumbnail(img, 330, 470, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(75)); err != nil {
+		w.log.Warn("Unable to encode cover thumbnail", zap.Error(err))
+		w.cover = -1
+		return
+	}
+	buf, _ = SetJpegDPI(buf, DpiPxPerInch, 300, 300)
+	w.images = append(w.images, buf.Bytes())
+}
+
+func (w *Writer) build() ([]byte, error) {
+
+	if len(w.text) == 0 {
+		return nil, errors.New("nothing to save")
+	}
+	w.thumbnail()
+
+	ntext := (len(w.text) + textRecordSize - 1) / textRecordSize
+
+	entries, cncx := buildIndexEntries(w.toc, len(w.text))
+	var tbs [][]byte
+	if len(entries) > 0 {
+		tbs = trailingIndexBytes(entries, ntext)
+	}
+
+	// record 0 is added at the very end when all numbers are known
+	records := [][]byte{nil}
+	size := 0
+	for _, r := range w.textRecords(tbs) {
+		records = append(records, r)
+		size += len(r)
+	}
+	if size%4 != 0 {
+		records = append(records, make([]byte, 4-size%4))
+	}
+	firstNonText := len(records)
+
+	primary := -1
+	if len(entries) > 0 {
+		indx, err := buildIndexRecords(entries, len(cncx))
+		if err != nil {
+			return nil, err
+		}
+		primary = len(records)
+		records = append(records, indx...)
+		records = append(records, cncx...)
+	}
+
+	firstImage := len(records)
+	records = append(records, w.images...)
+	lastContent := len(records) - 1
+
+	flis := len(records)
+	records = append(records, flisRecord)
+	fcis := len(records)
+	var fc bytes.Buffer
+	fc.Write([]byte{'F', 'C', 'I', 'S', 0, 0, 0, 0x14, 0, 0, 0, 0x10, 0, 0, 0, 1, 0, 0, 0, 0})
+	binary.Write(&fc, binary.BigEndian, uint32(len(w.text)))
+	fc.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0x20, 0, 0, 0, 8, 0, 1, 0, 1, 0, 0, 0, 0})
+	records = append(records, fc.Bytes())
+	records = append(records, eofRecord)
+
+	exth := w.exth()
+	title := []byte(w.meta.Title)
+
+	var rec0 bytes.Buffer
+	// PalmDOC header
+	binary.Write(&rec0, binary.BigEndian, uint16(2)) // PalmDOC compression
+	binary.Write(&rec0, binary.BigEndian, uint16(0))
+	binary.Write(&rec0, binary.BigEndian, uint32(len(w.text)))
+	binary.Write(&rec0, binary.BigEndian, uint16(ntext))
+	binary.Write(&rec0, binary.BigEndian, uint16(textRecordSize))
+	binary.Write(&rec0, binary.BigEndian, uint32(0)) // no encryption
+
+	// MOBI header
+	rec0.WriteString("MOBI")
+	binary.Write(&rec0, binary.BigEndian, uint32(mobiHeaderSize))
+	binary.Write(&rec0, binary.BigEndian, uint32(2)) // book
+	binary.Write(&rec0, binary.BigEndian, uint32(65001))
+	binary.Write(&rec0, binary.BigEndian, uint32(time.Now().UnixNano()))
+	binary.Write(&rec0, binary.BigEndian, uint32(6)) // generator version
+	rec0.Write(bytes.Repeat([]byte{0xff}, 8))
+	rec0.Write(bytes.Repeat([]byte{0xff}, 4)) // no secondary index
+	rec0.Write(bytes.Repeat([]byte{0xff}, 28))
+	binary.Write(&rec0, binary.BigEndian, uint32(firstNonText))
+	binary.Write(&rec0, binary.BigEndian, uint32(16+mobiHeaderSize+len(exth)))
+	binary.Write(&rec0, binary.BigEndian, uint32(len(title)))
+	binary.Write(&rec0, binary.BigEndian, uint32(languageCode(w.meta.Language)))
+	rec0.Write(make([]byte, 8))
+	binary.Write(&rec0, binary.BigEndian, uint32(6)) // format version
+	binary.Write(&rec0, binary.BigEndian, uint32(firstImage))
+	rec0.Write(make([]byte, 16)) // no HUFF/CDIC or DATP
+	binary.Write(&rec0, binary.BigEndian, uint32(0x50))
+	rec0.Write(make([]byte, 32))
+	binary.Write(&rec0, binary.BigEndian, uint32(0xffffffff)) // no DRM
+	binary.Write(&rec0, binary.BigEndian, uint32(0xffffffff))
+	rec0.Write(make([]byte, 8))
+	rec0.Write(make([]byte, 12))
+	binary.Write(&rec0, binary.BigEndian, uint16(1))
+	binary.Write(&rec0, binary.BigEndian, uint16(lastContent))
+	binary.Write(&rec0, binary.BigEndian, uint32(1))
+	binary.Write(&rec0, binary.BigEndian, uint32(fcis))
+	binary.Write(&rec0, binary.BigEndian, uint32(1))
+	binary.Write(&rec0, binary.BigEndian, uint32(flis))
+	binary.Write(&rec0, binary.BigEndian, uint32(1))
+	rec0.Write(make([]byte, 8))
+	binary.Write(&rec0, binary.BigEndian, uint32(0xffffffff)) // no SRCS
+	binary.Write(&rec0, binary.BigEndian, uint32(0))
+	binary.Write(&rec0, binary.BigEndian, uint32(0xffffffff))
+	binary.Write(&rec0, binary.BigEndian, uint32(0xffffffff))
+	extra := uint32(1) // multibyte overlap
+	if tbs != nil {
+		extra |= 2
+	}
+	binary.Write(&rec0, binary.BigEndian, extra)
+	binary.Write(&rec0, binary.BigEndian, uint32(primary))
+	rec0.Write(exth)
+	rec0.Write(title)
+	// leave some room the way kindlegen does, so others could add to EXTH if necessary
+	rec0.Write(make([]byte, 8192))
+	records[0] = alignBlock(rec0.Bytes())
+
+	return pdb(w.meta.Title, records), nil
+}
+
+// pdbName makes database name from the book title: printable ASCII only, no spaces, at most 31 bytes.
+func pdbName(title string) []byte {
+	name := []byte(strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		if r == ' ' {
+			return '_'
+		}
+		return r
+	}, title))
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	return name
+}
+
+// pdb assembles Palm database out of records.
+func pdb(title string, records [][]byte) []byte {
+
+	name := pdbName(title)
+
+	var b bytes.Buffer
+	b.Write(name)
+	b.Write(make([]byte, 32-len(name)))
+	now := uint32(time.Now().Unix())
+	binary.Write(&b, binary.BigEndian, uint16(0)) // attributes
+	binary.Write(&b, binary.BigEndian, uint16(0)) // version
+	binary.Write(&b, binary.BigEndian, now)
+	binary.Write(&b, binary.BigEndian, now)
+	b.Write(make([]byte, 16)) // backup date, modification number, app info, sort info
+	b.WriteString("BOOKMOBI")
+	binary.Write(&b, binary.BigEndian, uint32(2*len(records)-1))
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint16(len(records)))
+
+	offset := firstPdbRecord + 8*len(records) + 2
+	for i, r := range records {
+		binary.Write(&b, binary.BigEndian, uint32(offset))
+		binary.Write(&b, binary.BigEndian, uint32(2*i))
+		offset += len(r)
+	}
+	b.Write([]byte{0, 0})
+	for _, r := range records {
+		b.Write(r)
+	}
+	return b.Bytes()
+}
+
+// languageCode converts language tag to Mobipocket locale, sublanguage is not used.
+func languageCode(lang string) int {
+	codes := map[string]int{
+		"ar": 1, "bg": 2, "ca": 3, "zh": 4, "cs": 5, "da": 6, "de": 7, "el": 8, "en": 9, "es": 10, "fi": 11, "fr": 12,
+		"he": 13, "hu": 14, "is": 15, "it": 16, "ja": 17, "ko": 18, "nl": 19, "nb": 20, "no": 20, "pl": 21, "pt": 22,
+		"ro": 24, "ru": 25, "hr": 26, "sk": 27, "sq": 28, "sv": 29, "th": 30, "tr": 31, "ur": 32, "id": 33, "uk": 34,
+		"be": 35, "sl": 36, "et": 37, "lv": 38, "lt": 39, "fa": 41, "vi": 42, "hy": 43, "az": 44, "eu": 45, "mk": 47,
+		"af": 54, "ka": 55, "hi": 57, "kk": 63,
+	}
+	base := strings.ToLower(lang)
+	if i := strings.IndexAny(base, "-_"); i >= 0 {
+		base = base[:i]
+	}
+	return codes[base]
+}
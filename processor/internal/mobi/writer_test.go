@@ -0,0 +1,217 @@
+package mobi
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// extraDataFlags is rec0 offset of flags describing trailing entries of text records.
+const extraDataFlags = 240
+
+// decint decodes forward encoded variable width integer, returns value and number of bytes consumed.
+func decint(data []byte) (int, int) {
+	var val int
+	for i, b := range data {
+		val = val<<7 | int(b&0x7f)
+		if b&0x80 != 0 {
+			return val, i + 1
+		}
+	}
+	return val, len(data)
+}
+
+// stripTrailingEntries removes TBS and multibyte overlap from the end of text record.
+func stripTrailingEntries(t *testing.T, rec []byte, flags int) []byte {
+	t.Helper()
+	for bit := 1; bit < 16; bit++ {
+		if flags&(1<<bit) == 0 {
+			continue
+		}
+		// size is encoded backward and includes itself
+		var size, shift int
+		for i := len(rec) - 1; i >= 0; i-- {
+			size |= int(rec[i]&0x7f) << shift
+			shift += 7
+			if rec[i]&0x80 != 0 {
+				break
+			}
+		}
+		if size == 0 || size > len(rec) {
+			t.Fatalf("bad trailing entry size %d", size)
+		}
+		rec = rec[:len(rec)-size]
+	}
+	if flags&1 != 0 {
+		rec = rec[:len(rec)-int(rec[len(rec)-1]&3)-1]
+	}
+	return rec
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+
+	var cover, pic bytes.Buffer
+	if err := jpeg.Encode(&cover, image.NewGray(image.Rect(0, 0, 120, 160)), nil); err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewNRGBA(image.Rect(0, 0, 50, 40))
+	rgba.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	if err := png.Encode(&pic, rgba); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWriter(&Meta{Title: "Книга", Authors: []string{"Автор"}, Language: "ru"}, uuid.New(), "", true, zap.NewNop())
+	coverIdx, err := w.AddImage(cover.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	picIdx, err := w.AddImage(pic.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coverIdx != 1 || picIdx != 2 {
+		t.Fatalf("unexpected recindexes %d, %d", coverIdx, picIdx)
+	}
+	w.SetCover(coverIdx)
+
+	// multibyte character crosses the first record boundary
+	head := "<html><head><guide></guide></head><body><p>"
+	var sb strings.Builder
+	sb.WriteString(head + strings.Repeat("a", textRecordSize-1-len(head)) + "Жук</p>")
+	chapters := []string{"Глава первая", "Глава вторая"}
+	for i, ch := range chapters {
+		sb.WriteString("<mbp:pagebreak/><h2 align=\"center\">" + ch + "</h2>")
+		sb.WriteString(fmt.Sprintf(`<p align="center"><img recindex="%05d" /></p>`, picIdx))
+		for j := 0; j < 150*(i+1); j++ {
+			sb.WriteString("<p>Съешь же ещё этих мягких французских булок, да выпей чаю.</p>")
+		}
+	}
+	sb.WriteString("</body></html>")
+	text := []byte(sb.String())
+	if text[textRecordSize-1] != "Ж"[0] {
+		t.Fatal("test text does not split multibyte character")
+	}
+
+	var toc []TOCEntry
+	for _, ch := range chapters {
+		toc = append(toc, TOCEntry{Title: ch, Pos: bytes.Index(text, []byte("<h2 align=\"center\">"+ch))})
+	}
+	// entries pointing to the same location or outside of text are dropped
+	toc = append(toc, TOCEntry{Title: "duplicate", Pos: toc[1].Pos}, TOCEntry{Title: "outside", Pos: len(text)})
+	w.SetText(text, toc[0].Pos)
+	w.SetTOC(toc)
+
+	fname := filepath.Join(t.TempDir(), "book.mobi")
+	if err := w.SaveResult(fname); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec0 := readSection(data, 0)
+
+	// text
+	ntext := getUInt16(rec0, bookRecordCount)
+	if getInt32(rec0, lengthOfBook) != len(text) || ntext != (len(text)+textRecordSize-1)/textRecordSize || ntext < 3 {
+		t.Fatalf("unexpected text length %d in %d records", getInt32(rec0, lengthOfBook), ntext)
+	}
+	flags := getInt32(rec0, extraDataFlags)
+	if flags != 3 {
+		t.Errorf("unexpected extra data flags %b", flags)
+	}
+	var res []byte
+	for i := 1; i <= ntext; i++ {
+		res = append(res, palmdocDecompress(stripTrailingEntries(t, readSection(data, i), flags))...)
+	}
+	if !bytes.Equal(res, text) {
+		t.Fatalf("decompressed text differs from original: %d bytes instead of %d", len(res), len(text))
+	}
+	// first record keeps the rest of the split character followed by its size
+	if rec := stripTrailingEntries(t, readSection(data, 1), 2); rec[len(rec)-1] != 1 || rec[len(rec)-2] != "Ж"[1] {
+		t.Errorf("unexpected multibyte overlap in the first record: % x", rec[len(rec)-2:])
+	}
+
+	// NCX index
+	primary := getInt32(rec0, primaryIndex)
+	// index follows text records and optional padding record
+	if nt := getInt32(rec0, firstNonText); primary < nt || primary > nt+1 {
+		t.Fatalf("unexpected primary index record %d", primary)
+	}
+	hdr, indx, cncx := readSection(data, primary), readSection(data, primary+1), readSection(data, primary+2)
+	if string(hdr[:4]) != "INDX" || string(indx[:4]) != "INDX" || getInt32(hdr, 36) != len(chapters) || getInt32(hdr, 52) != 1 {
+		t.Fatalf("bad INDX header record")
+	}
+	count, idxt := getInt32(indx, 24), getInt32(indx, 20)
+	if count != len(chapters) || string(indx[idxt:idxt+4]) != "IDXT" {
+		t.Fatalf("bad INDX record: %d entries", count)
+	}
+	for i := 0; i < count; i++ {
+		ofs := getUInt16(indx, idxt+4+2*i)
+		ident := string(indx[ofs+1 : ofs+1+int(indx[ofs])])
+		ofs += 1 + len(ident)
+		if indx[ofs] != 0x0f {
+			t.Fatalf("entry %d: unexpected control byte %x", i, indx[ofs])
+		}
+		ofs++
+		var values []int
+		for k := 0; k < 4; k++ {
+			v, n := decint(indx[ofs:])
+			values = append(values, v)
+			ofs += n
+		}
+		length := len(text) - toc[i].Pos
+		if i+1 < count {
+			length = toc[i+1].Pos - toc[i].Pos
+		}
+		size, n := decint(cncx[values[2]:])
+		label := string(cncx[values[2]+n : values[2]+n+size])
+		if ident != fmt.Sprintf("%02X", i) || values[0] != toc[i].Pos || values[1] != length || label != chapters[i] || values[3] != 0 {
+			t.Errorf("entry %d: unexpected %s %v %q", i, ident, values, label)
+		}
+	}
+
+	// images are addressed by recindex relative to the first image record, thumbnail goes last
+	first := getInt32(rec0, firstRescRecord)
+	if first != primary+3 {
+		t.Errorf("first image record %d does not follow index", first)
+	}
+	if !bytes.Equal(readSection(data, first+coverIdx-1), cover.Bytes()) {
+		t.Error("cover image was changed")
+	}
+	if img, format, err := image.Decode(bytes.NewReader(readSection(data, first+picIdx-1))); err != nil || format != "jpeg" || img.Bounds().Dx() != 50 {
+		t.Errorf("PNG image was not converted to JPEG: %s, %v", format, err)
+	}
+	if v := readExth(rec0, exthCoverOffset); len(v) != 1 || getInt32(v[0], 0) != coverIdx-1 {
+		t.Errorf("unexpected cover offset %v", v)
+	}
+	thumb := readExth(rec0, exthThumbOffset)
+	if len(thumb) != 1 || getInt32(thumb[0], 0) != 2 {
+		t.Fatalf("unexpected thumbnail offset %v", thumb)
+	}
+	if img, _, err := image.Decode(bytes.NewReader(readSection(data, first+2))); err != nil || img.Bounds().Dx() != 330 || img.Bounds().Dy() != 470 {
+		t.Errorf("bad thumbnail record: %v", err)
+	}
+	if v := readExth(rec0, exthStartReading); len(v) != 1 || getInt32(v[0], 0) != toc[0].Pos {
+		t.Errorf("unexpected start reading location %v", v)
+	}
+
+	// device side view of the book
+	r, err := NewReader(fname, 330, 470, false, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.cdetype) != "EBOK" || len(r.asin) == 0 || len(r.thumbnail) == 0 {
+		t.Errorf("reader did not find book identity or cover: %q %q %d", r.asin, r.cdetype, len(r.thumbnail))
+	}
+}
@@ -0,0 +1,339 @@
+// Package pdf is minimal PDF 1.7 writer sufficient for laying out books: pages with text in embedded TrueType fonts,
+// images and bookmarks outline. It does not attempt to be generic.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Document accumulates pages and resources and serializes everything on WriteTo.
+type Document struct {
+	width, height float64
+	fonts         []*Font
+	images        []*Image
+	pages         []*Page
+	outline       []*Outline
+	info          map[string]string
+	lang          string
+}
+
+// New creates empty document with specified page size in points.
+func New(width, height float64) *Document {
+	return &Document{width: width, height: height, info: make(map[string]string)}
+}
+
+// SetInfo sets document information dictionary entry (Title, Author, Subject, Keywords, Creator, Producer).
+func (d *Document) SetInfo(key, value string) {
+	d.info[key] = value
+}
+
+// SetLang sets natural language of the document.
+func (d *Document) SetLang(lang string) {
+	d.lang = lang
+}
+
+// Size returns page size in points.
+func (d *Document) Size() (float64, float64) {
+	return d.width, d.height
+}
+
+// AddPage appends new empty page.
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d, fonts: make(map[*Font]bool), images: make(map[*Image]bool)}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Pages returns number of pages in the document.
+func (d *Document) Pages() int {
+	return len(d.pages)
+}
+
+// Outline is single bookmark.
+type Outline struct {
+	title    string
+	page     *Page
+	top      float64
+	children []*Outline
+}
+
+// AddOutline adds bookmark pointing to the vertical position on the page. When parent is nil bookmark is added on top level.
+func (d *Document) AddOutline(parent *Outline, title string, page *Page, top float64) *Outline {
+	o := &Outline{title: title, page: page, top: top}
+	if parent == nil {
+		d.outline = append(d.outline, o)
+	} else {
+		parent.children = append(parent.children, o)
+	}
+	return o
+}
+
+// Page is single page content stream with resources it references.
+type Page struct {
+	doc     *Document
+	content bytes.Buffer
+	fonts   map[*Font]bool
+	images  map[*Image]bool
+	id      int
+}
+
+// Run is piece of text drawn with single font.
+type Run struct {
+	Font       *Font
+	Size       float64
+	Rise       float64 // baseline shift, positive is up
+	FakeBold   bool    // stroke glyphs outlines when real bold face is not available
+	FakeItalic bool    // skew glyphs when real italic face is not available
+	Text       string
+}
+
+// Width returns run width in points with extra space added after every space character.
+func (r *Run) Width(extraSpace float64) float64 {
+	return r.Font.Width(r.Text, r.Size) + float64(strings.Count(r.Text, " "))*extraSpace
+}
+
+// Text draws run with baseline starting at x, y. Every space character is widened by extraSpace points to allow for
+// justification.
+func (p *Page) Text(r *Run, x, y float64, extraSpace float64) {
+
+	if len(r.Text) == 0 {
+		return
+	}
+	p.fonts[r.Font] = true
+
+	// text rendering mode, line width and rise are part of graphics state and survive ET
+	c := &p.content
+	c.WriteString("q BT\n")
+	fmt.Fprintf(c, "/F%d %s Tf\n", r.Font.id, num(r.Size))
+	if r.FakeBold {
+		fmt.Fprintf(c, "2 Tr %s w\n", num(r.Size/30))
+	}
+	if r.Rise != 0 {
+		fmt.Fprintf(c, "%s Ts\n", num(r.Rise))
+	}
+	skew := 0.0
+	if r.FakeItalic {
+		skew = 0.2
+	}
+	fmt.Fprintf(c, "1 0 %s 1 %s %s Tm\n", num(skew), num(x), num(y))
+
+	adjust := -extraSpace * 1000 / r.Size
+	c.WriteString("[<")
+	for _, ch := range r.Text {
+		fmt.Fprintf(c, "%04X", r.Font.glyph(ch))
+		if ch == ' ' && adjust != 0 {
+			fmt.Fprintf(c, "> %s <", num(adjust))
+		}
+	}
+	c.WriteString(">] TJ\nET Q\n")
+}
+
+// Image draws image scaled to the box with lower left corner at x, y.
+func (p *Page) Image(im *Image, x, y, w, h float64) {
+	p.images[im] = true
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /I%d Do Q\n", num(w), num(h), num(x), num(y), im.id)
+}
+
+// Line draws straight line.
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "q %s w %s %s m %s %s l S Q\n", num(width), num(x1), num(y1), num(x2), num(y2))
+}
+
+// WriteToFile saves document to file.
+func (d *Document) WriteToFile(fname string) error {
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := d.WriteTo(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type writer struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64
+}
+
+func (w *writer) printf(format string, args ...interface{}) {
+	n, _ := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+}
+
+func (w *writer) write(b []byte) {
+	n, _ := w.w.Write(b)
+	w.n += int64(n)
+}
+
+// reserve allocates object number to be written later.
+func (w *writer) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *writer) object(id int, dict string) {
+	w.offsets[id-1] = w.n
+	w.printf("%d 0 obj\n%s\nendobj\n", id, dict)
+}
+
+// stream writes compressed stream object, additional dictionary entries could be supplied.
+func (w *writer) stream(id int, data []byte, compress bool, extra string) {
+	filter := ""
+	if compress {
+		var buf bytes.Buffer
+		zw, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		_, _ = zw.Write(data)
+		_ = zw.Close()
+		data = buf.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+	w.offsets[id-1] = w.n
+	w.printf("%d 0 obj\n<< /Length %d%s%s >>\nstream\n", id, len(data), filter, extra)
+	w.write(data)
+	w.printf("\nendstream\nendobj\n")
+}
+
+// WriteTo serializes document.
+func (d *Document) WriteTo(out io.Writer) (int64, error) {
+
+	w := &writer{w: bufio.NewWriter(out)}
+	w.printf("%%PDF-1.7\n%%\xE2\xE3\xCF\xD3\n")
+
+	catalog := w.reserve()
+	pagesRoot := w.reserve()
+
+	for _, f := range d.fonts {
+		if len(f.used) > 0 {
+			f.write(w)
+		}
+	}
+	for _, im := range d.images {
+		im.write(w)
+	}
+	for _, p := range d.pages {
+		p.id = w.reserve()
+	}
+
+	kids := make([]string, 0, len(d.pages))
+	for _, p := range d.pages {
+		var res strings.Builder
+		res.WriteString("<< /ProcSet [/PDF /Text /ImageB /ImageC]")
+		if len(p.fonts) > 0 {
+			res.WriteString(" /Font <<")
+			for _, f := range d.fonts {
+				if p.fonts[f] {
+					fmt.Fprintf(&res, " /F%d %d 0 R", f.id, f.obj)
+				}
+			}
+			res.WriteString(" >>")
+		}
+		if len(p.images) > 0 {
+			res.WriteString(" /XObject <<")
+			for _, im := range d.images {
+				if p.images[im] {
+					fmt.Fprintf(&res, " /I%d %d 0 R", im.id, im.obj)
+				}
+			}
+			res.WriteString(" >>")
+		}
+		res.WriteString(" >>")
+
+		content := w.reserve()
+		w.stream(content, p.content.Bytes(), true, "")
+		w.object(p.id, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /Resources %s /Contents %d 0 R >>", pagesRoot, res.String(), content))
+		kids = append(kids, fmt.Sprintf("%d 0 R", p.id))
+	}
+	w.object(pagesRoot, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %s %s] >>",
+		strings.Join(kids, " "), len(d.pages), num(d.width), num(d.height)))
+
+	var extra string
+	if len(d.outline) > 0 {
+		outlines := w.reserve()
+		first, last, count := d.writeOutline(w, outlines, d.outline)
+		w.object(outlines, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", first, last, count))
+		extra += fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", outlines)
+	}
+	if len(d.lang) > 0 {
+		extra += " /Lang " + text(d.lang)
+	}
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R%s >>", pagesRoot, extra))
+
+	info := w.reserve()
+	var dict strings.Builder
+	dict.WriteString("<<")
+	for _, k := range []string{"Title", "Author", "Subject", "Keywords", "Creator", "Producer"} {
+		if v, ok := d.info[k]; ok && len(v) > 0 {
+			fmt.Fprintf(&dict, " /%s %s", k, text(v))
+		}
+	}
+	fmt.Fprintf(&dict, " /CreationDate (D:%s) >>", time.Now().UTC().Format("20060102150405Z"))
+	w.object(info, dict.String())
+
+	xref := w.n
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, off := range w.offsets {
+		w.printf("%010d 00000 n \n", off)
+	}
+	w.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, catalog, info, xref)
+
+	return w.n, w.w.Flush()
+}
+
+// writeOutline writes level of outline tree returning first and last item objects and number of visible descendants.
+func (d *Document) writeOutline(w *writer, parent int, items []*Outline) (int, int, int) {
+
+	ids := make([]int, len(items))
+	for i := range items {
+		ids[i] = w.reserve()
+	}
+	count := len(items)
+	for i, o := range items {
+		var b strings.Builder
+		fmt.Fprintf(&b, "<< /Title %s /Parent %d 0 R /Dest [%d 0 R /XYZ null %s null]", text(o.title), parent, o.page.id, num(o.top))
+		if i > 0 {
+			fmt.Fprintf(&b, " /Prev %d 0 R", ids[i-1])
+		}
+		if i < len(items)-1 {
+			fmt.Fprintf(&b, " /Next %d 0 R", ids[i+1])
+		}
+		if len(o.children) > 0 {
+			first, last, n := d.writeOutline(w, ids[i], o.children)
+			fmt.Fprintf(&b, " /First %d 0 R /Last %d 0 R /Count %d", first, last, n)
+			count += n
+		}
+		b.WriteString(" >>")
+		w.object(ids[i], b.String())
+	}
+	return ids[0], ids[len(ids)-1], count
+}
+
+// num formats number compactly.
+func num(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1000)/1000, 'f', -1, 64)
+}
+
+// text encodes string as UTF-16BE hex string with BOM, which is always safe.
+func text(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"fb2converter/static"
+)
+
+// parsed is serialized document split into objects using its cross-reference table.
+type parsed struct {
+	data    []byte
+	offsets []int // object offsets, index is object number
+	size    int
+	root    int
+}
+
+func parse(t *testing.T, data []byte) *parsed {
+	t.Helper()
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("no startxref at the end of document")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if xref >= len(data) || !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point to xref table", xref)
+	}
+	lines := strings.Split(string(data[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("bad xref subsection header %q", lines[1])
+	}
+	p := &parsed{data: data, offsets: make([]int, count)}
+	for i := 0; i < count; i++ {
+		// every entry is exactly 20 bytes including end of line
+		entry := lines[2+i] + "\n"
+		if len(entry) != 20 {
+			t.Fatalf("xref entry %d has wrong length: %q", i, entry)
+		}
+		p.offsets[i], _ = strconv.Atoi(entry[:10])
+	}
+	trailer := strings.Join(lines[2+count:], "\n")
+	m = regexp.MustCompile(`/Size (\d+) /Root (\d+) 0 R`).FindSubmatch([]byte(trailer))
+	if m == nil {
+		t.Fatalf("bad trailer %q", trailer)
+	}
+	p.size, _ = strconv.Atoi(string(m[1]))
+	p.root, _ = strconv.Atoi(string(m[2]))
+	return p
+}
+
+// object returns object content between "obj" and "endobj".
+func (p *parsed) object(t *testing.T, id int) string {
+	t.Helper()
+	if id <= 0 || id >= len(p.offsets) {
+		t.Fatalf("object %d is not in xref", id)
+	}
+	rest := p.data[p.offsets[id]:]
+	header := fmt.Sprintf("%d 0 obj\n", id)
+	if !bytes.HasPrefix(rest, []byte(header)) {
+		if len(rest) > 20 {
+			rest = rest[:20]
+		}
+		t.Fatalf("xref offset of object %d points to %q", id, rest)
+	}
+	end := bytes.Index(rest, []byte("endobj\n"))
+	return string(rest[len(header):end])
+}
+
+// stream returns decoded stream data of the object.
+func (p *parsed) stream(t *testing.T, id int) []byte {
+	t.Helper()
+	obj := p.object(t, id)
+	m := regexp.MustCompile(`/Length (\d+)`).FindStringSubmatch(obj)
+	start := strings.Index(obj, ">>\nstream\n")
+	if m == nil || start < 0 {
+		t.Fatalf("object %d is not a stream", id)
+	}
+	n, _ := strconv.Atoi(m[1])
+	data := []byte(obj[start+len(">>\nstream\n") : start+len(">>\nstream\n")+n])
+	if !strings.HasPrefix(obj[start+len(">>\nstream\n")+n:], "\nendstream\n") {
+		t.Fatalf("stream %d length is wrong", id)
+	}
+	if !strings.Contains(obj[:start], "/FlateDecode") {
+		return data
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func ref(t *testing.T, obj, key string) int {
+	t.Helper()
+	m := regexp.MustCompile(key + `\s*(\d+) 0 R`).FindStringSubmatch(obj)
+	if m == nil {
+		t.Fatalf("no %s reference in %q", key, obj)
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+func TestDocument(t *testing.T) {
+
+	font, err := static.Asset("resources/LinLibertine_RBah.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cover bytes.Buffer
+	if err := jpeg.Encode(&cover, image.NewGray(image.Rect(0, 0, 60, 80)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(300, 400)
+	d.SetInfo("Title", "Книга")
+	d.SetLang("ru")
+	f, err := d.AddFont(font)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im, err := d.AddImage(cover.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddPage().Image(im, 0, 0, 300, 400)
+	var chapter *Outline
+	for i := 1; i <= 2; i++ {
+		p := d.AddPage()
+		p.Text(&Run{Font: f, Size: 12, Text: fmt.Sprintf("Глава %d", i)}, 10, 380, 0)
+		p.Text(&Run{Font: f, Size: 10, Text: "Hello world"}, 10, 360, 2)
+		p.Line(10, 350, 290, 350, 0.5)
+		if i == 1 {
+			chapter = d.AddOutline(nil, "Глава 1", p, 400)
+		} else {
+			d.AddOutline(chapter, "Глава 2", p, 400)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := d.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("reported size %d, written %d", n, buf.Len())
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-1.7\n")) {
+		t.Error("no PDF header")
+	}
+
+	p := parse(t, buf.Bytes())
+	if p.size != len(p.offsets) {
+		t.Errorf("trailer size %d, xref has %d entries", p.size, len(p.offsets))
+	}
+	// every object is where cross-reference table says it is
+	for id := 1; id < len(p.offsets); id++ {
+		p.object(t, id)
+	}
+
+	catalog := p.object(t, p.root)
+	if !strings.Contains(catalog, "/Type /Catalog") || !strings.Contains(catalog, "/PageMode /UseOutlines") {
+		t.Errorf("unexpected catalog %q", catalog)
+	}
+	pages := p.object(t, ref(t, catalog, "/Pages"))
+	m := regexp.MustCompile(`/Kids \[([^\]]*)\] /Count (\d+)`).FindStringSubmatch(pages)
+	if m == nil {
+		t.Fatalf("unexpected pages tree %q", pages)
+	}
+	kids := regexp.MustCompile(`(\d+) 0 R`).FindAllStringSubmatch(m[1], -1)
+	if count, _ := strconv.Atoi(m[2]); count != d.Pages() || len(kids) != d.Pages() {
+		t.Errorf("pages tree has %d kids and count %d, document has %d pages", len(kids), count, d.Pages())
+	}
+	for _, k := range kids {
+		id, _ := strconv.Atoi(k[1])
+		if page := p.object(t, id); !strings.Contains(page, "/Type /Page ") {
+			t.Errorf("kid %d is not a page: %q", id, page)
+		}
+	}
+	outlines := p.object(t, ref(t, catalog, "/Outlines"))
+	if !strings.Contains(outlines, "/Count 2") {
+		t.Errorf("unexpected outlines %q", outlines)
+	}
+
+	// font is embedded as is with widths and unicode mapping for used glyphs
+	var fontObj string
+	for id := 1; id < len(p.offsets); id++ {
+		if obj := p.object(t, id); strings.Contains(obj, "/Subtype /Type0") {
+			fontObj = obj
+		}
+	}
+	if len(fontObj) == 0 {
+		t.Fatal("no font in document")
+	}
+	cid := p.object(t, ref(t, fontObj, `/DescendantFonts \[`))
+	desc := p.object(t, ref(t, cid, "/FontDescriptor"))
+	fileID := ref(t, desc, "/FontFile2")
+	if !strings.Contains(p.object(t, fileID), fmt.Sprintf("/Length1 %d", len(font))) {
+		t.Error("embedded font has wrong /Length1")
+	}
+	if !bytes.Equal(p.stream(t, fileID), font) {
+		t.Error("embedded font data differs from original")
+	}
+	widths := regexp.MustCompile(`/W \[([^\]]*(?:\][^\]]*)*)\]`).FindStringSubmatch(cid)
+	if widths == nil || strings.Count(widths[1], "[") != len(f.used) {
+		t.Errorf("widths do not match used glyphs: %q", cid)
+	}
+	cmap := string(p.stream(t, ref(t, fontObj, "/ToUnicode")))
+	if !strings.Contains(cmap, fmt.Sprintf("<%04X> <0413>", f.glyph('Г'))) {
+		t.Errorf("no unicode mapping for used glyph in %q", cmap)
+	}
+}
@@ -0,0 +1,146 @@
+package pdf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+)
+
+// Font is TrueType font embedded as CID-keyed font with Identity-H encoding, which allows for any Unicode text.
+type Font struct {
+	id      int
+	obj     int
+	data    []byte
+	font    *truetype.Font
+	name    string
+	widths  map[rune]float64
+	used    map[uint16]rune
+	ascent  float64
+	descent float64
+	bbox    [4]int
+}
+
+// AddFont parses TrueType font data and registers font with the document.
+func (d *Document) AddFont(data []byte) (*Font, error) {
+
+	tf, err := truetype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse font: %w", err)
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+			return -1
+		}
+		return r
+	}, tf.Name(truetype.NameIDPostscriptName))
+	if len(name) == 0 {
+		name = fmt.Sprintf("Font%d", len(d.fonts)+1)
+	}
+
+	b := tf.Bounds(1000)
+	f := &Font{
+		id:      len(d.fonts) + 1,
+		data:    data,
+		font:    tf,
+		name:    name,
+		widths:  make(map[rune]float64),
+		used:    make(map[uint16]rune),
+		ascent:  float64(b.Max.Y),
+		descent: float64(b.Min.Y),
+		bbox:    [4]int{int(b.Min.X), int(b.Min.Y), int(b.Max.X), int(b.Max.Y)},
+	}
+	d.fonts = append(d.fonts, f)
+	return f, nil
+}
+
+// Width returns width of the text in points.
+func (f *Font) Width(s string, size float64) float64 {
+	var w float64
+	for _, r := range s {
+		w += f.advance(r)
+	}
+	return w * size / 1000
+}
+
+// Ascent returns distance from baseline to the top of the highest glyph in points.
+func (f *Font) Ascent(size float64) float64 {
+	return f.ascent * size / 1000
+}
+
+// Descent returns (negative) distance from baseline to the bottom of the lowest glyph in points.
+func (f *Font) Descent(size float64) float64 {
+	return f.descent * size / 1000
+}
+
+// Has reports if font has glyph for the rune.
+func (f *Font) Has(r rune) bool {
+	return f.font.Index(r) != 0
+}
+
+func (f *Font) advance(r rune) float64 {
+	if w, ok := f.widths[r]; ok {
+		return w
+	}
+	w := float64(f.font.HMetric(1000, f.font.Index(r)).AdvanceWidth)
+	f.widths[r] = w
+	return w
+}
+
+// glyph returns glyph index for the rune remembering it for widths and ToUnicode tables.
+func (f *Font) glyph(r rune) uint16 {
+	g := uint16(f.font.Index(r))
+	if _, ok := f.used[g]; !ok {
+		f.used[g] = r
+	}
+	return g
+}
+
+func (f *Font) write(w *writer) {
+
+	f.obj = w.reserve()
+	cid := w.reserve()
+	desc := w.reserve()
+	file := w.reserve()
+	cmap := w.reserve()
+
+	glyphs := make([]int, 0, len(f.used))
+	for g := range f.used {
+		glyphs = append(glyphs, int(g))
+	}
+	sort.Ints(glyphs)
+
+	var widths strings.Builder
+	for _, g := range glyphs {
+		fmt.Fprintf(&widths, "%d [%s] ", g, num(f.advance(f.used[uint16(g)])))
+	}
+
+	w.object(f.obj, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		f.name, cid, cmap))
+	w.object(cid, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /W [%s] >>",
+		f.name, desc, strings.TrimSpace(widths.String())))
+	w.object(desc, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %d 0 R >>",
+		f.name, f.bbox[0], f.bbox[1], f.bbox[2], f.bbox[3], num(f.ascent), num(f.descent), num(f.ascent), file))
+	w.stream(file, f.data, true, fmt.Sprintf(" /Length1 %d", len(f.data)))
+
+	var cm strings.Builder
+	cm.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	cm.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	cm.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	cm.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for i := 0; i < len(glyphs); i += 100 {
+		chunk := glyphs[i:]
+		if len(chunk) > 100 {
+			chunk = chunk[:100]
+		}
+		fmt.Fprintf(&cm, "%d beginbfchar\n", len(chunk))
+		for _, g := range chunk {
+			fmt.Fprintf(&cm, "<%04X> <%s\n", g, strings.TrimPrefix(text(string(f.used[uint16(g)])), "<FEFF"))
+		}
+		cm.WriteString("endbfchar\n")
+	}
+	cm.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	w.stream(cmap, []byte(cm.String()), true, "")
+}
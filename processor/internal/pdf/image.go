@@ -0,0 +1,76 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	// image decoders
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Image is raster image XObject.
+type Image struct {
+	id     int
+	obj    int
+	width  int
+	height int
+	data   []byte
+	dict   string
+	flate  bool
+}
+
+// AddImage registers image with the document. JPEG data is embedded as is, everything else is decoded and stored
+// as compressed pixels composed on white background.
+func (d *Document) AddImage(data []byte) (*Image, error) {
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode image: %w", err)
+	}
+
+	im := &Image{id: len(d.images) + 1, width: cfg.Width, height: cfg.Height}
+	if format == "jpeg" && (cfg.ColorModel == color.YCbCrModel || cfg.ColorModel == color.GrayModel) {
+		cs := "/DeviceRGB"
+		if cfg.ColorModel == color.GrayModel {
+			cs = "/DeviceGray"
+		}
+		im.data = data
+		im.dict = fmt.Sprintf(" /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			cfg.Width, cfg.Height, cs)
+	} else {
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode image: %w", err)
+		}
+		b := src.Bounds()
+		dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+
+		pix := make([]byte, 0, b.Dx()*b.Dy()*3)
+		for i := 0; i < len(dst.Pix); i += 4 {
+			pix = append(pix, dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2])
+		}
+		im.data = pix
+		im.flate = true
+		im.dict = fmt.Sprintf(" /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8",
+			b.Dx(), b.Dy())
+	}
+	d.images = append(d.images, im)
+	return im, nil
+}
+
+// Size returns image dimensions in pixels.
+func (im *Image) Size() (int, int) {
+	return im.width, im.height
+}
+
+func (im *Image) write(w *writer) {
+	im.obj = w.reserve()
+	w.stream(im.obj, im.data, im.flate, im.dict)
+}
@@ -0,0 +1,337 @@
+package processor
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/processor/internal/mobi"
+)
+
+const (
+	kindleDocuments   = "documents"
+	kindleSystem      = "system"
+	kindleThumbnails  = "thumbnails"
+	kindleCollections = "collections.json"
+	// kindleSynced remembers what devicesync put on device, nothing else is ever removed.
+	kindleSynced = "fb2c-devicesync.json"
+	// kindleMountPoint is where device sees its own storage, sideloaded books are referred by their path there.
+	kindleMountPoint = "/mnt/us"
+	// collectionsLocale is appended to collection names, device shows collection in any locale.
+	collectionsLocale = "@en-US"
+)
+
+// KindleDevice is Kindle mounted as USB storage.
+type KindleDevice struct {
+	Root string
+	log  *zap.Logger
+	own  *kindleOwned
+}
+
+// DeviceBook is Kindle book found on device.
+type DeviceBook struct {
+	File  string // full path to the book
+	Item  string // how book is referred to in collections
+	Key   string // ASIN (or CDE key) book thumbnail is named after, empty if book has none
+	Thumb string // name of book thumbnail, empty if book cannot have one
+}
+
+// kindleCollection is the way collections are kept in "system/collections.json".
+type kindleCollection struct {
+	Items      []string `json:"items"`
+	LastAccess int64    `json:"lastAccess"`
+}
+
+// kindleOwned is what was put on device by devicesync, device may have books, thumbnails and collections from other
+// sources (kfx, pdf, personal documents) which we know nothing about and must never touch.
+type kindleOwned struct {
+	Thumbnails  []string            `json:"thumbnails"`
+	Collections map[string][]string `json:"collections"` // items added to collection by devicesync
+	Created     []string            `json:"created"`     // collections created by devicesync
+}
+
+// NewKindleDevice checks that root looks like mounted Kindle.
+func NewKindleDevice(root string, log *zap.Logger) (*KindleDevice, error) {
+
+	for _, dir := range []string{kindleDocuments, kindleSystem} {
+		if fi, err := os.Stat(filepath.Join(root, dir)); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("%s does not look like mounted Kindle, there is no %s directory", root, dir)
+		}
+	}
+	d := &KindleDevice{Root: root, log: log, own: &kindleOwned{}}
+	if err := os.MkdirAll(d.Thumbnails(), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create thumbnails directory: %w", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, kindleSystem, kindleSynced)); err == nil {
+		if err := json.Unmarshal(data, d.own); err != nil {
+			return nil, fmt.Errorf("unable to read synchronization state: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read synchronization state: %w", err)
+	}
+	if d.own.Collections == nil {
+		d.own.Collections = make(map[string][]string)
+	}
+	return d, nil
+}
+
+// Save remembers thumbnails and collection items devicesync is responsible for, so later runs could clean them up.
+func (d *KindleDevice) Save() error {
+	data, err := json.Marshal(d.own)
+	if err != nil {
+		return err
+	}
+	return writeReplace(filepath.Join(d.Root, kindleSystem, kindleSynced), data)
+}
+
+// Documents returns directory books are kept in.
+func (d *KindleDevice) Documents() string {
+	return filepath.Join(d.Root, kindleDocuments)
+}
+
+// Thumbnails returns directory cover thumbnails are kept in.
+func (d *KindleDevice) Thumbnails() string {
+	return filepath.Join(d.Root, kindleSystem, kindleThumbnails)
+}
+
+// Books finds all Kindle books on device.
+func (d *KindleDevice) Books() ([]*DeviceBook, error) {
+
+	var books []*DeviceBook
+	err := filepath.Walk(d.Documents(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			d.log.Warn("Skipping path", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".mobi", ".azw3", ".azw", ".prc":
+		default:
+			return nil
+		}
+		b, err := d.book(path)
+		if err != nil {
+			return err
+		}
+		books = append(books, b)
+		return nil
+	})
+	return books, err
+}
+
+func (d *KindleDevice) book(fname string) (*DeviceBook, error) {
+
+	rel, err := filepath.Rel(d.Root, fname)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(kindleMountPoint + "/" + filepath.ToSlash(rel)))
+	b := &DeviceBook{File: fname, Item: "*" + hex.EncodeToString(sum[:])}
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	mi, err := mobi.ReadInfo(data)
+	if err != nil {
+		// device still shows it, so it could be part of collection
+		d.log.Debug("Unable to read book headers", zap.String("file", fname), zap.Error(err))
+		return b, nil
+	}
+	b.Key = mi.ASIN
+	if len(mi.CDEKey) > 0 {
+		b.Key = mi.CDEKey
+	}
+	if len(b.Key) > 0 {
+		b.Thumb = "thumbnail_" + b.Key + "_" + mi.CDEType + "_portrait.jpg"
+	}
+	if len(mi.ASIN) > 0 && len(mi.CDEType) > 0 {
+		// books with ASIN are indexed by it rather than by location
+		b.Item = "#" + mi.ASIN + "^" + mi.CDEType
+	}
+	return b, nil
+}
+
+// TrackThumbnails remembers thumbnails of synchronized books as created by devicesync.
+func (d *KindleDevice) TrackThumbnails(synced []string, books []*DeviceBook) {
+
+	for _, b := range books {
+		if len(b.Thumb) == 0 || !isOneOf(b.File, synced) || isOneOf(b.Thumb, d.own.Thumbnails) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(d.Thumbnails(), b.Thumb)); err == nil {
+			d.own.Thumbnails = append(d.own.Thumbnails, b.Thumb)
+		}
+	}
+}
+
+// RemoveOrphanThumbnails deletes thumbnails created by devicesync for books which are no longer on device and returns
+// their names. Thumbnails devicesync did not create are left alone.
+func (d *KindleDevice) RemoveOrphanThumbnails(books []*DeviceBook) ([]string, error) {
+
+	thumbs := make(map[string]bool, len(books))
+	for _, b := range books {
+		if len(b.Thumb) > 0 {
+			thumbs[b.Thumb] = true
+		}
+	}
+
+	var removed []string
+	kept := d.own.Thumbnails[:0]
+	for i, name := range d.own.Thumbnails {
+		if thumbs[name] {
+			kept = append(kept, name)
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.Thumbnails(), name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			d.own.Thumbnails = append(kept, d.own.Thumbnails[i:]...)
+			return removed, err
+		}
+		d.log.Debug("Orphan thumbnail removed", zap.String("thumb", name))
+		removed = append(removed, name)
+	}
+	d.own.Thumbnails = kept
+	return removed, nil
+}
+
+// UpdateCollections adds books to collections creating them when necessary. groups maps collection name to book files.
+// When prune is set items devicesync added earlier for books which are no longer on device are removed, collections
+// devicesync created are removed when left empty. Items and collections from other sources are never touched.
+// Collections are kept in "system/collections.json" which is read by older devices and collection managers on newer ones.
+func (d *KindleDevice) UpdateCollections(groups map[string][]string, books []*DeviceBook, prune bool) error {
+
+	items := make(map[string]string, len(books))
+	present := make(map[string]bool, len(books))
+	for _, b := range books {
+		items[b.File] = b.Item
+		present[b.Item] = true
+	}
+
+	fname := filepath.Join(d.Root, kindleSystem, kindleCollections)
+	colls := make(map[string]*kindleCollection)
+	if data, err := os.ReadFile(fname); err == nil {
+		if err := json.Unmarshal(data, &colls); err != nil {
+			return fmt.Errorf("unable to read collections: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to read collections: %w", err)
+	}
+
+	// forget what was removed from device by other means
+	for key, owned := range d.own.Collections {
+		c, ok := colls[key]
+		if !ok || c == nil {
+			delete(d.own.Collections, key)
+			continue
+		}
+		kept := owned[:0]
+		for _, it := range owned {
+			if isOneOf(it, c.Items) {
+				kept = append(kept, it)
+			}
+		}
+		d.own.Collections[key] = kept
+	}
+	created := d.own.Created[:0]
+	for _, key := range d.own.Created {
+		if _, ok := colls[key]; ok {
+			created = append(created, key)
+		}
+	}
+	d.own.Created = created
+
+	if prune {
+		keys := make([]string, 0, len(d.own.Collections))
+		for key := range d.own.Collections {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			var stale, owned []string
+			for _, it := range d.own.Collections[key] {
+				if present[it] {
+					owned = append(owned, it)
+				} else {
+					stale = append(stale, it)
+				}
+			}
+			c := colls[key]
+			kept := c.Items[:0]
+			for _, it := range c.Items {
+				if !isOneOf(it, stale) {
+					kept = append(kept, it)
+				}
+			}
+			c.Items, d.own.Collections[key] = kept, owned
+			if len(c.Items) == 0 && isOneOf(key, d.own.Created) {
+				d.log.Debug("Empty collection removed", zap.String("collection", key))
+				delete(colls, key)
+				delete(d.own.Collections, key)
+				d.own.Created = removeString(d.own.Created, key)
+			}
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := name + collectionsLocale
+		c, ok := colls[key]
+		if !ok || c == nil {
+			c = &kindleCollection{LastAccess: now}
+			colls[key] = c
+			d.own.Created = append(d.own.Created, key)
+		}
+		for _, file := range groups[name] {
+			it, ok := items[file]
+			if !ok {
+				d.log.Debug("Book is not on device, ignoring", zap.String("file", file))
+				continue
+			}
+			if !isOneOf(it, c.Items) {
+				c.Items = append(c.Items, it)
+				d.own.Collections[key] = append(d.own.Collections[key], it)
+			}
+		}
+	}
+
+	data, err := json.Marshal(colls)
+	if err != nil {
+		return err
+	}
+	return writeReplace(fname, data)
+}
+
+// writeReplace writes file through temporary one, so device never sees it partially written.
+func writeReplace(fname string, data []byte) error {
+	tmp := fname + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+func removeString(list []string, s string) []string {
+	res := list[:0]
+	for _, v := range list {
+		if v != s {
+			res = append(res, v)
+		}
+	}
+	return res
+}
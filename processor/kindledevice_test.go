@@ -0,0 +1,203 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"fb2converter/processor/internal/mobi"
+)
+
+// writeKindleBook creates book with cover and ASIN.
+func writeKindleBook(t *testing.T, fname, asin string) {
+	var cover bytes.Buffer
+	if err := jpeg.Encode(&cover, image.NewGray(image.Rect(0, 0, 600, 800)), nil); err != nil {
+		t.Fatal(err)
+	}
+	w := mobi.NewWriter(&mobi.Meta{Title: "Title", Authors: []string{"Author"}, Language: "en"}, uuid.New(), asin, true, zap.NewNop())
+	idx, err := w.AddImage(cover.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetCover(idx)
+	w.SetText([]byte("<html><body><p>text</p></body></html>"), -1)
+	if err := w.SaveResult(fname); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readCollections(t *testing.T, root string) map[string]*kindleCollection {
+	data, err := os.ReadFile(filepath.Join(root, "system", "collections.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var colls map[string]*kindleCollection
+	if err := json.Unmarshal(data, &colls); err != nil {
+		t.Fatal(err)
+	}
+	return colls
+}
+
+func checkCollections(t *testing.T, root string, expected map[string][]string) {
+	t.Helper()
+	colls := readCollections(t, root)
+	if len(colls) != len(expected) {
+		t.Errorf("unexpected collections: %v", colls)
+	}
+	for name, items := range expected {
+		if c, ok := colls[name]; !ok || len(c.Items) != len(items) || (len(items) > 0 && !reflect.DeepEqual(c.Items, items)) {
+			t.Errorf("unexpected collection %s: %+v", name, c)
+		}
+	}
+}
+
+func TestKindleDevice(t *testing.T) {
+
+	root := t.TempDir()
+	if _, err := NewKindleDevice(root, zap.NewNop()); err == nil {
+		t.Fatal("directory without Kindle layout was accepted")
+	}
+	for _, dir := range []string{"documents/Author", "system"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dev, err := NewKindleDevice(root, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := filepath.Join(dev.Documents(), "Author", "Title.mobi")
+	writeKindleBook(t, book, "B000000001")
+	second := filepath.Join(dev.Documents(), "Author", "Second.mobi")
+	writeKindleBook(t, second, "B000000002")
+	// book device shows, but we cannot read
+	other := filepath.Join(dev.Documents(), "other.azw3")
+	if err := os.WriteFile(other, []byte("not a book"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// books and thumbnails from other sources, which devicesync does not recognize
+	if err := os.WriteFile(filepath.Join(dev.Documents(), "manual.pdf"), []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	foreign := []string{"thumbnail_B0KFX00001_EBOK_portrait.jpg", "thumbnail_GONE_EBOK_portrait.jpg", "unrelated.jpg"}
+	for _, name := range foreign {
+		if err := os.WriteFile(filepath.Join(dev.Thumbnails(), name), []byte("jpeg"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, fname := range []string{book, second} {
+		if created, err := ProduceThumbnail(fname, dev.Thumbnails(), 330, 470, false, zap.NewNop()); err != nil || !created {
+			t.Fatalf("thumbnail was not created: %v", err)
+		}
+	}
+
+	books, err := dev.Books()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].File < books[j].File })
+	if len(books) != 3 || books[1].File != book || books[1].Key != "B000000001" || books[1].Item != "#B000000001^EBOK" ||
+		books[1].Thumb != "thumbnail_B000000001_EBOK_portrait.jpg" || books[0].File != second ||
+		books[2].File != other || books[2].Key != "" || books[2].Thumb != "" || books[2].Item[0] != '*' {
+		t.Fatalf("unexpected books: %+v, %+v, %+v", books[0], books[1], books[2])
+	}
+	dev.TrackThumbnails([]string{book, second}, books)
+
+	if err := os.WriteFile(filepath.Join(root, "system", "collections.json"), []byte(`{
+		"Manuals@en-US": {"items": ["*0000"], "lastAccess": 1},
+		"Series@en-US": {"items": ["*0000"], "lastAccess": 3}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string][]string{
+		"Series": {book, second, filepath.Join(dev.Documents(), "missing.mobi")},
+		"Author": {book, other},
+	}
+	for i := 0; i < 2; i++ {
+		// second run must not duplicate anything
+		if err := dev.UpdateCollections(groups, books, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkCollections(t, root, map[string][]string{
+		"Manuals@en-US": {"*0000"},
+		"Series@en-US":  {"*0000", books[1].Item, books[0].Item},
+		"Author@en-US":  {books[1].Item, books[2].Item},
+	})
+	if readCollections(t, root)["Series@en-US"].LastAccess != 3 {
+		t.Error("existing collection was recreated")
+	}
+	if err := dev.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// state must survive between runs
+	if dev, err = NewKindleDevice(root, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(second); err != nil {
+		t.Fatal(err)
+	}
+	if books, err = dev.Books(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].File < books[j].File })
+
+	// without pruning nothing is removed
+	if err := dev.UpdateCollections(nil, books, false); err != nil {
+		t.Fatal(err)
+	}
+	if c := readCollections(t, root)["Series@en-US"]; len(c.Items) != 3 {
+		t.Errorf("collection was pruned: %v", c.Items)
+	}
+
+	removed, err := dev.RemoveOrphanThumbnails(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"thumbnail_B000000002_EBOK_portrait.jpg"}) {
+		t.Errorf("unexpected thumbnails removed: %v", removed)
+	}
+	for _, name := range append(foreign, books[0].Thumb) {
+		if _, err := os.Stat(filepath.Join(dev.Thumbnails(), name)); err != nil {
+			t.Errorf("thumbnail %s was removed", name)
+		}
+	}
+
+	if err := dev.UpdateCollections(nil, books, true); err != nil {
+		t.Fatal(err)
+	}
+	checkCollections(t, root, map[string][]string{
+		"Manuals@en-US": {"*0000"},
+		"Series@en-US":  {"*0000", books[0].Item},
+		"Author@en-US":  {books[0].Item, books[1].Item},
+	})
+
+	// collections devicesync created go away when empty, others stay
+	for _, b := range books {
+		if err := os.Remove(b.File); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if books, err = dev.Books(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.UpdateCollections(nil, books, true); err != nil {
+		t.Fatal(err)
+	}
+	checkCollections(t, root, map[string][]string{
+		"Manuals@en-US": {"*0000"},
+		"Series@en-US":  {"*0000"},
+	})
+}
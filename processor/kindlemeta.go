@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"fb2converter/config"
+	"fb2converter/processor/internal/mobi"
+	"fb2converter/state"
+)
+
+// KindleMeta is meta information which could be changed in already produced Kindle book. MOBI has no standard place
+// for series, so sequence name and number only affect book title formatted according to "title_format".
+type KindleMeta struct {
+	config.MetaInfo
+	Publisher string
+	CDEType   string
+}
+
+// EditKindle changes meta information of MOBI/AZW3 book (both parts of combo file) and returns new book content.
+// "src" is used for title formatting the same way source book name is used during conversion.
+func EditKindle(data []byte, src string, m *KindleMeta, env *state.LocalEnv) ([]byte, error) {
+
+	e := &mobi.Edit{
+		Publisher: strings.TrimSpace(m.Publisher),
+		Language:  strings.TrimSpace(m.Lang),
+		ASIN:      strings.TrimSpace(m.ASIN),
+		CDEType:   strings.ToUpper(strings.TrimSpace(m.CDEType)),
+	}
+	if len(e.CDEType) > 0 && e.CDEType != "EBOK" && e.CDEType != "PDOC" {
+		return nil, fmt.Errorf("unsupported cdetype %s, should be EBOK or PDOC", e.CDEType)
+	}
+	for _, an := range m.Authors {
+		if an != nil {
+			e.Authors = append(e.Authors, ReplaceKeywords(env.Cfg.Doc.AuthorFormatMeta, CreateAuthorKeywordsMap(an)))
+		}
+	}
+
+	title, seq := strings.TrimSpace(m.Title), strings.TrimSpace(m.SeqName)
+	if len(seq) > 0 || m.SeqNum > 0 {
+		if len(title) == 0 {
+			// there is no way to tell what part of existing title came from formatting
+			return nil, errors.New("book title is required to change sequence")
+		}
+		if len(env.Cfg.Doc.TitleFormat) == 0 {
+			env.Log.Warn("Sequence is only stored as part of book title, title_format is not set")
+		}
+	}
+	e.Title = title
+	if len(title) > 0 && len(env.Cfg.Doc.TitleFormat) > 0 {
+		b := &Book{Title: title, SeqName: seq, SeqNum: m.SeqNum, Date: strings.TrimSpace(m.Date), Publisher: e.Publisher}
+		e.Title = ReplaceKeywords(env.Cfg.Doc.TitleFormat, CreateTitleKeywordsMap(b, env.Cfg.Doc.SeqNumPos, src))
+	}
+
+	if len(m.CoverImage) > 0 {
+		if m.CoverImage == "remove cover" {
+			return nil, errors.New("cover cannot be removed from Kindle book")
+		}
+		cover, err := os.ReadFile(m.CoverImage)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cover image: %w", err)
+		}
+		e.Cover = cover
+	}
+	return mobi.EditMeta(data, e)
+}
@@ -0,0 +1,409 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/etree"
+	"fb2converter/processor/internal/mobi"
+)
+
+// Legacy MOBI 7 knows nothing about CSS, so content has to be converted to the Mobipocket HTML dialect: presentation
+// is expressed with plain tags and attributes, links point to byte offsets in the text ("filepos") and images
+// reference records ("recindex").
+
+var mobiEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+type mobiLink struct {
+	pos    int    // position of filepos placeholder
+	target string // anchor id
+}
+
+type mobiText struct {
+	p       *Processor
+	w       *mobi.Writer
+	buf     bytes.Buffer
+	known   map[string]bool // ids present in content
+	anchors map[string]int  // id -> position in text
+	links   []mobiLink
+	images  map[string]int // relative path -> recindex
+}
+
+// fileAnchor returns anchor id used for content file start.
+func fileAnchor(fname string) string {
+	return "file:" + fname
+}
+
+func (m *mobiText) write(s string) {
+	m.buf.WriteString(s)
+}
+
+func (m *mobiText) text(s string) {
+	m.buf.WriteString(mobiEscaper.Replace(s))
+}
+
+func (m *mobiText) anchor(id string) {
+	if _, ok := m.anchors[id]; !ok && len(id) > 0 {
+		m.anchors[id] = m.buf.Len()
+	}
+}
+
+// filepos writes placeholder to be replaced with target offset when all anchors are known.
+func (m *mobiText) filepos(target string) {
+	m.write("filepos=")
+	m.links = append(m.links, mobiLink{pos: m.buf.Len(), target: target})
+	m.write("0000000000")
+}
+
+// target returns anchor id for internal link or empty string.
+func (m *mobiText) target(href string) string {
+	u, err := url.Parse(href)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 {
+		return ""
+	}
+	var id string
+	switch {
+	case len(u.Fragment) > 0:
+		id = u.Fragment
+	case len(u.Path) > 0:
+		id = fileAnchor(u.Path)
+	}
+	if !m.known[id] {
+		return ""
+	}
+	return id
+}
+
+// image loads referenced image storing it in the book, returns recindex.
+func (m *mobiText) image(rel string) int {
+
+	if idx, ok := m.images[rel]; ok {
+		return idx
+	}
+	data, err := os.ReadFile(filepath.Join(m.p.tmpDir, DirContent, filepath.FromSlash(rel)))
+	if err == nil {
+		var idx int
+		if idx, err = m.w.AddImage(data); err == nil {
+			m.images[rel] = idx
+			return idx
+		}
+	}
+	m.p.env.Log.Warn("Unable to load image for MOBI, skipping", zap.String("ref", rel), zap.Error(err))
+	m.images[rel] = 0
+	return 0
+}
+
+func (m *mobiText) img(e *etree.Element) {
+	if idx := m.image(getAttrValue(e, "src")); idx > 0 {
+		m.write(fmt.Sprintf(`<img recindex="%05d" />`, idx))
+	}
+}
+
+// inline converts text level content of the element.
+func (m *mobiText) inline(e *etree.Element) {
+	for _, t := range e.Child {
+		switch c := t.(type) {
+		case *etree.CharData:
+			m.text(c.Data)
+		case *etree.Element:
+			m.inlineElement(c)
+			m.text(c.Tail())
+		}
+	}
+}
+
+func (m *mobiText) inlineElement(e *etree.Element) {
+
+	m.anchor(getAttrValue(e, "id"))
+
+	var tag string
+	switch cls := getAttrValue(e, "class"); {
+	case e.Tag == "img":
+		m.img(e)
+		return
+	case e.Tag == "br":
+		m.write("<br/>")
+		return
+	case e.Tag == "a":
+		href := getAttrValue(e, "href")
+		if len(href) == 0 {
+			break
+		}
+		if target := m.target(href); len(target) > 0 {
+			m.write("<a ")
+			m.filepos(target)
+			m.write(">")
+		} else if u, err := url.Parse(href); err == nil && len(u.Scheme) > 0 {
+			m.write(`<a href="` + mobiEscaper.Replace(href) + `">`)
+		} else {
+			break
+		}
+		m.inline(e)
+		m.write("</a>")
+		return
+	case cls == "strong":
+		tag = "b"
+	case cls == "emphasis":
+		tag = "i"
+	case cls == "strike":
+		tag = "s"
+	case cls == "inlinenote":
+		tag = "small"
+	case IsOneOf(e.Tag, []string{"sup", "sub", "code"}):
+		tag = e.Tag
+	}
+	if len(tag) == 0 {
+		m.inline(e)
+		return
+	}
+	m.write("<" + tag + ">")
+	m.inline(e)
+	m.write("</" + tag + ">")
+}
+
+// paragraph writes single paragraph with inline content.
+func (m *mobiText) paragraph(e *etree.Element, attrs, inner string) {
+	m.write("<p" + attrs + ">")
+	if len(inner) > 0 {
+		m.write("<" + inner + ">")
+	}
+	m.inline(e)
+	if len(inner) > 0 {
+		m.write("</" + inner + ">")
+	}
+	m.write("</p>")
+}
+
+// lines writes children as lines separated by line breaks.
+func (m *mobiText) lines(e *etree.Element) {
+	for i, c := range e.ChildElements() {
+		if i > 0 {
+			m.write("<br/>")
+		}
+		m.anchor(getAttrValue(c, "id"))
+		m.inline(c)
+	}
+}
+
+// blocks converts block level children of the element.
+func (m *mobiText) blocks(e *etree.Element) {
+
+	for _, c := range e.ChildElements() {
+
+		m.anchor(getAttrValue(c, "id"))
+
+		switch cls := getAttrValue(c, "class"); {
+		case c.Tag == "p" && (cls == "subtitle" || cls == "title"):
+			m.paragraph(c, ` align="center"`, "b")
+		case c.Tag == "p" && cls == "vignette_chapter_end":
+			m.paragraph(c, ` align="center"`, "")
+		case c.Tag == "p":
+			m.paragraph(c, "", "")
+		case c.Tag == "img":
+			m.write(`<p align="center">`)
+			m.img(c)
+			m.write("</p>")
+		case c.Tag == "table":
+			m.write(`<table border="1">`)
+			for _, tr := range c.FindElements(".//tr") {
+				m.write("<tr>")
+				for _, td := range tr.ChildElements() {
+					var attrs string
+					for _, a := range []string{"colspan", "rowspan", "align"} {
+						if v := getAttrValue(td, a); len(v) > 0 {
+							attrs += fmt.Sprintf(` %s="%s"`, a, mobiEscaper.Replace(v))
+						}
+					}
+					m.write("<" + td.Tag + attrs + ">")
+					m.inline(td)
+					m.write("</" + td.Tag + ">")
+				}
+				m.write("</tr>")
+			}
+			m.write("</table>")
+		case cls == "image" || strings.HasPrefix(cls, "vignette_"):
+			m.write(`<p align="center">`)
+			for _, img := range c.FindElements(".//img") {
+				m.img(img)
+			}
+			m.write("</p>")
+		case cls == "emptyline":
+			m.write("<br/>")
+		case cls == "chapter_end":
+			// marker only
+		case textHeaderPattern.MatchString(cls):
+			level := 0
+			fmt.Sscanf(cls, "h%d", &level)
+			if level++; level > 6 {
+				level = 6
+			}
+			m.write(fmt.Sprintf(`<h%d align="center">`, level))
+			m.lines(c)
+			m.write(fmt.Sprintf("</h%d>", level))
+		case cls == "titlenotes":
+			m.write(`<p align="center"><b>`)
+			m.lines(c)
+			m.write("</b></p>")
+		case cls == "stanza":
+			m.write("<p>")
+			m.lines(c)
+			m.write("</p>")
+		case IsOneOf(cls, []string{"epigraph", "cite", "poem", "annotation", "blocknote"}) || c.Tag == "aside":
+			m.write("<blockquote>")
+			m.blocks(c)
+			m.write("</blockquote>")
+		case cls == "text-author":
+			m.paragraph(c, ` align="right"`, "i")
+		case hasInlineContent(c):
+			m.paragraph(c, "", "")
+		default:
+			m.blocks(c)
+		}
+	}
+}
+
+// generateMOBI7 converts previously generated content files into single MOBI HTML text and collects images and
+// TOC for the writer.
+func (p *Processor) generateMOBI7(w *mobi.Writer) {
+
+	p.env.Log.Debug("Generating MOBI 7 - start")
+	defer func(start time.Time) {
+		p.env.Log.Debug("Generating MOBI 7 - done", zap.Duration("elapsed", time.Since(start)))
+	}(time.Now())
+
+	m := &mobiText{
+		p:       p,
+		w:       w,
+		known:   make(map[string]bool),
+		anchors: make(map[string]int),
+		images:  make(map[string]int),
+	}
+
+	var files []*dataFile
+	for _, f := range p.Book.Files {
+		if f.doc == nil || f.ct != "application/xhtml+xml" || filepath.Ext(f.fname) != ".xhtml" || f.transient&dataNotForSpline != 0 {
+			continue
+		}
+		files = append(files, f)
+		m.known[fileAnchor(f.fname)] = true
+		for _, e := range f.doc.FindElements("./html/body//*[@id]") {
+			m.known[getAttrValue(e, "id")] = true
+		}
+	}
+
+	// cover image goes first so device could find it easily
+	if len(p.Book.Cover) > 0 {
+		for _, b := range p.Book.Images {
+			if b.id == p.Book.Cover {
+				if idx := m.image(filepath.ToSlash(filepath.Join(DirImages, b.fname))); idx > 0 {
+					w.SetCover(idx)
+				}
+				break
+			}
+		}
+	}
+
+	// the same logic as for OPF guide
+	var start, toc string
+	for _, f := range files {
+		if p.env.Cfg.Doc.OpenFromCover && strings.HasPrefix(f.fname, "annotation") {
+			start = f.fname
+			break
+		}
+	}
+	for _, f := range files {
+		if len(start) == 0 && strings.HasPrefix(f.fname, "index") {
+			start = f.fname
+		}
+		if f.id == "toc" {
+			toc = f.fname
+		}
+	}
+
+	m.write("<html><head><guide>")
+	if len(toc) > 0 {
+		m.write(`<reference type="toc" title="Table of Contents" `)
+		m.filepos(fileAnchor(toc))
+		m.write(" />")
+	}
+	if len(start) > 0 {
+		m.write(`<reference type="text" title="Starts here" `)
+		m.filepos(fileAnchor(start))
+		m.write(" />")
+	}
+	m.write("</guide></head><body>")
+
+	for i, f := range files {
+		body := f.doc.FindElement("./html/body")
+		if body == nil {
+			continue
+		}
+		if i > 0 {
+			m.write("<mbp:pagebreak/>")
+		}
+		m.anchor(fileAnchor(f.fname))
+		m.blocks(body)
+	}
+	m.write("</body></html>")
+
+	text := m.buf.Bytes()
+	for _, l := range m.links {
+		copy(text[l.pos:], fmt.Sprintf("%010d", m.anchors[l.target]))
+	}
+
+	pos := -1
+	if len(start) > 0 {
+		pos = m.anchors[fileAnchor(start)]
+	}
+	w.SetText(text, pos)
+
+	entries := make([]mobi.TOCEntry, 0, len(p.Book.TOC))
+	for _, te := range p.Book.TOC {
+		u, err := url.Parse(te.ref)
+		if err != nil {
+			continue
+		}
+		id := u.Fragment
+		if len(id) == 0 {
+			id = fileAnchor(u.Path)
+		}
+		if pos, ok := m.anchors[id]; ok {
+			entries = append(entries, mobi.TOCEntry{Title: AllLines(te.title), Pos: pos})
+		}
+	}
+	w.SetTOC(entries)
+}
+
+// produceMOBI7 writes legacy MOBI 7 file without kindlegen.
+func (p *Processor) produceMOBI7(fname string) error {
+
+	meta := &mobi.Meta{
+		Title:       p.Book.Title,
+		Language:    p.Book.Lang.String(),
+		Publisher:   p.Book.Publisher,
+		Description: p.Book.Annotation,
+		ISBN:        p.Book.ISBN,
+		Subjects:    append(append([]string{}, p.Book.Subjects...), p.Book.Keywords...),
+		Date:        p.Book.Date,
+	}
+	for _, an := range p.Book.Authors {
+		meta.Authors = append(meta.Authors, ReplaceKeywords(p.env.Cfg.Doc.AuthorFormatMeta, CreateAuthorKeywordsMap(an)))
+	}
+
+	w := mobi.NewWriter(meta, p.Book.ID, p.Book.ASIN, p.env.Cfg.Doc.Kindlegen.RemovePersonal, p.env.Log)
+	p.generateMOBI7(w)
+	if p.kindlePageMap != APNXNone {
+		p.env.Log.Warn("Page map is not supported for natively produced MOBI, ignoring")
+	}
+	if err := w.SaveResult(fname); err != nil {
+		return fmt.Errorf("unable to save resulting MOBI: %w", err)
+	}
+	return nil
+}
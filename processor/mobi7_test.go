@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+	"fb2converter/etree"
+	"fb2converter/processor/internal/mobi"
+	"fb2converter/state"
+)
+
+func TestMOBI7Text(t *testing.T) {
+
+	conf, err := config.BuildConfig("", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	var pic bytes.Buffer
+	if err := jpeg.Encode(&pic, image.NewGray(image.Rect(0, 0, 20, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, DirContent, DirImages), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, DirContent, DirImages, "pic.jpg"), pic.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &Processor{tmpDir: tmp, env: &state.LocalEnv{Cfg: conf, Log: zap.NewNop()}}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(`<body><div class="h2" id="ch1"><p class="title">Глава</p><p class="title">первая</p></div>
+<p class="subtitle">* * *</p>
+<p>Текст <span class="strong">жирный</span> &amp; <a href="index1.xhtml#n1">[1]</a> <a href="http://example.com/?a=1&amp;b=2">сайт</a> <a href="missing.xhtml">нет</a></p>
+<div class="image"><img src="images/pic.jpg"/></div>
+<div class="epigraph"><p>Эпи<span class="emphasis">граф</span></p><div class="text-author">Кто-то</div></div>
+<div class="poem"><div class="stanza"><p>Строка</p><p>ещё</p></div></div>
+<p id="n1">Примечание</p></body>`); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mobiText{
+		p:       p,
+		w:       mobi.NewWriter(&mobi.Meta{Title: "Книга"}, uuid.New(), "", false, zap.NewNop()),
+		known:   map[string]bool{"n1": true, "ch1": true},
+		anchors: make(map[string]int),
+		images:  make(map[string]int),
+	}
+	m.blocks(doc.Root())
+	text := m.buf.Bytes()
+	for _, l := range m.links {
+		copy(text[l.pos:], fmt.Sprintf("%010d", m.anchors[l.target]))
+	}
+
+	expected := `<h3 align="center">Глава<br/>первая</h3>` +
+		`<p align="center"><b>* * *</b></p>` +
+		`<p>Текст <b>жирный</b> &amp; <a filepos=%010d>[1]</a> <a href="http://example.com/?a=1&amp;b=2">сайт</a> нет</p>` +
+		`<p align="center"><img recindex="00001" /></p>` +
+		`<blockquote><p>Эпи<i>граф</i></p><p align="right"><i>Кто-то</i></p></blockquote>` +
+		`<blockquote><p>Строка<br/>ещё</p></blockquote>` +
+		`<p>Примечание</p>`
+	note := strings.Index(string(text), "<p>Примечание")
+	if res := string(text); res != fmt.Sprintf(expected, note) {
+		t.Errorf("unexpected MOBI text:\n%s", res)
+	}
+	if m.anchors["ch1"] != 0 || m.anchors["n1"] != note {
+		t.Errorf("unexpected anchors: %v", m.anchors)
+	}
+	if m.images["images/pic.jpg"] != 1 {
+		t.Errorf("image was not stored: %v", m.images)
+	}
+}
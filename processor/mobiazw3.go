@@ -19,23 +19,22 @@ import (
 // FinalizeMOBI produces final mobi file out of previously saved temporary files.
 func (p *Processor) FinalizeMOBI(fname string) error {
 
-	tmp, err := p.generateIntermediateContent(fname)
-	if err != nil {
-		return fmt.Errorf("unable to generate intermediate content: %w", err)
+	var (
+		tmp string
+		err error
+	)
+	if !p.env.Cfg.Doc.Kindlegen.NativeMOBI {
+		if tmp, err = p.generateIntermediateContent(fname); err != nil {
+			return fmt.Errorf("unable to generate intermediate content: %w", err)
+		}
 	}
 
-	if _, err := os.Stat(fname); err == nil {
-		if !p.overwrite {
-			return fmt.Errorf("output file already exists: %s", fname)
-		}
-		p.env.Log.Warn("Overwriting existing file", zap.String("file", fname))
-		if err = os.Remove(fname); err != nil {
-			return err
-		}
-	} else if !os.IsNotExist(err) {
+	if err := p.PrepareOutputFile(fname); err != nil {
 		return err
-	} else if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
-		return fmt.Errorf("unable to create output directory: %w", err)
+	}
+
+	if p.env.Cfg.Doc.Kindlegen.NativeMOBI {
+		return p.produceMOBI7(fname)
 	}
 
 	if p.env.Cfg.Doc.Kindlegen.NoOptimization {
@@ -78,18 +77,8 @@ func (p *Processor) FinalizeAZW3(fname string) error {
 		return fmt.Errorf("unable to generate intermediate content: %w", err)
 	}
 
-	if _, err := os.Stat(fname); err == nil {
-		if !p.overwrite {
-			return fmt.Errorf("output file already exists: %s", fname)
-		}
-		p.env.Log.Warn("Overwriting existing file", zap.String("file", fname))
-		if err = os.Remove(fname); err != nil {
-			return err
-		}
-	} else if !os.IsNotExist(err) {
+	if err := p.PrepareOutputFile(fname); err != nil {
 		return err
-	} else if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
-		return fmt.Errorf("unable to create output directory: %w", err)
 	}
 
 	if p.env.Cfg.Doc.Kindlegen.NoOptimization {
@@ -0,0 +1,61 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"fb2converter/config"
+	"fb2converter/state"
+)
+
+// native MOBI 7 writer produces MOBI only, AZW3 must always come from kindlegen.
+func TestFinalizeAZW3NativeMOBI(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	dir := t.TempDir()
+	kindlegen := filepath.Join(dir, "kindlegen")
+	if err := os.WriteFile(kindlegen, []byte(`#!/bin/sh
+src=$1
+while [ $# -gt 0 ]; do
+	[ "$1" = "-o" ] && out=$2
+	shift
+done
+printf 'kindlegen output' > "$(dirname "$src")/$out"
+`), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := config.BuildConfig("", nil, []string{"document.kindlegen.native_mobi7=true", "document.kindlegen.no_mobi_optimization=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conf.Doc.Kindlegen.NativeMOBI {
+		t.Fatal("native MOBI 7 writer was not requested")
+	}
+	tmp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmp, DirContent), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p := &Processor{
+		kind:          InFb2,
+		format:        OAzw3,
+		tmpDir:        tmp,
+		kindlegenPath: kindlegen,
+		env:           &state.LocalEnv{Cfg: conf, Log: zap.NewNop()},
+	}
+
+	fname := filepath.Join(dir, "out", "book.azw3")
+	if err := p.FinalizeAZW3(fname); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(fname); err != nil || string(data) != "kindlegen output" {
+		t.Errorf("AZW3 was not produced by kindlegen: %q, %v", data, err)
+	}
+}
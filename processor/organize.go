@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/language"
+
+	"fb2converter/config"
+	"fb2converter/state"
+)
+
+// OrganizedName parses book description and returns name book file would have in organized library. It is the same
+// name conversion would produce, but with provided extension.
+func (p *Processor) OrganizedName(ext string) (string, error) {
+	if err := p.processDescription(); err != nil {
+		return "", err
+	}
+	p.processGenres()
+	return outputName(p.Book, p.src, p.dst, p.nodirs, true, ext, p.env), nil
+}
+
+// authorsFromInfo converts display author names back. Order of name parts is unknown unless it is "Last, First", so
+// such names are kept whole as last names.
+func authorsFromInfo(names []string) []*config.AuthorName {
+	var res []*config.AuthorName
+	for _, n := range names {
+		if n = strings.TrimSpace(n); len(n) == 0 {
+			continue
+		}
+		if strings.Contains(n, ",") {
+			if an := config.ParseAuthorString(n); an != nil {
+				res = append(res, an)
+			}
+			continue
+		}
+		res = append(res, &config.AuthorName{Last: n})
+	}
+	return res
+}
+
+// OrganizedNameFromInfo does the same as OrganizedName for books of other formats using metadata read from the book.
+// Author names are taken from their display form, so author keywords may not be as precise as for FB2.
+func OrganizedNameFromInfo(info *BookInfo, src, dst string, nodirs bool, ext string, env *state.LocalEnv) string {
+
+	b := &Book{
+		Title:      info.Title,
+		GenreNames: info.Genres,
+		Date:       info.Date,
+		Publisher:  info.Publisher,
+		ISBN:       info.ISBN,
+	}
+	if u, err := uuid.Parse(info.ID); err == nil {
+		b.ID = u
+	}
+	if t, err := language.Parse(info.Language); err == nil {
+		b.Lang = t
+	}
+	b.Authors = authorsFromInfo(info.Authors)
+	b.Translators = authorsFromInfo(info.Translators)
+	if len(info.Series) > 0 {
+		b.SeqName, b.SeqNum = strings.TrimSpace(info.Series[0].Name), info.Series[0].Num
+	}
+	return outputName(b, src, dst, nodirs, len(b.Title) > 0, ext, env)
+}
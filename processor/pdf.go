@@ -0,0 +1,1085 @@
+package processor
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"fb2converter/etree"
+	"fb2converter/processor/internal/pdf"
+	"fb2converter/static"
+)
+
+const (
+	mmToPt = 72 / 25.4
+	pxToPt = 0.75
+)
+
+// supported footnotes placements for PDF
+const (
+	pdfNotesBottom     = "bottom"
+	pdfNotesChapterEnd = "chapter_end"
+)
+
+type pdfAlign int
+
+const (
+	pdfJustify pdfAlign = iota
+	pdfLeft
+	pdfCenter
+	pdfRight
+)
+
+// pdfBlockStyle is inherited by block elements, all distances are in ems of the base font.
+type pdfBlockStyle struct {
+	align       pdfAlign
+	indent      float64
+	left, right float64
+	scale       float64
+	bold        bool
+	italic      bool
+	keep        bool // keep with next block
+}
+
+// pdfInlineStyle is inherited by inline elements.
+type pdfInlineStyle struct {
+	bold, italic bool
+	scale        float64
+	rise         float64 // in ems of current size
+}
+
+type pdfPart struct {
+	text string
+	st   pdfInlineStyle
+	note string // id of the note referenced from this part
+}
+
+type pdfWord struct {
+	parts  []pdfPart
+	space  bool // word is followed by space
+	brk    bool // forced line break after word
+	hyphen bool // word was split on soft hyphen, dash should be drawn
+}
+
+type pdfLine struct {
+	words  []*pdfWord
+	style  pdfBlockStyle
+	first  bool
+	last   bool
+	height float64
+	notes  []string
+}
+
+type pdfAnchor struct {
+	page *pdf.Page
+	top  float64
+}
+
+var pdfRefTrim = regexp.MustCompile(`^[\[\{\(\s]+|[\]\}\)\s]+$`)
+
+// pdfLayout is very simple flow layout engine: it breaks paragraphs into lines and lines into pages, placing
+// footnotes at the bottom of the pages or at the chapter ends.
+type pdfLayout struct {
+	p   *Processor
+	doc *pdf.Document
+
+	fonts [2][2]*pdf.Font // [bold][italic]
+	fake  [2][2][2]bool   // [bold][italic] -> fake bold, fake italic
+
+	size    float64 // base font size
+	spacing float64 // line height factor
+	width   float64 // content width
+	left    float64
+	top     float64
+	bottom  float64
+
+	footnotes   bool // note references have to be turned into footnotes
+	notesBottom bool // footnotes are placed at page bottom rather than at chapter end
+
+	page      *pdf.Page
+	cur       float64 // top of free space on the page
+	empty     bool    // nothing was placed on the page yet
+	breakNext bool    // next block should start new page
+	ids       []string
+	footH     float64 // height of footnotes area on current page
+	foot      []*pdfLine
+	carry     []*pdfLine // footnotes lines which did not fit on previous page
+	final     bool
+	placed    map[string]bool   // notes already sent to the footnotes area
+	chapter   []string          // notes waiting for chapter end
+	labels    map[string]string // note labels as they appear in the text
+	anchors   map[string]pdfAnchor
+	images    map[string]*pdf.Image
+}
+
+func (l *pdfLayout) lineHeight(scale float64) float64 {
+	return l.size * scale * l.spacing
+}
+
+func (l *pdfLayout) limit() float64 {
+	return l.bottom + l.footH
+}
+
+func (l *pdfLayout) separator() float64 {
+	return l.size * l.spacing
+}
+
+func (l *pdfLayout) font(st pdfInlineStyle) (*pdf.Font, bool, bool) {
+	b, i := 0, 0
+	if st.bold {
+		b = 1
+	}
+	if st.italic {
+		i = 1
+	}
+	return l.fonts[b][i], l.fake[b][i][0], l.fake[b][i][1]
+}
+
+func (l *pdfLayout) partWidth(pt pdfPart, bs pdfBlockStyle) float64 {
+	f, _, _ := l.font(pt.st)
+	return f.Width(strings.ReplaceAll(pt.text, strSOFTHYPHEN, ""), l.size*bs.scale*pt.st.scale)
+}
+
+func (l *pdfLayout) wordWidth(w *pdfWord, bs pdfBlockStyle) float64 {
+	var res float64
+	for _, pt := range w.parts {
+		res += l.partWidth(pt, bs)
+	}
+	if w.hyphen && len(w.parts) > 0 {
+		res += l.partWidth(pdfPart{text: "-", st: w.parts[len(w.parts)-1].st}, bs)
+	}
+	return res
+}
+
+func (l *pdfLayout) spaceWidth(w *pdfWord, bs pdfBlockStyle) float64 {
+	if len(w.parts) == 0 {
+		return 0
+	}
+	return l.partWidth(pdfPart{text: " ", st: w.parts[len(w.parts)-1].st}, bs)
+}
+
+// words splits inline parts into words.
+func (l *pdfLayout) words(parts []pdfPart) []*pdfWord {
+
+	var (
+		res []*pdfWord
+		cur = &pdfWord{}
+	)
+	flush := func() {
+		if len(cur.parts) > 0 {
+			res = append(res, cur)
+		} else if len(res) > 0 {
+			res[len(res)-1].space = res[len(res)-1].space || cur.space
+			res[len(res)-1].brk = res[len(res)-1].brk || cur.brk
+		}
+		cur = &pdfWord{}
+	}
+	for _, pt := range parts {
+		if pt.text == "\n" {
+			cur.brk = true
+			flush()
+			continue
+		}
+		var sb strings.Builder
+		for _, r := range pt.text {
+			if r != ' ' {
+				sb.WriteRune(r)
+				continue
+			}
+			if sb.Len() > 0 {
+				cur.parts = append(cur.parts, pdfPart{text: sb.String(), st: pt.st, note: pt.note})
+				sb.Reset()
+			}
+			cur.space = true
+			flush()
+		}
+		if sb.Len() > 0 {
+			cur.parts = append(cur.parts, pdfPart{text: sb.String(), st: pt.st, note: pt.note})
+		}
+	}
+	flush()
+	return res
+}
+
+// cut splits word at byte position pos of part i.
+func (l *pdfLayout) cut(w *pdfWord, i, pos int, hyphen bool) (*pdfWord, *pdfWord) {
+	pt := w.parts[i]
+	head := &pdfWord{hyphen: hyphen}
+	head.parts = append(append(head.parts, w.parts[:i]...), pdfPart{text: strings.TrimSuffix(pt.text[:pos], strSOFTHYPHEN), st: pt.st, note: pt.note})
+	tail := &pdfWord{space: w.space, brk: w.brk}
+	if rest := pt.text[pos:]; len(rest) > 0 {
+		tail.parts = append(tail.parts, pdfPart{text: rest, st: pt.st, note: pt.note})
+	}
+	tail.parts = append(tail.parts, w.parts[i+1:]...)
+	return head, tail
+}
+
+// split tries to break word on soft hyphen or dash so the head fits into available width.
+func (l *pdfLayout) split(w *pdfWord, bs pdfBlockStyle, avail float64) (*pdfWord, *pdfWord) {
+
+	shy, _ := utf8.DecodeRuneInString(strSOFTHYPHEN)
+
+	var head, tail *pdfWord
+	for i, pt := range w.parts {
+		for j, r := range pt.text {
+			pos := j + utf8.RuneLen(r)
+			if (r != shy && r != '-') || (i == 0 && j == 0) || (i == len(w.parts)-1 && pos == len(pt.text)) {
+				continue
+			}
+			h, t := l.cut(w, i, pos, r == shy)
+			if l.wordWidth(h, bs) > avail {
+				return head, tail
+			}
+			head, tail = h, t
+		}
+	}
+	return head, tail
+}
+
+// breakChars forcibly breaks word which is wider than the line.
+func (l *pdfLayout) breakChars(w *pdfWord, bs pdfBlockStyle, avail float64) (*pdfWord, *pdfWord) {
+
+	var head, tail *pdfWord
+	for i, pt := range w.parts {
+		for j, r := range pt.text {
+			pos := j + utf8.RuneLen(r)
+			if i == len(w.parts)-1 && pos == len(pt.text) {
+				break
+			}
+			h, t := l.cut(w, i, pos, false)
+			if head != nil && l.wordWidth(h, bs) > avail {
+				return head, tail
+			}
+			head, tail = h, t
+		}
+	}
+	return w, nil
+}
+
+// typeset breaks paragraph into lines.
+func (l *pdfLayout) typeset(parts []pdfPart, bs pdfBlockStyle) []*pdfLine {
+
+	words := l.words(parts)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var (
+		lines []*pdfLine
+		line  = &pdfLine{style: bs, first: true}
+		width float64
+	)
+	avail := func() float64 {
+		w := l.width - (bs.left+bs.right)*l.size
+		if line.first {
+			w -= bs.indent * l.size
+		}
+		return w
+	}
+	push := func() {
+		lines = append(lines, line)
+		line = &pdfLine{style: bs}
+		width = 0
+	}
+
+	for len(words) > 0 {
+		w := words[0]
+		ww := l.wordWidth(w, bs)
+		var sp float64
+		if len(line.words) > 0 {
+			sp = l.spaceWidth(line.words[len(line.words)-1], bs)
+		}
+		if width+sp+ww <= avail() {
+			line.words = append(line.words, w)
+			width += sp + ww
+			words = words[1:]
+			if w.brk {
+				push()
+			}
+			continue
+		}
+		// does not fit - try to hyphenate
+		if head, tail := l.split(w, bs, avail()-width-sp); head != nil {
+			line.words = append(line.words, head)
+			words[0] = tail
+			push()
+			continue
+		}
+		if len(line.words) == 0 {
+			// single word is wider than the line
+			head, tail := l.breakChars(w, bs, avail())
+			line.words = append(line.words, head)
+			if tail != nil {
+				words[0] = tail
+			} else {
+				words = words[1:]
+			}
+		}
+		push()
+	}
+	if len(line.words) > 0 {
+		push()
+	}
+	lines[len(lines)-1].last = true
+
+	for _, ln := range lines {
+		maxScale := 1.0
+		for _, w := range ln.words {
+			if w.brk {
+				ln.last = true
+			}
+			for _, pt := range w.parts {
+				if pt.st.rise == 0 && pt.st.scale > maxScale {
+					maxScale = pt.st.scale
+				}
+				if len(pt.note) > 0 && !IsOneOf(pt.note, ln.notes) {
+					ln.notes = append(ln.notes, pt.note)
+				}
+			}
+		}
+		ln.height = l.lineHeight(bs.scale * maxScale)
+	}
+	return lines
+}
+
+// draw renders line with its top at y.
+func (l *pdfLayout) draw(ln *pdfLine, y float64) {
+
+	bs := ln.style
+	x := l.left + bs.left*l.size
+	avail := l.width - (bs.left+bs.right)*l.size
+	if ln.first {
+		x += bs.indent * l.size
+		avail -= bs.indent * l.size
+	}
+
+	var (
+		runs   []*pdf.Run
+		spaces int
+	)
+	for i, w := range ln.words {
+		for j, pt := range w.parts {
+			f, fb, fi := l.font(pt.st)
+			size := l.size * bs.scale * pt.st.scale
+			t := strings.ReplaceAll(pt.text, strSOFTHYPHEN, "")
+			if j == len(w.parts)-1 {
+				if w.hyphen {
+					t += "-"
+				}
+				if i < len(ln.words)-1 && w.space {
+					t += " "
+					spaces++
+				}
+			}
+			r := &pdf.Run{Font: f, Size: size, Rise: pt.st.rise * size, FakeBold: fb, FakeItalic: fi, Text: t}
+			if n := len(runs); n > 0 && runs[n-1].Font == r.Font && runs[n-1].Size == r.Size && runs[n-1].Rise == r.Rise &&
+				runs[n-1].FakeBold == r.FakeBold && runs[n-1].FakeItalic == r.FakeItalic {
+				runs[n-1].Text += r.Text
+				continue
+			}
+			runs = append(runs, r)
+		}
+	}
+
+	var natural float64
+	for _, r := range runs {
+		natural += r.Width(0)
+	}
+	var extra float64
+	switch {
+	case bs.align == pdfCenter:
+		x += (avail - natural) / 2
+	case bs.align == pdfRight:
+		x += avail - natural
+	case bs.align == pdfJustify && !ln.last && spaces > 0 && natural < avail:
+		extra = (avail - natural) / float64(spaces)
+	}
+
+	// put baseline so glyphs are vertically centered in the line box
+	size := ln.height / l.spacing
+	baseline := y - ln.height/2 - size*0.3
+	for _, r := range runs {
+		l.page.Text(r, x, baseline, extra)
+		x += r.Width(extra)
+	}
+}
+
+func (l *pdfLayout) newPage() {
+
+	l.finishPage()
+
+	l.page = l.doc.AddPage()
+	_, h := l.doc.Size()
+	l.cur = h - l.top
+	l.empty = true
+	l.footH = 0
+	l.foot = nil
+
+	if len(l.carry) > 0 {
+		limit := l.cur - l.bottom - l.separator()
+		if !l.final {
+			limit /= 2
+		}
+		for len(l.carry) > 0 && l.footH+l.carry[0].height <= limit {
+			l.footH += l.carry[0].height
+			l.foot = append(l.foot, l.carry[0])
+			l.carry = l.carry[1:]
+		}
+		l.footH += l.separator()
+	}
+}
+
+func (l *pdfLayout) finishPage() {
+
+	if l.page == nil || len(l.foot) == 0 {
+		return
+	}
+	y := l.bottom + l.footH
+	l.page.Line(l.left, y-l.separator()/2, l.left+l.width/3, y-l.separator()/2, 0.5)
+	y -= l.separator()
+	for _, ln := range l.foot {
+		l.draw(ln, y)
+		y -= ln.height
+	}
+}
+
+func (l *pdfLayout) space(pts float64) {
+	if !l.empty {
+		l.cur -= pts
+	}
+}
+
+// anchor remembers position of all ids waiting for placement.
+func (l *pdfLayout) anchor() {
+	for _, id := range l.ids {
+		l.anchors[id] = pdfAnchor{page: l.page, top: l.cur}
+	}
+	l.ids = nil
+}
+
+// noteLines typesets note body to be used as footnote.
+func (l *pdfLayout) noteLines(id string) []*pdfLine {
+
+	n, ok := l.p.Book.Notes[id]
+	if !ok {
+		return nil
+	}
+	var words []string
+	for _, w := range strings.Fields(n.body) {
+		if l.p.Book.hyph != nil && utf8.RuneCountInString(w) > 2 {
+			w = l.p.Book.hyph.hyphenate(w)
+		}
+		words = append(words, w)
+	}
+	label := l.labels[id]
+	if len(label) == 0 {
+		label = "*"
+	}
+	parts := []pdfPart{
+		{text: label + " ", st: pdfInlineStyle{bold: true, scale: 1}},
+		{text: strings.Join(words, " "), st: pdfInlineStyle{scale: 1}},
+	}
+	return l.typeset(parts, pdfBlockStyle{align: pdfJustify, scale: 0.8})
+}
+
+// lines puts paragraph lines on pages, breaking pages as necessary.
+func (l *pdfLayout) lines(lines []*pdfLine) {
+
+	if len(lines) == 0 {
+		return
+	}
+	if l.breakNext && !l.empty {
+		l.newPage()
+	}
+	l.breakNext = false
+
+	if lines[0].style.keep && !l.empty {
+		var h float64
+		for _, ln := range lines {
+			h += ln.height
+		}
+		if l.cur-h-3*l.lineHeight(1) < l.limit() {
+			l.newPage()
+		}
+	}
+
+	for _, ln := range lines {
+
+		var notes []*pdfLine
+		if l.footnotes && l.notesBottom {
+			for _, id := range ln.notes {
+				if !l.placed[id] {
+					notes = append(notes, l.noteLines(id)...)
+					l.placed[id] = true
+				}
+			}
+		}
+		need := func() float64 {
+			var h float64
+			for _, n := range notes {
+				h += n.height
+			}
+			if h > 0 && l.footH == 0 {
+				h += l.separator()
+			}
+			return h
+		}
+
+		if !l.empty && l.cur-ln.height-need() < l.limit() {
+			l.newPage()
+		}
+		l.anchor()
+		l.draw(ln, l.cur)
+		l.cur -= ln.height
+		l.empty = false
+
+		if len(notes) > 0 {
+			if l.footH == 0 {
+				l.footH = l.separator()
+			}
+			for len(l.carry) == 0 && len(notes) > 0 && l.cur-notes[0].height >= l.limit() {
+				l.foot = append(l.foot, notes[0])
+				l.footH += notes[0].height
+				notes = notes[1:]
+			}
+			l.carry = append(l.carry, notes...)
+		}
+		if l.footnotes && !l.notesBottom {
+			for _, id := range ln.notes {
+				if !IsOneOf(id, l.chapter) {
+					l.chapter = append(l.chapter, id)
+				}
+			}
+		}
+	}
+}
+
+// image puts image on the page scaled to specified width (but never higher than page).
+func (l *pdfLayout) image(im *pdf.Image, w float64) {
+
+	if l.breakNext && !l.empty {
+		l.newPage()
+	}
+	l.breakNext = false
+
+	iw, ih := im.Size()
+	if w > l.width {
+		w = l.width
+	}
+	h := w * float64(ih) / float64(iw)
+	_, ph := l.doc.Size()
+	if maxH := ph - l.top - l.bottom; h > maxH {
+		w, h = w*maxH/h, maxH
+	}
+	if !l.empty && l.cur-h < l.limit() {
+		l.newPage()
+	}
+	l.anchor()
+	l.page.Image(im, l.left+(l.width-w)/2, l.cur-h, w, h)
+	l.cur -= h
+	l.empty = false
+}
+
+// flushChapter outputs notes collected for the chapter.
+func (l *pdfLayout) flushChapter() {
+
+	if len(l.chapter) == 0 {
+		return
+	}
+	ids := l.chapter
+	l.chapter = nil
+
+	if !l.empty && l.cur-2*l.separator() < l.limit() {
+		l.newPage()
+	}
+	y := l.cur - l.separator()/2
+	l.page.Line(l.left, y, l.left+l.width/3, y, 0.5)
+	l.cur -= l.separator()
+	for _, id := range ids {
+		l.lines(l.noteLines(id))
+	}
+}
+
+// loadImage returns image referenced from content, caching it.
+func (l *pdfLayout) loadImage(rel string) *pdf.Image {
+
+	if im, ok := l.images[rel]; ok {
+		return im
+	}
+	data, err := os.ReadFile(filepath.Join(l.p.tmpDir, DirContent, filepath.FromSlash(rel)))
+	if err == nil {
+		var im *pdf.Image
+		if im, err = l.doc.AddImage(data); err == nil {
+			l.images[rel] = im
+			return im
+		}
+	}
+	l.p.env.Log.Warn("Unable to load image for PDF, skipping", zap.String("ref", rel), zap.Error(err))
+	l.images[rel] = nil
+	return nil
+}
+
+// noteRef returns note id if anchor references note which should become footnote.
+func (l *pdfLayout) noteRef(e *etree.Element) string {
+	if !l.footnotes || e.Tag != "a" {
+		return ""
+	}
+	u, err := url.Parse(getAttrValue(e, "href"))
+	if err != nil || len(u.Fragment) == 0 {
+		return ""
+	}
+	if _, ok := l.p.Book.Notes[u.Fragment]; !ok {
+		return ""
+	}
+	return u.Fragment
+}
+
+func (l *pdfLayout) text(s string, st pdfInlineStyle, parts *[]pdfPart) {
+	if s = textSpacesPattern.ReplaceAllString(s, " "); len(s) > 0 {
+		*parts = append(*parts, pdfPart{text: s, st: st})
+	}
+}
+
+// inline collects text of element with inline styles applied.
+func (l *pdfLayout) inline(e *etree.Element, st pdfInlineStyle, parts *[]pdfPart) {
+	for _, t := range e.Child {
+		switch c := t.(type) {
+		case *etree.CharData:
+			l.text(c.Data, st, parts)
+		case *etree.Element:
+			l.inlineElement(c, st, parts)
+			l.text(c.Tail(), st, parts)
+		}
+	}
+}
+
+func (l *pdfLayout) inlineElement(e *etree.Element, st pdfInlineStyle, parts *[]pdfPart) {
+
+	if id := l.noteRef(e); len(id) > 0 {
+		var ref []pdfPart
+		l.inline(e, st, &ref)
+		var sb strings.Builder
+		for _, pt := range ref {
+			sb.WriteString(pt.text)
+		}
+		t := strings.ReplaceAll(strings.TrimSpace(sb.String()), " ", strNBSP)
+		if _, ok := l.labels[id]; !ok {
+			l.labels[id] = pdfRefTrim.ReplaceAllString(t, "")
+		}
+		st.scale *= 0.7
+		st.rise = 0.45
+		*parts = append(*parts, pdfPart{text: t, st: st, note: id})
+		return
+	}
+
+	switch cls := getAttrValue(e, "class"); {
+	case e.Tag == "img" || e.Tag == "svg":
+		return
+	case e.Tag == "br":
+		*parts = append(*parts, pdfPart{text: "\n", st: st})
+		return
+	case cls == "strong":
+		st.bold = true
+	case cls == "emphasis":
+		st.italic = true
+	case cls == "inlinenote":
+		st.scale *= 0.85
+	case e.Tag == "sup":
+		st.scale *= 0.7
+		st.rise = 0.45
+	case e.Tag == "sub":
+		st.scale *= 0.7
+		st.rise = -0.2
+	}
+	l.inline(e, st, parts)
+}
+
+func (l *pdfLayout) paragraph(e *etree.Element, bs pdfBlockStyle) {
+
+	var parts []pdfPart
+	l.inline(e, pdfInlineStyle{bold: bs.bold, italic: bs.italic, scale: 1}, &parts)
+
+	var hasText bool
+	for _, pt := range parts {
+		if len(strings.TrimSpace(pt.text)) > 0 {
+			hasText = true
+			break
+		}
+	}
+	if !hasText {
+		// paragraph could be just an image holder
+		for _, img := range e.SelectElements("img") {
+			if im := l.loadImage(getAttrValue(img, "src")); im != nil {
+				w, _ := im.Size()
+				l.image(im, float64(w)*pxToPt)
+			}
+		}
+		return
+	}
+	l.lines(l.typeset(parts, bs))
+}
+
+// hasInlineContent checks if division has text directly inside.
+func hasInlineContent(e *etree.Element) bool {
+	if len(strings.TrimSpace(e.Text())) > 0 {
+		return true
+	}
+	for _, c := range e.ChildElements() {
+		if IsOneOf(c.Tag, []string{"span", "a", "code", "sup", "sub", "time", "br"}) || len(strings.TrimSpace(c.Tail())) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// blocks lays out block level children of the element.
+func (l *pdfLayout) blocks(e *etree.Element, bs pdfBlockStyle) {
+
+	em := l.size
+	for _, c := range e.ChildElements() {
+
+		cls := getAttrValue(c, "class")
+		if id := getAttrValue(c, "id"); len(id) > 0 && c.Tag != "a" {
+			l.ids = append(l.ids, id)
+		}
+
+		st := bs
+		switch {
+		case c.Tag == "p" && cls == "subtitle":
+			st.bold, st.align, st.indent, st.keep = true, pdfCenter, 0, true
+			l.space(em / 2)
+			l.paragraph(c, st)
+			l.space(em / 2)
+		case c.Tag == "p" && IsOneOf(cls, []string{"title", "floatnote", "vignette_chapter_end"}):
+			st.indent = 0
+			l.paragraph(c, st)
+		case c.Tag == "p":
+			l.paragraph(c, st)
+		case c.Tag == "img":
+			if im := l.loadImage(getAttrValue(c, "src")); im != nil {
+				w, _ := im.Size()
+				l.image(im, float64(w)*pxToPt)
+			}
+		case c.Tag == "table":
+			for _, tr := range c.FindElements(".//tr") {
+				var parts []pdfPart
+				for i, td := range tr.ChildElements() {
+					if i > 0 {
+						parts = append(parts, pdfPart{text: " │ ", st: pdfInlineStyle{scale: 1}})
+					}
+					l.inline(td, pdfInlineStyle{bold: td.Tag == "th", scale: 1}, &parts)
+				}
+				st.align, st.indent = pdfLeft, 0
+				l.lines(l.typeset(parts, st))
+			}
+		case cls == "image":
+			for _, img := range c.FindElements(".//img") {
+				if im := l.loadImage(getAttrValue(img, "src")); im != nil {
+					l.image(im, l.width)
+				}
+			}
+		case strings.HasPrefix(cls, "vignette_"):
+			for _, img := range c.FindElements(".//img") {
+				if im := l.loadImage(getAttrValue(img, "src")); im != nil {
+					w, _ := im.Size()
+					l.image(im, float64(w)*pxToPt)
+				}
+			}
+		case cls == "emptyline":
+			l.space(l.lineHeight(1))
+		case cls == "chapter_end":
+			// marker only
+		case cls == "titleblock" || cls == "titleblock_nobreak":
+			if cls == "titleblock" {
+				if l.footnotes && !l.notesBottom {
+					l.flushChapter()
+				}
+				l.breakNext = true
+			}
+			st.align, st.indent, st.keep = pdfCenter, 0, true
+			l.space(em)
+			l.blocks(c, st)
+			l.space(em)
+		case textHeaderPattern.MatchString(cls):
+			level := 0
+			fmt.Sscanf(cls, "h%d", &level)
+			st.bold = true
+			st.scale = 1.8 - 0.2*float64(level)
+			if st.scale < 1.1 {
+				st.scale = 1.1
+			}
+			l.blocks(c, st)
+		case cls == "titlenotes":
+			st.bold, st.align, st.indent, st.keep = true, pdfCenter, 0, true
+			l.space(em / 2)
+			l.blocks(c, st)
+			l.space(em / 2)
+		case cls == "epigraph":
+			st.left += l.width / em / 3
+			st.italic, st.indent, st.scale = true, 0, st.scale*0.9
+			l.blocks(c, st)
+			l.space(em)
+		case cls == "annotation":
+			st.italic = true
+			l.blocks(c, st)
+		case cls == "poem":
+			st.left += 2
+			st.align, st.indent = pdfLeft, 0
+			l.space(em / 2)
+			l.blocks(c, st)
+		case cls == "stanza":
+			l.blocks(c, st)
+			l.space(em / 2)
+		case cls == "cite":
+			st.left += 2
+			st.right += 2
+			st.scale *= 0.95
+			l.space(em / 2)
+			l.blocks(c, st)
+			l.space(em / 2)
+		case cls == "blocknote":
+			st.left += 1
+			st.indent, st.scale = 0, st.scale*0.85
+			l.blocks(c, st)
+		case cls == "text-author":
+			st.italic, st.align, st.indent = true, pdfRight, 0
+			l.paragraph(c, st)
+		case hasInlineContent(c):
+			l.paragraph(c, st)
+		default:
+			l.blocks(c, st)
+		}
+	}
+}
+
+// cover puts cover image on its own page scaled to fit the whole page.
+func (l *pdfLayout) cover(body *etree.Element) {
+
+	var rel string
+	if img := body.FindElement(".//image"); img != nil {
+		rel = img.SelectAttrValue("xlink:href", "")
+	} else if img := body.FindElement(".//img"); img != nil {
+		rel = getAttrValue(img, "src")
+	}
+	if len(rel) == 0 {
+		return
+	}
+	im := l.loadImage(rel)
+	if im == nil {
+		return
+	}
+	if !l.empty {
+		l.newPage()
+	}
+	pw, ph := l.doc.Size()
+	iw, ih := im.Size()
+	w, h := pw, pw*float64(ih)/float64(iw)
+	if h > ph {
+		w, h = ph*float64(iw)/float64(ih), ph
+	}
+	l.page.Image(im, (pw-w)/2, (ph-h)/2, w, h)
+	l.empty = false
+}
+
+// loadPDFFont reads configured font or returns default one.
+func (p *Processor) loadPDFFont(name string) ([]byte, error) {
+	if len(name) == 0 {
+		return static.Asset(path.Join(DirResources, "LinLibertine_RBah.ttf"))
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(p.env.Cfg.Path, name)
+	}
+	return os.ReadFile(name)
+}
+
+func (l *pdfLayout) loadFonts() error {
+
+	cfg := l.p.env.Cfg.Doc.PDF
+
+	data, err := l.p.loadPDFFont(cfg.FontRegular)
+	if err != nil {
+		return fmt.Errorf("unable to load PDF font: %w", err)
+	}
+	regular, err := l.doc.AddFont(data)
+	if err != nil {
+		return fmt.Errorf("unable to load PDF font: %w", err)
+	}
+	optional := func(name string) (*pdf.Font, error) {
+		if len(name) == 0 {
+			return nil, nil
+		}
+		data, err := l.p.loadPDFFont(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load PDF font: %w", err)
+		}
+		return l.doc.AddFont(data)
+	}
+	bold, err := optional(cfg.FontBold)
+	if err != nil {
+		return err
+	}
+	italic, err := optional(cfg.FontItalic)
+	if err != nil {
+		return err
+	}
+	boldItalic, err := optional(cfg.FontBoldItalic)
+	if err != nil {
+		return err
+	}
+
+	// styles missing in configuration are simulated
+	l.fonts[0][0] = regular
+	l.fonts[1][0], l.fake[1][0] = regular, [2]bool{true, false}
+	if bold != nil {
+		l.fonts[1][0], l.fake[1][0] = bold, [2]bool{false, false}
+	}
+	l.fonts[0][1], l.fake[0][1] = regular, [2]bool{false, true}
+	if italic != nil {
+		l.fonts[0][1], l.fake[0][1] = italic, [2]bool{false, false}
+	}
+	switch {
+	case boldItalic != nil:
+		l.fonts[1][1] = boldItalic
+	case bold != nil:
+		l.fonts[1][1], l.fake[1][1] = bold, [2]bool{false, true}
+	case italic != nil:
+		l.fonts[1][1], l.fake[1][1] = italic, [2]bool{true, false}
+	default:
+		l.fonts[1][1], l.fake[1][1] = regular, [2]bool{true, true}
+	}
+	return nil
+}
+
+// generatePDF lays out all previously generated content files.
+func (p *Processor) generatePDF() (*pdf.Document, error) {
+
+	p.env.Log.Debug("Generating PDF - start")
+	defer func(start time.Time) {
+		p.env.Log.Debug("Generating PDF - done", zap.Duration("elapsed", time.Since(start)))
+	}(time.Now())
+
+	cfg := p.env.Cfg.Doc.PDF
+
+	pw, ph := cfg.PageWidth*mmToPt, cfg.PageHeight*mmToPt
+	l := &pdfLayout{
+		p:         p,
+		doc:       pdf.New(pw, ph),
+		size:      cfg.FontSize,
+		spacing:   cfg.LineSpacing,
+		width:     pw - (cfg.MarginLeft+cfg.MarginRight)*mmToPt,
+		left:      cfg.MarginLeft * mmToPt,
+		top:       cfg.MarginTop * mmToPt,
+		bottom:    cfg.MarginBottom * mmToPt,
+		footnotes: p.notesMode != NInline && p.notesMode != NBlock,
+		placed:    make(map[string]bool),
+		labels:    make(map[string]string),
+		anchors:   make(map[string]pdfAnchor),
+		images:    make(map[string]*pdf.Image),
+	}
+	if l.size <= 0 || l.spacing <= 0 || l.width < 10*l.size || ph-l.top-l.bottom < 10*l.lineHeight(1) {
+		return nil, fmt.Errorf("PDF page does not have enough space for text, check page size, margins and font size")
+	}
+	switch cfg.Notes {
+	case pdfNotesBottom:
+		l.notesBottom = true
+	case pdfNotesChapterEnd:
+	default:
+		p.env.Log.Warn("Unknown PDF notes placement, using default", zap.String("placement", cfg.Notes))
+		l.notesBottom = true
+	}
+	if err := l.loadFonts(); err != nil {
+		return nil, err
+	}
+
+	l.doc.SetLang(p.Book.Lang.String())
+	l.doc.SetInfo("Title", p.Book.Title)
+	l.doc.SetInfo("Author", p.Book.BookAuthors(p.env.Cfg.Doc.AuthorFormatMeta, false))
+	l.doc.SetInfo("Subject", strings.Join(p.Book.Subjects, ", "))
+	l.doc.SetInfo("Creator", "fb2converter")
+
+	// when notes become footnotes there is no need for notes bodies
+	var skip []string
+	if l.footnotes {
+		for _, n := range p.Book.Notes {
+			skip = append(skip, GenSafeName(n.bodyName)+".xhtml")
+		}
+	}
+
+	l.newPage()
+	for _, f := range p.Book.Files {
+		if f.doc == nil || f.ct != "application/xhtml+xml" || filepath.Ext(f.fname) != ".xhtml" || f.transient&dataNotForSpline != 0 {
+			continue
+		}
+		if f.id == "toc" || IsOneOf(f.fname, skip) {
+			continue
+		}
+		body := f.doc.FindElement("./html/body")
+		if body == nil {
+			continue
+		}
+		if f.id == "cover-page" {
+			l.cover(body)
+			continue
+		}
+		// every content file starts on a new page, the same way e-book readers do it
+		l.breakNext = true
+		l.blocks(body, pdfBlockStyle{align: pdfJustify, indent: 1.5, scale: 1})
+		l.flushChapter()
+	}
+	l.final = true
+	for len(l.carry) > 0 {
+		l.newPage()
+	}
+	l.finishPage()
+
+	// bookmarks
+	type level struct {
+		level int
+		o     *pdf.Outline
+	}
+	var stack []level
+	for _, te := range p.Book.TOC {
+		u, err := url.Parse(te.ref)
+		if err != nil {
+			continue
+		}
+		a, ok := l.anchors[u.Fragment]
+		if !ok {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= te.level.Int() {
+			stack = stack[:len(stack)-1]
+		}
+		var parent *pdf.Outline
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].o
+		}
+		stack = append(stack, level{level: te.level.Int(), o: l.doc.AddOutline(parent, AllLines(te.title), a.page, a.top)})
+	}
+	return l.doc, nil
+}
+
+// FinalizePDF produces PDF file out of previously generated content.
+func (p *Processor) FinalizePDF(fname string) error {
+
+	if err := p.PrepareOutputFile(fname); err != nil {
+		return err
+	}
+
+	doc, err := p.generatePDF()
+	if err != nil {
+		return err
+	}
+	if err := doc.WriteToFile(fname); err != nil {
+		return fmt.Errorf("unable to write PDF (%s): %w", fname, err)
+	}
+	return nil
+}
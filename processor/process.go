@@ -23,7 +23,6 @@ import (
 	"golang.org/x/net/html/charset"
 	"golang.org/x/text/language"
 	"golang.org/x/text/language/display"
-	"gopkg.in/gomail.v2"
 
 	"fb2converter/config"
 	"fb2converter/etree"
@@ -60,8 +59,9 @@ type Processor struct {
 	// what kind of processing is expected
 	kind InputFmt
 	// input parameters
-	src string
-	dst string
+	src     string
+	dst     string
+	srcFile string // actual file when book is read directly from file system
 	// parameters translated to internal types
 	nodirs         bool
 	stk            bool
@@ -73,6 +73,8 @@ type Processor struct {
 	kindlePageMap  APNXGeneration
 	stampPlacement StampPlacement
 	coverResize    CoverProcessing
+	seriesPrimary  SeriesSelection
+	genreSubjects  GenreSubjects
 	// working directory
 	tmpDir string
 	// input document
@@ -85,6 +87,7 @@ type Processor struct {
 	speechTransform *config.Transformation
 	dashTransform   *config.Transformation
 	metaOverwrite   *config.MetaInfo
+	rawID           string
 	kindlegenPath   string
 }
 
@@ -139,6 +142,22 @@ func NewFB2(r io.Reader, unknownEncoding bool, src, dst string, nodirs, stk, ove
 			resize = CoverNone
 		}
 	}
+	var series SeriesSelection
+	if len(env.Cfg.Doc.SeriesPrimary) > 0 {
+		series = ParseSeriesSelectionString(env.Cfg.Doc.SeriesPrimary)
+		if series == UnsupportedSeriesSelection {
+			env.Log.Warn("Unknown primary series selection requested, using first", zap.String("series", env.Cfg.Doc.SeriesPrimary))
+			series = SeriesFirst
+		}
+	}
+	var subjects GenreSubjects
+	if len(env.Cfg.Doc.Genres.Subjects) > 0 {
+		subjects = ParseGenreSubjectsString(env.Cfg.Doc.Genres.Subjects)
+		if subjects == UnsupportedGenreSubjects {
+			env.Log.Warn("Unknown genre subjects requested, using names", zap.String("subjects", env.Cfg.Doc.Genres.Subjects))
+			subjects = SubjectName
+		}
+	}
 
 	p := &Processor{
 		kind:            InFb2,
@@ -154,16 +173,17 @@ func NewFB2(r io.Reader, unknownEncoding bool, src, dst string, nodirs, stk, ove
 		kindlePageMap:   apnx,
 		stampPlacement:  stamp,
 		coverResize:     resize,
+		seriesPrimary:   series,
+		genreSubjects:   subjects,
 		doc:             etree.NewDocument(),
 		Book:            NewBook(u, filepath.Base(src)),
 		env:             env,
 		speechTransform: env.Cfg.GetTransformation("speech"),
 		dashTransform:   env.Cfg.GetTransformation("dashes"),
-		metaOverwrite:   env.Cfg.GetOverwrite(src),
 	}
 	p.doc.WriteSettings = etree.WriteSettings{CanonicalText: true, CanonicalAttrVal: true}
 
-	if kindle {
+	if kindle && !(format == OMobi && env.Cfg.Doc.Kindlegen.NativeMOBI) {
 		// Fail early
 		if p.kindlegenPath, err = env.Cfg.GetKindlegenPath(); err != nil {
 			return nil, err
@@ -214,6 +234,11 @@ func NewFB2(r io.Reader, unknownEncoding bool, src, dst string, nodirs, stk, ove
 	return p, nil
 }
 
+// SetSourceFile lets processor know actual book file, so per-book meta information sidecars could be found.
+func (p *Processor) SetSourceFile(fname string) {
+	p.srcFile = fname
+}
+
 // Process does all the work.
 func (p *Processor) Process() error {
 
@@ -228,6 +253,7 @@ func (p *Processor) Process() error {
 	if err := p.processDescription(); err != nil {
 		return err
 	}
+	p.processGenres()
 	if err := p.processNotes(); err != nil {
 		return err
 	}
@@ -295,103 +321,40 @@ func (p *Processor) Save() (string, error) {
 
 	fname := p.prepareOutputName()
 
-	var err error
-	switch p.format {
-	case OEpub:
-		err = p.FinalizeEPUB(fname)
-	case OKepub:
-		err = p.FinalizeKEPUB(fname)
-	case OMobi:
-		err = p.FinalizeMOBI(fname)
-	case OAzw3:
-		err = p.FinalizeAZW3(fname)
+	e := lookupFormat(p.format)
+	if e == nil {
+		return fname, fmt.Errorf("unsupported output format: %s", p.format)
 	}
+	err := e.fin.Finalize(p, fname)
 	return fname, err
 }
 
-// SendToKindle will mail converted file to specified address and remove file if requested.
-func (p *Processor) SendToKindle(fname string) error {
-
-	if !p.stk || p.format != OEpub || len(fname) == 0 {
-		return nil
-	}
+// Deliver hands converted file to configured delivery targets.
+func (p *Processor) Deliver(fname string) error {
 
-	if !p.env.Cfg.SMTPConfig.IsValid() {
-		p.env.Log.Warn("Configuration for Send To Kindle is incorrect, skipping", zap.Any("configuration", p.env.Cfg.SMTPConfig))
+	if len(fname) == 0 || p.env.Dlv == nil {
 		return nil
 	}
 
-	p.env.Log.Debug("Sending content to Kindle - starting",
-		zap.String("from", p.env.Cfg.SMTPConfig.From),
-		zap.String("to", p.env.Cfg.SMTPConfig.To),
-		zap.String("file", fname),
-	)
-	defer func(start time.Time) {
-		p.env.Log.Debug("Sending content to Kindle - done", zap.Duration("elapsed", time.Since(start)))
-	}(time.Now())
-
-	// NOTE: Content-Type and Content-Disposition headers require special encoding (rfc2231/rfc5987/rfc8187)
-
-	ext := filepath.Ext(fname)
-	fullname := strings.TrimSuffix(filepath.Base(fname), ext)
-	safename := slug.Make(fullname)
-
-	m := gomail.NewMessage(gomail.SetCharset("UTF-8"), gomail.SetEncoding(gomail.Base64))
-	m.SetAddressHeader("From", p.env.Cfg.SMTPConfig.From, "fb2converter ")
-	m.SetAddressHeader("To", p.env.Cfg.SMTPConfig.To, "kindle device")
-	m.SetHeader("Subject", "Sent to Kindle: "+fullname+ext)
-	m.SetBody("text/plain", "This email has been sent by fb2converter")
-	m.Attach(fname,
-		gomail.Rename(safename+ext),
-		gomail.SetHeader(
-			map[string][]string{
-				"Content-Type":        {`application/epub+zip; name="` + mime.BEncoding.Encode("UTF-8", fullname+ext) + `"`},
-				"Content-Disposition": {`attachment; ` + EncodeContentDispFilename(safename+ext, fullname+ext)},
-			},
-		),
-	)
-
-	// debugging
-	if p.env.Rpt != nil {
-		var sf gomail.SendFunc = func(from string, to []string, m io.WriterTo) error {
-			buf, err := os.Create(filepath.Join(p.tmpDir, safename+".mail"))
-			if err != nil {
-				return err
-			}
-			defer buf.Close()
-			_, err = m.WriteTo(buf)
-			if err != nil {
-				return err
-			}
-			return nil
-		}
-		if err := gomail.Send(sf, m); err != nil {
-			return err
-		}
+	var root string
+	if !p.nodirs {
+		root = p.dst
 	}
+	return p.env.Dlv.Deliver(fname, root, p.format.String())
+}
 
-	// real send
-	d := gomail.NewDialer(p.env.Cfg.SMTPConfig.Server, p.env.Cfg.SMTPConfig.Port, p.env.Cfg.SMTPConfig.User, p.env.Cfg.SMTPConfig.Password)
+// SendToKindle will queue converted file to be mailed to specified address.
+func (p *Processor) SendToKindle(fname string) error {
 
-	if err := d.DialAndSend(m); err != nil {
-		return fmt.Errorf("SentToKindle failed: %w", err)
+	if !p.stk || p.format != OEpub || len(fname) == 0 || p.env.Stk == nil {
+		return nil
 	}
 
-	if p.env.Cfg.SMTPConfig.DeleteOnSuccess {
-		p.env.Log.Debug("Deleting after send", zap.String("location", fname))
-		if err := os.Remove(fname); err != nil {
-			p.env.Log.Warn("Unable to delete after send", zap.String("location", fname), zap.Error(err))
-		}
-		if !p.nodirs {
-			// remove all empty directories in the path following p.dst
-			for outDir := filepath.Dir(fname); outDir != p.dst; outDir = filepath.Dir(outDir) {
-				if err := os.Remove(outDir); err != nil {
-					p.env.Log.Warn("Unable to delete after send", zap.String("location", outDir), zap.Error(err))
-				}
-			}
-		}
+	var root string
+	if !p.nodirs {
+		root = p.dst
 	}
-	return nil
+	return p.env.Stk.Add(fname, root)
 }
 
 // Clean removes temporary files left after processing.
@@ -406,23 +369,26 @@ func (p *Processor) Clean() error {
 
 // prepareOutputName generates output file name.
 func (p *Processor) prepareOutputName() string {
+	return outputName(p.Book, p.src, p.dst, p.nodirs, p.kind == InFb2, p.format.Extension(), p.env)
+}
+
+// outputName generates file name for the book with extension ext, when useFormat is set and "file_name_format" is
+// configured name is produced from the book description, otherwise source name is used.
+func outputName(b *Book, src, dst string, nodirs, useFormat bool, ext string, env *state.LocalEnv) string {
 
 	var outDir string
-	if !p.nodirs {
-		outDir = filepath.Dir(p.src)
+	if !nodirs {
+		outDir = filepath.Dir(src)
 	}
-	outDir = filepath.Join(p.dst, outDir)
+	outDir = filepath.Join(dst, outDir)
 
-	name := strings.TrimSuffix(filepath.Base(p.src), filepath.Ext(p.src))
-	if p.env.Cfg.Doc.FileNameTransliterate {
+	name := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	if env.Cfg.Doc.FileNameTransliterate {
 		name = slug.Make(name)
 	}
-	outFile := config.CleanFileName(name) + "." + p.format.String()
-	if p.format == OKepub {
-		outFile += "." + OEpub.String()
-	}
+	outFile := config.CleanFileName(name) + "." + ext
 
-	if p.kind == InFb2 && len(p.env.Cfg.Doc.FileNameFormat) > 0 {
+	if useFormat && len(env.Cfg.Doc.FileNameFormat) > 0 {
 
 		insertDir := func(dirs []string, dir string) []string {
 			dirs = append(dirs, "")
@@ -431,22 +397,19 @@ func (p *Processor) prepareOutputName() string {
 			return dirs
 		}
 
-		name = filepath.FromSlash(ReplaceKeywords(p.env.Cfg.Doc.FileNameFormat, CreateFileNameKeywordsMap(p.Book, p.env.Cfg.Doc.AuthorFormatFileName, p.env.Cfg.Doc.SeqNumPos)))
+		name = filepath.FromSlash(ReplaceKeywords(env.Cfg.Doc.FileNameFormat, CreateFileNameKeywordsMap(b, env.Cfg.Doc.AuthorFormatFileName, env.Cfg.Doc.SeqNumPos)))
 		if len(name) > 0 {
 			first := true
 			dirs := make([]string, 0, 16)
 			for head, tail := filepath.Split(strings.TrimSuffix(name, string(os.PathSeparator))); ; head, tail = filepath.Split(strings.TrimSuffix(head, string(os.PathSeparator))) {
 				if first {
-					if p.env.Cfg.Doc.FileNameTransliterate {
+					if env.Cfg.Doc.FileNameTransliterate {
 						tail = slug.Make(tail)
 					}
-					outFile = config.CleanFileName(tail) + "." + p.format.String()
-					if p.format == OKepub {
-						outFile += "." + OEpub.String()
-					}
+					outFile = config.CleanFileName(tail) + "." + ext
 					first = false
 				} else {
-					if p.env.Cfg.Doc.FileNameTransliterate {
+					if env.Cfg.Doc.FileNameTransliterate {
 						tail = slug.Make(tail)
 					}
 					dirs = insertDir(dirs, config.CleanFileName(tail))
@@ -462,6 +425,104 @@ func (p *Processor) prepareOutputName() string {
 	return filepath.Join(outDir, outFile)
 }
 
+// parseAuthorName reads author-like element (author, translator), returns nil if there is no name.
+func parseAuthorName(e *etree.Element) *config.AuthorName {
+	var (
+		an       = new(config.AuthorName)
+		notEmpty bool
+	)
+	for _, f := range []struct {
+		tag string
+		val *string
+	}{
+		{"first-name", &an.First},
+		{"middle-name", &an.Middle},
+		{"last-name", &an.Last},
+	} {
+		if n := e.SelectElement(f.tag); n != nil {
+			if v := strings.TrimSpace(n.Text()); len(v) > 0 {
+				*f.val = v
+				notEmpty = true
+			}
+		}
+	}
+	if !notEmpty {
+		return nil
+	}
+	return an
+}
+
+// parseSequence adds sequence and all sequences nested in it to the list.
+func (p *Processor) parseSequence(e *etree.Element, level int, publisher bool, list []*Sequence) []*Sequence {
+
+	s := &Sequence{Name: strings.TrimSpace(getAttrValue(e, "name")), Level: level, Publisher: publisher}
+	if num := strings.TrimSpace(getAttrValue(e, "number")); len(num) > 0 {
+		if !govalidator.IsNumeric(num) {
+			p.env.Log.Warn("Sequence number is not an integer, ignoring", zap.String("xml", getXMLFragmentFromElement(e, true)))
+		} else if n, err := strconv.Atoi(num); err != nil {
+			p.env.Log.Warn("Unable to parse sequence number, ignoring", zap.String("number", num), zap.Error(err))
+		} else {
+			s.Num = n
+		}
+	}
+	if len(s.Name) > 0 {
+		list = append(list, s)
+	}
+	for _, c := range e.SelectElements("sequence") {
+		list = p.parseSequence(c, level+1, publisher, list)
+	}
+	return list
+}
+
+// primarySequence selects sequence which describes the book according to configuration.
+func (p *Processor) primarySequence() *Sequence {
+
+	var first, nested, publisher *Sequence
+	for i, s := range p.Book.Sequences {
+		switch {
+		case s.Publisher:
+			if publisher == nil {
+				publisher = s
+			}
+		case first == nil:
+			first, nested = s, s
+		case nested == p.Book.Sequences[i-1] && s.Level > nested.Level:
+			// follow the chain of first children only
+			nested = s
+		}
+	}
+
+	switch p.seriesPrimary {
+	case SeriesNested:
+		return nested
+	case SeriesPublisher:
+		if publisher != nil {
+			return publisher
+		}
+	}
+	// publisher series are never primary unless explicitly requested
+	return first
+}
+
+// additionalSequences returns unique sequences other than primary one.
+func (p *Processor) additionalSequences() []*Sequence {
+
+	type key struct {
+		name string
+		num  int
+	}
+	seen := map[key]bool{{p.Book.SeqName, p.Book.SeqNum}: true}
+
+	var res []*Sequence
+	for _, s := range p.Book.Sequences {
+		if k := (key{s.Name, s.Num}); !seen[k] {
+			seen[k] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
 // processDescription processes book description element.
 func (p *Processor) processDescription() error {
 
@@ -478,15 +539,27 @@ func (p *Processor) processDescription() error {
 			zap.String("authors", p.Book.BookAuthors(p.env.Cfg.Doc.AuthorFormat, false)),
 			zap.String("sequence", p.Book.SeqName),
 			zap.Int("sequence number", p.Book.SeqNum),
+			zap.Int("sequences", len(p.Book.Sequences)),
 			zap.String("date", p.Book.Date),
+			zap.String("translators", p.Book.BookTranslators(p.env.Cfg.Doc.AuthorFormat, false)),
+			zap.Strings("keywords", p.Book.Keywords),
+			zap.String("publisher", p.Book.Publisher),
+			zap.String("city", p.Book.City),
+			zap.String("year", p.Book.Year),
+			zap.String("isbn", p.Book.ISBN),
+			zap.String("source title", p.Book.SrcTitle),
+			zap.String("source lang", p.Book.SrcLang),
+			zap.Any("custom info", p.Book.CustomInfo),
 		)
 	}(time.Now())
 
+	var annotation *etree.Element
 	for _, desc := range p.doc.FindElements("./FictionBook/description") {
 
 		if info := desc.SelectElement("document-info"); info != nil {
 			if id := info.SelectElement("id"); id != nil {
 				text := strings.TrimSpace(id.Text())
+				p.rawID = text
 				if u, err := uuid.Parse(text); err == nil {
 					p.Book.ID = u
 				} else {
@@ -518,7 +591,7 @@ func (p *Processor) processDescription() error {
 						}
 					}
 					p.Book.Lang = t
-					if p.env.Cfg.Doc.Hyphenate {
+					if p.env.Cfg.Doc.Hyphenate || p.format == OPdf {
 						p.Book.hyph = newHyph(t, p.env.Log)
 					}
 					if p.format == OKepub {
@@ -544,81 +617,99 @@ func (p *Processor) processDescription() error {
 				}
 			}
 			for _, e := range info.SelectElements("author") {
-				var (
-					an       = new(config.AuthorName)
-					notEmpty bool
-				)
-				if n := e.SelectElement("first-name"); n != nil {
-					if f := strings.TrimSpace(n.Text()); len(f) > 0 {
-						an.First = f
-						notEmpty = true
-					}
-				}
-				if n := e.SelectElement("middle-name"); n != nil {
-					if m := strings.TrimSpace(n.Text()); len(m) > 0 {
-						an.Middle = m
-						notEmpty = true
-					}
-				}
-				if n := e.SelectElement("last-name"); n != nil {
-					if l := strings.TrimSpace(n.Text()); len(l) > 0 {
-						an.Last = l
-						notEmpty = true
-					}
-				}
-				if notEmpty {
+				if an := parseAuthorName(e); an != nil {
 					p.Book.Authors = append(p.Book.Authors, an)
 				}
 			}
-			if e := info.SelectElement("sequence"); e != nil {
-				var err error
-				p.Book.SeqName = getAttrValue(e, "name")
-				num := getAttrValue(e, "number")
-				if len(num) > 0 {
-					if !govalidator.IsNumeric(num) {
-						p.env.Log.Warn("Sequence number is not an integer, ignoring", zap.String("xml", getXMLFragmentFromElement(e, true)))
-					} else {
-						p.Book.SeqNum, err = strconv.Atoi(num)
-						if err != nil {
-							p.env.Log.Warn("Unable to parse sequence number, ignoring", zap.String("number", getAttrValue(e, "number")), zap.Error(err))
-						}
+			for _, e := range info.SelectElements("translator") {
+				if an := parseAuthorName(e); an != nil {
+					p.Book.Translators = append(p.Book.Translators, an)
+				}
+			}
+			if e := info.SelectElement("keywords"); e != nil {
+				for _, k := range strings.Split(e.Text(), ",") {
+					if k = strings.TrimSpace(k); len(k) > 0 {
+						p.Book.Keywords = append(p.Book.Keywords, k)
 					}
 				}
 			}
+			for _, e := range info.SelectElements("sequence") {
+				p.Book.Sequences = p.parseSequence(e, 0, false, p.Book.Sequences)
+			}
 			if e := info.SelectElement("annotation"); e != nil {
 				p.Book.Annotation = getTextFragment(e)
-				if p.env.Cfg.Doc.Annotation.Create {
-					to, f := p.ctx().createXHTML("annotation", attr("xmlns", `http://www.w3.org/1999/xhtml`))
-					inner := to.AddNext("div", attr("class", "annotation"))
-					inner.AddNext("div", attr("class", "h1")).SetText(p.env.Cfg.Doc.Annotation.Title)
-					if err := p.transfer(e, inner, "div"); err != nil {
-						p.env.Log.Warn("Unable to parse annotation", zap.String("path", e.GetPath()), zap.Error(err))
-					} else {
-						p.Book.Files = append(p.Book.Files, f)
-						if p.env.Cfg.Doc.Annotation.AddToToc {
-							tocRefID := fmt.Sprintf("tocref%d", p.ctx().tocIndex)
-							inner.CreateAttr("id", tocRefID)
-							p.Book.TOC = append(p.Book.TOC, &tocEntry{
-								ref:      p.ctx().fname + "#" + tocRefID,
-								title:    p.env.Cfg.Doc.Annotation.Title,
-								level:    p.ctx().header,
-								bodyName: p.ctx().bodyName,
-							})
-							p.ctx().tocIndex++
-						}
-					}
-				}
+				annotation = e
 			}
 			if e := info.SelectElement("date"); e != nil {
 				p.Book.Date = getTextFragment(e)
 			}
 		}
+		if info := desc.SelectElement("src-title-info"); info != nil {
+			if e := info.SelectElement("book-title"); e != nil {
+				p.Book.SrcTitle = strings.TrimSpace(e.Text())
+			}
+			if e := info.SelectElement("lang"); e != nil {
+				p.Book.SrcLang = strings.TrimSpace(e.Text())
+			}
+		}
+		if info := desc.SelectElement("publish-info"); info != nil {
+			for _, f := range []struct {
+				tag string
+				val *string
+			}{
+				{"publisher", &p.Book.Publisher},
+				{"city", &p.Book.City},
+				{"year", &p.Book.Year},
+				{"isbn", &p.Book.ISBN},
+			} {
+				if e := info.SelectElement(f.tag); e != nil {
+					*f.val = strings.TrimSpace(e.Text())
+				}
+			}
+			for _, e := range info.SelectElements("sequence") {
+				p.Book.Sequences = p.parseSequence(e, 0, true, p.Book.Sequences)
+			}
+		}
+		for _, e := range desc.SelectElements("custom-info") {
+			t, v := strings.TrimSpace(getAttrValue(e, "info-type")), strings.TrimSpace(e.Text())
+			if len(t) == 0 || len(v) == 0 {
+				continue
+			}
+			if prev, ok := p.Book.CustomInfo[t]; ok {
+				v = prev + "; " + v
+			}
+			p.Book.CustomInfo[t] = v
+		}
+	}
+
+	if s := p.primarySequence(); s != nil {
+		p.Book.SeqName, p.Book.SeqNum = s.Name, s.Num
 	}
 
 	// Let's see if we need to correct any meta information - always comes last
-	if p.metaOverwrite == nil {
-		return nil
+	ref := &config.BookRef{
+		Path:    p.src,
+		File:    p.srcFile,
+		ID:      p.rawID,
+		UUID:    p.Book.ID.String(),
+		Title:   p.Book.Title,
+		Authors: p.Book.Authors,
 	}
+	var err error
+	if p.metaOverwrite, err = p.env.Cfg.GetOverwrite(ref); err != nil {
+		p.env.Log.Warn("Unable to get meta information overwrite, ignoring", zap.Error(err))
+	}
+	if p.metaOverwrite != nil {
+		annotation = p.applyMetaOverwrite(annotation)
+	}
+	if annotation != nil && p.env.Cfg.Doc.Annotation.Create {
+		p.createAnnotation(annotation)
+	}
+	return nil
+}
+
+// applyMetaOverwrite replaces parsed description values, returns annotation to be used.
+func (p *Processor) applyMetaOverwrite(annotation *etree.Element) *etree.Element {
 
 	if len(p.metaOverwrite.ID) > 0 {
 		if u, err := uuid.Parse(strings.TrimSpace(p.metaOverwrite.ID)); err == nil {
@@ -640,7 +731,7 @@ func (p *Processor) processDescription() error {
 			if t, err := language.Parse(l); err == nil {
 				p.Book.Lang = t
 				p.env.Log.Info("Meta overwrite", zap.Stringer("lang", p.Book.Lang))
-				if p.env.Cfg.Doc.Hyphenate {
+				if p.env.Cfg.Doc.Hyphenate || p.format == OPdf {
 					p.Book.hyph = newHyph(t, p.env.Log)
 				}
 			}
@@ -674,7 +765,36 @@ func (p *Processor) processDescription() error {
 		p.Book.Date = date
 		p.env.Log.Info("Meta overwrite", zap.String("date", p.Book.Date))
 	}
-	return nil
+	if text := strings.TrimSpace(p.metaOverwrite.Annotation); len(text) > 0 {
+		annotation = annotationFromText(text)
+		p.Book.Annotation = getTextFragment(annotation)
+		p.env.Log.Info("Meta overwrite", zap.String("annotation", p.Book.Annotation))
+	}
+	return annotation
+}
+
+// createAnnotation adds separate annotation page to the book.
+func (p *Processor) createAnnotation(e *etree.Element) {
+
+	to, f := p.ctx().createXHTML("annotation", attr("xmlns", `http://www.w3.org/1999/xhtml`))
+	inner := to.AddNext("div", attr("class", "annotation"))
+	inner.AddNext("div", attr("class", "h1")).SetText(p.env.Cfg.Doc.Annotation.Title)
+	if err := p.transfer(e, inner, "div"); err != nil {
+		p.env.Log.Warn("Unable to parse annotation", zap.String("path", e.GetPath()), zap.Error(err))
+		return
+	}
+	p.Book.Files = append(p.Book.Files, f)
+	if p.env.Cfg.Doc.Annotation.AddToToc {
+		tocRefID := fmt.Sprintf("tocref%d", p.ctx().tocIndex)
+		inner.CreateAttr("id", tocRefID)
+		p.Book.TOC = append(p.Book.TOC, &tocEntry{
+			ref:      p.ctx().fname + "#" + tocRefID,
+			title:    p.env.Cfg.Doc.Annotation.Title,
+			level:    p.ctx().header,
+			bodyName: p.ctx().bodyName,
+		})
+		p.ctx().tocIndex++
+	}
 }
 
 // processBodies processes book bodies, including main one.
@@ -884,6 +1004,9 @@ func (p *Processor) processBinaries() error {
 				b.flags |= imageScale
 				b.scaleFactor = p.env.Cfg.Doc.ImagesScaleFactor
 			}
+			if p.env.Cfg.Doc.ImagesGrayscale && (imgType == "png" || imgType == "jpeg") {
+				b.flags |= imageGrayscale
+			}
 		}
 		p.Book.Images = append(p.Book.Images, b)
 	}
@@ -953,7 +1076,7 @@ func (p *Processor) processImages() error {
 						}
 					}
 					// NOTE: We will process cover separately
-					b.flags &= ^imageScale
+					b.flags &= ^(imageScale | imageGrayscale)
 					b.scaleFactor = 0
 				}
 			}
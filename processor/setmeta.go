@@ -0,0 +1,358 @@
+package processor
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/html/charset"
+
+	"fb2converter/config"
+	"fb2converter/etree"
+)
+
+// Order of elements as required by FB2 schema.
+var (
+	descriptionOrder  = []string{"title-info", "src-title-info", "document-info", "publish-info", "custom-info", "output"}
+	titleInfoOrder    = []string{"genre", "author", "book-title", "annotation", "keywords", "date", "coverpage", "lang", "src-lang", "translator", "sequence"}
+	documentInfoOrder = []string{"author", "program-used", "date", "src-url", "src-ocr", "id", "version", "history", "publisher"}
+)
+
+const xlinkNS = "http://www.w3.org/1999/xlink"
+
+// annotationFromText creates FB2 annotation element, every non-empty line of text becomes paragraph.
+func annotationFromText(text string) *etree.Element {
+	a := etree.NewElement("annotation")
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); len(l) > 0 {
+			a.AddNext("p").SetText(l)
+		}
+	}
+	return a
+}
+
+// childIndent returns whitespace used to separate children of the element, if any.
+func childIndent(parent *etree.Element) string {
+	if len(parent.Child) > 0 {
+		if cd, ok := parent.Child[0].(*etree.CharData); ok && len(strings.TrimSpace(cd.Data)) == 0 {
+			return cd.Data
+		}
+	}
+	return ""
+}
+
+// replaceChildren removes all children with the tag and puts new elements in their place, when there were no such
+// children new elements are placed according to schema order.
+func replaceChildren(parent *etree.Element, order []string, tag string, elems ...*etree.Element) {
+
+	indent := childIndent(parent)
+	old := parent.SelectElements(tag)
+
+	var ex *etree.Element
+	if len(old) > 0 {
+		ex = old[0]
+	} else {
+		pos := indexOf(tag, order)
+		for _, c := range parent.ChildElements() {
+			if indexOf(c.Tag, order) > pos {
+				ex = c
+				break
+			}
+		}
+	}
+
+	for i, e := range elems {
+		switch {
+		case ex == nil:
+			appendChild(parent, e)
+		case i == len(elems)-1 && len(old) > 0:
+			e.SetTail(old[len(old)-1].Tail())
+			parent.InsertChild(ex, e)
+		default:
+			e.SetTail(indent)
+			parent.InsertChild(ex, e)
+		}
+	}
+	for _, e := range old {
+		parent.RemoveChild(e)
+	}
+}
+
+// appendChild adds element as the last child, new element takes over closing whitespace.
+func appendChild(parent, e *etree.Element) {
+	if children := parent.ChildElements(); len(children) > 0 {
+		last := children[len(children)-1]
+		e.SetTail(last.Tail())
+		last.SetTail(childIndent(parent))
+	}
+	parent.AddChild(e)
+}
+
+// indexOf returns position of name in the list or -1.
+func indexOf(name string, names []string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// textElement creates element with text.
+func textElement(tag, text string) *etree.Element {
+	e := etree.NewElement(tag)
+	e.SetText(text)
+	return e
+}
+
+// xlinkPrefix returns namespace prefix for xlink declared in the document, declaring it when necessary.
+func xlinkPrefix(root *etree.Element) string {
+	for _, a := range root.Attr {
+		if a.Space == "xmlns" && a.Value == xlinkNS {
+			return a.Key
+		}
+	}
+	root.CreateAttr("xmlns:l", xlinkNS)
+	return "l"
+}
+
+// hrefAttr returns link attribute of the element regardless of its namespace prefix.
+func hrefAttr(e *etree.Element) *etree.Attr {
+	for i := range e.Attr {
+		if e.Attr[i].Key == "href" {
+			return &e.Attr[i]
+		}
+	}
+	return nil
+}
+
+// removeUnusedBinary removes binary with id if nothing refers to it.
+func removeUnusedBinary(root *etree.Element, id string) {
+	if len(id) == 0 {
+		return
+	}
+	for _, e := range root.FindElements(".//*") {
+		if a := hrefAttr(e); a != nil && a.Value == "#"+id {
+			return
+		}
+	}
+	for _, b := range root.SelectElements("binary") {
+		if getAttrValue(b, "id") == id {
+			root.RemoveChild(b)
+		}
+	}
+}
+
+// setCover replaces book cover with image from file or removes it.
+func setCover(root, info *etree.Element, fname string) error {
+
+	var oldID string
+	cp := info.SelectElement("coverpage")
+	if cp != nil {
+		if img := cp.SelectElement("image"); img != nil {
+			if a := hrefAttr(img); a != nil {
+				oldID = strings.TrimPrefix(a.Value, "#")
+			}
+		}
+	}
+
+	if fname == "remove cover" {
+		if cp != nil {
+			info.RemoveChild(cp)
+		}
+		removeUnusedBinary(root, oldID)
+		return nil
+	}
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("unable to read cover image: %w", err)
+	}
+	var ext string
+	ct := http.DetectContentType(data)
+	switch ct {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	case "image/gif":
+		ext = ".gif"
+	default:
+		return fmt.Errorf("unsupported cover image type %s (%s)", ct, fname)
+	}
+
+	ids := make(map[string]bool)
+	for _, b := range root.SelectElements("binary") {
+		ids[getAttrValue(b, "id")] = true
+	}
+	id := "cover" + ext
+	for i := 1; ids[id]; i++ {
+		id = "cover" + strconv.Itoa(i) + ext
+	}
+
+	bin := etree.NewElement("binary")
+	bin.CreateAttr("id", id)
+	bin.CreateAttr("content-type", ct)
+	bin.SetText(base64.StdEncoding.EncodeToString(data))
+	appendChild(root, bin)
+
+	img := etree.NewElement("image")
+	img.CreateAttr(xlinkPrefix(root)+":href", "#"+id)
+	cp = etree.NewElement("coverpage")
+	cp.AddChild(img)
+	replaceChildren(info, titleInfoOrder, "coverpage", cp)
+
+	removeUnusedBinary(root, oldID)
+	return nil
+}
+
+// authorElement creates FB2 author-like element.
+func authorElement(tag string, an *config.AuthorName) *etree.Element {
+	e := etree.NewElement(tag)
+	for _, f := range []struct{ tag, val string }{
+		{"first-name", an.First},
+		{"middle-name", an.Middle},
+		{"last-name", an.Last},
+	} {
+		if len(f.val) > 0 {
+			e.AddChild(textElement(f.tag, f.val))
+		}
+	}
+	return e
+}
+
+// bookRef fills book reference with values from the document description.
+func bookRef(desc *etree.Element, ref *config.BookRef) {
+	if e := desc.FindElement("./document-info/id"); e != nil {
+		ref.ID = strings.TrimSpace(e.Text())
+		if u, err := uuid.Parse(ref.ID); err == nil {
+			ref.UUID = u.String()
+		} else {
+			ref.UUID = uuid.NewSHA1(nameSpaceFB2, []byte(ref.ID)).String()
+		}
+	}
+	if info := desc.SelectElement("title-info"); info != nil {
+		if e := info.SelectElement("book-title"); e != nil {
+			ref.Title = strings.TrimSpace(e.Text())
+		}
+		for _, e := range info.SelectElements("author") {
+			if an := parseAuthorName(e); an != nil {
+				ref.Authors = append(ref.Authors, an)
+			}
+		}
+	}
+}
+
+// SetMeta changes FB2 document description according to meta information returned by "meta" for the book reference
+// (reference is completed with values from the document). Nothing is written and false is returned when there is
+// nothing to change. Resulting document is always encoded in UTF-8.
+func SetMeta(r io.Reader, unknownEncoding bool, ref *config.BookRef, meta func(*config.BookRef) (*config.MetaInfo, error), w io.Writer) (bool, error) {
+
+	doc := etree.NewDocument()
+	doc.WriteSettings = etree.WriteSettings{CanonicalText: true, CanonicalAttrVal: true}
+	if unknownEncoding {
+		doc.ReadSettings = etree.ReadSettings{CharsetReader: charset.NewReaderLabel}
+	}
+	if _, err := doc.ReadFrom(r); err != nil {
+		return false, fmt.Errorf("unable to parse FB2: %w", err)
+	}
+
+	root := doc.SelectElement("FictionBook")
+	if root == nil {
+		return false, errors.New("unable to find FictionBook element")
+	}
+	desc := root.SelectElement("description")
+	if desc == nil {
+		return false, errors.New("unable to find book description")
+	}
+	bookRef(desc, ref)
+
+	m, err := meta(ref)
+	if err != nil || m == nil || m.IsEmpty() {
+		return false, err
+	}
+
+	info := desc.SelectElement("title-info")
+	if info == nil {
+		info = etree.NewElement("title-info")
+		replaceChildren(desc, descriptionOrder, "title-info", info)
+	}
+
+	if id := strings.TrimSpace(m.ID); len(id) > 0 {
+		di := desc.SelectElement("document-info")
+		if di == nil {
+			di = etree.NewElement("document-info")
+			replaceChildren(desc, descriptionOrder, "document-info", di)
+		}
+		replaceChildren(di, documentInfoOrder, "id", textElement("id", id))
+	}
+	if title := strings.TrimSpace(m.Title); len(title) > 0 {
+		replaceChildren(info, titleInfoOrder, "book-title", textElement("book-title", title))
+	}
+	if lang := strings.TrimSpace(m.Lang); len(lang) > 0 {
+		replaceChildren(info, titleInfoOrder, "lang", textElement("lang", lang))
+	}
+	var genres []*etree.Element
+	for _, g := range m.Genres {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			genres = append(genres, textElement("genre", g))
+		}
+	}
+	if len(genres) > 0 {
+		replaceChildren(info, titleInfoOrder, "genre", genres...)
+	}
+	var authors []*etree.Element
+	for _, an := range m.Authors {
+		if an != nil {
+			authors = append(authors, authorElement("author", an))
+		}
+	}
+	if len(authors) > 0 {
+		replaceChildren(info, titleInfoOrder, "author", authors...)
+	}
+	if seq := strings.TrimSpace(m.SeqName); len(seq) > 0 {
+		e := etree.NewElement("sequence")
+		e.CreateAttr("name", seq)
+		if m.SeqNum > 0 {
+			e.CreateAttr("number", strconv.Itoa(m.SeqNum))
+		}
+		replaceChildren(info, titleInfoOrder, "sequence", e)
+	} else if m.SeqNum > 0 {
+		if e := info.SelectElement("sequence"); e != nil {
+			e.CreateAttr("number", strconv.Itoa(m.SeqNum))
+		}
+	}
+	if date := strings.TrimSpace(m.Date); len(date) > 0 {
+		e := textElement("date", date)
+		if _, err := time.Parse("2006-01-02", date); err == nil {
+			e.CreateAttr("value", date)
+		}
+		replaceChildren(info, titleInfoOrder, "date", e)
+	}
+	if text := strings.TrimSpace(m.Annotation); len(text) > 0 {
+		replaceChildren(info, titleInfoOrder, "annotation", annotationFromText(text))
+	}
+	if len(m.CoverImage) > 0 {
+		if err := setCover(root, info, m.CoverImage); err != nil {
+			return false, err
+		}
+	}
+
+	// document was decoded while reading
+	for _, t := range doc.Child {
+		if pi, ok := t.(*etree.ProcInst); ok && pi.Target == "xml" {
+			pi.Inst = `version="1.0" encoding="UTF-8"`
+		}
+	}
+	if _, err := doc.WriteTo(w); err != nil {
+		return false, fmt.Errorf("unable to write FB2: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"fb2converter/config"
+)
+
+func TestSetMeta(t *testing.T) {
+
+	const book = `<?xml version="1.0" encoding="windows-1251"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:xl="http://www.w3.org/1999/xlink">
+<description>
+<title-info>
+<genre>sf</genre>
+<author><first-name>Old</first-name><last-name>Author</last-name></author>
+<book-title>Old Title</book-title>
+<coverpage><image xl:href="#cover.jpg"/></coverpage>
+<lang>ru</lang>
+<sequence name="Old" number="1"><sequence name="Nested"/></sequence>
+</title-info>
+<document-info><id>book-id</id></document-info>
+</description>
+<body><section><p>text</p></section></body>
+<binary id="cover.jpg" content-type="image/jpeg">AAAA</binary>
+</FictionBook>`
+
+	var ref *config.BookRef
+	meta := &config.MetaInfo{
+		Title:      "New & Title",
+		Authors:    []*config.AuthorName{{First: "John", Last: "Doe"}, {Last: "Roe"}},
+		SeqName:    "New",
+		SeqNum:     2,
+		Date:       "2001-02-03",
+		Annotation: "One\n\nTwo",
+		CoverImage: "remove cover",
+	}
+
+	var out bytes.Buffer
+	ok, err := SetMeta(strings.NewReader(book), false, &config.BookRef{Path: "book.fb2"}, func(r *config.BookRef) (*config.MetaInfo, error) {
+		ref = r
+		return meta, nil
+	}, &out)
+	if err != nil || !ok {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	}
+	if ref.ID != "book-id" || ref.Title != "Old Title" || len(ref.Authors) != 1 || ref.Authors[0].Last != "Author" {
+		t.Errorf("bad book reference: %+v", ref)
+	}
+
+	res := out.String()
+	for _, s := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		"<genre>sf</genre>\n" +
+			"<author><first-name>John</first-name><last-name>Doe</last-name></author>\n" +
+			"<author><last-name>Roe</last-name></author>\n" +
+			"<book-title>New &amp; Title</book-title>\n" +
+			"<annotation><p>One</p><p>Two</p></annotation>\n" +
+			`<date value="2001-02-03">2001-02-03</date>` + "\n" +
+			"<lang>ru</lang>\n" +
+			`<sequence name="New" number="2"/>` + "\n" +
+			"</title-info>",
+		"<document-info><id>book-id</id></document-info>",
+	} {
+		if !strings.Contains(res, s) {
+			t.Errorf("expected %q in\n%s", s, res)
+		}
+	}
+	for _, s := range []string{"coverpage", "<binary", "Old"} {
+		if strings.Contains(res, s) {
+			t.Errorf("unexpected %q in\n%s", s, res)
+		}
+	}
+
+	out.Reset()
+	ok, err = SetMeta(strings.NewReader(book), false, &config.BookRef{}, func(*config.BookRef) (*config.MetaInfo, error) {
+		return &config.MetaInfo{}, nil
+	}, &out)
+	if err != nil || ok || out.Len() != 0 {
+		t.Errorf("empty meta should not change anything: %v, %v, %d", ok, err, out.Len())
+	}
+}
@@ -0,0 +1,364 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"fb2converter/etree"
+)
+
+var (
+	textSpacesPattern = regexp.MustCompile(`[ \t\r\n]+`)
+	textHeaderPattern = regexp.MustCompile(`^h(\d+)$`)
+	mdListPattern     = regexp.MustCompile(`^(\d+)\. `)
+	mdEscaper         = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`)
+)
+
+// textWriter renders previously generated XHTML content as plain text or markdown. Since all transformations and notes
+// presentation were already applied to XHTML, resulting text matches what e-book shows.
+type textWriter struct {
+	markdown bool
+}
+
+// blocks returns list of text blocks (paragraphs, titles, stanzas, etc.) for all children of element.
+func (tw *textWriter) blocks(e *etree.Element) []string {
+
+	var res []string
+	if t := tw.clean(e.Text()); len(t) > 0 {
+		res = append(res, tw.escape(t))
+	}
+	for _, c := range e.ChildElements() {
+		cls := getAttrValue(c, "class")
+		switch {
+		case c.Tag == "p" && cls == "subtitle" && tw.markdown:
+			if t := tw.inline(c); len(t) > 0 {
+				res = append(res, "**"+t+"**")
+			}
+		case c.Tag == "p" || cls == "text-author":
+			if t := tw.inline(c); len(t) > 0 {
+				res = append(res, tw.paragraph(t))
+			}
+		case c.Tag == "img" || c.Tag == "svg":
+			// images cannot be presented
+		case c.Tag == "table":
+			if t := tw.table(c); len(t) > 0 {
+				res = append(res, t)
+			}
+		case textHeaderPattern.MatchString(cls):
+			if t := tw.title(c, cls); len(t) > 0 {
+				res = append(res, t)
+			}
+		case cls == "titlenotes":
+			if t := strings.Join(tw.lines(c), " "); len(t) > 0 {
+				if tw.markdown {
+					t = "**" + t + "**"
+				}
+				res = append(res, t)
+			}
+		case cls == "stanza":
+			if t := strings.Join(tw.lines(c), tw.lineBreak()); len(t) > 0 {
+				res = append(res, t)
+			}
+		case cls == "epigraph" || cls == "cite" || cls == "blocknote":
+			res = append(res, tw.quote(tw.blocks(c))...)
+		case cls == "emptyline" || cls == "chapter_end" || cls == "image" || strings.HasPrefix(cls, "vignette_"):
+			// nothing to render
+		default:
+			res = append(res, tw.blocks(c)...)
+		}
+	}
+	return res
+}
+
+// lines returns rendered text of every child paragraph - used for titles and stanzas where line breaks are significant.
+func (tw *textWriter) lines(e *etree.Element) []string {
+
+	var res []string
+	if t := tw.clean(e.Text()); len(t) > 0 {
+		res = append(res, tw.escape(t))
+	}
+	for _, c := range e.ChildElements() {
+		if getAttrValue(c, "class") == "emptyline" {
+			continue
+		}
+		if c.Tag != "p" {
+			res = append(res, tw.lines(c)...)
+			continue
+		}
+		if t := tw.inline(c); len(t) > 0 {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func (tw *textWriter) title(e *etree.Element, cls string) string {
+
+	lines := tw.lines(e)
+	if len(lines) == 0 {
+		return ""
+	}
+	if !tw.markdown {
+		return strings.Join(lines, "\n")
+	}
+	level, _ := strconv.Atoi(textHeaderPattern.FindStringSubmatch(cls)[1])
+	if level > 5 {
+		level = 5
+	}
+	return strings.Repeat("#", level+1) + " " + strings.Join(lines, " ")
+}
+
+func (tw *textWriter) table(e *etree.Element) string {
+
+	var rows []string
+	for i, tr := range e.FindElements(".//tr") {
+		var cells []string
+		for _, td := range tr.ChildElements() {
+			cells = append(cells, tw.inline(td))
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		if !tw.markdown {
+			rows = append(rows, strings.Join(cells, "\t"))
+			continue
+		}
+		rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			rows = append(rows, strings.Repeat("| --- ", len(cells))+"|")
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+// quote indents (or marks as markdown blockquote) every line of blocks.
+func (tw *textWriter) quote(blocks []string) []string {
+
+	prefix := "    "
+	if tw.markdown {
+		prefix = "> "
+	}
+	res := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		res = append(res, prefix+strings.ReplaceAll(b, "\n", "\n"+prefix))
+	}
+	return res
+}
+
+// inline returns text of element with all inline formatting applied.
+func (tw *textWriter) inline(e *etree.Element) string {
+	var sb strings.Builder
+	tw.inlineTo(&sb, e)
+	return strings.TrimSpace(textSpacesPattern.ReplaceAllString(sb.String(), " "))
+}
+
+func (tw *textWriter) inlineTo(sb *strings.Builder, e *etree.Element) {
+
+	for _, t := range e.Child {
+		switch c := t.(type) {
+		case *etree.CharData:
+			sb.WriteString(tw.escape(strings.ReplaceAll(c.Data, strSOFTHYPHEN, "")))
+		case *etree.Element:
+			tw.element(sb, c)
+			sb.WriteString(tw.escape(strings.ReplaceAll(c.Tail(), strSOFTHYPHEN, "")))
+		}
+	}
+}
+
+func (tw *textWriter) element(sb *strings.Builder, e *etree.Element) {
+
+	var mark string
+	switch cls := getAttrValue(e, "class"); {
+	case e.Tag == "img" || e.Tag == "svg":
+		return
+	case e.Tag == "br":
+		sb.WriteString(" ")
+		return
+	case cls == "inlinenote":
+		sb.WriteString(" (")
+		tw.inlineTo(sb, e)
+		sb.WriteString(")")
+		return
+	case e.Tag == "code" && tw.markdown:
+		mark = "`"
+	case cls == "strong" && tw.markdown:
+		mark = "**"
+	case cls == "emphasis" && tw.markdown:
+		mark = "*"
+	case cls == "strike" && tw.markdown:
+		mark = "~~"
+	}
+	if len(mark) == 0 {
+		tw.inlineTo(sb, e)
+		return
+	}
+
+	// markdown emphasis does not tolerate spaces inside markers
+	var inner strings.Builder
+	tw.inlineTo(&inner, e)
+	text := inner.String()
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) == 0 {
+		sb.WriteString(text)
+		return
+	}
+	if strings.TrimLeft(text, " \t\r\n") != text {
+		sb.WriteString(" ")
+	}
+	sb.WriteString(mark + trimmed + mark)
+	if strings.TrimRight(text, " \t\r\n") != text {
+		sb.WriteString(" ")
+	}
+}
+
+func (tw *textWriter) clean(s string) string {
+	return strings.TrimSpace(textSpacesPattern.ReplaceAllString(strings.ReplaceAll(s, strSOFTHYPHEN, ""), " "))
+}
+
+func (tw *textWriter) escape(s string) string {
+	if !tw.markdown {
+		return s
+	}
+	return mdEscaper.Replace(s)
+}
+
+// paragraph makes sure markdown would not interpret beginning of the text as heading or list.
+func (tw *textWriter) paragraph(s string) string {
+	if !tw.markdown {
+		return s
+	}
+	if strings.HasPrefix(s, "#") || strings.HasPrefix(s, "- ") || strings.HasPrefix(s, "+ ") {
+		return `\` + s
+	}
+	return mdListPattern.ReplaceAllString(s, `$1\. `)
+}
+
+func (tw *textWriter) lineBreak() string {
+	if tw.markdown {
+		// trailing double space is markdown hard line break
+		return "  \n"
+	}
+	return "\n"
+}
+
+// header returns book metadata to be put in front of the text - YAML front matter for markdown.
+func (tw *textWriter) header(b *Book, authors []string) string {
+
+	// both formats show the same genres, names are only missing when genres were never resolved
+	genres := b.GenreNames
+	if len(genres) == 0 {
+		genres = b.Genres
+	}
+
+	var sb strings.Builder
+	if tw.markdown {
+		sb.WriteString("---\n")
+		sb.WriteString("title: " + strconv.Quote(b.Title) + "\n")
+		if len(authors) > 0 {
+			sb.WriteString("author:\n")
+			for _, a := range authors {
+				sb.WriteString("  - " + strconv.Quote(a) + "\n")
+			}
+		}
+		if len(b.SeqName) > 0 {
+			sb.WriteString("series: " + strconv.Quote(b.SeqName) + "\n")
+			if b.SeqNum > 0 {
+				sb.WriteString("series_index: " + strconv.Itoa(b.SeqNum) + "\n")
+			}
+		}
+		sb.WriteString("lang: " + strconv.Quote(b.Lang.String()) + "\n")
+		if len(b.Date) > 0 {
+			sb.WriteString("date: " + strconv.Quote(b.Date) + "\n")
+		}
+		if len(genres) > 0 {
+			sb.WriteString("subject:\n")
+			for _, g := range genres {
+				sb.WriteString("  - " + strconv.Quote(g) + "\n")
+			}
+		}
+		sb.WriteString("identifier: " + strconv.Quote(b.ID.String()) + "\n")
+		sb.WriteString("---\n")
+		return sb.String()
+	}
+
+	sb.WriteString("Title: " + b.Title + "\n")
+	if len(authors) > 0 {
+		sb.WriteString("Authors: " + strings.Join(authors, ", ") + "\n")
+	}
+	if len(b.SeqName) > 0 {
+		sb.WriteString("Series: " + b.SeqName)
+		if b.SeqNum > 0 {
+			sb.WriteString(" #" + strconv.Itoa(b.SeqNum))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("Language: " + b.Lang.String() + "\n")
+	if len(b.Date) > 0 {
+		sb.WriteString("Date: " + b.Date + "\n")
+	}
+	if len(genres) > 0 {
+		sb.WriteString("Genres: " + strings.Join(genres, ", ") + "\n")
+	}
+	sb.WriteString("ID: " + b.ID.String() + "\n")
+	sb.WriteString(strings.Repeat("=", 40) + "\n")
+	return sb.String()
+}
+
+// generateText renders all content files (except cover and TOC pages) in spine order.
+func (p *Processor) generateText(markdown bool) string {
+
+	p.env.Log.Debug("Generating text - start", zap.Bool("markdown", markdown))
+	defer func(start time.Time) {
+		p.env.Log.Debug("Generating text - done", zap.Duration("elapsed", time.Since(start)))
+	}(time.Now())
+
+	tw := &textWriter{markdown: markdown}
+
+	var authors []string
+	for _, an := range p.Book.Authors {
+		authors = append(authors, ReplaceKeywords(p.env.Cfg.Doc.AuthorFormatMeta, CreateAuthorKeywordsMap(an)))
+	}
+
+	var blocks []string
+	for _, f := range p.Book.Files {
+		if f.doc == nil || f.ct != "application/xhtml+xml" || filepath.Ext(f.fname) != ".xhtml" || f.transient&dataNotForSpline != 0 {
+			continue
+		}
+		if f.id == "cover-page" || f.id == "toc" {
+			continue
+		}
+		if body := f.doc.FindElement("./html/body"); body != nil {
+			blocks = append(blocks, tw.blocks(body)...)
+		}
+	}
+	return tw.header(p.Book, authors) + "\n" + strings.Join(blocks, "\n\n") + "\n"
+}
+
+// FinalizeTXT produces plain text file out of previously generated content.
+func (p *Processor) FinalizeTXT(fname string) error {
+	return p.finalizeText(fname, false)
+}
+
+// FinalizeMD produces markdown file out of previously generated content.
+func (p *Processor) FinalizeMD(fname string) error {
+	return p.finalizeText(fname, true)
+}
+
+func (p *Processor) finalizeText(fname string, markdown bool) error {
+
+	if err := p.PrepareOutputFile(fname); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(fname, []byte(p.generateText(markdown)), 0644); err != nil {
+		return fmt.Errorf("unable to write text (%s): %w", fname, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/google/uuid"
+
+	"fb2converter/etree"
+)
+
+var casesTextBody = []testCase{
+	//-----------------------------------------------------------------------------
+	{
+		in: `<body><div class="titleblock" id="tocref0"><div class="h0"><p class="title">Автор</p><p class="title">Назва­ние</p></div></div>
+<div class="epigraph"><p>Эпи<span class="emphasis">граф</span></p><div class="text-author">Кто-то</div></div>
+<p class="subtitle">* * *</p>
+<p>Первый <span class="strong">абзац</span><a class="anchor" href="notes.xhtml#n1" id="back_n1">[1]</a>.</p></body>`,
+		out: `Автор
+Название
+
+    Эпиграф
+
+    Кто-то
+
+* * *
+
+Первый абзац[1].`,
+	},
+	//-----------------------------------------------------------------------------
+	{
+		in: `<body><div class="poem"><div class="stanza"><p>Строка один</p><p>Строка два</p></div><div class="emptyline"/><div class="stanza"><p>Строка три</p></div></div>
+<p>Текст<span class="inlineanchor">[1]</span><span class="inlinenote">примечание</span> дальше.</p>
+<div class="blocknote"><p><span class="notenum">1) </span>Блочное</p></div></body>`,
+		out: `Строка один
+Строка два
+
+Строка три
+
+Текст[1] (примечание) дальше.
+
+    1) Блочное`,
+	},
+}
+
+var casesMarkdownBody = []testCase{
+	//-----------------------------------------------------------------------------
+	{
+		in: `<body><div class="titleblock" id="tocref0"><div class="h0"><p class="title">Автор</p><p class="title">Назва­ние</p></div></div>
+<div class="titleblock_nobreak" id="tocref1"><div class="h2"><p class="title">Глава_1</p></div></div>
+<div class="epigraph"><p>Эпи<span class="emphasis">граф </span>два</p><div class="text-author">Кто-то</div></div>
+<p class="subtitle">* * *</p>
+<p>- Первый <span class="strong">абзац</span><a class="anchor" href="notes.xhtml#n1" id="back_n1">[1]</a>.</p></body>`,
+		out: `# Автор Название
+
+### Глава\_1
+
+> Эпи*граф* два
+
+> Кто-то
+
+**\* \* \***
+
+\- Первый **абзац**\[1\].`,
+	},
+	//-----------------------------------------------------------------------------
+	{
+		in: `<body><div class="poem"><div class="stanza"><p>Строка один</p><p>Строка два</p></div></div>
+<table class="table"><tr><th>А</th><th>Б</th></tr><tr><td>1</td><td>2</td></tr></table></body>`,
+		out: "Строка один  \nСтрока два\n\n| А | Б |\n| --- | --- |\n| 1 | 2 |",
+	},
+}
+
+func runTextCases(t *testing.T, cases []testCase, markdown bool) {
+
+	tw := &textWriter{markdown: markdown}
+	for i, c := range cases {
+		d := etree.NewDocument()
+		if err := d.ReadFromString(c.in); err != nil {
+			t.Fatal(err)
+		}
+
+		res := strings.Join(tw.blocks(d.Root()), "\n\n")
+
+		fmt.Printf("Case %d - FORMATTED:\n[%s]\n", i+1, res)
+		if res != c.out {
+			t.Fatalf("BAD RESULT\nEXPECTED:\n[%s]\nGOT:\n[%s]", spew.Sdump(c.out), spew.Sdump(res))
+		}
+	}
+	t.Logf("OK - %s: %d cases", t.Name(), len(cases))
+}
+
+func TestTextBody(t *testing.T) {
+	runTextCases(t, casesTextBody, false)
+}
+
+func TestMarkdownBody(t *testing.T) {
+	runTextCases(t, casesMarkdownBody, true)
+}
+
+func TestTextHeaderGenres(t *testing.T) {
+
+	b := NewBook(uuid.New(), "Книга")
+	b.Genres = []string{"sf", "prose_classic"}
+	b.GenreNames = []string{"Фантастика", "Классическая проза"}
+	// subjects may be codes or BISAC/Thema, headers are for readers and always show names
+	b.Subjects = []string{"FIC028000", "FIC004000"}
+
+	txt := (&textWriter{}).header(b, nil)
+	if !strings.Contains(txt, "Genres: Фантастика, Классическая проза\n") {
+		t.Errorf("unexpected text header:\n%s", txt)
+	}
+	md := (&textWriter{markdown: true}).header(b, nil)
+	if !strings.Contains(md, "subject:\n  - \"Фантастика\"\n  - \"Классическая проза\"\n") {
+		t.Errorf("unexpected markdown front matter:\n%s", md)
+	}
+
+	b.GenreNames = nil
+	if txt := (&textWriter{}).header(b, nil); !strings.Contains(txt, "Genres: sf, prose_classic\n") {
+		t.Errorf("unexpected text header without genre names:\n%s", txt)
+	}
+	if md := (&textWriter{markdown: true}).header(b, nil); !strings.Contains(md, "subject:\n  - \"sf\"\n  - \"prose_classic\"\n") {
+		t.Errorf("unexpected markdown front matter without genre names:\n%s", md)
+	}
+}
@@ -193,9 +193,33 @@ func CreateTitleKeywordsMap(b *Book, pos int, src string) map[string]string {
 	if len(b.Date) > 0 {
 		rd["#date"] = b.Date
 	}
+	addDescriptionKeywords(rd, b)
 	return rd
 }
 
+var customInfoKeyCleaner = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// addDescriptionKeywords adds keywords for genres, publish-info, src-title-info, title-info keywords and custom-info.
+func addDescriptionKeywords(rd map[string]string, b *Book) {
+	rd["#publisher"] = b.Publisher
+	rd["#city"] = b.City
+	rd["#year"] = b.Year
+	rd["#isbn"] = b.ISBN
+	rd["#srctitle"] = b.SrcTitle
+	rd["#srclang"] = b.SrcLang
+	rd["#keywords"] = strings.Join(b.Keywords, ", ")
+	rd["#genre"], rd["#genres"] = "", strings.Join(b.GenreNames, ", ")
+	if len(b.GenreNames) > 0 {
+		rd["#genre"] = b.GenreNames[0]
+	}
+	rd["#genregroup"] = b.GenreGroup
+	for t, v := range b.CustomInfo {
+		if k := strings.Trim(customInfoKeyCleaner.ReplaceAllString(strings.ToLower(t), "_"), "_"); len(k) > 0 {
+			rd["#custom_"+k] = v
+		}
+	}
+}
+
 func abbrSeq(seq string) (abbr string) {
 	for _, w := range strings.Split(seq, " ") {
 		for len(w) > 0 {
@@ -233,7 +257,10 @@ func CreateFileNameKeywordsMap(b *Book, format string, pos int) map[string]strin
 	}
 	rd["#authors"] = b.BookAuthors(format, false)
 	rd["#author"] = b.BookAuthors(format, true)
+	rd["#translators"] = b.BookTranslators(format, false)
+	rd["#translator"] = b.BookTranslators(format, true)
 	rd["#bookid"] = b.ID.String()
+	addDescriptionKeywords(rd, b)
 	return rd
 }
 
@@ -265,6 +292,16 @@ func AppendIfMissing(slice []string, str string) []string {
 	return append(slice, str)
 }
 
+// sortedKeys returns map keys in stable order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // IsOneOf checks if string is present in slice of strings. Comparison is case insensitive.
 func IsOneOf(name string, names []string) bool {
 	for _, n := range names {
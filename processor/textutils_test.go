@@ -4,6 +4,10 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/google/uuid"
+
+	"fb2converter/config"
 )
 
 type testCase struct {
@@ -97,6 +101,34 @@ func TestReplaceKeywords(t *testing.T) {
 	t.Logf("OK - %s: %d cases", t.Name(), len(cases))
 }
 
+func TestDescriptionKeywords(t *testing.T) {
+
+	b := NewBook(uuid.Nil, "test")
+	b.Title = "Title"
+	b.Authors = []*config.AuthorName{{First: "Ivan", Last: "Ivanov"}}
+	b.Translators = []*config.AuthorName{{First: "John", Last: "Smith"}, {First: "Jane", Last: "Doe"}}
+	b.Publisher, b.City, b.Year, b.ISBN = "Publisher", "City", "2001", "978-5-00-000000-0"
+	b.SrcTitle, b.SrcLang = "Original", "en"
+	b.Keywords = []string{"one", "two"}
+	b.CustomInfo["Library Id"] = "42"
+
+	m := CreateFileNameKeywordsMap(b, "#l", 2)
+	for in, out := range map[string]string{
+		"#title{ (#translators)}":    "Title (Smith, Doe)",
+		"#title{ (#translator)}":     "Title (Smith и др)",
+		"#publisher, #city, #year":   "Publisher, City, 2001",
+		"#title{, ISBN #isbn}":       "Title, ISBN 978-5-00-000000-0",
+		"#srctitle{ [#srclang]}":     "Original [en]",
+		"#keywords":                  "one, two",
+		"#custom_library_id":         "42",
+		"#title{ [#custom_unknown]}": "Title",
+	} {
+		if res := ReplaceKeywords(in, m); res != out {
+			t.Errorf("BAD RESULT for %q\nEXPECTED:\n[%s]\nGOT:\n[%s]", in, out, res)
+		}
+	}
+}
+
 var cases1 = []string{
 	"1",
 	"test book.epub",
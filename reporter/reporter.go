@@ -11,11 +11,15 @@ import (
 	"time"
 )
 
+// redacted is put in place of secrets.
+const redacted = "[redacted]"
+
 // Reporter accumulates information necessary to prepare debug report.
 type Report struct {
 	// NOTE: not to be used concurrently!
-	paths map[string]string
-	file  *os.File
+	paths   map[string]string
+	secrets [][]byte
+	file    *os.File
 }
 
 // NewReporter() creates initialized empty reporter.
@@ -75,6 +79,37 @@ func (r *Report) Store(name, path string) {
 	}
 }
 
+// Redact() registers secret which should never appear in the final archive. Every occurrence of secret in stored files
+// is replaced.
+func (r *Report) Redact(secret string) {
+
+	if r == nil || len(secret) == 0 {
+		return
+	}
+	for _, s := range r.secrets {
+		if string(s) == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, []byte(secret))
+}
+
+// redact returns reader with all known secrets replaced.
+func (r *Report) redact(src io.Reader) (io.Reader, error) {
+
+	if len(r.secrets) == 0 {
+		return src, nil
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range r.secrets {
+		data = bytes.ReplaceAll(data, s, []byte(redacted))
+	}
+	return bytes.NewReader(data), nil
+}
+
 func (r *Report) finalize() error {
 
 	arc := zip.NewWriter(r.file)
@@ -94,17 +129,11 @@ func (r *Report) finalize() error {
 		if info, err := os.Stat(path); err == nil {
 			switch {
 			case info.Mode().IsRegular():
-				var r io.ReadCloser
-				if r, err = os.Open(path); err != nil {
-					return err
-				}
-				if err := saveFile(arc, name, info.ModTime(), r); err != nil {
-					r.Close()
+				if err := r.saveFile(arc, name, info.ModTime(), path); err != nil {
 					return err
 				}
-				r.Close()
 			case info.Mode().IsDir():
-				if err := saveDir(arc, name, path); err != nil {
+				if err := r.saveDir(arc, name, path); err != nil {
 					return err
 				}
 			default:
@@ -148,7 +177,23 @@ func saveFile(dst *zip.Writer, name string, t time.Time, src io.Reader) error {
 	return nil
 }
 
-func saveDir(dst *zip.Writer, name, dir string) error {
+// saveFile stores file from disk removing secrets.
+func (r *Report) saveFile(dst *zip.Writer, name string, t time.Time, path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	src, err := r.redact(f)
+	if err != nil {
+		return err
+	}
+	return saveFile(dst, name, t, src)
+}
+
+func (r *Report) saveDir(dst *zip.Writer, name, dir string) error {
 	saveFile := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -165,16 +210,7 @@ func saveDir(dst *zip.Writer, name, dir string) error {
 		// root entry under new name
 		rel = filepath.ToSlash(filepath.Join(name, rel))
 
-		var r io.ReadCloser
-		if r, err = os.Open(path); err != nil {
-			return err
-		}
-		defer r.Close()
-
-		if err = saveFile(dst, rel, info.ModTime(), r); err != nil {
-			return err
-		}
-		return nil
+		return r.saveFile(dst, rel, info.ModTime(), path)
 	}
 	if err := filepath.Walk(dir, saveFile); err != nil {
 		return err
@@ -15,6 +15,19 @@ type LocalEnv struct {
 	Cfg *config.Config
 	Log *zap.Logger
 	Rpt *reporter.Report
+	Stk Sender    // set only when converted books are sent to Kindle
+	Dlv Deliverer // set only when delivery targets are configured
+}
+
+// Sender accepts converted books for delivery, root is directory where removal of empty directories should stop when
+// book is deleted after sending.
+type Sender interface {
+	Add(fname, root string) error
+}
+
+// Deliverer hands converted books to delivery targets accepting their output format.
+type Deliverer interface {
+	Deliver(fname, root, format string) error
 }
 
 // NewLocalEnv creates LocalEnv and initializes it.
@@ -12,7 +12,7 @@ import (
 	"strings"
 )
 
-//go:embed configuration.toml default_cover.jpeg dictionaries profiles resources sentences
+//go:embed configuration.toml default_cover.jpeg devices dictionaries genres.toml profiles resources sentences templates
 var content embed.FS
 
 // -------------------------------------------------------------------------------------------------------------------------